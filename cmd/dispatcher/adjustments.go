@@ -0,0 +1,67 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// requestAdjustment：规范化请求时被修改的一个字段（apiResponse.adjustments）；未提供而取默认值的字段不记录。
+type requestAdjustment struct {
+	Field     string `json:"field"`
+	Requested int64  `json:"requested"`
+	Applied   int64  `json:"applied"`
+	Reason    string `json:"reason"`
+}
+
+type adjustments []requestAdjustment
+
+// clamp：clampInt 并在取值改变时记录。
+func (a *adjustments) clamp(field string, v, minV, maxV int) int {
+	applied := clampInt(v, minV, maxV)
+	if applied != v {
+		*a = append(*a, requestAdjustment{Field: field, Requested: int64(v), Applied: int64(applied), Reason: fmt.Sprintf("out of range %d..%d", minV, maxV)})
+	}
+	return applied
+}
+
+// nonNegative：负值按 0 处理并记录。
+func (a *adjustments) nonNegative(field string, v int64) int64 {
+	if v >= 0 {
+		return v
+	}
+	*a = append(*a, requestAdjustment{Field: field, Requested: v, Applied: 0, Reason: "negative value treated as 0"})
+	return 0
+}
+
+// maxWait：只在调用方显式给出 maxWaitMs 且被 effectiveTimeout（或 HTTP 变体的运行时长上限）缩短时记录。
+func (a *adjustments) maxWait(requestedMs int, applied, runLimit time.Duration) {
+	if requestedMs <= 0 || applied <= 0 || applied.Milliseconds() == int64(requestedMs) {
+		return
+	}
+	reason := "capped by remaining Lambda time"
+	switch {
+	case applied == maxEffectiveWait:
+		reason = fmt.Sprintf("capped at %d (API Gateway limit)", maxEffectiveWait.Milliseconds())
+	case applied == runLimit:
+		reason = "capped by maxRunDurationMs"
+	}
+	*a = append(*a, requestAdjustment{Field: "maxWaitMs", Requested: int64(requestedMs), Applied: applied.Milliseconds(), Reason: reason})
+}
+
+// withAdjustments 把 adjustments 写入 JSON 响应的 apiResponse；没有修改或响应不是 apiResponse 时原样返回。
+func withAdjustments(resp events.APIGatewayProxyResponse, adj adjustments) events.APIGatewayProxyResponse {
+	if len(adj) == 0 || resp.Headers["Content-Type"] != "application/json" {
+		return resp
+	}
+	var api apiResponse
+	if err := json.Unmarshal([]byte(resp.Body), &api); err != nil {
+		return resp
+	}
+	api.Adjustments = adj
+	b, _ := json.Marshal(api)
+	resp.Body = string(b)
+	return resp
+}
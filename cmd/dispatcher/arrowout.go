@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/apache/arrow-go/v18/arrow"
+	"github.com/apache/arrow-go/v18/arrow/array"
+	"github.com/apache/arrow-go/v18/arrow/ipc"
+	"github.com/apache/arrow-go/v18/arrow/memory"
+)
+
+const (
+	outputFormatArrow = "arrow"
+
+	// arrowStreamFormat：output.format 的取值，也是 HTTP 变体二进制响应的 Content-Type。
+	arrowStreamFormat      = "arrow-ipc-stream"
+	arrowStreamContentType = "application/vnd.apache.arrow.stream"
+)
+
+// arrowOutput：outputFormat=arrow 时的 output，data 为 Arrow IPC stream 的 base64。
+type arrowOutput struct {
+	Format  string   `json:"format"`
+	Rows    int      `json:"rows"`
+	Columns []string `json:"columns"`
+	Data    string   `json:"data"`
+}
+
+// arrowStringColumns：除 *UnixNano 之外固定输出的标识列。
+var arrowStringColumns = []string{"runId", "id", "status"}
+
+// encodeArrowOutput 把输出中的逐样本原始时间戳转为列式：有 samples 数组时每个样本一行，否则整个输出为一行。
+// 列为 runId/id/status（utf8）与所有以 UnixNano 结尾的字段（int64，按名称排序），缺失值为 null。
+func encodeArrowOutput(out json.RawMessage) (json.RawMessage, error) {
+	rows, err := arrowRows(out)
+	if err != nil {
+		return nil, err
+	}
+	nanoCols := map[string]bool{}
+	for _, r := range rows {
+		for k := range r {
+			if strings.HasSuffix(k, "UnixNano") {
+				nanoCols[k] = true
+			}
+		}
+	}
+	nanoNames := make([]string, 0, len(nanoCols))
+	for k := range nanoCols {
+		nanoNames = append(nanoNames, k)
+	}
+	sort.Strings(nanoNames)
+
+	fields := make([]arrow.Field, 0, len(arrowStringColumns)+len(nanoNames))
+	for _, name := range arrowStringColumns {
+		fields = append(fields, arrow.Field{Name: name, Type: arrow.BinaryTypes.String, Nullable: true})
+	}
+	for _, name := range nanoNames {
+		fields = append(fields, arrow.Field{Name: name, Type: arrow.PrimitiveTypes.Int64, Nullable: true})
+	}
+	schema := arrow.NewSchema(fields, nil)
+
+	b := array.NewRecordBuilder(memory.NewGoAllocator(), schema)
+	defer b.Release()
+	for _, r := range rows {
+		for i, name := range arrowStringColumns {
+			sb := b.Field(i).(*array.StringBuilder)
+			if s, ok := r[name].(string); ok {
+				sb.Append(s)
+			} else {
+				sb.AppendNull()
+			}
+		}
+		for i, name := range nanoNames {
+			ib := b.Field(len(arrowStringColumns) + i).(*array.Int64Builder)
+			n, ok := r[name].(json.Number)
+			v, err := n.Int64()
+			if !ok || err != nil {
+				ib.AppendNull()
+				continue
+			}
+			ib.Append(v)
+		}
+	}
+	rec := b.NewRecord()
+	defer rec.Release()
+
+	var buf bytes.Buffer
+	w := ipc.NewWriter(&buf, ipc.WithSchema(schema))
+	if err := w.Write(rec); err != nil {
+		return nil, fmt.Errorf("write arrow record: %w", err)
+	}
+	if err := w.Close(); err != nil {
+		return nil, fmt.Errorf("close arrow writer: %w", err)
+	}
+
+	columns := append(append([]string(nil), arrowStringColumns...), nanoNames...)
+	encoded, _ := json.Marshal(arrowOutput{Format: arrowStreamFormat, Rows: len(rows), Columns: columns, Data: base64.StdEncoding.EncodeToString(buf.Bytes())})
+	return encoded, nil
+}
+
+// arrowRows：按 json.Number 解码，避免 UnixNano（约 1.7e18）经 float64 丢失精度。
+func arrowRows(out json.RawMessage) ([]map[string]any, error) {
+	dec := json.NewDecoder(bytes.NewReader(out))
+	dec.UseNumber()
+	var top map[string]any
+	if err := dec.Decode(&top); err != nil {
+		return nil, fmt.Errorf("arrow output: %w", err)
+	}
+	samples, ok := top["samples"].([]any)
+	if !ok {
+		return []map[string]any{top}, nil
+	}
+	rows := make([]map[string]any, 0, len(samples))
+	for _, s := range samples {
+		if m, ok := s.(map[string]any); ok {
+			rows = append(rows, m)
+		}
+	}
+	return rows, nil
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+// maxAttributeComparisonRounds：compareAttributes 的轮数上限，每轮发送两条消息（用户属性/系统属性各一条）。
+const maxAttributeComparisonRounds = 5
+
+// 消息属性放置方式：SQS 的 MessageSystemAttributes 只支持 AWSTraceHeader，
+// 因此对比实验统一携带一个 X-Ray trace header，分别作为用户属性 traceHeader 与系统属性 AWSTraceHeader 发送。
+const (
+	attributePlacementUser   = "user"
+	attributePlacementSystem = "system"
+
+	attrTraceHeader = "traceHeader"
+)
+
+// attributeComparisonOutput：compareAttributes 模式的输出；DeltaMeanMs/DeltaP50Ms 为 system - user。
+type attributeComparisonOutput struct {
+	RunID       string                   `json:"runId"`
+	Rounds      int                      `json:"rounds"`
+	TraceHeader string                   `json:"traceHeader"`
+	User        attributePlacementResult `json:"user"`
+	System      attributePlacementResult `json:"system"`
+	DeltaMeanMs *float64                 `json:"deltaMeanMs,omitempty"`
+	DeltaP50Ms  *float64                 `json:"deltaP50Ms,omitempty"`
+}
+
+// attributePlacementResult：同一放置方式下各轮的往返延迟（receiveMessageUnixNano - sendStartUnixNano）。
+type attributePlacementResult struct {
+	Placement   string    `json:"placement"`
+	OK          int       `json:"ok"`
+	Failed      int       `json:"failed"`
+	LatenciesMs []float64 `json:"latenciesMs"`
+	MeanMs      float64   `json:"meanMs"`
+	P50Ms       float64   `json:"p50Ms"`
+	Error       string    `json:"error,omitempty"`
+}
+
+func validateAttributeComparison(body apiRequest) error {
+	if body.CompareAttributes == 0 {
+		return nil
+	}
+	if body.CompareAttributes < 1 || body.CompareAttributes > maxAttributeComparisonRounds {
+		return fmt.Errorf("compareAttributes must be 1..%d, got %d", maxAttributeComparisonRounds, body.CompareAttributes)
+	}
+	if len(body.BatchDelaySeconds) > 0 || body.RampMaxConcurrency > 0 {
+		return fmt.Errorf("compareAttributes cannot be combined with batchDelaySeconds or rampMaxConcurrency")
+	}
+	return nil
+}
+
+// newTraceHeader 生成格式合法的 X-Ray trace header（SQS 会校验 AWSTraceHeader 的格式）。
+func newTraceHeader() string {
+	return fmt.Sprintf("Root=1-%08x-%s;Parent=%s;Sampled=0", time.Now().Unix(), randHex(12), randHex(8))
+}
+
+// placementAttributes 按放置方式把 trace header 加到 entry 上。
+func placementAttributes(entry *types.SendMessageBatchRequestEntry, placement, traceHeader string) {
+	switch placement {
+	case attributePlacementUser:
+		if entry.MessageAttributes == nil {
+			entry.MessageAttributes = map[string]types.MessageAttributeValue{}
+		}
+		entry.MessageAttributes[attrTraceHeader] = types.MessageAttributeValue{DataType: aws.String("String"), StringValue: aws.String(traceHeader)}
+	case attributePlacementSystem:
+		entry.MessageSystemAttributes = map[string]types.MessageSystemAttributeValue{
+			string(types.MessageSystemAttributeNameForSendsAWSTraceHeader): {DataType: aws.String("String"), StringValue: aws.String(traceHeader)},
+		}
+	}
+}
+
+// handleAttributeComparison 交替发送除 trace header 放置方式外完全相同的消息（每轮交换先后顺序以抵消预热偏差），
+// 对比用户 MessageAttributes 与系统 MessageSystemAttributes 的往返延迟。
+func handleAttributeComparison(ctx context.Context, body apiRequest, q queueTargets, inv invocationInfo) (events.APIGatewayProxyResponse, error) {
+	dispatchStart := time.Now().UnixNano()
+	out := attributeComparisonOutput{
+		RunID:       body.RunID,
+		TraceHeader: newTraceHeader(),
+		User:        attributePlacementResult{Placement: attributePlacementUser},
+		System:      attributePlacementResult{Placement: attributePlacementSystem},
+	}
+
+	for round := 0; round < body.CompareAttributes && ctx.Err() == nil; round++ {
+		order := []*attributePlacementResult{&out.User, &out.System}
+		if round%2 == 1 {
+			order[0], order[1] = order[1], order[0]
+		}
+		for _, r := range order {
+			b := body
+			b.attributePlacement, b.traceHeader = r.Placement, out.TraceHeader
+			samples, err := sendBatch(ctx, b, q, inv, sendTimes{dispatchStart: time.Now().UnixNano()}, []int{0})
+			switch {
+			case err != nil:
+				r.Failed++
+				r.Error = err.Error()
+			case samples[0].Status != "OK":
+				r.Failed++
+				r.Error = samples[0].Error
+			default:
+				r.OK++
+				r.LatenciesMs = append(r.LatenciesMs, float64(samples[0].ReceiveMessageUnixNano-samples[0].SendStartUnixNano)/float64(time.Millisecond))
+			}
+		}
+		out.Rounds++
+	}
+	out.User.summarize()
+	out.System.summarize()
+	if out.User.OK > 0 && out.System.OK > 0 {
+		mean, p50 := out.System.MeanMs-out.User.MeanMs, out.System.P50Ms-out.User.P50Ms
+		out.DeltaMeanMs, out.DeltaP50Ms = &mean, &p50
+	}
+
+	code, status := 200, "OK"
+	switch {
+	case out.User.OK == 0 && out.System.OK == 0:
+		code, status = 502, "ERROR"
+	case out.User.Failed > 0 || out.System.Failed > 0:
+		status = "PARTIAL"
+	}
+	outBytes, _ := json.Marshal(out)
+	elapsedNs := time.Now().UnixNano() - dispatchStart
+	elapsedMs := elapsedNs / int64(time.Millisecond)
+	results.put(storedResult{RunID: body.RunID, StoredAt: time.Now(), TotalMs: elapsedMs, Output: outBytes})
+
+	outBytes, upload := persistResult(ctx, body.resultS3, body.RunID, outBytes, nil)
+
+	outBytes, err := formatOutput(outBytes, body.OutputFormat)
+	if err != nil {
+		return jsonResp(500, apiResponse{Status: "ERROR", Error: err.Error()})
+	}
+	apiOut := apiResponse{Status: status, TotalMs: elapsedMs, TotalUs: nanosToUs(elapsedNs), Output: outBytes, PerceivedLatencyMs: perceivedLatencyMs(body.requestTimeEpochMs)}
+	upload.apply(&apiOut)
+	return jsonResp(code, apiOut)
+}
+
+func (r *attributePlacementResult) summarize() {
+	if len(r.LatenciesMs) == 0 {
+		return
+	}
+	sorted := append([]float64(nil), r.LatenciesMs...)
+	sort.Float64s(sorted)
+	var sum float64
+	for _, v := range sorted {
+		sum += v
+	}
+	r.MeanMs = sum / float64(len(sorted))
+	r.P50Ms = sorted[clampInt(int(math.Ceil(0.5*float64(len(sorted))))-1, 0, len(sorted)-1)]
+}
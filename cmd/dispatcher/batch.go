@@ -0,0 +1,342 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+// maxBatchEntries：SendMessageBatch 单次最多 10 条。
+const maxBatchEntries = 10
+
+// batchPollWaitSeconds：批量模式下各样本并发轮询，缩短长轮询时长，
+// 以便被其他样本替自己收到（暂存）的回调能尽快取走。
+const batchPollWaitSeconds = 1
+
+// batchOutput：批量模式的输出，每条消息对应一个样本。
+type batchOutput struct {
+	RunID   string        `json:"runId"`
+	Samples []batchSample `json:"samples,omitempty"`
+	// SampleCount：结果写入 S3 后内联摘要省略 samples，只保留样本数。
+	SampleCount int `json:"sampleCount,omitempty"`
+	// 并发样本之间的轮询排队（headOfLineDelayMs）分布，仅统计成功的样本。
+	HeadOfLine *headOfLineSummary `json:"headOfLine,omitempty"`
+	// 成功样本按匹配时间（receiveMessageUnixNano）排序后的相邻间隔，反映 Worker 侧的吞吐节奏；至少 2 个样本时输出。
+	InterArrival *interArrivalSummary `json:"interArrival,omitempty"`
+	// pureForwardLeg=true 时成功样本的 pureForwardLegMs 汇总。
+	ForwardLeg *forwardLegSummary `json:"forwardLeg,omitempty"`
+	// DuplicateIDCount：生成 id 时与本次运行已有 id 碰撞并重新生成的次数。
+	DuplicateIDCount int `json:"duplicateIdCount,omitempty"`
+	// fifoGroupCount 模式下的分组分布与逐组延迟。
+	FifoGroups *fifoGroupsSummary `json:"fifoGroups,omitempty"`
+	// byWorkerInstance=true 时各 Worker 容器的延迟分布。
+	WorkerInstances []workerInstanceStats `json:"workerInstances,omitempty"`
+}
+
+type interArrivalSummary struct {
+	Callbacks int       `json:"callbacks"`
+	MinMs     float64   `json:"minMs"`
+	MeanMs    float64   `json:"meanMs"`
+	MaxMs     float64   `json:"maxMs"`
+	DeltasMs  []float64 `json:"deltasMs"`
+}
+
+type headOfLineSummary struct {
+	Samples int     `json:"samples"`
+	AvgMs   float64 `json:"avgMs"`
+	P50Ms   int64   `json:"p50Ms"`
+	P90Ms   int64   `json:"p90Ms"`
+	MaxMs   int64   `json:"maxMs"`
+}
+
+type batchSample struct {
+	dispatcherOutput
+
+	// 请求的 DelaySeconds 与观测到的延迟：sqsFirstReceive - sqsSent（均为 SQS 服务端时钟）。
+	RequestedDelaySeconds int    `json:"requestedDelaySeconds"`
+	ObservedDelayMs       int64  `json:"observedDelayMs"`
+	Status                string `json:"status"`
+	Error                 string `json:"error,omitempty"`
+	// MessageGroupID：fifoGroupCount 模式下该样本所在的 FIFO 分组。
+	MessageGroupID string `json:"messageGroupId,omitempty"`
+}
+
+func validateBatchDelays(delays []int) error {
+	if len(delays) > maxBatchEntries {
+		return fmt.Errorf("batchDelaySeconds supports at most %d entries, got %d", maxBatchEntries, len(delays))
+	}
+	for i, d := range delays {
+		if d < 0 || d > 900 {
+			return fmt.Errorf("batchDelaySeconds[%d]=%d out of range 0..900", i, d)
+		}
+	}
+	return nil
+}
+
+// validateBatchDelaysWithin：DelaySeconds 不小于生效的 maxWait 的条目在截止时间前不可能可见，必然超时，直接拒绝。
+func validateBatchDelaysWithin(delays []int, maxWait time.Duration) error {
+	for i, d := range delays {
+		if time.Duration(d)*time.Second >= maxWait {
+			return fmt.Errorf("batchDelaySeconds[%d]=%d cannot complete within the effective maxWaitMs %d", i, d, maxWait.Milliseconds())
+		}
+	}
+	return nil
+}
+
+// batchStatus 按全部样本判定：任一条超时即 504 TIMEOUT（样本仍在 output 中），全部失败为 502 ERROR，部分失败为 PARTIAL。
+func batchStatus(samples []batchSample) (int, string) {
+	okCount, timedOut := 0, false
+	for _, s := range samples {
+		switch s.Status {
+		case "OK":
+			okCount++
+		case "TIMEOUT":
+			timedOut = true
+		}
+	}
+	switch {
+	case timedOut:
+		return 504, "TIMEOUT"
+	case okCount == 0:
+		return 502, "ERROR"
+	case okCount < len(samples):
+		return 200, "PARTIAL"
+	}
+	return 200, "OK"
+}
+
+// handleBatch 用一次 SendMessageBatch 发送多条消息（每条独立 DelaySeconds），
+// 随后并发等待各自的回调；样本之间通过 callbackStash 互相转交收到的回调。
+func handleBatch(ctx context.Context, body apiRequest, q queueTargets, inv invocationInfo) (events.APIGatewayProxyResponse, error) {
+	dispatchStart := time.Now().UnixNano()
+	jitter, err := applyInitialJitter(ctx, body.InitialJitterMs)
+	if err != nil {
+		elapsedNs := time.Now().UnixNano() - dispatchStart
+		elapsed := elapsedNs / int64(time.Millisecond)
+		return jsonResp(504, apiResponse{Status: "TIMEOUT", TotalMs: elapsed, TotalUs: nanosToUs(elapsedNs), Error: fmt.Sprintf("initial jitter: %v", err)})
+	}
+	st := sendTimes{dispatchStart: dispatchStart, jitter: jitter}
+
+	samples, err := sendBatch(ctx, body, q, inv, st, body.BatchDelaySeconds)
+	if err != nil {
+		return sendErrorResp(err)
+	}
+
+	code, status := batchStatus(samples)
+
+	bo := batchOutput{RunID: body.RunID, Samples: samples, HeadOfLine: summarizeHeadOfLine(samples), InterArrival: summarizeInterArrival(samples), DuplicateIDCount: body.ids.duplicateCount()}
+	if body.PureForwardLeg {
+		bo.ForwardLeg = summarizeForwardLeg(samples)
+	}
+	bo.FifoGroups = summarizeFIFOGroups(ctx, body, q.pushURL, samples)
+	if body.ByWorkerInstance {
+		bo.WorkerInstances = batchWorkerInstances(samples)
+	}
+	outBytes, _ := json.Marshal(bo)
+	elapsedNs := time.Now().UnixNano() - dispatchStart
+	elapsedMs := elapsedNs / int64(time.Millisecond)
+	results.put(storedResult{RunID: body.RunID, StoredAt: time.Now(), TotalMs: elapsedMs, Output: outBytes})
+
+	bo.Samples, bo.SampleCount = nil, len(samples)
+	trimmed, _ := json.Marshal(bo)
+	outBytes, upload := persistResult(ctx, body.resultS3, body.RunID, outBytes, trimmed)
+
+	outBytes, err = formatOutput(outBytes, body.OutputFormat)
+	if err != nil {
+		return jsonResp(500, apiResponse{Status: "ERROR", Error: err.Error()})
+	}
+	apiOut := apiResponse{Status: status, TotalMs: elapsedMs, TotalUs: nanosToUs(elapsedNs), Output: outBytes, PerceivedLatencyMs: perceivedLatencyMs(body.requestTimeEpochMs)}
+	upload.apply(&apiOut)
+	return jsonResp(code, apiOut)
+}
+
+// sendBatch 发送一批消息（delays[i] 为第 i 条的 DelaySeconds）并并发等待全部回调。
+// st 只需提供 dispatchStart/jitter，发送阶段的时间点在这里补齐。整批发送失败时返回 error，
+// 单条失败/超时记录在对应样本的 Status/Error 中。
+func sendBatch(ctx context.Context, body apiRequest, q queueTargets, inv invocationInfo, st sendTimes, delays []int) ([]batchSample, error) {
+	n := len(delays)
+	samples := make([]batchSample, n)
+	entries := make([]types.SendMessageBatchRequestEntry, n)
+	bodySizes := make([]int, n)
+	rawBodySizes := make([]int, n)
+
+	// 整批只有一次 SendMessageBatch，发往同一个 Push 队列。
+	q = q.forSend()
+	clock := startSendClock()
+	st.sendUnixNano, st.sendStart = clock.baseUnixNano, clock.baseUnixNano
+
+	for i, delay := range delays {
+		id, err := body.ids.next(body.IDFormat)
+		if err != nil {
+			return nil, err
+		}
+		samples[i] = batchSample{
+			dispatcherOutput:      dispatcherOutput{RunID: body.RunID, ID: id},
+			RequestedDelaySeconds: delay,
+		}
+		msgText, msgAttrs := encodeMessage(msgBody{
+			ID:                id,
+			SendUnixNano:      st.sendUnixNano,
+			SendStartUnixNano: st.sendStart,
+			RunID:             body.RunID,
+			Padding:           makePadding(body.MessageBodyBytes),
+			DispatcherVersion: dispatcherVersion,
+			RouteAttributes:   body.RouteAttributes,
+			EchoPadding:       body.EchoPadding,
+			WorkMs:            body.WorkMs,
+			CallbackQueueURL:  body.callbackQueueURL,
+		}, body.UltraMinimal)
+		if body.Compress {
+			rawBodySizes[i] = len(msgText)
+			msgText = compressBody(msgText)
+		}
+		bodySizes[i] = len(msgText)
+		groupID, dedupID := fifoParams(q.pushURL, messageGroupIDAt(body, i), id)
+		if body.FifoGroupCount > 0 {
+			samples[i].MessageGroupID = aws.ToString(groupID)
+		}
+		entries[i] = types.SendMessageBatchRequestEntry{
+			Id:                     aws.String(strconv.Itoa(i)),
+			MessageBody:            aws.String(msgText),
+			MessageAttributes:      signedAttributes(msgAttrs, msgText),
+			DelaySeconds:           int32(delay),
+			MessageGroupId:         groupID,
+			MessageDeduplicationId: dedupID,
+		}
+		placementAttributes(&entries[i], body.attributePlacement, body.traceHeader)
+		unregister := stash.register(body.RunID, id)
+		defer unregister()
+	}
+
+	budget := newRetryBudget(time.Duration(body.RetryBudgetMs) * time.Millisecond)
+	var out *sqs.SendMessageBatchOutput
+	_, err := budget.retrySend(ctx, func(ctx context.Context) error {
+		var err error
+		out, err = sqsClient.SendMessageBatch(ctx, &sqs.SendMessageBatchInput{QueueUrl: &q.pushURL, Entries: entries}, noSDKRetry)
+		return err
+	})
+	st.sendEnd = clock.now()
+	if err != nil {
+		return nil, fmt.Errorf("send message batch: %w", err)
+	}
+	noteSendOK()
+	failed := map[int]string{}
+	for _, f := range out.Failed {
+		i, _ := strconv.Atoi(aws.ToString(f.Id))
+		failed[i] = fmt.Sprintf("send message batch entry: %s: %s", aws.ToString(f.Code), aws.ToString(f.Message))
+	}
+
+	st.pollStart = clock.now()
+	var wg sync.WaitGroup
+	for i := range samples {
+		if msg, ok := failed[i]; ok {
+			samples[i].Status = "ERROR"
+			samples[i].Error = msg
+			continue
+		}
+		wg.Add(1)
+		go func(s *batchSample, bodySize, rawBodySize int) {
+			defer wg.Done()
+			po := pollOptions{waitSeconds: batchPollWaitSeconds, sendStart: st.sendStart, sendStartToleranceNs: body.SendStartToleranceNs, retry: budget, maxMessages: int32(body.BatchReceive), visibilityTimeout: body.visibilityTimeout}
+			if body.Debug {
+				po.debug = &pollDebug{}
+			}
+			pr, err := pollForCallback(ctx, q.receiveURLs, body.RunID, s.ID, po)
+			if err != nil {
+				_, s.Status = pollErrorStatus(err)
+				s.Error = err.Error()
+				s.ForeignGrabCount = pr.foreignGrabs
+				return
+			}
+			s.dispatcherOutput = newDispatcherOutput(body.RunID, s.ID, q, st, pr, inv)
+			s.BodyBytes, s.UncompressedBodyBytes = bodySize, rawBodySize
+			if po.debug != nil {
+				s.Debug = &debugInfo{Poll: po.debug, SQSHTTPProtocol: sqsProtocol.Load(), TLS: tlsHandshakes.snapshot()}
+			}
+			sendAckIfConfigured(ctx, clock, &s.dispatcherOutput)
+			if s.SqsFirstReceiveTimestampMs > 0 && s.SqsSentTimestampMs > 0 {
+				s.ObservedDelayMs = s.SqsFirstReceiveTimestampMs - s.SqsSentTimestampMs
+			}
+			s.Status = "OK"
+			if body.EchoPadding && !verifyEchoedPadding(&s.dispatcherOutput, makePadding(body.MessageBodyBytes), pr.cb.EchoedPadding) {
+				s.Status = statusPayloadMismatch
+			}
+			if body.PureForwardLeg {
+				applyPureForwardLeg(&s.dispatcherOutput)
+			}
+			if applyRegionCheck(&s.dispatcherOutput, body.RegionCheck) && s.Status == "OK" {
+				s.Status = statusRegionMismatch
+			}
+		}(&samples[i], bodySizes[i], rawBodySizes[i])
+	}
+	wg.Wait()
+	for i := range samples {
+		samples[i].RetryBudgetUsedMs = budget.usedMs()
+	}
+	return samples, nil
+}
+
+func summarizeHeadOfLine(samples []batchSample) *headOfLineSummary {
+	var delays []int64
+	var sum int64
+	for _, s := range samples {
+		if s.Status == "OK" {
+			delays = append(delays, s.HeadOfLineDelayMs)
+			sum += s.HeadOfLineDelayMs
+		}
+	}
+	if len(delays) == 0 {
+		return nil
+	}
+	sort.Slice(delays, func(i, j int) bool { return delays[i] < delays[j] })
+	// 最近秩（nearest-rank）百分位。
+	rank := func(p float64) int64 {
+		i := int(math.Ceil(p/100*float64(len(delays)))) - 1
+		return delays[clampInt(i, 0, len(delays)-1)]
+	}
+	return &headOfLineSummary{
+		Samples: len(delays),
+		AvgMs:   float64(sum) / float64(len(delays)),
+		P50Ms:   rank(50),
+		P90Ms:   rank(90),
+		MaxMs:   delays[len(delays)-1],
+	}
+}
+
+func summarizeInterArrival(samples []batchSample) *interArrivalSummary {
+	var matched []int64
+	for _, s := range samples {
+		if s.Status == "OK" && s.ReceiveMessageUnixNano > 0 {
+			matched = append(matched, s.ReceiveMessageUnixNano)
+		}
+	}
+	if len(matched) < 2 {
+		return nil
+	}
+	sort.Slice(matched, func(i, j int) bool { return matched[i] < matched[j] })
+	sum := &interArrivalSummary{Callbacks: len(matched)}
+	var total float64
+	for i := 1; i < len(matched); i++ {
+		d := float64(matched[i]-matched[i-1]) / float64(time.Millisecond)
+		if i == 1 || d < sum.MinMs {
+			sum.MinMs = d
+		}
+		if d > sum.MaxMs {
+			sum.MaxMs = d
+		}
+		total += d
+		sum.DeltasMs = append(sum.DeltasMs, d)
+	}
+	sum.MeanMs = total / float64(len(sum.DeltasMs))
+	return sum
+}
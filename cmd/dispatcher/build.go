@@ -0,0 +1,40 @@
+package main
+
+import (
+	"runtime"
+	"runtime/debug"
+)
+
+// 构建信息，由 -ldflags 注入（见 Dockerfile）：
+//
+//	-X main.buildCommit=<git sha> -X main.buildTime=<RFC3339>
+//
+// 未注入时退化为 Go 工具链记录的 VCS 信息（vcs.revision / vcs.time）。
+var (
+	buildCommit string
+	buildTime   string
+)
+
+// buildInfo：输出中的 build 段，用于把存档的测量结果追溯到确切的源码版本。
+type buildInfo struct {
+	Commit    string `json:"commit"`
+	Time      string `json:"time"`
+	GoVersion string `json:"goVersion"`
+}
+
+var currentBuild = readBuildInfo()
+
+func readBuildInfo() buildInfo {
+	b := buildInfo{Commit: buildCommit, Time: buildTime, GoVersion: runtime.Version()}
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, s := range info.Settings {
+			switch {
+			case s.Key == "vcs.revision" && b.Commit == "":
+				b.Commit = s.Value
+			case s.Key == "vcs.time" && b.Time == "":
+				b.Time = s.Value
+			}
+		}
+	}
+	return b
+}
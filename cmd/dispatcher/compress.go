@@ -0,0 +1,40 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// compressedBodyPrefix：压缩消息体的前缀。SQS 消息体只允许 Unicode 文本，gzip 结果以 base64 编码；
+// JSON 消息体总以 '{' 开头，不会与该前缀混淆。
+const compressedBodyPrefix = "gz1:"
+
+// compressBody：compress=true 时对消息体 gzip + base64 并加前缀，使大 padding 不超出 SQS 256KB 上限。
+func compressBody(text string) string {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	_, _ = zw.Write([]byte(text))
+	_ = zw.Close()
+	return compressedBodyPrefix + base64.StdEncoding.EncodeToString(buf.Bytes())
+}
+
+// decodeMessageBody：带前缀时解压，否则原样返回（与 Worker 的同名函数一致）。
+func decodeMessageBody(raw string) ([]byte, error) {
+	if !strings.HasPrefix(raw, compressedBodyPrefix) {
+		return []byte(raw), nil
+	}
+	z, err := base64.StdEncoding.DecodeString(raw[len(compressedBodyPrefix):])
+	if err != nil {
+		return nil, fmt.Errorf("decode compressed body: %w", err)
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(z))
+	if err != nil {
+		return nil, fmt.Errorf("gunzip body: %w", err)
+	}
+	defer zr.Close()
+	return io.ReadAll(zr)
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"hash/fnv"
+	"math/rand"
+	"sort"
+)
+
+const (
+	ciMethodBootstrap = "percentile-bootstrap"
+	// ciResamples：bootstrap 重采样次数；2000 次时 95% 区间端点的蒙特卡洛误差已远小于毫秒级的测量抖动。
+	ciResamples  = 2000
+	ciConfidence = 0.95
+	// ciMinSamples：少于该样本数时不输出区间（重采样几乎只能得到原样本本身）。
+	ciMinSamples = 5
+)
+
+// confidenceInterval：点估计与 [lower, upper] 区间（毫秒）。
+type confidenceInterval struct {
+	Estimate float64 `json:"estimate"`
+	Lower    float64 `json:"lower"`
+	Upper    float64 `json:"upper"`
+}
+
+// latencyCI：confidenceIntervals=true 时各阶段输出的均值与 p99 置信区间。
+type latencyCI struct {
+	Method     string             `json:"method"`
+	Samples    int                `json:"samples"`
+	Resamples  int                `json:"resamples"`
+	Confidence float64            `json:"confidence"`
+	Mean       confidenceInterval `json:"mean"`
+	P99        confidenceInterval `json:"p99"`
+}
+
+// bootstrapCI 用百分位 bootstrap 估计均值与 p99 的置信区间：从 v 中有放回地抽取 len(v) 个值，
+// 重复 ciResamples 次，每次计算均值与 p99（最近秩，与 newLatencyStats 口径一致），
+// 取这些统计量分布的 2.5% 与 97.5% 分位作为 95% 区间。不假设延迟服从正态分布（延迟通常右偏），
+// 但 p99 依赖最慢的少数样本：样本数远小于 100 时 p99 区间很宽且偏向样本最大值，比较两次运行时应以均值区间为主。
+// 随机源以 runId 为种子，同一份结果重复计算得到相同的区间。
+func bootstrapCI(v []float64, runID string) *latencyCI {
+	if len(v) < ciMinSamples {
+		return nil
+	}
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(runID))
+	rng := rand.New(rand.NewSource(int64(h.Sum64())))
+
+	means := make([]float64, ciResamples)
+	p99s := make([]float64, ciResamples)
+	resample := make([]float64, len(v))
+	for b := range ciResamples {
+		var sum float64
+		for i := range resample {
+			resample[i] = v[rng.Intn(len(v))]
+			sum += resample[i]
+		}
+		sort.Float64s(resample)
+		means[b] = sum / float64(len(resample))
+		p99s[b] = nearestRank(resample, 99)
+	}
+	sort.Float64s(means)
+	sort.Float64s(p99s)
+
+	point := newLatencyStats(v)
+	tail := (1 - ciConfidence) / 2 * 100
+	return &latencyCI{
+		Method:     ciMethodBootstrap,
+		Samples:    len(v),
+		Resamples:  ciResamples,
+		Confidence: ciConfidence,
+		Mean:       confidenceInterval{Estimate: point.Mean, Lower: nearestRank(means, tail), Upper: nearestRank(means, 100-tail)},
+		P99:        confidenceInterval{Estimate: point.P99, Lower: nearestRank(p99s, tail), Upper: nearestRank(p99s, 100-tail)},
+	}
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+// failModeAlwaysError：Worker 始终处理失败，消息经 maxReceiveCount 次重投后转入 DLQ。
+const failModeAlwaysError = "always-error"
+
+// dlqOutput：DLQ 转移耗时探测的输出。
+type dlqOutput struct {
+	RunID         string `json:"runId"`
+	ID            string `json:"id"`
+	Region        string `json:"region"`
+	PushQueueName string `json:"pushQueueName"`
+	DlqQueueName  string `json:"dlqQueueName"`
+
+	SendStartUnixNano  int64 `json:"sendStartUnixNano"`
+	SendEndUnixNano    int64 `json:"sendEndUnixNano"`
+	DlqReceiveUnixNano int64 `json:"dlqReceiveUnixNano"`
+
+	// sendEnd -> 在 DLQ 中收到该消息（Dispatcher 本地时钟），即重投 + 转移的总耗时上界。
+	DlqArrivalMs int64 `json:"dlqArrivalMs"`
+	// DLQ 消息上的 ApproximateReceiveCount（转移时保留源队列的计数，DLQ 上本次接收会再 +1）。
+	DlqReceiveCount    int64 `json:"dlqReceiveCount"`
+	SqsSentTimestampMs int64 `json:"sqsSentTimestampMs"`
+}
+
+// handleDLQProbe 发送一条 failMode=always-error 的消息，并轮询 DLQ_URL 等待它被转移过来。
+func handleDLQProbe(ctx context.Context, body apiRequest, q queueTargets) (events.APIGatewayProxyResponse, error) {
+	dlqURL := strings.TrimSpace(os.Getenv("DLQ_URL"))
+	if dlqURL == "" {
+		return jsonResp(500, apiResponse{Status: "ERROR", Error: "missing env DLQ_URL"})
+	}
+
+	messageID := newMessageID(body.IDFormat)
+	dispatchStart := time.Now().UnixNano()
+	clock := startSendClock()
+	bodyBytes := msgBody{
+		ID:                messageID,
+		SendUnixNano:      clock.baseUnixNano,
+		SendStartUnixNano: clock.baseUnixNano,
+		RunID:             body.RunID,
+		FailMode:          body.FailMode,
+	}.marshal()
+	q = q.forSend()
+	groupID, dedupID := fifoParams(q.pushURL, messageGroupID(body), messageID)
+	err := pushMessage(ctx, &sqs.SendMessageInput{
+		QueueUrl:               &q.pushURL,
+		MessageBody:            awsString(string(bodyBytes)),
+		MessageGroupId:         groupID,
+		MessageDeduplicationId: dedupID,
+	}, body.RunID, messageID)
+	sendEnd := clock.now()
+	if err != nil {
+		return sendErrorResp(fmt.Errorf("send message: %w", err))
+	}
+
+	m, recvNano, err := pollDLQ(ctx, dlqURL, body.RunID, messageID)
+	if err != nil {
+		elapsedNs := time.Now().UnixNano() - dispatchStart
+		elapsed := elapsedNs / int64(time.Millisecond)
+		code, status := pollErrorStatus(err)
+		return jsonResp(code, apiResponse{Status: status, TotalMs: elapsed, TotalUs: nanosToUs(elapsedNs), Error: err.Error()})
+	}
+
+	outBytes, _ := json.Marshal(dlqOutput{
+		RunID:              body.RunID,
+		ID:                 messageID,
+		Region:             awsCfg.Region,
+		PushQueueName:      q.pushName,
+		DlqQueueName:       queueNameFromURL(dlqURL),
+		SendStartUnixNano:  clock.baseUnixNano,
+		SendEndUnixNano:    sendEnd,
+		DlqReceiveUnixNano: recvNano,
+		DlqArrivalMs:       (recvNano - sendEnd) / int64(time.Millisecond),
+		DlqReceiveCount:    parseInt64OrZero(m.Attributes[string(types.MessageSystemAttributeNameApproximateReceiveCount)]),
+		SqsSentTimestampMs: parseInt64OrZero(m.Attributes[string(types.MessageSystemAttributeNameSentTimestamp)]),
+	})
+	elapsedNs := time.Now().UnixNano() - dispatchStart
+	elapsedMs := elapsedNs / int64(time.Millisecond)
+	results.put(storedResult{RunID: body.RunID, ID: messageID, StoredAt: time.Now(), TotalMs: elapsedMs, Output: outBytes})
+
+	outBytes, err = formatOutput(outBytes, body.OutputFormat)
+	if err != nil {
+		return jsonResp(500, apiResponse{Status: "ERROR", Error: err.Error()})
+	}
+	return jsonResp(200, apiResponse{Status: "OK", TotalMs: elapsedMs, TotalUs: nanosToUs(elapsedNs), Output: outBytes})
+}
+
+// dlqPollMaxMessages：DLQ 中可能积压其他运行的死信，每次 ReceiveMessage 取满一批再逐条比较。
+const dlqPollMaxMessages = 10
+
+// pollDLQ 在 DLQ 中等待本次发送的原始请求消息（msgBody），匹配后删除；其他消息用一次
+// ChangeMessageVisibilityBatch 立即释放。长轮询时长与可见性超时按 fitPollToDeadline 收缩到剩余截止时间。
+func pollDLQ(ctx context.Context, dlqURL string, runID string, id string) (types.Message, int64, error) {
+	for {
+		if ctx.Err() != nil {
+			return types.Message{}, 0, ctx.Err()
+		}
+		wait, visibility := fitPollToDeadline(ctx, maxPollWaitSeconds, pollVisibilityTimeoutSeconds)
+		out, err := sqsClient.ReceiveMessage(ctx, &sqs.ReceiveMessageInput{
+			QueueUrl:            &dlqURL,
+			MaxNumberOfMessages: dlqPollMaxMessages,
+			WaitTimeSeconds:     wait,
+			VisibilityTimeout:   visibility,
+			MessageSystemAttributeNames: []types.MessageSystemAttributeName{
+				types.MessageSystemAttributeNameApproximateReceiveCount,
+				types.MessageSystemAttributeNameSentTimestamp,
+			},
+		})
+		if err != nil {
+			if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) {
+				return types.Message{}, 0, err
+			}
+			return types.Message{}, 0, fmt.Errorf("receive dlq message: %w", err)
+		}
+		recvNano := time.Now().UnixNano()
+
+		var (
+			matched types.Message
+			found   bool
+			release []types.ChangeMessageVisibilityBatchRequestEntry
+		)
+		for _, m := range out.Messages {
+			var mb msgBody
+			if m.Body != nil {
+				_ = json.Unmarshal([]byte(*m.Body), &mb)
+			}
+			if !found && mb.RunID == runID && mb.ID == id {
+				if m.ReceiptHandle != nil {
+					_, _ = sqsClient.DeleteMessage(ctx, &sqs.DeleteMessageInput{QueueUrl: &dlqURL, ReceiptHandle: m.ReceiptHandle})
+				}
+				matched, found = m, true
+				continue
+			}
+			if m.ReceiptHandle != nil {
+				release = append(release, types.ChangeMessageVisibilityBatchRequestEntry{
+					Id:                aws.String(strconv.Itoa(len(release))),
+					ReceiptHandle:     m.ReceiptHandle,
+					VisibilityTimeout: 0,
+				})
+			}
+		}
+		if len(release) > 0 {
+			_, _ = sqsClient.ChangeMessageVisibilityBatch(ctx, &sqs.ChangeMessageVisibilityBatchInput{QueueUrl: &dlqURL, Entries: release})
+		}
+		switch {
+		case found:
+			return matched, recvNano, nil
+		case len(release) > 0:
+			time.Sleep(20 * time.Millisecond)
+		case wait == 0 && len(out.Messages) == 0:
+			sleepShortPoll(ctx)
+		}
+	}
+}
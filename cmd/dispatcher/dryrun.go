@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"github.com/aws/smithy-go"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+)
+
+const (
+	dryRunErrorAccessDenied = "access_denied"
+	dryRunErrorNotFound     = "not_found"
+	dryRunErrorOther        = "other"
+)
+
+// dryRunOutput：dryRun 的输出，只调用 GetQueueAttributes，不发送也不轮询。
+type dryRunOutput struct {
+	Region string        `json:"region"`
+	Queues []dryRunQueue `json:"queues"`
+}
+
+// dryRunQueue：一个 Push/Receive 队列的可达性与属性；errorKind 区分权限不足（access_denied）、
+// 队列不存在（not_found）与其他错误（other）。
+type dryRunQueue struct {
+	Role                                  string `json:"role"`
+	QueueName                             string `json:"queueName"`
+	QueueArn                              string `json:"queueArn,omitempty"`
+	ApproximateNumberOfMessages           int    `json:"approximateNumberOfMessages"`
+	ApproximateNumberOfMessagesNotVisible int    `json:"approximateNumberOfMessagesNotVisible"`
+	Error                                 string `json:"error,omitempty"`
+	ErrorKind                             string `json:"errorKind,omitempty"`
+}
+
+// isAccessDenied：IAM 拒绝或凭证无效（HTTP 403）。
+func isAccessDenied(err error) bool {
+	var respErr *smithyhttp.ResponseError
+	if errors.As(err, &respErr) && respErr.HTTPStatusCode() == 403 {
+		return true
+	}
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) {
+		code := apiErr.ErrorCode()
+		return strings.Contains(code, "AccessDenied") || code == "InvalidClientTokenId" || code == "UnrecognizedClientException"
+	}
+	return false
+}
+
+// handleDryRun 对所有 Push 与 Receive 队列调用 GetQueueAttributes，确认可达与权限，不发送、不轮询、不写入结果存储。
+// 有队列权限不足时返回 403，队列不存在时返回 404（两者都有时 403 优先），其他错误 502。
+func handleDryRun(ctx context.Context, pushURLs, receiveURLs []string) (events.APIGatewayProxyResponse, error) {
+	out := dryRunOutput{Region: awsCfg.Region}
+	check := func(role, queueURL string) {
+		dq := dryRunQueue{Role: role, QueueName: queueNameFromURL(queueURL)}
+		res, err := sqsClient.GetQueueAttributes(ctx, &sqs.GetQueueAttributesInput{
+			QueueUrl: &queueURL,
+			AttributeNames: []types.QueueAttributeName{
+				types.QueueAttributeNameQueueArn,
+				types.QueueAttributeNameApproximateNumberOfMessages,
+				types.QueueAttributeNameApproximateNumberOfMessagesNotVisible,
+			},
+		})
+		switch {
+		case err == nil:
+			dq.QueueArn = res.Attributes[string(types.QueueAttributeNameQueueArn)]
+			dq.ApproximateNumberOfMessages, _ = strconv.Atoi(res.Attributes[string(types.QueueAttributeNameApproximateNumberOfMessages)])
+			dq.ApproximateNumberOfMessagesNotVisible, _ = strconv.Atoi(res.Attributes[string(types.QueueAttributeNameApproximateNumberOfMessagesNotVisible)])
+		case isAccessDenied(err):
+			dq.Error, dq.ErrorKind = err.Error(), dryRunErrorAccessDenied
+		case isQueueDoesNotExist(err):
+			dq.Error, dq.ErrorKind = err.Error(), dryRunErrorNotFound
+		default:
+			dq.Error, dq.ErrorKind = err.Error(), dryRunErrorOther
+		}
+		out.Queues = append(out.Queues, dq)
+	}
+	for _, u := range pushURLs {
+		check("push", u)
+	}
+	for _, u := range receiveURLs {
+		check("receive", u)
+	}
+
+	code, status := 200, "OK"
+	kinds := map[string]bool{}
+	for _, dq := range out.Queues {
+		kinds[dq.ErrorKind] = true
+	}
+	switch {
+	case kinds[dryRunErrorAccessDenied]:
+		code, status = 403, "ACCESS_DENIED"
+	case kinds[dryRunErrorNotFound]:
+		code, status = 404, "NOT_FOUND"
+	case kinds[dryRunErrorOther]:
+		code, status = 502, "ERROR"
+	}
+	outBytes, _ := json.Marshal(out)
+	return jsonResp(code, apiResponse{Status: status, Output: outBytes})
+}
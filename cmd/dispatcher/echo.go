@@ -0,0 +1,40 @@
+package main
+
+import "fmt"
+
+// maxEchoPaddingBytes：echoPadding 只允许小负载，避免回调把流量翻倍。
+const maxEchoPaddingBytes = 4096
+
+const statusPayloadMismatch = "PAYLOAD_MISMATCH"
+
+func validateEchoPadding(body apiRequest) error {
+	if body.EchoPadding && body.MessageBodyBytes > maxEchoPaddingBytes {
+		return fmt.Errorf("echoPadding requires messageBodyBytes <= %d, got %d", maxEchoPaddingBytes, body.MessageBodyBytes)
+	}
+	return nil
+}
+
+// firstDiff 返回两段内容第一个不同字节的偏移；完全相同时返回 -1。长度不同且前缀相同时返回较短者的长度。
+func firstDiff(sent, echoed string) int {
+	n := min(len(sent), len(echoed))
+	for i := 0; i < n; i++ {
+		if sent[i] != echoed[i] {
+			return i
+		}
+	}
+	if len(sent) != len(echoed) {
+		return n
+	}
+	return -1
+}
+
+// verifyEchoedPadding 把 Worker 回显的 padding 与发送值逐字节比较，结果写入 out。
+func verifyEchoedPadding(out *dispatcherOutput, sent, echoed string) bool {
+	off := firstDiff(sent, echoed)
+	ok := off < 0
+	out.PaddingVerified = &ok
+	if !ok {
+		out.PaddingMismatchOffset = &off
+	}
+	return ok
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+const defaultMetricsNamespace = "TestFastServerless"
+
+// emfOut：EMF 日志行的输出目标（stdout 即 CloudWatch Logs），测试中替换。
+var emfOut io.Writer = os.Stdout
+
+// emfEnabled：ENABLE_EMF=1/true 时每个请求结束后输出一行 Embedded Metric Format。
+func emfEnabled() bool {
+	switch strings.ToLower(strings.TrimSpace(os.Getenv("ENABLE_EMF"))) {
+	case "1", "true":
+		return true
+	}
+	return false
+}
+
+// metricsNamespace：METRICS_NAMESPACE，缺省 TestFastServerless。
+func metricsNamespace() string {
+	if v := strings.TrimSpace(os.Getenv("METRICS_NAMESPACE")); v != "" {
+		return v
+	}
+	return defaultMetricsNamespace
+}
+
+// emfMetric 见 https://docs.aws.amazon.com/AmazonCloudWatch/latest/monitoring/CloudWatch_Embedded_Metric_Format_Specification.html
+type emfMetric struct {
+	Name string `json:"Name"`
+	Unit string `json:"Unit"`
+}
+
+type emfDirective struct {
+	Namespace  string      `json:"Namespace"`
+	Dimensions [][]string  `json:"Dimensions"`
+	Metrics    []emfMetric `json:"Metrics"`
+}
+
+type emfMetadata struct {
+	Timestamp         int64          `json:"Timestamp"`
+	CloudWatchMetrics []emfDirective `json:"CloudWatchMetrics"`
+}
+
+// writeEMF 把 metrics（毫秒）与 dims 写成一行 EMF JSON；json.Marshal 不产生换行，CloudWatch 按行自动提取。
+// props 为不作为维度的附加字段（如 runId），便于 Logs Insights 关联到请求。
+func writeEMF(dims map[string]string, metrics map[string]float64, props map[string]string) {
+	doc := make(map[string]any, len(dims)+len(metrics)+len(props)+1)
+	dimNames := make([]string, 0, len(dims))
+	for _, k := range []string{"Region", "PushQueueName"} {
+		if v, ok := dims[k]; ok {
+			dimNames = append(dimNames, k)
+			doc[k] = v
+		}
+	}
+	defs := make([]emfMetric, 0, len(metrics))
+	for _, k := range []string{"EndToEndMs", "QueueToWorkerMs", "WorkerProcessingMs"} {
+		if v, ok := metrics[k]; ok {
+			defs = append(defs, emfMetric{Name: k, Unit: "Milliseconds"})
+			doc[k] = v
+		}
+	}
+	for k, v := range props {
+		doc[k] = v
+	}
+	doc["_aws"] = emfMetadata{
+		Timestamp:         time.Now().UnixMilli(),
+		CloudWatchMetrics: []emfDirective{{Namespace: metricsNamespace(), Dimensions: [][]string{dimNames}, Metrics: defs}},
+	}
+	b, err := json.Marshal(doc)
+	if err != nil {
+		return
+	}
+	fmt.Fprintln(emfOut, string(b))
+}
+
+// emitRequestMetrics：单条往返成功（输出中有 Worker 时间戳）时输出 EndToEndMs（receiveMessage - sendStart）、
+// QueueToWorkerMs（workerReceive - sendEnd，跨时钟）与 WorkerProcessingMs（workerDone - workerReceive）。
+// 批量类模式、探测与失败的请求不输出。
+func emitRequestMetrics(resp events.APIGatewayProxyResponse) {
+	if !emfEnabled() {
+		return
+	}
+	var r struct {
+		Status string          `json:"status"`
+		Output json.RawMessage `json:"output"`
+	}
+	if json.Unmarshal([]byte(resp.Body), &r) != nil || r.Status != "OK" {
+		return
+	}
+	var out struct {
+		RunID                  string `json:"runId"`
+		Probe                  bool   `json:"probe"`
+		Region                 string `json:"region"`
+		PushQueueName          string `json:"pushQueueName"`
+		SendStartUnixNano      int64  `json:"sendStartUnixNano"`
+		SendEndUnixNano        int64  `json:"sendEndUnixNano"`
+		WorkerReceiveUnixNano  int64  `json:"workerReceiveUnixNano"`
+		WorkerDoneUnixNano     int64  `json:"workerDoneUnixNano"`
+		ReceiveMessageUnixNano int64  `json:"receiveMessageUnixNano"`
+	}
+	if json.Unmarshal(r.Output, &out) != nil || out.Probe || out.WorkerReceiveUnixNano == 0 || out.ReceiveMessageUnixNano == 0 {
+		return
+	}
+	region := out.Region
+	if region == "" {
+		region = os.Getenv("AWS_REGION")
+	}
+	writeEMF(
+		map[string]string{"Region": region, "PushQueueName": out.PushQueueName},
+		map[string]float64{
+			"EndToEndMs":         nanosToMs(out.ReceiveMessageUnixNano - out.SendStartUnixNano),
+			"QueueToWorkerMs":    nanosToMs(out.WorkerReceiveUnixNano - out.SendEndUnixNano),
+			"WorkerProcessingMs": nanosToMs(out.WorkerDoneUnixNano - out.WorkerReceiveUnixNano),
+		},
+		map[string]string{"runId": out.RunID},
+	)
+}
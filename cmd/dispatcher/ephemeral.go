@@ -0,0 +1,245 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	lambdasvc "github.com/aws/aws-sdk-go-v2/service/lambda"
+	lambdatypes "github.com/aws/aws-sdk-go-v2/service/lambda/types"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+const (
+	// ephemeralQueuePrefix：临时队列的名称前缀；模板中 Dispatcher/Worker 的权限与 Worker 对 callbackQueueUrl 的校验都以它为界。
+	ephemeralQueuePrefix = "TestFastServerlessEphemeral-"
+	// ephemeralSetupTimeout：建队列与等待事件源映射变为 Enabled 的上限。
+	ephemeralSetupTimeout = 15 * time.Second
+	// ephemeralTeardownReserve：为拆除预留的时间，测量阶段的截止时间相应提前。
+	ephemeralTeardownReserve = 5 * time.Second
+	// ephemeralESMPollInterval：查询事件源映射状态的间隔。
+	ephemeralESMPollInterval = 500 * time.Millisecond
+	// ephemeralRetentionSeconds：临时队列的消息保留期（SQS 下限 60s），拆除失败时残留消息也会很快过期。
+	ephemeralRetentionSeconds = "60"
+)
+
+// lambdaESMAPI：ephemeral 模式用到的事件源映射调用，测试中替换为 mock。
+type lambdaESMAPI interface {
+	CreateEventSourceMapping(ctx context.Context, params *lambdasvc.CreateEventSourceMappingInput, optFns ...func(*lambdasvc.Options)) (*lambdasvc.CreateEventSourceMappingOutput, error)
+	GetEventSourceMapping(ctx context.Context, params *lambdasvc.GetEventSourceMappingInput, optFns ...func(*lambdasvc.Options)) (*lambdasvc.GetEventSourceMappingOutput, error)
+	DeleteEventSourceMapping(ctx context.Context, params *lambdasvc.DeleteEventSourceMappingInput, optFns ...func(*lambdasvc.Options)) (*lambdasvc.DeleteEventSourceMappingOutput, error)
+}
+
+// lambdaClient：initAWS 只在配置了 WORKER_FUNCTION_NAME 时创建。
+var lambdaClient lambdaESMAPI
+
+// ephemeralOutput：ephemeral 模式的输出。setupMs/teardownMs 单独计时，不计入样本。
+type ephemeralOutput struct {
+	RunID                  string        `json:"runId"`
+	PushQueueName          string        `json:"pushQueueName,omitempty"`
+	ReceiveQueueName       string        `json:"receiveQueueName,omitempty"`
+	WorkerFunction         string        `json:"workerFunction"`
+	EventSourceMappingUUID string        `json:"eventSourceMappingUuid,omitempty"`
+	SetupMs                float64       `json:"setupMs"`
+	BenchmarkMs            float64       `json:"benchmarkMs"`
+	TeardownMs             float64       `json:"teardownMs"`
+	SetupError             string        `json:"setupError,omitempty"`
+	BenchmarkError         string        `json:"benchmarkError,omitempty"`
+	TeardownErrors         []string      `json:"teardownErrors,omitempty"`
+	Samples                []batchSample `json:"samples,omitempty"`
+}
+
+// ephemeralPipeline：本次请求创建的资源；teardown 只删除已创建的部分，可重复调用。
+type ephemeralPipeline struct {
+	pushURL, receiveURL string
+	esmUUID             string
+	tornDown            bool
+	teardownErrs        []string
+}
+
+func validateEphemeral(body apiRequest) error {
+	if !body.Ephemeral {
+		return nil
+	}
+	if strings.TrimSpace(os.Getenv("WORKER_FUNCTION_NAME")) == "" {
+		return errors.New("ephemeral requires env WORKER_FUNCTION_NAME")
+	}
+	if minWait := 2 * ephemeralTeardownReserve; body.MaxWaitMs > 0 && time.Duration(body.MaxWaitMs)*time.Millisecond < minWait {
+		return fmt.Errorf("ephemeral requires maxWaitMs >= %d (teardown reserves %s)", minWait.Milliseconds(), ephemeralTeardownReserve)
+	}
+	if body.RampMaxConcurrency > 0 || body.CompareAttributes > 0 || body.OneWay || body.FailMode != "" || body.Iterations > 1 ||
+		len(body.VisibilitySweep) > 0 || body.FifoGroupCount > 0 || body.FifoBlocking > 0 || body.PurgeReceiveQueue || body.UltraMinimal {
+		return errors.New("ephemeral cannot be combined with rampMaxConcurrency, compareAttributes, oneWay, failMode, iterations, visibilitySweep, fifoGroupCount, fifoBlocking, purgeReceiveQueue or ultraMinimal")
+	}
+	return nil
+}
+
+// handleEphemeral 创建临时 Push/Receive 队列并把 Worker 函数订阅到临时 Push 队列，按 batchDelaySeconds（缺省为一条 delaySeconds）
+// 跑一次测量后拆除全部资源再返回。Worker 按消息中的 callbackQueueUrl 把回调发往临时 Receive 队列。
+// 任何退出路径（建资源失败、测量超时、panic）都会拆除已创建的资源；拆除使用独立的超时，不受 callCtx 到期影响。
+func handleEphemeral(ctx context.Context, body apiRequest, inv invocationInfo) (events.APIGatewayProxyResponse, error) {
+	dispatchStart := time.Now().UnixNano()
+	fn := strings.TrimSpace(os.Getenv("WORKER_FUNCTION_NAME"))
+	out := ephemeralOutput{RunID: body.RunID, WorkerFunction: fn}
+	p := &ephemeralPipeline{}
+	defer p.teardown(ctx)
+
+	benchCtx := ctx
+	if deadline, ok := ctx.Deadline(); ok {
+		var cancel context.CancelFunc
+		benchCtx, cancel = context.WithDeadline(ctx, deadline.Add(-ephemeralTeardownReserve))
+		defer cancel()
+	}
+
+	setupStart := time.Now()
+	err := p.setup(benchCtx, body.RunID, fn)
+	out.SetupMs = float64(time.Since(setupStart)) / float64(time.Millisecond)
+	out.EventSourceMappingUUID = p.esmUUID
+	if p.pushURL != "" {
+		out.PushQueueName = queueNameFromURL(p.pushURL)
+	}
+	if p.receiveURL != "" {
+		out.ReceiveQueueName = queueNameFromURL(p.receiveURL)
+	}
+
+	code, status := 200, "OK"
+	if err != nil {
+		out.SetupError = err.Error()
+		code, status = 502, "ERROR"
+		if benchCtx.Err() != nil {
+			code, status = 504, "TIMEOUT"
+		}
+	} else {
+		q := queueTargets{pushURL: p.pushURL, receiveURLs: []string{p.receiveURL}, pushName: out.PushQueueName, receiveName: out.ReceiveQueueName}
+		body.callbackQueueURL = p.receiveURL
+		delays := body.BatchDelaySeconds
+		if len(delays) == 0 {
+			delays = []int{body.DelaySeconds}
+		}
+		benchStart := time.Now()
+		out.Samples, err = sendBatch(benchCtx, body, q, inv, sendTimes{dispatchStart: benchStart.UnixNano()}, delays)
+		out.BenchmarkMs = float64(time.Since(benchStart)) / float64(time.Millisecond)
+		code, status = ephemeralStatus(out.Samples, err)
+		if err != nil {
+			out.BenchmarkError = err.Error()
+		}
+	}
+
+	teardownStart := time.Now()
+	out.TeardownErrors = p.teardown(ctx)
+	out.TeardownMs = float64(time.Since(teardownStart)) / float64(time.Millisecond)
+	if len(out.TeardownErrors) > 0 && code == 200 {
+		status = "PARTIAL"
+	}
+
+	outBytes, _ := json.Marshal(out)
+	elapsedNs := time.Now().UnixNano() - dispatchStart
+	elapsedMs := elapsedNs / int64(time.Millisecond)
+	results.put(storedResult{RunID: body.RunID, StoredAt: time.Now(), TotalMs: elapsedMs, Output: outBytes})
+	outBytes, upload := persistResult(ctx, body.resultS3, body.RunID, outBytes, nil)
+	outBytes, err = formatOutput(outBytes, body.OutputFormat)
+	if err != nil {
+		return jsonResp(500, apiResponse{Status: "ERROR", Error: err.Error()})
+	}
+	apiOut := apiResponse{Status: status, TotalMs: elapsedMs, TotalUs: nanosToUs(elapsedNs), Output: outBytes, PerceivedLatencyMs: perceivedLatencyMs(body.requestTimeEpochMs)}
+	upload.apply(&apiOut)
+	return jsonResp(code, apiOut)
+}
+
+// ephemeralStatus：发送失败为 502，否则按全部样本判定，与 handleBatch 一致（见 batchStatus）。
+func ephemeralStatus(samples []batchSample, err error) (int, string) {
+	if err != nil {
+		return 502, "ERROR"
+	}
+	return batchStatus(samples)
+}
+
+// setup：建两个临时队列，再为 Worker 函数创建事件源映射并等待 Enabled。
+func (p *ephemeralPipeline) setup(ctx context.Context, runID, fn string) error {
+	ctx, cancel := context.WithTimeout(ctx, ephemeralSetupTimeout)
+	defer cancel()
+
+	suffix := randHex(6)
+	var err error
+	if p.pushURL, err = createEphemeralQueue(ctx, ephemeralQueuePrefix+"push-"+suffix); err != nil {
+		return err
+	}
+	if p.receiveURL, err = createEphemeralQueue(ctx, ephemeralQueuePrefix+"receive-"+suffix); err != nil {
+		return err
+	}
+	attrs, err := sqsClient.GetQueueAttributes(ctx, &sqs.GetQueueAttributesInput{QueueUrl: &p.pushURL, AttributeNames: []types.QueueAttributeName{types.QueueAttributeNameQueueArn}})
+	if err != nil {
+		return fmt.Errorf("get ephemeral queue arn: %w", err)
+	}
+	esm, err := lambdaClient.CreateEventSourceMapping(ctx, &lambdasvc.CreateEventSourceMappingInput{
+		EventSourceArn:                 aws.String(attrs.Attributes[string(types.QueueAttributeNameQueueArn)]),
+		FunctionName:                   aws.String(fn),
+		BatchSize:                      aws.Int32(1),
+		MaximumBatchingWindowInSeconds: aws.Int32(0),
+		FunctionResponseTypes:          []lambdatypes.FunctionResponseType{lambdatypes.FunctionResponseTypeReportBatchItemFailures},
+		Enabled:                        aws.Bool(true),
+	})
+	if err != nil {
+		return fmt.Errorf("create event source mapping (runId=%s): %w", runID, err)
+	}
+	p.esmUUID = aws.ToString(esm.UUID)
+	state := aws.ToString(esm.State)
+	for state != "Enabled" {
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("event source mapping %s not enabled (state=%s): %w", p.esmUUID, state, ctx.Err())
+		case <-time.After(ephemeralESMPollInterval):
+		}
+		got, err := lambdaClient.GetEventSourceMapping(ctx, &lambdasvc.GetEventSourceMappingInput{UUID: aws.String(p.esmUUID)})
+		if err != nil {
+			return fmt.Errorf("get event source mapping: %w", err)
+		}
+		state = aws.ToString(got.State)
+	}
+	return nil
+}
+
+// teardown 先删事件源映射（停止 Worker 继续轮询），再删两个队列；返回各步骤的错误。
+func (p *ephemeralPipeline) teardown(ctx context.Context) []string {
+	if p.tornDown {
+		return p.teardownErrs
+	}
+	p.tornDown = true
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), ephemeralTeardownReserve)
+	defer cancel()
+	if p.esmUUID != "" {
+		if _, err := lambdaClient.DeleteEventSourceMapping(ctx, &lambdasvc.DeleteEventSourceMappingInput{UUID: aws.String(p.esmUUID)}); err != nil {
+			p.teardownErrs = append(p.teardownErrs, fmt.Sprintf("delete event source mapping %s: %v", p.esmUUID, err))
+		}
+	}
+	for _, u := range []string{p.pushURL, p.receiveURL} {
+		if u == "" {
+			continue
+		}
+		if _, err := sqsClient.DeleteQueue(ctx, &sqs.DeleteQueueInput{QueueUrl: aws.String(u)}); err != nil {
+			p.teardownErrs = append(p.teardownErrs, fmt.Sprintf("delete queue %s: %v", queueNameFromURL(u), err))
+		}
+	}
+	return p.teardownErrs
+}
+
+func createEphemeralQueue(ctx context.Context, name string) (string, error) {
+	out, err := sqsClient.CreateQueue(ctx, &sqs.CreateQueueInput{
+		QueueName: &name,
+		Attributes: map[string]string{
+			string(types.QueueAttributeNameVisibilityTimeout):      "30",
+			string(types.QueueAttributeNameMessageRetentionPeriod): ephemeralRetentionSeconds,
+		},
+	})
+	if err != nil {
+		return "", fmt.Errorf("create ephemeral queue %s: %w", name, err)
+	}
+	return aws.ToString(out.QueueUrl), nil
+}
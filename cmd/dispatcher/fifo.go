@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+// isFIFOQueue：队列名以 .fifo 结尾即为 FIFO 队列。
+func isFIFOQueue(queueURL string) bool {
+	return strings.HasSuffix(queueNameFromURL(queueURL), ".fifo")
+}
+
+// fifoParams：FIFO 队列需要的 MessageGroupId/MessageDeduplicationId；标准队列返回 nil（不设置，行为不变）。
+func fifoParams(queueURL, groupID, dedupID string) (*string, *string) {
+	if !isFIFOQueue(queueURL) {
+		return nil, nil
+	}
+	return aws.String(groupID), aws.String(dedupID)
+}
+
+// messageGroupID：Push 消息的分组，默认取 runId（同一 runId 的消息保持顺序），可用 messageGroupId 覆盖。
+func messageGroupID(body apiRequest) string {
+	if g := strings.TrimSpace(body.MessageGroupId); g != "" {
+		return g
+	}
+	return body.RunID
+}
+
+// messageGroupIDAt：fifoGroupCount > 0 时第 i 条消息轮流落在 <group>-g0..g(N-1) 中，用于高吞吐 FIFO 实验。
+func messageGroupIDAt(body apiRequest, i int) string {
+	if body.FifoGroupCount <= 0 {
+		return messageGroupID(body)
+	}
+	return fmt.Sprintf("%s-g%d", messageGroupID(body), i%body.FifoGroupCount)
+}
+
+// validateFIFO：FIFO 队列不支持逐条 DelaySeconds（只能在队列级别配置）；fifoGroupCount 只用于 FIFO Push 队列的批量模式。
+func validateFIFO(body apiRequest, pushQueueURLs []string) error {
+	fifo := false
+	for _, u := range pushQueueURLs {
+		fifo = fifo || isFIFOQueue(u)
+	}
+	if body.FifoGroupCount != 0 {
+		switch {
+		case !fifo:
+			return fmt.Errorf("fifoGroupCount requires a FIFO (.fifo) push queue")
+		case body.FifoGroupCount < 1 || body.FifoGroupCount > maxBatchEntries:
+			return fmt.Errorf("fifoGroupCount must be 1..%d, got %d", maxBatchEntries, body.FifoGroupCount)
+		case len(body.BatchDelaySeconds) == 0:
+			return fmt.Errorf("fifoGroupCount requires batchDelaySeconds (one entry per message)")
+		}
+	}
+	if !fifo {
+		return nil
+	}
+	if body.DelaySeconds > 0 {
+		return fmt.Errorf("delaySeconds is not supported on FIFO push queues")
+	}
+	for _, d := range body.BatchDelaySeconds {
+		if d > 0 {
+			return fmt.Errorf("batchDelaySeconds must be 0 on FIFO push queues")
+		}
+	}
+	return nil
+}
+
+// fifoGroupsSummary：fifoGroupCount 模式下的分组分布与逐组延迟（receiveMessageUnixNano - sendStartUnixNano）。
+type fifoGroupsSummary struct {
+	GroupCount int `json:"groupCount"`
+	// HighThroughput：Push 队列是否配置为高吞吐 FIFO（DeduplicationScope=messageGroup 且 FifoThroughputLimit=perMessageGroupId）；
+	// 读取队列属性失败时省略，并在 queueConfigError 中给出原因。
+	HighThroughput   *bool            `json:"highThroughput,omitempty"`
+	QueueConfigError string           `json:"queueConfigError,omitempty"`
+	Groups           []fifoGroupStats `json:"groups"`
+}
+
+type fifoGroupStats struct {
+	MessageGroupID string  `json:"messageGroupId"`
+	Samples        int     `json:"samples"`
+	OK             int     `json:"ok"`
+	P50Ms          float64 `json:"p50Ms"`
+	P99Ms          float64 `json:"p99Ms"`
+	MeanMs         float64 `json:"meanMs"`
+}
+
+// summarizeFIFOGroups 按 messageGroupId 汇总样本，并检查 Push 队列是否开启了高吞吐模式。
+func summarizeFIFOGroups(ctx context.Context, body apiRequest, pushURL string, samples []batchSample) *fifoGroupsSummary {
+	if body.FifoGroupCount <= 0 {
+		return nil
+	}
+	sum := &fifoGroupsSummary{GroupCount: body.FifoGroupCount}
+	if qc := loadQueueConfig(ctx, pushURL); qc.Error != "" {
+		sum.QueueConfigError = qc.Error
+	} else {
+		ht := qc.highThroughputFIFO()
+		sum.HighThroughput = &ht
+	}
+
+	byGroup := map[string]*fifoGroupStats{}
+	latencies := map[string][]float64{}
+	for _, s := range samples {
+		g := byGroup[s.MessageGroupID]
+		if g == nil {
+			g = &fifoGroupStats{MessageGroupID: s.MessageGroupID}
+			byGroup[s.MessageGroupID] = g
+		}
+		g.Samples++
+		if s.Status == "OK" {
+			g.OK++
+			latencies[s.MessageGroupID] = append(latencies[s.MessageGroupID], nanosToMs(s.ReceiveMessageUnixNano-s.SendStartUnixNano))
+		}
+	}
+	for id, g := range byGroup {
+		st := newLatencyStats(latencies[id])
+		g.P50Ms, g.P99Ms, g.MeanMs = st.P50, st.P99, st.Mean
+		sum.Groups = append(sum.Groups, *g)
+	}
+	sort.Slice(sum.Groups, func(i, j int) bool { return sum.Groups[i].MessageGroupID < sum.Groups[j].MessageGroupID })
+	return sum
+}
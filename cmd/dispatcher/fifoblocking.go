@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// fifoBlockingOutput：fifoBlocking 模式的输出。同样的 N 条消息先全部发往同一 MessageGroupId（sameGroup），
+// 再轮流分散到 N 个分组（spread）；两轮逐位置比较 queueToWorkerMs（workerReceive - sendEnd），差值即组内队头阻塞。
+type fifoBlockingOutput struct {
+	RunID     string              `json:"runId"`
+	Messages  int                 `json:"messages"`
+	WorkMs    int                 `json:"workMs"`
+	SameGroup fifoBlockingPhase   `json:"sameGroup"`
+	Spread    fifoBlockingPhase   `json:"spread"`
+	Positions []fifoBlockingPoint `json:"positions"`
+	// MeanBlockingDelayMs：两轮都成功的位置上 blockingDelayMs 的均值。
+	MeanBlockingDelayMs *float64 `json:"meanBlockingDelayMs,omitempty"`
+	DuplicateIDCount    int      `json:"duplicateIdCount,omitempty"`
+}
+
+type fifoBlockingPhase struct {
+	OK     int    `json:"ok"`
+	Failed int    `json:"failed"`
+	Error  string `json:"error,omitempty"`
+}
+
+// fifoBlockingPoint：批内第 position 条消息（0 起）的结果；任一轮该位置失败时对应字段省略。
+// expectedBlockingMs = position × workMs，即严格组内顺序下理论上排在前面的处理时间。
+type fifoBlockingPoint struct {
+	Position           int      `json:"position"`
+	SameGroupWaitMs    *float64 `json:"sameGroupWaitMs,omitempty"`
+	SpreadWaitMs       *float64 `json:"spreadWaitMs,omitempty"`
+	BlockingDelayMs    *float64 `json:"blockingDelayMs,omitempty"`
+	ExpectedBlockingMs int      `json:"expectedBlockingMs"`
+}
+
+func validateFIFOBlocking(body apiRequest, pushQueueURLs []string) error {
+	if body.FifoBlocking == 0 {
+		return nil
+	}
+	if body.FifoBlocking < 2 || body.FifoBlocking > maxBatchEntries {
+		return fmt.Errorf("fifoBlocking must be 2..%d, got %d", maxBatchEntries, body.FifoBlocking)
+	}
+	for _, u := range pushQueueURLs {
+		if !isFIFOQueue(u) {
+			return errors.New("fifoBlocking requires FIFO (.fifo) push queues")
+		}
+	}
+	if body.WorkMs <= 0 {
+		return errors.New("fifoBlocking requires workMs > 0 (the slow Worker that blocks later messages)")
+	}
+	if len(benchmarkModes(body)) > 1 || body.FifoGroupCount > 0 {
+		return errors.New("fifoBlocking cannot be combined with other benchmark modes or fifoGroupCount")
+	}
+	return nil
+}
+
+// handleFIFOBlocking 依次跑 sameGroup 与 spread 两轮（各一次 SendMessageBatch），第二轮在第一轮全部回调后开始，
+// 互不排队；Worker 的事件源映射在同一分组内要等前一条处理完才投递下一条，spread 轮则可以并行处理。
+func handleFIFOBlocking(ctx context.Context, body apiRequest, q queueTargets, inv invocationInfo) (events.APIGatewayProxyResponse, error) {
+	dispatchStart := time.Now().UnixNano()
+	n := body.FifoBlocking
+	out := fifoBlockingOutput{RunID: body.RunID, Messages: n, WorkMs: body.WorkMs}
+
+	same, sameErr := sendBatch(ctx, body, q, inv, sendTimes{dispatchStart: time.Now().UnixNano()}, make([]int, n))
+	out.SameGroup = summarizeFIFOBlockingPhase(same, sameErr, n)
+	spreadBody := body
+	spreadBody.FifoGroupCount = n
+	var spread []batchSample
+	var spreadErr error
+	if ctx.Err() == nil {
+		spread, spreadErr = sendBatch(ctx, spreadBody, q, inv, sendTimes{dispatchStart: time.Now().UnixNano()}, make([]int, n))
+	} else {
+		spreadErr = ctx.Err()
+	}
+	out.Spread = summarizeFIFOBlockingPhase(spread, spreadErr, n)
+
+	var sum float64
+	var both int
+	for i := 0; i < n; i++ {
+		p := fifoBlockingPoint{Position: i, ExpectedBlockingMs: i * body.WorkMs}
+		p.SameGroupWaitMs = fifoBlockingWaitMs(same, i)
+		p.SpreadWaitMs = fifoBlockingWaitMs(spread, i)
+		if p.SameGroupWaitMs != nil && p.SpreadWaitMs != nil {
+			d := *p.SameGroupWaitMs - *p.SpreadWaitMs
+			p.BlockingDelayMs = &d
+			sum += d
+			both++
+		}
+		out.Positions = append(out.Positions, p)
+	}
+	if both > 0 {
+		mean := sum / float64(both)
+		out.MeanBlockingDelayMs = &mean
+	}
+	out.DuplicateIDCount = body.ids.duplicateCount()
+
+	code, status := 200, "OK"
+	switch {
+	case both == 0 && ctx.Err() != nil:
+		code, status = 504, "TIMEOUT"
+	case both == 0:
+		code, status = 502, "ERROR"
+	case both < n:
+		status = "PARTIAL"
+	}
+	outBytes, _ := json.Marshal(out)
+	elapsedNs := time.Now().UnixNano() - dispatchStart
+	elapsedMs := elapsedNs / int64(time.Millisecond)
+	results.put(storedResult{RunID: body.RunID, StoredAt: time.Now(), TotalMs: elapsedMs, Output: outBytes})
+
+	// 每个位置只有汇总数据，内联输出本身已足够精简。
+	outBytes, upload := persistResult(ctx, body.resultS3, body.RunID, outBytes, nil)
+
+	outBytes, err := formatOutput(outBytes, body.OutputFormat)
+	if err != nil {
+		return jsonResp(500, apiResponse{Status: "ERROR", Error: err.Error()})
+	}
+	apiOut := apiResponse{Status: status, TotalMs: elapsedMs, TotalUs: nanosToUs(elapsedNs), Output: outBytes, PerceivedLatencyMs: perceivedLatencyMs(body.requestTimeEpochMs)}
+	upload.apply(&apiOut)
+	return jsonResp(code, apiOut)
+}
+
+func summarizeFIFOBlockingPhase(samples []batchSample, err error, n int) fifoBlockingPhase {
+	var ph fifoBlockingPhase
+	if err != nil {
+		ph.Failed, ph.Error = n, err.Error()
+		return ph
+	}
+	for _, s := range samples {
+		if s.Status != "OK" {
+			ph.Failed++
+			if ph.Error == "" {
+				ph.Error = s.Error
+			}
+			continue
+		}
+		ph.OK++
+	}
+	return ph
+}
+
+// fifoBlockingWaitMs：第 i 条样本的 workerReceive - sendEnd（跨时钟，两轮相减后偏差抵消）；样本失败或缺少时间戳时返回 nil。
+func fifoBlockingWaitMs(samples []batchSample, i int) *float64 {
+	if i >= len(samples) || samples[i].Status != "OK" || samples[i].WorkerReceiveUnixNano <= 0 {
+		return nil
+	}
+	ms := nanosToMs(samples[i].WorkerReceiveUnixNano - samples[i].SendEndUnixNano)
+	return &ms
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"math"
+	"sort"
+	"time"
+)
+
+// forwardLegSkewToleranceMs：SentTimestamp/FirstReceive 只有毫秒精度，低于该容差的倒挂不视为时钟偏差。
+const forwardLegSkewToleranceMs = 1
+
+// forwardLegSummary：批量样本的 pureForwardLegMs 汇总（跳过被标记为时钟偏差的样本）。
+type forwardLegSummary struct {
+	Samples       int     `json:"samples"`
+	Skewed        int     `json:"skewed"`
+	MinMs         float64 `json:"minMs"`
+	MeanMs        float64 `json:"meanMs"`
+	P50Ms         float64 `json:"p50Ms"`
+	MaxMs         float64 `json:"maxMs"`
+	SqsOnlyMeanMs float64 `json:"sqsOnlyMeanMs"`
+}
+
+// applyPureForwardLeg 只用 SQS 权威时间戳计算去程：pureForwardLegMs = workerReceive - SentTimestamp，
+// 不含 Dispatcher 发送侧代码与回调链路；sqsOnlyForwardLegMs = FirstReceive - Sent 为纯 SQS 时钟的替代值。
+// Lambda 在 SQS 首次投递之后才会调用 Worker，pure 小于 sqsOnly（超出毫秒容差）或为负说明 Worker 时钟与 SQS 有偏差。
+func applyPureForwardLeg(out *dispatcherOutput) {
+	if out.SqsSentTimestampMs <= 0 || out.WorkerReceiveUnixNano <= 0 {
+		return
+	}
+	pure := float64(out.WorkerReceiveUnixNano-out.SqsSentTimestampMs*int64(time.Millisecond)) / float64(time.Millisecond)
+	out.PureForwardLegMs = &pure
+	out.ForwardLegSkew = pure < 0
+	if out.SqsFirstReceiveTimestampMs > 0 {
+		sqsOnly := out.SqsFirstReceiveTimestampMs - out.SqsSentTimestampMs
+		out.SqsOnlyForwardLegMs = &sqsOnly
+		if pure < float64(sqsOnly-forwardLegSkewToleranceMs) {
+			out.ForwardLegSkew = true
+		}
+	}
+}
+
+func summarizeForwardLeg(samples []batchSample) *forwardLegSummary {
+	var pure []float64
+	var sqsOnlySum float64
+	sum := &forwardLegSummary{}
+	for _, s := range samples {
+		if s.Status != "OK" || s.PureForwardLegMs == nil {
+			continue
+		}
+		if s.ForwardLegSkew {
+			sum.Skewed++
+			continue
+		}
+		pure = append(pure, *s.PureForwardLegMs)
+		if s.SqsOnlyForwardLegMs != nil {
+			sqsOnlySum += float64(*s.SqsOnlyForwardLegMs)
+		}
+	}
+	if len(pure) == 0 {
+		if sum.Skewed == 0 {
+			return nil
+		}
+		return sum
+	}
+	sort.Float64s(pure)
+	var total float64
+	for _, v := range pure {
+		total += v
+	}
+	sum.Samples = len(pure)
+	sum.MinMs, sum.MaxMs = pure[0], pure[len(pure)-1]
+	sum.MeanMs = total / float64(len(pure))
+	sum.P50Ms = pure[clampInt(int(math.Ceil(0.5*float64(len(pure))))-1, 0, len(pure)-1)]
+	sum.SqsOnlyMeanMs = sqsOnlySum / float64(len(pure))
+	return sum
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"encoding/json"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+const healthPath = "/health"
+
+// healthOutput：/health 的输出，不发送消息也不轮询，只反映本容器的配置与初始化状态。
+type healthOutput struct {
+	Region           string `json:"region"`
+	PushQueueName    string `json:"pushQueueName"`
+	ReceiveQueueName string `json:"receiveQueueName"`
+	InitOK           bool   `json:"initOk"`
+	InitError        string `json:"initError,omitempty"`
+	// *URLSet：PUSH_QUEUE_URL(S) / RECEIVE_QUEUE_URL(S) 已设置；多个队列时 *QueueName 为第一个。
+	PushQueueURLSet    bool   `json:"pushQueueUrlSet"`
+	ReceiveQueueURLSet bool   `json:"receiveQueueUrlSet"`
+	InvocationClass    string `json:"invocationClass"`
+	DispatcherVersion  string `json:"dispatcherVersion"`
+}
+
+func isHealthCheck(req events.APIGatewayProxyRequest) bool {
+	return req.Path == healthPath || req.Resource == healthPath
+}
+
+// handleHealth：供负载均衡与合成监控廉价探测。初始化失败或缺少队列配置时返回 503，body 相同。
+func handleHealth(inv invocationInfo) (events.APIGatewayProxyResponse, error) {
+	pushURLs, receiveURLs := pushQueueURLsFromEnv(), receiveQueueURLsFromEnv()
+	out := healthOutput{
+		Region:             awsCfg.Region,
+		InitOK:             initErr == nil,
+		PushQueueURLSet:    len(pushURLs) > 0,
+		ReceiveQueueURLSet: len(receiveURLs) > 0,
+		InvocationClass:    inv.Class,
+		DispatcherVersion:  dispatcherVersion,
+	}
+	if initErr != nil {
+		out.InitError = initErr.Error()
+	}
+	if out.PushQueueURLSet {
+		out.PushQueueName = queueNameFromURL(pushURLs[0])
+	}
+	if out.ReceiveQueueURLSet {
+		out.ReceiveQueueName = queueNameFromURL(receiveURLs[0])
+	}
+	outBytes, _ := json.Marshal(out)
+	if !out.InitOK || !out.PushQueueURLSet || !out.ReceiveQueueURLSet {
+		return jsonResp(503, apiResponse{Status: "UNHEALTHY", Output: outBytes})
+	}
+	return jsonResp(200, apiResponse{Status: "OK", Output: outBytes})
+}
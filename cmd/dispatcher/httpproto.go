@@ -0,0 +1,119 @@
+package main
+
+import (
+	"crypto/tls"
+	"log"
+	"net/http"
+	"net/http/httptrace"
+	"os"
+	"strings"
+	"sync/atomic"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+)
+
+// 协议实验：SQS_HTTP_PROTOCOL 控制 SQS 客户端的 HTTP 协议。
+//   - 空（默认）：SDK 默认 Transport（ForceAttemptHTTP2=true，由 TLS ALPN 协商）
+//   - h2：显式 ForceAttemptHTTP2
+//   - http1：禁用 HTTP/2，只用 HTTP/1.1
+//
+// 是否真正使用 HTTP/2 取决于 SQS 端点的 ALPN 应答；实际协商结果见 debug 输出。
+const (
+	httpProtocolH2    = "h2"
+	httpProtocolHTTP1 = "http1"
+)
+
+// sqsProtocol 记录最近一次 SQS 响应的协议；客户端在请求之间共享，因此是近似值。
+var sqsProtocol atomic.Pointer[httpProtocolInfo]
+
+type httpProtocolInfo struct {
+	Requested string `json:"requested,omitempty"`
+	Proto     string `json:"proto"`
+	ALPN      string `json:"alpn,omitempty"`
+}
+
+func httpProtocolOptions() []func(*sqs.Options) {
+	requested := strings.TrimSpace(strings.ToLower(os.Getenv("SQS_HTTP_PROTOCOL")))
+	client := awshttp.NewBuildableClient()
+	switch requested {
+	case "":
+	case httpProtocolH2:
+		client = client.WithTransportOptions(func(tr *http.Transport) { tr.ForceAttemptHTTP2 = true })
+	case httpProtocolHTTP1:
+		client = client.WithTransportOptions(func(tr *http.Transport) {
+			tr.ForceAttemptHTTP2 = false
+			// 非 nil 的空 map 会关闭 net/http 内置的 HTTP/2 支持。
+			tr.TLSNextProto = map[string]func(string, *tls.Conn) http.RoundTripper{}
+		})
+	default:
+		log.Printf("WARNING: unknown SQS_HTTP_PROTOCOL=%q, using SDK default", requested)
+		requested = ""
+	}
+	// Go 默认不缓存 TLS 会话，新连接总是完整握手；SQS_TLS_SESSION_CACHE>0 时启用会话缓存以允许恢复。
+	if n := envIntDefault("SQS_TLS_SESSION_CACHE", 0); n > 0 {
+		client = client.WithTransportOptions(func(tr *http.Transport) {
+			if tr.TLSClientConfig == nil {
+				tr.TLSClientConfig = &tls.Config{}
+			}
+			tr.TLSClientConfig.ClientSessionCache = tls.NewLRUClientSessionCache(n)
+		})
+	}
+	rc := protocolRecordingClient{next: client, requested: requested}
+	return []func(*sqs.Options){func(o *sqs.Options) { o.HTTPClient = rc }}
+}
+
+type protocolRecordingClient struct {
+	next      aws.HTTPClient
+	requested string
+}
+
+func (c protocolRecordingClient) Do(r *http.Request) (*http.Response, error) {
+	r = r.WithContext(httptrace.WithClientTrace(r.Context(), &httptrace.ClientTrace{
+		TLSHandshakeDone: func(state tls.ConnectionState, err error) {
+			if err == nil {
+				tlsHandshakes.record(state.DidResume)
+			}
+		},
+	}))
+	resp, err := c.next.Do(r)
+	if err == nil {
+		info := &httpProtocolInfo{Requested: c.requested, Proto: resp.Proto}
+		if resp.TLS != nil {
+			info.ALPN = resp.TLS.NegotiatedProtocol
+		}
+		sqsProtocol.Store(info)
+	}
+	return resp, err
+}
+
+// tlsHandshakes：容器内 SQS 连接的 TLS 握手累计统计（完整握手 vs 会话恢复）。
+var tlsHandshakes tlsHandshakeStats
+
+type tlsHandshakeStats struct {
+	total   atomic.Int64
+	resumed atomic.Int64
+}
+
+type tlsHandshakeInfo struct {
+	Handshakes    int64   `json:"handshakes"`
+	Resumed       int64   `json:"resumed"`
+	TLSResumeRate float64 `json:"tlsResumeRate"`
+}
+
+func (s *tlsHandshakeStats) record(resumed bool) {
+	s.total.Add(1)
+	if resumed {
+		s.resumed.Add(1)
+	}
+}
+
+func (s *tlsHandshakeStats) snapshot() *tlsHandshakeInfo {
+	total := s.total.Load()
+	if total == 0 {
+		return nil
+	}
+	resumed := s.resumed.Load()
+	return &tlsHandshakeInfo{Handshakes: total, Resumed: resumed, TLSResumeRate: float64(resumed) / float64(total)}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// httpMode：通过 LISTEN_ADDR 以本地 HTTP 服务运行（而不是 Lambda）。进程在请求之间不会被冻结，
+// 因此只有该模式支持后台 goroutine（例如 resultWebhook）。
+var httpMode bool
+
+// serveHTTP 把 POST /run（及 GET /health）适配为 APIGatewayProxyRequest 交给 handler，用于本地或容器内运行。
+func serveHTTP(addr string) error {
+	httpMode = true
+	if localWorkerEnabled() {
+		startLocalWorker(context.Background())
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc(healthPath, func(w http.ResponseWriter, r *http.Request) {
+		resp, _ := handler(r.Context(), events.APIGatewayProxyRequest{HTTPMethod: r.Method, Path: r.URL.Path})
+		writeProxyResponse(w, resp)
+	})
+	mux.HandleFunc("/run", func(w http.ResponseWriter, r *http.Request) {
+		b, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		headers := map[string]string{}
+		for k := range r.Header {
+			headers[k] = r.Header.Get(k)
+		}
+		resp, _ := handler(r.Context(), events.APIGatewayProxyRequest{
+			HTTPMethod: r.Method,
+			Path:       r.URL.Path,
+			Headers:    headers,
+			Body:       string(b),
+		})
+		if strings.Contains(r.Header.Get("Accept"), arrowStreamContentType) {
+			if writeArrowResponse(w, resp) {
+				return
+			}
+		}
+		writeProxyResponse(w, resp)
+	})
+	log.Printf("dispatcher listening on %s (HTTP variant)", addr)
+	return http.ListenAndServe(addr, mux)
+}
+
+// writeArrowResponse：请求 Accept: application/vnd.apache.arrow.stream 且 outputFormat=arrow 时直接返回 IPC 字节，
+// 行数、列数与状态放在响应头中；其他响应（错误等）返回 false，按原样输出 JSON。
+func writeArrowResponse(w http.ResponseWriter, resp events.APIGatewayProxyResponse) bool {
+	var api struct {
+		Status string      `json:"status"`
+		Output arrowOutput `json:"output"`
+	}
+	if json.Unmarshal([]byte(resp.Body), &api) != nil || api.Output.Format != arrowStreamFormat {
+		return false
+	}
+	data, err := base64.StdEncoding.DecodeString(api.Output.Data)
+	if err != nil {
+		return false
+	}
+	w.Header().Set("Content-Type", arrowStreamContentType)
+	w.Header().Set("X-Output-Format", api.Output.Format)
+	w.Header().Set("X-Output-Rows", strconv.Itoa(api.Output.Rows))
+	w.Header().Set("X-Run-Status", api.Status)
+	w.WriteHeader(resp.StatusCode)
+	_, _ = w.Write(data)
+	return true
+}
+
+func writeProxyResponse(w http.ResponseWriter, resp events.APIGatewayProxyResponse) {
+	for k, v := range resp.Headers {
+		w.Header().Set(k, v)
+	}
+	w.WriteHeader(resp.StatusCode)
+	_, _ = io.WriteString(w, resp.Body)
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// 消息 id 格式：hex（默认，32 位随机十六进制）或 ulid（26 位 Crockford Base32，前 48 位为毫秒时间戳），
+// ulid 按生成时间排序，便于关联 Dispatcher/Worker 日志并交叉校验发送时间。
+const (
+	idFormatHex  = "hex"
+	idFormatULID = "ulid"
+)
+
+const crockfordAlphabet = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+// maxIDAttempts：同一 id 连续碰撞时最多重新生成的次数，超过即视为随机源异常。
+const maxIDAttempts = 5
+
+// idRandRead：消息 id 的随机源，测试中可替换为固定序列以制造碰撞。
+var idRandRead = rand.Read
+
+func validateIDFormat(format string) error {
+	switch format {
+	case "", idFormatHex, idFormatULID:
+		return nil
+	default:
+		return fmt.Errorf("idFormat must be %q or %q, got %q", idFormatHex, idFormatULID, format)
+	}
+}
+
+// newMessageID 按 format 生成消息 id；ulid 模式下同时打印日志，便于与 Worker 日志中的同一 ulid 对照。
+func newMessageID(format string) string {
+	if format != idFormatULID {
+		b := make([]byte, 16)
+		_, _ = idRandRead(b)
+		return hex.EncodeToString(b)
+	}
+	var entropy [10]byte
+	_, _ = idRandRead(entropy[:])
+	id := encodeULID(uint64(time.Now().UnixMilli()), entropy)
+	log.Printf("dispatch ulid=%s", id)
+	return id
+}
+
+// encodeULID：10 个字符编码 48 位毫秒时间戳，16 个字符编码 80 位随机数。
+func encodeULID(ms uint64, entropy [10]byte) string {
+	var b [26]byte
+	for i := 9; i >= 0; i-- {
+		b[i] = crockfordAlphabet[ms&31]
+		ms >>= 5
+	}
+	var acc uint64
+	bits, j := 0, 10
+	for _, e := range entropy {
+		acc = acc<<8 | uint64(e)
+		bits += 8
+		for bits >= 5 {
+			bits -= 5
+			b[j] = crockfordAlphabet[(acc>>bits)&31]
+			j++
+		}
+	}
+	return string(b[:])
+}
+
+// decodeULIDTime 返回 ulid 中嵌入的毫秒时间戳；id 不是合法的 ulid 时返回 false。
+func decodeULIDTime(id string) (int64, bool) {
+	if len(id) != 26 || id[0] > '7' {
+		return 0, false
+	}
+	var ms int64
+	for i := 0; i < len(id); i++ {
+		v := crockfordValue(id[i])
+		if v < 0 {
+			return 0, false
+		}
+		if i < 10 {
+			ms = ms<<5 | int64(v)
+		}
+	}
+	return ms, true
+}
+
+func crockfordValue(c byte) int {
+	for i := 0; i < len(crockfordAlphabet); i++ {
+		if crockfordAlphabet[i] == c {
+			return i
+		}
+	}
+	return -1
+}
+
+// idSet：一次运行（同一请求内的批量、爬坡、多轮等）已发出的消息 id。
+// 回调只按 runId/id 匹配，重复的 id 会让两个样本串配，因此发送前检查并重新生成。
+type idSet struct {
+	mu   sync.Mutex
+	seen map[string]bool
+	// duplicates：生成时发生碰撞并已重新生成的次数。
+	duplicates int
+}
+
+func newIDSet() *idSet {
+	return &idSet{seen: map[string]bool{}}
+}
+
+// next 生成一个本次运行内未出现过的 id；s 为 nil 时不做检查。
+func (s *idSet) next(format string) (string, error) {
+	if s == nil {
+		return newMessageID(format), nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for attempt := 0; attempt < maxIDAttempts; attempt++ {
+		id := newMessageID(format)
+		if !s.seen[id] {
+			s.seen[id] = true
+			return id, nil
+		}
+		s.duplicates++
+		log.Printf("WARNING: duplicate message id regenerated: id=%s", id)
+	}
+	return "", fmt.Errorf("duplicate message id: %d consecutive collisions", maxIDAttempts)
+}
+
+// duplicateCount：s 为 nil 时为 0。
+func (s *idSet) duplicateCount() int {
+	if s == nil {
+		return 0
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.duplicates
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+const (
+	// maxIterations：iterations 的上限；实际能跑多少轮由 maxWaitMs 决定。
+	maxIterations = 1000
+	// iterationMinBudget：剩余时间低于该值（或低于已观测到的最慢一轮）时不再启动下一轮，避免最后一轮超时。
+	iterationMinBudget = 100 * time.Millisecond
+)
+
+// iterationsOutput：多轮模式的输出，每轮一条消息，顺序执行。
+type iterationsOutput struct {
+	RunID     string            `json:"runId"`
+	Requested int               `json:"requested"`
+	Samples   []iterationSample `json:"samples,omitempty"`
+	// DuplicateIDCount：各轮生成 id 时发生碰撞并重新生成的次数。
+	DuplicateIDCount int `json:"duplicateIdCount,omitempty"`
+	// byWorkerInstance=true 时各 Worker 容器的 pipelineLatencyMs 分布。
+	WorkerInstances []workerInstanceStats `json:"workerInstances,omitempty"`
+}
+
+// iterationSample：单轮的结果；各阶段口径与远程测试的 Latency Breakdown 一致。
+type iterationSample struct {
+	Iteration int    `json:"iteration"`
+	ID        string `json:"id"`
+	Status    string `json:"status"`
+	Error     string `json:"error,omitempty"`
+	// TotalMs：本轮发送到匹配回调的墙钟耗时（Dispatcher 本地时钟）。
+	TotalMs               float64 `json:"totalMs"`
+	SendMs                float64 `json:"sendMs"`
+	SqsWaitMs             float64 `json:"sqsWaitMs"`
+	WorkerMs              float64 `json:"workerMs"`
+	PipelineLatencyMs     float64 `json:"pipelineLatencyMs"`
+	WorkerInstanceID      string  `json:"workerInstanceId,omitempty"`
+	WorkerInvocationClass string  `json:"workerInvocationClass,omitempty"`
+	// WorkerColdStart：Worker 报告本次调用为冷启动（workerInvocationClass=cold）。
+	WorkerColdStart bool `json:"workerColdStart,omitempty"`
+}
+
+// iterationStats：apiResponse.stats，只统计成功的轮次。
+type iterationStats struct {
+	Completed int `json:"completed"`
+	Failed    int `json:"failed"`
+	// StoppedEarly：截止时间不足，未跑完 iterations 轮。
+	StoppedEarly      bool         `json:"stoppedEarly,omitempty"`
+	TotalMs           latencyStats `json:"totalMs"`
+	SendMs            latencyStats `json:"sendMs"`
+	SqsWaitMs         latencyStats `json:"sqsWaitMs"`
+	WorkerMs          latencyStats `json:"workerMs"`
+	PipelineLatencyMs latencyStats `json:"pipelineLatencyMs"`
+	// 冷启动代价：cold 轮次与 warm 轮次（near-cold 不计入任何一方）totalMs 均值之差，两者都存在时才输出。
+	ColdSamples        int      `json:"coldSamples"`
+	WarmSamples        int      `json:"warmSamples"`
+	ColdStartPenaltyMs *float64 `json:"coldStartPenaltyMs,omitempty"`
+	// confidenceIntervals=true 时 totalMs 与 pipelineLatencyMs 的均值/p99 95% 置信区间（见 bootstrapCI）。
+	TotalMsCI           *latencyCI `json:"totalMsCi,omitempty"`
+	PipelineLatencyMsCI *latencyCI `json:"pipelineLatencyMsCi,omitempty"`
+}
+
+type latencyStats struct {
+	P50  float64 `json:"p50"`
+	P90  float64 `json:"p90"`
+	P99  float64 `json:"p99"`
+	Min  float64 `json:"min"`
+	Max  float64 `json:"max"`
+	Mean float64 `json:"mean"`
+}
+
+func validateIterations(body apiRequest) error {
+	if body.ConfidenceIntervals && body.Iterations < ciMinSamples {
+		return fmt.Errorf("confidenceIntervals requires iterations >= %d", ciMinSamples)
+	}
+	if body.Iterations == 0 || body.Iterations == 1 {
+		return nil
+	}
+	if body.Iterations < 0 || body.Iterations > maxIterations {
+		return fmt.Errorf("iterations must be 1..%d, got %d", maxIterations, body.Iterations)
+	}
+	if len(body.BatchDelaySeconds) > 0 || body.RampMaxConcurrency > 0 || body.CompareAttributes > 0 || body.OneWay || body.FailMode != "" {
+		return fmt.Errorf("iterations cannot be combined with batchDelaySeconds, rampMaxConcurrency, compareAttributes, oneWay or failMode")
+	}
+	return nil
+}
+
+// handleIterations 顺序跑 iterations 轮单条往返（每轮新的 id，共用 runId），在 maxWaitMs 内尽量跑完，
+// 并在 apiResponse.stats 中返回各阶段的 p50/p90/p99/min/max/mean；预算不足时提前停止并返回已有轮次的统计。
+func handleIterations(ctx context.Context, body apiRequest, q queueTargets, inv invocationInfo) (events.APIGatewayProxyResponse, error) {
+	dispatchStart := time.Now().UnixNano()
+	out := iterationsOutput{RunID: body.RunID, Requested: body.Iterations}
+	stats := &iterationStats{}
+
+	var slowest time.Duration
+	for i := 1; i <= body.Iterations; i++ {
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < max(slowest, iterationMinBudget) {
+			stats.StoppedEarly = true
+			break
+		}
+		start := time.Now()
+		samples, err := sendBatch(ctx, body, q, inv, sendTimes{dispatchStart: start.UnixNano()}, []int{body.DelaySeconds})
+		elapsed := time.Since(start)
+		slowest = max(slowest, elapsed)
+
+		rec := iterationSample{Iteration: i, TotalMs: float64(elapsed) / float64(time.Millisecond)}
+		switch {
+		case err != nil:
+			rec.Status, rec.Error = "ERROR", err.Error()
+		default:
+			s := samples[0]
+			rec.ID, rec.Status, rec.Error = s.ID, s.Status, s.Error
+			if s.Status == "OK" {
+				rec.SendMs = nanosToMs(s.SendEndUnixNano - s.SendStartUnixNano)
+				rec.SqsWaitMs = nanosToMs(s.WorkerReceiveUnixNano - s.SendEndUnixNano)
+				rec.WorkerMs = nanosToMs(s.WorkerDoneUnixNano - s.WorkerReceiveUnixNano)
+				rec.PipelineLatencyMs = nanosToMs(s.ReceiveMessageUnixNano - s.SendStartUnixNano)
+				rec.WorkerInstanceID, rec.WorkerInvocationClass = s.WorkerInstanceID, s.WorkerInvocationClass
+				rec.WorkerColdStart = s.WorkerInvocationClass == invocationCold
+			}
+		}
+		out.Samples = append(out.Samples, rec)
+		if rec.Status == "OK" {
+			stats.Completed++
+		} else {
+			stats.Failed++
+		}
+		if ctx.Err() != nil {
+			stats.StoppedEarly = i < body.Iterations
+			break
+		}
+	}
+	stats.summarize(out.Samples)
+	if body.ConfidenceIntervals {
+		stats.applyConfidenceIntervals(out.Samples, body.RunID)
+	}
+	out.DuplicateIDCount = body.ids.duplicateCount()
+	if body.ByWorkerInstance {
+		out.WorkerInstances = iterationWorkerInstances(out.Samples)
+	}
+
+	code, status := 200, "OK"
+	switch {
+	case stats.Completed == 0 && (ctx.Err() != nil || stats.StoppedEarly):
+		code, status = 504, "TIMEOUT"
+	case stats.Completed == 0:
+		code, status = 502, "ERROR"
+	case stats.Failed > 0 || stats.StoppedEarly:
+		status = "PARTIAL"
+	}
+	outBytes, _ := json.Marshal(out)
+	elapsedNs := time.Now().UnixNano() - dispatchStart
+	elapsedMs := elapsedNs / int64(time.Millisecond)
+	results.put(storedResult{RunID: body.RunID, StoredAt: time.Now(), TotalMs: elapsedMs, Output: outBytes})
+
+	out.Samples = nil
+	trimmed, _ := json.Marshal(out)
+	outBytes, upload := persistResult(ctx, body.resultS3, body.RunID, outBytes, trimmed)
+
+	outBytes, err := formatOutput(outBytes, body.OutputFormat)
+	if err != nil {
+		return jsonResp(500, apiResponse{Status: "ERROR", Error: err.Error()})
+	}
+	apiOut := apiResponse{Status: status, TotalMs: elapsedMs, TotalUs: nanosToUs(elapsedNs), Output: outBytes, PerceivedLatencyMs: perceivedLatencyMs(body.requestTimeEpochMs), Stats: stats}
+	upload.apply(&apiOut)
+	return jsonResp(code, apiOut)
+}
+
+func (st *iterationStats) summarize(samples []iterationSample) {
+	var total, send, sqsWait, worker, pipeline, cold, warm []float64
+	for _, s := range samples {
+		if s.Status != "OK" {
+			continue
+		}
+		switch s.WorkerInvocationClass {
+		case invocationCold:
+			cold = append(cold, s.TotalMs)
+		case invocationWarm:
+			warm = append(warm, s.TotalMs)
+		}
+		total = append(total, s.TotalMs)
+		send = append(send, s.SendMs)
+		sqsWait = append(sqsWait, s.SqsWaitMs)
+		worker = append(worker, s.WorkerMs)
+		pipeline = append(pipeline, s.PipelineLatencyMs)
+	}
+	st.TotalMs = newLatencyStats(total)
+	st.SendMs = newLatencyStats(send)
+	st.SqsWaitMs = newLatencyStats(sqsWait)
+	st.WorkerMs = newLatencyStats(worker)
+	st.PipelineLatencyMs = newLatencyStats(pipeline)
+	st.ColdSamples, st.WarmSamples = len(cold), len(warm)
+	if len(cold) > 0 && len(warm) > 0 {
+		penalty := newLatencyStats(cold).Mean - newLatencyStats(warm).Mean
+		st.ColdStartPenaltyMs = &penalty
+	}
+}
+
+func (st *iterationStats) applyConfidenceIntervals(samples []iterationSample, runID string) {
+	var total, pipeline []float64
+	for _, s := range samples {
+		if s.Status == "OK" {
+			total = append(total, s.TotalMs)
+			pipeline = append(pipeline, s.PipelineLatencyMs)
+		}
+	}
+	st.TotalMsCI = bootstrapCI(total, runID)
+	st.PipelineLatencyMsCI = bootstrapCI(pipeline, runID)
+}
+
+// newLatencyStats：最近秩百分位；v 为空时返回零值。
+func newLatencyStats(v []float64) latencyStats {
+	if len(v) == 0 {
+		return latencyStats{}
+	}
+	sorted := append([]float64(nil), v...)
+	sort.Float64s(sorted)
+	var sum float64
+	for _, x := range sorted {
+		sum += x
+	}
+	return latencyStats{P50: nearestRank(sorted, 50), P90: nearestRank(sorted, 90), P99: nearestRank(sorted, 99), Min: sorted[0], Max: sorted[len(sorted)-1], Mean: sum / float64(len(sorted))}
+}
+
+// nearestRank：已排序 sorted（非空）的第 p 百分位（最近秩）。
+func nearestRank(sorted []float64, p float64) float64 {
+	return sorted[clampInt(int(math.Ceil(p/100*float64(len(sorted))))-1, 0, len(sorted)-1)]
+}
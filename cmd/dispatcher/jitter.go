@@ -0,0 +1,33 @@
+package main
+
+import (
+	"context"
+	"math/rand/v2"
+	"time"
+)
+
+// applyInitialJitter 在首次发送前休眠 [0, maxMs] 内均匀随机的时长，用于打散同时到达的请求。
+// 休眠上限不超过 ctx 剩余时间的一半，保证抖动之后仍有时间完成发送与轮询。
+func applyInitialJitter(ctx context.Context, maxMs int) (time.Duration, error) {
+	if maxMs <= 0 {
+		return 0, nil
+	}
+	limit := time.Duration(maxMs) * time.Millisecond
+	if deadline, ok := ctx.Deadline(); ok {
+		if half := time.Until(deadline) / 2; half < limit {
+			limit = half
+		}
+	}
+	if limit <= 0 {
+		return 0, nil
+	}
+	d := rand.N(limit + 1)
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return d, nil
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	}
+}
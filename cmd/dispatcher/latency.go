@@ -0,0 +1,43 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/smithy-go/middleware"
+)
+
+// 校准/自测模式：设置 INJECT_SQS_LATENCY_MS 后，在每次 SendMessage/ReceiveMessage 发出前固定 sleep，
+// 用于验证上报的阶段耗时与注入的延迟是否一致。
+//
+// 警告：仅用于测试，绝不能在生产环境开启——它会人为放大所有测量结果。
+func injectedLatencyOptions() []func(*sqs.Options) {
+	ms := envIntDefault("INJECT_SQS_LATENCY_MS", 0)
+	if ms <= 0 {
+		return nil
+	}
+	log.Printf("WARNING: INJECT_SQS_LATENCY_MS=%d is set; SQS latencies are artificially inflated (test only)", ms)
+	return []func(*sqs.Options){withInjectedLatency(time.Duration(ms) * time.Millisecond)}
+}
+
+func withInjectedLatency(d time.Duration) func(*sqs.Options) {
+	return func(o *sqs.Options) {
+		o.APIOptions = append(o.APIOptions, func(stack *middleware.Stack) error {
+			return stack.Initialize.Add(middleware.InitializeMiddlewareFunc("InjectLatency", func(ctx context.Context, in middleware.InitializeInput, next middleware.InitializeHandler) (middleware.InitializeOutput, middleware.Metadata, error) {
+				switch in.Parameters.(type) {
+				case *sqs.SendMessageInput, *sqs.ReceiveMessageInput:
+					t := time.NewTimer(d)
+					select {
+					case <-t.C:
+					case <-ctx.Done():
+						t.Stop()
+						return middleware.InitializeOutput{}, middleware.Metadata{}, ctx.Err()
+					}
+				}
+				return next.HandleInitialize(ctx, in)
+			}), middleware.Before)
+		})
+	}
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+// localWorkerInstanceID：本地 Worker 的实例标识，与部署的 Worker 日志流名区分开。
+var localWorkerInstanceID = "local-worker-" + randHex(4)
+
+// localWorkerEnabled：LOCAL_WORKER=1/true 时启用。
+func localWorkerEnabled() bool {
+	v, _ := strconv.ParseBool(strings.TrimSpace(os.Getenv("LOCAL_WORKER")))
+	return v
+}
+
+// startLocalWorker（LOCAL_WORKER，仅 HTTP 变体）：在 Dispatcher 进程内为每个 Push 队列启动一个后台消费 goroutine，
+// 按 Worker（cmd/worker）的处理逻辑把回调发往第一个 Receive 队列，便于在本地（如 LocalStack）跑完整往返而无需部署两个 Lambda。
+// 部署的 Lambda 从不走这里：只有 serveHTTP 会调用它，Worker 的部署代码也不依赖本文件。
+func startLocalWorker(ctx context.Context) {
+	initAWS()
+	if initErr != nil {
+		log.Printf("local worker disabled: %v", initErr)
+		return
+	}
+	receiveQueueURLs := receiveQueueURLsFromEnv()
+	if len(receiveQueueURLs) == 0 {
+		log.Printf("local worker disabled: missing env RECEIVE_QUEUE_URL")
+		return
+	}
+	for _, pushURL := range pushQueueURLsFromEnv() {
+		log.Printf("local worker consuming %s -> %s", queueNameFromURL(pushURL), queueNameFromURL(receiveQueueURLs[0]))
+		go consumeLocally(ctx, pushURL, receiveQueueURLs[0])
+	}
+}
+
+func consumeLocally(ctx context.Context, pushURL, receiveURL string) {
+	for ctx.Err() == nil {
+		out, err := sqsClient.ReceiveMessage(ctx, &sqs.ReceiveMessageInput{
+			QueueUrl:              &pushURL,
+			MaxNumberOfMessages:   10,
+			WaitTimeSeconds:       maxPollWaitSeconds,
+			MessageAttributeNames: []string{"All"},
+			MessageSystemAttributeNames: []types.MessageSystemAttributeName{
+				types.MessageSystemAttributeNameSentTimestamp,
+				types.MessageSystemAttributeNameApproximateFirstReceiveTimestamp,
+				types.MessageSystemAttributeNameApproximateReceiveCount,
+				types.MessageSystemAttributeNameAWSTraceHeader,
+			},
+		})
+		if err != nil {
+			if ctx.Err() == nil {
+				log.Printf("local worker receive failed: queue=%s err=%v", queueNameFromURL(pushURL), err)
+				time.Sleep(time.Second)
+			}
+			continue
+		}
+		for _, m := range out.Messages {
+			processLocally(ctx, pushURL, receiveURL, m)
+		}
+	}
+}
+
+// processLocally：与 Worker handler 对单条 record 的处理相同（不含 oneWay 与路由校验）。
+func processLocally(ctx context.Context, pushURL, receiveURL string, m types.Message) {
+	workerReceiveUnixNano := time.Now().UnixNano()
+	if !signatureValid(m) {
+		// 与 Worker 相同：SHARED_SECRET 设置时丢弃未签名或签名不符的消息。
+		log.Printf("local worker dropped message with invalid signature: messageId=%s", aws.ToString(m.MessageId))
+		deleteLocally(ctx, pushURL, m)
+		return
+	}
+	var body msgBody
+	raw, err := decodeMessageBody(aws.ToString(m.Body))
+	if err == nil {
+		err = json.Unmarshal(raw, &body)
+	}
+	if err != nil || strings.TrimSpace(body.ID) == "" {
+		log.Printf("local worker dropped unparseable message: messageId=%s", aws.ToString(m.MessageId))
+		deleteLocally(ctx, pushURL, m)
+		return
+	}
+	attr := func(name string) string {
+		if a, ok := m.MessageAttributes[name]; ok {
+			return aws.ToString(a.StringValue)
+		}
+		return ""
+	}
+	if body.RunID == "" {
+		body.RunID = attr(attrRunID)
+	}
+	if body.SendUnixNano == 0 {
+		body.SendUnixNano = parseInt64OrZero(attr(attrSendUnixNano))
+	}
+	if body.SendStartUnixNano == 0 {
+		body.SendStartUnixNano = parseInt64OrZero(attr(attrSendStartUnixNano))
+	}
+	if body.DispatcherVersion == "" {
+		body.DispatcherVersion = attr(attrDispatcherVersion)
+	}
+
+	switch {
+	case body.FailMode == failModeAlwaysError:
+		// 与 Worker 相同：立即释放可见性，让消息尽快达到 maxReceiveCount。
+		_, _ = sqsClient.ChangeMessageVisibility(ctx, &sqs.ChangeMessageVisibilityInput{QueueUrl: &pushURL, ReceiptHandle: m.ReceiptHandle, VisibilityTimeout: 0})
+		return
+	case body.OneWay:
+		log.Printf("local worker does not support oneWay: id=%s", body.ID)
+		deleteLocally(ctx, pushURL, m)
+		return
+	}
+
+	if body.WorkMs > 0 && !body.Probe {
+		select {
+		case <-ctx.Done():
+		case <-time.After(time.Duration(min(body.WorkMs, maxWorkMs)) * time.Millisecond):
+		}
+	}
+	echoed := ""
+	if body.EchoPadding {
+		echoed = body.Padding
+	}
+	var esmInvokeLatencyMs *float64
+	if firstReceiveMs := parseInt64OrZero(m.Attributes[string(types.MessageSystemAttributeNameApproximateFirstReceiveTimestamp)]); firstReceiveMs > 0 {
+		ms := nanosToMs(workerReceiveUnixNano - firstReceiveMs*int64(time.Millisecond))
+		esmInvokeLatencyMs = &ms
+	}
+	workerDoneUnixNano := time.Now().UnixNano()
+	callbackSendStartUnixNano := time.Now().UnixNano()
+	cbBytes, _ := json.Marshal(callbackMessage{
+		ID:                         body.ID,
+		RunID:                      body.RunID,
+		Probe:                      body.Probe,
+		Region:                     awsCfg.Region,
+		PushQueueName:              queueNameFromURL(pushURL),
+		ReceiveQueueName:           queueNameFromURL(receiveURL),
+		SendUnixNano:               body.SendUnixNano,
+		SendStartUnixNano:          body.SendStartUnixNano,
+		WorkerReceiveUnixNano:      workerReceiveUnixNano,
+		WorkerDoneUnixNano:         workerDoneUnixNano,
+		CallbackSendStartUnixNano:  callbackSendStartUnixNano,
+		SqsSentTimestampMs:         parseInt64OrZero(m.Attributes[string(types.MessageSystemAttributeNameSentTimestamp)]),
+		SqsFirstReceiveTimestampMs: parseInt64OrZero(m.Attributes[string(types.MessageSystemAttributeNameApproximateFirstReceiveTimestamp)]),
+		SqsApproxReceiveCount:      parseInt64OrZero(m.Attributes[string(types.MessageSystemAttributeNameApproximateReceiveCount)]),
+		EsmInvokeLatencyMs:         esmInvokeLatencyMs,
+		WorkerInvocationClass:      invocationWarm,
+		WorkerInstanceID:           localWorkerInstanceID,
+		DispatcherVersion:          body.DispatcherVersion,
+		WorkerVersion:              dispatcherVersion,
+		EchoedPadding:              echoed,
+		TraceHeader:                m.Attributes[string(types.MessageSystemAttributeNameAWSTraceHeader)],
+	})
+	// 与 Worker 相同：FIFO 回调队列以 id 同时作为分组与去重 id。
+	groupID, dedupID := fifoParams(receiveURL, body.ID, body.ID)
+	cbInput := &sqs.SendMessageInput{QueueUrl: &receiveURL, MessageBody: aws.String(string(cbBytes)), MessageGroupId: groupID, MessageDeduplicationId: dedupID}
+	// 与 Worker 相同：回调带 runId/id 属性，供 callbackAttributes 免解析匹配。
+	if body.RunID != "" && body.ID != "" {
+		cbInput.MessageAttributes = map[string]types.MessageAttributeValue{
+			attrRunID: {DataType: aws.String("String"), StringValue: aws.String(body.RunID)},
+			attrID:    {DataType: aws.String("String"), StringValue: aws.String(body.ID)},
+		}
+	}
+	cbInput.MessageAttributes = signedAttributes(cbInput.MessageAttributes, string(cbBytes))
+	if _, err := sqsClient.SendMessage(ctx, cbInput); err != nil {
+		// 不删除：消息在可见性超时后重投，与 Worker 返回错误时的行为一致。
+		log.Printf("local worker send callback failed: id=%s err=%v", body.ID, err)
+		return
+	}
+	deleteLocally(ctx, pushURL, m)
+}
+
+func deleteLocally(ctx context.Context, pushURL string, m types.Message) {
+	if m.ReceiptHandle == nil {
+		return
+	}
+	if _, err := sqsClient.DeleteMessage(ctx, &sqs.DeleteMessageInput{QueueUrl: &pushURL, ReceiptHandle: m.ReceiptHandle}); err != nil {
+		log.Printf("local worker delete failed: messageId=%s err=%v", aws.ToString(m.MessageId), err)
+	}
+}
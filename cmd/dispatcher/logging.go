@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// initLogger：每行一个 JSON 对象（slog JSONHandler，写到 stderr 即 CloudWatch Logs），便于 Logs Insights 按字段查询。
+// 设为默认 logger 后，其余 log.Printf 也以 INFO 级别的 JSON 行输出（内容在 msg 字段中）。
+func initLogger() {
+	slog.SetDefault(slog.New(slog.NewJSONHandler(os.Stderr, &slog.HandlerOptions{Level: logLevelFromEnv()})))
+}
+
+// logLevelFromEnv：LOG_LEVEL=debug/info/warn/error，缺省或无法识别时为 info。
+func logLevelFromEnv() slog.Level {
+	switch strings.ToLower(strings.TrimSpace(os.Getenv("LOG_LEVEL"))) {
+	case "debug":
+		return slog.LevelDebug
+	case "warn", "warning":
+		return slog.LevelWarn
+	case "error":
+		return slog.LevelError
+	default:
+		return slog.LevelInfo
+	}
+}
+
+// logRequest：每个请求结束时输出一行结构化日志，包含 HTTP 状态码、最终 status 与 totalMs。
+func logRequest(req events.APIGatewayProxyRequest, resp events.APIGatewayProxyResponse, elapsed time.Duration) {
+	var r struct {
+		Status  string          `json:"status"`
+		TotalMs int64           `json:"totalMs"`
+		Output  json.RawMessage `json:"output"`
+	}
+	_ = json.Unmarshal([]byte(resp.Body), &r)
+	var ids struct {
+		RunID string `json:"runId"`
+		ID    string `json:"id"`
+		Probe bool   `json:"probe"`
+	}
+	if json.Unmarshal(r.Output, &ids) != nil || ids.RunID == "" {
+		_ = json.Unmarshal([]byte(req.Body), &ids)
+	}
+	level := slog.LevelInfo
+	if resp.StatusCode >= 500 {
+		level = slog.LevelWarn
+	}
+	args := []any{
+		"runId", ids.RunID,
+		"id", ids.ID,
+		"httpStatus", resp.StatusCode,
+		"status", r.Status,
+		"totalMs", r.TotalMs,
+		"handlerMs", nanosToMs(int64(elapsed)),
+	}
+	// 探测请求单独标记，便于日志查询把它排除在正常测量之外。
+	if ids.Probe {
+		args = append(args, "probe", true)
+	}
+	slog.Log(context.Background(), level, "dispatcher request", args...)
+}
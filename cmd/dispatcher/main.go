@@ -7,25 +7,51 @@
 // 对应 SAM 资源：template.yaml 中的 DispatcherFunction
 // 环境变量：
 //   - PUSH_QUEUE_URL
+//   - PUSH_QUEUE_URLS（可选：逗号分隔的 Push 队列池，每次请求按 PUSH_QUEUE_SELECTION=round-robin|random 选一个；设置后覆盖 PUSH_QUEUE_URL）
 //   - RECEIVE_QUEUE_URL
+//   - RECEIVE_QUEUE_URLS（可选：逗号分隔的多个 Receive 队列，按优先级顺序轮询；设置后覆盖 RECEIVE_QUEUE_URL）
+//   - RECEIVE_QUEUE_URL_FALLBACK（可选：Receive 队列不存在时，本次请求剩余时间内改为轮询该队列）
+//   - PUSH_TRANSPORT（可选：sqs 默认 | sns；sns 时 Push 消息 Publish 到 PUSH_TOPIC_ARN，由订阅该主题的 Push 队列转给 Worker）
+//     eventbridge 时 PutEvents 到 EVENT_BUS_NAME（EVENT_SOURCE、EVENT_DETAIL_TYPE 可选），由规则投递到 Push 队列
+//   - CONFIRM_QUEUE_URL（可选：匹配到回调后向该队列发送 ack）
+//   - DLQ_URL（可选：failMode=always-error 时轮询的 Push 死信队列）
+//   - MAINTENANCE（可选：1/true 时新请求返回 503 MAINTENANCE，附 Retry-After=MAINTENANCE_RETRY_AFTER_SECONDS，默认 30）
+//   - SQS_HTTP_PROTOCOL（可选：h2|http1，SQS 客户端 HTTP 协议实验，默认沿用 SDK 设置）
+//   - LISTEN_ADDR（可选：设置后以本地 HTTP 服务运行 POST /run，而不是 Lambda）
+//   - LOCAL_WORKER（可选：仅 HTTP 变体，1/true 时在进程内消费 Push 队列并发送回调，代替 Worker Lambda）
+//   - RESULT_WEBHOOK_ALLOWLIST（可选：resultWebhook 允许的主机名，逗号分隔）
+//   - QUARANTINE_QUEUE_URL（可选：无法解析的回调转移到该队列，而不是直接删除）
+//   - ONE_WAY_TABLE（可选：oneWay 模式下 Worker 写入接收时间的 DynamoDB 表）
+//   - WORKER_FUNCTION_NAME（可选：ephemeral 模式下订阅到临时 Push 队列的 Worker 函数）
+//   - RESULT_BUCKET（可选：桶名或 s3://bucket/prefix，完整结果写入 S3，内联只返回摘要）
+//   - LOG_LEVEL（可选：debug|info|warn|error，JSON 结构化日志的级别，默认 info）
 package main
 
 import (
+	"bytes"
 	"context"
 	"crypto/rand"
 	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"log"
 	"os"
 	"path"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-lambda-go/lambdacontext"
 	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/eventbridge"
+	lambdasvc "github.com/aws/aws-sdk-go-v2/service/lambda"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/sns"
 	"github.com/aws/aws-sdk-go-v2/service/sqs"
 )
 
@@ -34,13 +60,147 @@ type apiRequest struct {
 	DelaySeconds     int    `json:"delaySeconds,omitempty"`
 	MessageBodyBytes int    `json:"messageBodyBytes,omitempty"`
 	MaxWaitMs        int    `json:"maxWaitMs,omitempty"`
+	// MaxRunDurationMs：整轮运行的墙钟上限（毫秒），与 MAX_RUN_DURATION_MS 取较小值，见 runDurationLimit。
+	MaxRunDurationMs int `json:"maxRunDurationMs,omitempty"`
+	// OutputFormat：输出格式，"nested"（默认）或 "flat"（单层 map，点号连接的 key）。
+	OutputFormat string `json:"outputFormat,omitempty"`
+	// Fetch：不发起新的往返，按 runId（可选 id）返回本容器内已记录的结果。
+	Fetch bool   `json:"fetch,omitempty"`
+	ID    string `json:"id,omitempty"`
+	// BatchDelaySeconds：非空时进入批量模式，用一次 SendMessageBatch 发送 len 条消息（最多 10 条），
+	// 第 i 条使用 BatchDelaySeconds[i] 作为 DelaySeconds（0..900）。
+	BatchDelaySeconds []int `json:"batchDelaySeconds,omitempty"`
+	// Debug：在输出中附带 debug 段（轮询明细等），用于深入排查。
+	Debug bool `json:"debug,omitempty"`
+	// TimeoutDiagnostics：仅单条模式，轮询超时时在响应中附带 timeoutDiagnostics（见 newTimeoutDiagnostics）。
+	TimeoutDiagnostics bool `json:"timeoutDiagnostics,omitempty"`
+	// SDKLog：仅单条模式，把本次请求内 SQS 调用的 HTTP 请求（含 body）与响应头写入 debug.sdkLog（截断、凭证脱敏）。
+	SDKLog bool `json:"sdkLog,omitempty"`
+	// SendStartToleranceNs：回调回显的 sendStartUnixNano 与发送值之差在该范围内即视为匹配（默认 0，精确相等）。
+	SendStartToleranceNs int64 `json:"sendStartToleranceNs,omitempty"`
+	// FailMode="always-error"：让 Worker 始终失败，测量消息经 maxReceiveCount 次重投后进入 DLQ 的耗时。
+	FailMode string `json:"failMode,omitempty"`
+	// InitialJitterMs：首次 SendMessage 前随机休眠 [0, initialJitterMs] 毫秒（默认 0，不休眠）。
+	InitialJitterMs int `json:"initialJitterMs,omitempty"`
+	// UltraMinimal：消息体只携带 id，其余字段放入 MessageAttributes。
+	UltraMinimal bool `json:"ultraMinimal,omitempty"`
+	// RetryBudgetMs：发送/接收/删除所有重试共享的时间预算（默认 500；<0 表示不重试）。
+	RetryBudgetMs int `json:"retryBudgetMs,omitempty"`
+	// PurgeReceiveQueue：发送前清空 Receive 队列（会影响共享该队列的其他使用者）。
+	PurgeReceiveQueue bool `json:"purgeReceiveQueue,omitempty"`
+	// DryRun：只对 Push/Receive 队列调用 GetQueueAttributes 确认可达与权限，不发送也不轮询（见 handleDryRun）。
+	DryRun bool `json:"dryRun,omitempty"`
+	// ResultWebhook：立即返回 202，完成后把最终响应 POST 到该 URL（仅 HTTP 变体）。
+	ResultWebhook string `json:"resultWebhook,omitempty"`
+	// RouteAttributes：作为 MessageAttributes 发送的路由属性，Worker 校验收到的属性与之完全一致。
+	RouteAttributes map[string]string `json:"routeAttributes,omitempty"`
+	// EchoPadding：Worker 回显 padding，Dispatcher 逐字节比较（messageBodyBytes 不超过 4096）。
+	EchoPadding bool `json:"echoPadding,omitempty"`
+	// RegionCheck：Worker 区域与 Dispatcher 不一致时的处理，"flag"（默认）/"strict"/"off"。
+	RegionCheck string `json:"regionCheck,omitempty"`
+	// QueueConfig：在输出中附带 Push 队列的 RedrivePolicy/VisibilityTimeout（容器内缓存 5 分钟）。
+	QueueConfig bool `json:"queueConfig,omitempty"`
+	// RampMaxConcurrency：非 0 时进入并发爬坡模式，并发从 1 增长到该值（最多 10），寻找 p99 劣化的拐点。
+	RampMaxConcurrency int `json:"rampMaxConcurrency,omitempty"`
+	// RampStep：爬坡步进，"double"（默认，1,2,4,...）或 "linear"（1,2,3,...）。
+	RampStep string `json:"rampStep,omitempty"`
+	// ResultS3Uri：把完整结果写入 s3://bucket/prefix（缺省时使用 RESULT_BUCKET），内联只返回精简摘要。
+	ResultS3Uri string `json:"resultS3Uri,omitempty"`
+	// CompareAttributes：非 0 时进入属性放置对比模式，共跑该轮数（最多 5），每轮用户属性/系统属性各发一条。
+	CompareAttributes int `json:"compareAttributes,omitempty"`
+	// CompareSSE：非 0 时进入加密对比模式，共跑该轮数（最多 10），每轮在 SSE/非 SSE 两组队列上各往返一条；
+	// SSEPrimeRounds 为正式测量前每组不计入结果的预热往返次数（缺省 1）。
+	CompareSSE     int  `json:"compareSse,omitempty"`
+	SSEPrimeRounds *int `json:"ssePrimeRounds,omitempty"`
+	// OneWay：Worker 不发回调，改为把接收时间写入 ONE_WAY_TABLE（DynamoDB），Dispatcher 用 GetItem 取回。
+	OneWay bool `json:"oneWay,omitempty"`
+	// IDFormat：消息 id 格式，"hex"（默认）或 "ulid"（嵌入生成时间的毫秒时间戳）。
+	IDFormat string `json:"idFormat,omitempty"`
+	// PureForwardLeg：输出只基于 SQS 时间戳的去程延迟 pureForwardLegMs（见 applyPureForwardLeg）。
+	PureForwardLeg bool `json:"pureForwardLeg,omitempty"`
+	// PollWaitSeconds：单条模式下轮询回调的长轮询时长（0..20，默认 20；0 为短轮询），实际值不超过剩余截止时间。
+	PollWaitSeconds *int `json:"pollWaitSeconds,omitempty"`
+	// BatchReceive：轮询回调时单次 ReceiveMessage 最多取回的消息数（1..10，默认 1），并发负载下减少往返与逐条释放。
+	BatchReceive int `json:"batchReceive,omitempty"`
+	// Iterations：大于 1 时在一次请求内顺序跑该轮数的单条往返（每轮新的 id），在 stats 中返回汇总百分位。
+	Iterations int `json:"iterations,omitempty"`
+	// ConfidenceIntervals：iterations 模式下额外输出 totalMs/pipelineLatencyMs 的均值与 p99 95% 置信区间（bootstrap）。
+	ConfidenceIntervals bool `json:"confidenceIntervals,omitempty"`
+	// MessageGroupId：Push 队列为 FIFO（.fifo）时的 MessageGroupId，默认取 runId；标准队列忽略。
+	MessageGroupId string `json:"messageGroupId,omitempty"`
+	// WorkMs：Worker 在记录 workerReceive 与 workerDone 之间模拟处理的时长（0..maxWorkMs 毫秒，默认 0）。
+	WorkMs int `json:"workMs,omitempty"`
+	// VisibilitySweep：非空时进入可见性超时扫描模式，依次以每个取值（秒，0..30）作为轮询的 VisibilityTimeout，
+	// 在固定并发下比较共享 Receive 队列的争用（foreignGrabCount）与匹配延迟。
+	VisibilitySweep []int `json:"visibilitySweep,omitempty"`
+	// VisibilitySweepConcurrency：visibilitySweep 每级的并发请求数（1..10，默认 4）。
+	VisibilitySweepConcurrency int `json:"visibilitySweepConcurrency,omitempty"`
+	// FifoGroupCount：FIFO Push 队列的批量模式下把消息轮流分散到该数量的 MessageGroupId（1..10），测试高吞吐 FIFO。
+	FifoGroupCount int `json:"fifoGroupCount,omitempty"`
+	// FifoBlocking：FIFO Push 队列上测量组内队头阻塞：2..10 条消息先全部发往同一分组、再分散到同样数量的分组各跑一轮，
+	// 逐位置比较到达 Worker 的等待时间（需要 workMs > 0）。
+	FifoBlocking int `json:"fifoBlocking,omitempty"`
+	// ByWorkerInstance：批量/多轮模式下按 workerInstanceId 输出各 Worker 容器的延迟分布（output.workerInstances）。
+	ByWorkerInstance bool `json:"byWorkerInstance,omitempty"`
+	// ForceSample：单条模式下 Push 消息携带 Sampled=1 的 AWSTraceHeader，保证整条往返被追踪后端采样。
+	ForceSample bool `json:"forceSample,omitempty"`
+	// ProbeWorker：只发送一条探测消息确认 Worker 存活并测量最小往返延迟（output.probeLatencyMs），见 handleProbeWorker。
+	ProbeWorker bool `json:"probeWorker,omitempty"`
+	// Count/Concurrency：并行在途模式，最多 concurrency 条同时在途、共发送 count 条并等待全部回调（见 handleParallel）。
+	Count       int `json:"count,omitempty"`
+	Concurrency int `json:"concurrency,omitempty"`
+	// MonotonicTimeline：单条模式下按估计的时钟偏差平移 Worker 侧时间戳，输出单调的展示用时间线（output.timeline）。
+	MonotonicTimeline bool `json:"monotonicTimeline,omitempty"`
+	// Compress：对消息体 gzip + base64 并加 gz1: 前缀（Worker 识别后解压），使大 messageBodyBytes 不超出 256KB 上限。
+	Compress bool `json:"compress,omitempty"`
+	// Ephemeral：创建临时 Push/Receive 队列并把 Worker 订阅上去，测量后全部拆除（见 handleEphemeral）。
+	Ephemeral bool `json:"ephemeral,omitempty"`
+
+	// requestTimeEpochMs：API Gateway 收到请求的时间（RequestContext.RequestTimeEpoch），非代理调用时为 0。
+	requestTimeEpochMs int64
+	// resultS3：解析后的结果存储位置，未配置时为 nil。
+	resultS3 *s3Location
+	// attributePlacement/traceHeader：compareAttributes 模式下本条消息携带 trace header 的方式。
+	attributePlacement string
+	traceHeader        string
+	// ids：本次请求内已发出的消息 id，sendBatch 据此避免重复 id。
+	ids *idSet
+	// visibilityTimeout：visibilitySweep 模式下当前级别轮询使用的可见性超时。
+	visibilityTimeout *int32
+	// reproCurl：HTTP 变体或 debug=true 时，按生效参数渲染的复现命令（见 reproCurl）。
+	reproCurl string
+	// callbackQueueURL：ephemeral 模式的临时 Receive 队列，写入 msgBody.CallbackQueueURL。
+	callbackQueueURL string
 }
 
 type apiResponse struct {
-	Status  string          `json:"status"`
-	TotalMs int64           `json:"totalMs"`
+	Status  string `json:"status"`
+	TotalMs int64  `json:"totalMs"`
+	// TotalUs：与 totalMs 同一区间的微秒精度值（由纳秒计算），用于区分同区域的亚毫秒差异。
+	TotalUs float64         `json:"totalUs,omitempty"`
 	Output  json.RawMessage `json:"output,omitempty"`
 	Error   string          `json:"error,omitempty"`
+	// PerceivedLatencyMs：API Gateway 收到请求到 handler 准备返回的耗时，近似客户端感知延迟；
+	// 缺少 RequestTimeEpoch（非 API Gateway 代理调用）时省略。
+	PerceivedLatencyMs int64 `json:"perceivedLatencyMs,omitempty"`
+	// 结果写入 S3 时的对象 URI 与 PutObject 耗时；写入失败时 S3Error 非空且 output 为完整结果。
+	ResultS3Uri string  `json:"resultS3Uri,omitempty"`
+	S3PutMs     float64 `json:"s3PutMs,omitempty"`
+	S3Error     string  `json:"s3Error,omitempty"`
+	// Stats：iterations 模式下各阶段延迟的汇总（只统计成功轮次）。
+	Stats *iterationStats `json:"stats,omitempty"`
+	// 单条模式的时间预算明细（超时响应同样输出）：budgetMs 为生效的 maxWait，elapsedSendMs 为发送（含重试）耗时，
+	// pollAttempts 为 ReceiveMessage 次数。超时且 pollAttempts 很多说明 Worker 一直没有回调，而非回调慢。
+	BudgetMs      int64   `json:"budgetMs,omitempty"`
+	ElapsedSendMs float64 `json:"elapsedSendMs,omitempty"`
+	PollAttempts  int     `json:"pollAttempts,omitempty"`
+	// Adjustments：规范化请求时被钳制或改写的字段（见 requestAdjustment）。
+	Adjustments []requestAdjustment `json:"adjustments,omitempty"`
+	// 配置了运行时长上限时输出：runDurationMs 为整轮墙钟耗时，runDeadlineExceeded 表示被上限截断（status=RUN_DEADLINE）。
+	RunDurationMs       int64 `json:"runDurationMs,omitempty"`
+	RunDeadlineExceeded bool  `json:"runDeadlineExceeded,omitempty"`
+	// TimeoutDiagnostics：timeoutDiagnostics=true 且单条模式轮询超时时的部分时间线。
+	TimeoutDiagnostics *timeoutDiagnostics `json:"timeoutDiagnostics,omitempty"`
 }
 
 type dispatcherOutput struct {
@@ -50,6 +210,15 @@ type dispatcherOutput struct {
 	Region           string `json:"region"`
 	PushQueueName    string `json:"pushQueueName"`
 	ReceiveQueueName string `json:"receiveQueueName"`
+	// RetrievalMethod：结果取回方式，回调模式为 sqs-callback（oneWay 模式见 oneWayOutput）。
+	RetrievalMethod string `json:"retrievalMethod"`
+	// idFormat=ulid 时 id 中嵌入的毫秒时间戳，以及它相对 sendStartUnixNano 的偏差（新旧两种打点应当一致）。
+	UlidEmbeddedTimeMs int64    `json:"ulidEmbeddedTimeMs,omitempty"`
+	UlidSkewMs         *float64 `json:"ulidSkewMs,omitempty"`
+	// pureForwardLeg=true 时输出：workerReceive - SentTimestamp 与 FirstReceive - Sent；forwardLegSkew 标记时钟偏差。
+	PureForwardLegMs    *float64 `json:"pureForwardLegMs,omitempty"`
+	SqsOnlyForwardLegMs *int64   `json:"sqsOnlyForwardLegMs,omitempty"`
+	ForwardLegSkew      bool     `json:"forwardLegSkew,omitempty"`
 
 	DispatchStartUnixNano int64 `json:"dispatchStartUnixNano"`
 	SendUnixNano          int64 `json:"sendUnixNano"`
@@ -60,14 +229,137 @@ type dispatcherOutput struct {
 	PollEndUnixNano   int64 `json:"pollEndUnixNano"`
 
 	// Worker 回写的时间戳与元数据
-	WorkerReceiveUnixNano      int64 `json:"workerReceiveUnixNano"`
-	WorkerDoneUnixNano         int64 `json:"workerDoneUnixNano"`
-	CallbackSendStartUnixNano  int64 `json:"callbackSendStartUnixNano"`
-	CallbackSendEndUnixNano    int64 `json:"callbackSendEndUnixNano"`
-	ReceiveMessageUnixNano     int64 `json:"receiveMessageUnixNano"`
+	WorkerReceiveUnixNano     int64 `json:"workerReceiveUnixNano"`
+	WorkerDoneUnixNano        int64 `json:"workerDoneUnixNano"`
+	CallbackSendStartUnixNano int64 `json:"callbackSendStartUnixNano"`
+	CallbackSendEndUnixNano   int64 `json:"callbackSendEndUnixNano"`
+	ReceiveMessageUnixNano    int64 `json:"receiveMessageUnixNano"`
+	// 回调消息在 SQS 端的 SentTimestamp；callbackSendMs = 它 - callbackSendStartUnixNano（跨时钟近似）。
+	CallbackSqsSentTimestampMs int64 `json:"callbackSqsSentTimestampMs"`
+	CallbackSendMs             int64 `json:"callbackSendMs"`
+
+	// 由原始纳秒计算的微秒精度耗时，两端时间戳都存在时输出：pipelineLatencyUs 同 pipelineLatencyMs；
+	// sendUs = sendEnd - sendStart；pollUs = pollEnd - pollStart；workerUs = workerDone - workerReceive（Worker 时钟）。
+	PipelineLatencyUs float64 `json:"pipelineLatencyUs,omitempty"`
+	SendUs            float64 `json:"sendUs,omitempty"`
+	PollUs            float64 `json:"pollUs,omitempty"`
+	WorkerUs          float64 `json:"workerUs,omitempty"`
+	// 与 sendUs、workerUs 首尾相接的跨时钟分段：queueToWorkerUs = workerReceive - sendEnd（Dispatcher→Worker），
+	// callbackSendUs = 回调 SentTimestamp - callbackSendStart（Worker→SQS，毫秒精度），
+	// receiveLatencyUs = receiveMessage - 回调 SentTimestamp（SQS→Dispatcher，毫秒精度）。
+	// 时钟偏差导致差值为负时记 0（省略）并置 skewDetected。
+	QueueToWorkerUs  float64 `json:"queueToWorkerUs,omitempty"`
+	CallbackSendUs   float64 `json:"callbackSendUs,omitempty"`
+	ReceiveLatencyUs float64 `json:"receiveLatencyUs,omitempty"`
+	SkewDetected     bool    `json:"skewDetected,omitempty"`
+	// estimatedSkewMs：以 SQS SentTimestamp 为参照估计的 Worker 时钟 - Dispatcher 时钟（正值表示 Worker 时钟快），
+	// 由 workerSqsOffsetMs - dispatcherSqsOffsetMs 得出（各为本地时钟 - SQS 时钟，见 clockSkew）。
+	// 假设 SendMessage 的网络单程耗时远小于偏差、往返期间时钟不漂移；估计值偏小，误差约为两次单程耗时之和。
+	// 可估计时 queueToWorkerUs 已按它校正（workerReceive 换算到 Dispatcher 时钟），未校正的原值见 queueToWorkerRawUs。
+	EstimatedSkewMs       *float64 `json:"estimatedSkewMs,omitempty"`
+	DispatcherSqsOffsetMs *float64 `json:"dispatcherSqsOffsetMs,omitempty"`
+	WorkerSqsOffsetMs     *float64 `json:"workerSqsOffsetMs,omitempty"`
+	QueueToWorkerRawUs    float64  `json:"queueToWorkerRawUs,omitempty"`
+
+	// 纯管线耗时：sendEnd -> receiveMessage（均为 Dispatcher 本地时钟），
+	// 不含发送前的准备与返回前的序列化；apiResponse.totalMs 则包含 Dispatcher 自身开销。
+	PipelineLatencyMs          int64 `json:"pipelineLatencyMs"`
 	SqsSentTimestampMs         int64 `json:"sqsSentTimestampMs"`
 	SqsFirstReceiveTimestampMs int64 `json:"sqsFirstReceiveTimestampMs"`
 	SqsApproxReceiveCount      int64 `json:"sqsApproxReceiveCount"`
+	// Worker 计算的 workerReceive - ApproximateFirstReceiveTimestamp：事件源映射轮询 + 调用 Worker 的开销，
+	// 与纯队列传输（sqsOnlyForwardLegMs）分开；低于 -1ms（毫秒精度容差）时说明 Worker 与 SQS 时钟有偏差，置 esmInvokeLatencySkew。
+	EsmInvokeLatencyMs   *float64 `json:"esmInvokeLatencyMs,omitempty"`
+	EsmInvokeLatencySkew bool     `json:"esmInvokeLatencySkew,omitempty"`
+
+	// 调用分类（cold|near-cold|warm）：Dispatcher 自身与 Worker（来自回调），见 trackInvocation。
+	InvocationClass             string `json:"invocationClass"`
+	ContainerRequestCount       int64  `json:"containerRequestCount"`
+	InitToFirstInvokeMs         int64  `json:"initToFirstInvokeMs"`
+	WorkerInvocationClass       string `json:"workerInvocationClass"`
+	WorkerContainerRequestCount int64  `json:"workerContainerRequestCount"`
+	WorkerInitToFirstInvokeMs   int64  `json:"workerInitToFirstInvokeMs"`
+	// forceSample=true 时实际发送的 trace id（Root）与采样决定；workerTraceSampled 为 Worker 收到的 AWSTraceHeader 中的采样位。
+	TraceID            string `json:"traceId,omitempty"`
+	TraceSampled       *bool  `json:"traceSampled,omitempty"`
+	WorkerTraceSampled *bool  `json:"workerTraceSampled,omitempty"`
+	// ColdStart/WorkerColdStart：该调用是各自容器初始化后的第一次调用（预置并发的首调同样为 true，此时 invocationClass 不是 cold）。
+	ColdStart         bool   `json:"coldStart"`
+	WorkerColdStart   bool   `json:"workerColdStart"`
+	WorkerInstanceID  string `json:"workerInstanceId"`
+	DispatcherVersion string `json:"dispatcherVersion"`
+	WorkerVersion     string `json:"workerVersion"`
+	// monotonicTimeline=true 时的修正时间线（原始时间戳不变）。
+	Timeline *monotonicTimeline `json:"timeline,omitempty"`
+	// 两端版本不一致（部分部署），测量结果可能混入了新旧两套代码。
+	VersionMismatch bool `json:"versionMismatch,omitempty"`
+	// Worker 回写的区域；与 region 不一致说明单区域测试混入了跨区域的 Worker。
+	WorkerRegion   string `json:"workerRegion,omitempty"`
+	RegionMismatch bool   `json:"regionMismatch,omitempty"`
+	// Worker 对路由属性的校验结果（未请求校验时省略）。
+	RouteVerified *bool  `json:"routeVerified,omitempty"`
+	RouteMismatch string `json:"routeMismatch,omitempty"`
+	// echoPadding 的逐字节比较结果；不一致时给出第一个不同字节的偏移。
+	PaddingVerified       *bool `json:"paddingVerified,omitempty"`
+	PaddingMismatchOffset *int  `json:"paddingMismatchOffset,omitempty"`
+
+	// 仅在配置 CONFIRM_QUEUE_URL 时输出：回写 ack 的耗时（亚毫秒精度，不会因截断为 0 而省略）与错误。
+	AckSendMs float64 `json:"ackSendMs,omitempty"`
+	AckError  string  `json:"ackError,omitempty"`
+
+	// 回调是否直接取自进程内暂存区（见 callbackStash），以及本容器累计的查询/命中次数。
+	StashHit     bool  `json:"stashHit"`
+	StashLookups int64 `json:"stashLookups"`
+	StashHits    int64 `json:"stashHits"`
+
+	// runId/id 匹配但 sendStart 回显超出容差而被拒绝的回调数。
+	SendStartRejects int `json:"sendStartRejects,omitempty"`
+
+	// 匹配回调的 DeleteMessage 重试后仍失败：该回调之后可能被重复投递。
+	DeleteFailed bool `json:"deleteFailed,omitempty"`
+	// 发送前实际休眠的抖动时长（initialJitterMs > 0 时）。
+	AppliedJitterMs float64 `json:"appliedJitterMs,omitempty"`
+	// Push 消息体的实际字节数（不含 MessageAttributes）。
+	BodyBytes int `json:"bodyBytes"`
+	// compress=true 时压缩前的消息体字节数。
+	UncompressedBodyBytes int `json:"uncompressedBodyBytes,omitempty"`
+	// 本次请求各阶段重试实际消耗的共享预算。
+	RetryBudgetUsedMs float64 `json:"retryBudgetUsedMs"`
+	// SendAttempts：发送到 Push 队列的尝试次数（含首次，见 retrySend）。
+	SendAttempts int `json:"sendAttempts"`
+	// 本次轮询期间转移到 QUARANTINE_QUEUE_URL 的无法解析的消息数。
+	QuarantinedCount int `json:"quarantinedCount,omitempty"`
+	// 本次轮询收到的其他请求的回调数（共享 Receive 队列时的争用）。
+	ForeignGrabCount int `json:"foreignGrabCount,omitempty"`
+	// Receive 队列不存在，回调改从 RECEIVE_QUEUE_URL_FALLBACK 取得。
+	UsedFallbackQueue bool `json:"usedFallbackQueue,omitempty"`
+	// 回调已进入 Receive 队列（SQS SentTimestamp）到本进程匹配到它的耗时：
+	// 反映轮询自身的排队（例如并发时处理其他请求的消息），而非链路延迟。跨 SQS 与本机时钟，毫秒精度。
+	HeadOfLineDelayMs int64 `json:"headOfLineDelayMs"`
+	// 配置了 Push 队列池时，本容器内各队列累计的发送次数。
+	PushQueueDistribution map[string]int64 `json:"pushQueueDistribution,omitempty"`
+	// 本次请求发送前是否清空了 Receive 队列。
+	ReceiveQueuePurged bool `json:"receiveQueuePurged,omitempty"`
+
+	Build buildInfo `json:"build"`
+	// 请求 queueConfig 时附带的 Push 队列有效重投配置。
+	QueueConfig *queueConfig `json:"queueConfig,omitempty"`
+
+	// 仅在请求 debug=true 时输出。
+	Debug *debugInfo `json:"debug,omitempty"`
+}
+
+// debugInfo：debug=true 时附带的排查信息。
+type debugInfo struct {
+	Poll *pollDebug `json:"poll,omitempty"`
+	// 最近一次 SQS 响应协商到的 HTTP 协议（SQS_HTTP_PROTOCOL 实验）。
+	SQSHTTPProtocol *httpProtocolInfo `json:"sqsHttpProtocol,omitempty"`
+	// 本容器 SQS 连接累计的 TLS 握手次数与会话恢复比例。
+	TLS *tlsHandshakeInfo `json:"tls,omitempty"`
+	// Curl：按生效（收紧后）参数复现本次请求的 curl 命令，敏感请求头已脱敏。
+	Curl string `json:"curl,omitempty"`
+	// SDKLog：sdkLog=true 时捕获的 SDK 请求/响应日志，见 withSDKLogging。
+	SDKLog string `json:"sdkLog,omitempty"`
 }
 
 type msgBody struct {
@@ -76,11 +368,47 @@ type msgBody struct {
 	SendStartUnixNano int64  `json:"sendStartUnixNano"`
 	RunID             string `json:"runId"`
 	Padding           string `json:"padding,omitempty"`
+	FailMode          string `json:"failMode,omitempty"`
+	// Dispatcher 的部署版本，Worker 原样回显。
+	DispatcherVersion string `json:"dispatcherVersion,omitempty"`
+	// 路由属性的期望值（同时作为 MessageAttributes 发送），Worker 据此校验收到的属性。
+	RouteAttributes map[string]string `json:"routeAttributes,omitempty"`
+	// EchoPadding：要求 Worker 在回调中原样回显 padding。
+	EchoPadding bool `json:"echoPadding,omitempty"`
+	// OneWay：Worker 把接收时间写入 ONE_WAY_TABLE，不发送回调。
+	OneWay bool `json:"oneWay,omitempty"`
+	// WorkMs：Worker 模拟处理的时长（毫秒）。
+	WorkMs int `json:"workMs,omitempty"`
+	// CallbackQueueURL：ephemeral 模式下 Worker 改把回调发往该临时队列（Worker 只接受 ephemeralQueuePrefix 开头的队列）。
+	CallbackQueueURL string `json:"callbackQueueUrl,omitempty"`
+	// Probe：probeWorker 的探测消息，Worker 跳过 workMs 立即回调，且不计入 Worker 的正常处理日志。
+	Probe bool `json:"probe,omitempty"`
+}
+
+// maxWorkMs：workMs 的上限，需低于 Worker 的 Lambda 超时（template.yaml 中为 30 秒）与 Push 队列的可见性超时，
+// 否则消息会在处理中途被重投。
+const maxWorkMs = 25000
+
+// marshal 生成发往 Push 队列的消息体。FIFO 基于内容去重依赖字节级一致：
+// 字段只能是结构体字段（按声明顺序输出）或 map（encoding/json 按 key 排序输出），
+// 不要改用会打乱顺序的自定义 MarshalJSON。
+func (b msgBody) marshal() []byte {
+	out, _ := json.Marshal(b)
+	return out
+}
+
+// ackMessage：Dispatcher 匹配到回调后发往 Confirm 队列的确认消息（请求/响应/确认三段式）。
+type ackMessage struct {
+	ID          string `json:"id"`
+	RunID       string `json:"runId"`
+	AckUnixNano int64  `json:"ackUnixNano"`
 }
 
 type callbackMessage struct {
 	ID    string `json:"id"`
 	RunID string `json:"runId"`
+	// Probe：Worker 识别并应答了探测消息。
+	Probe bool `json:"probe,omitempty"`
 
 	Region           string `json:"region"`
 	PushQueueName    string `json:"pushQueueName"`
@@ -92,11 +420,24 @@ type callbackMessage struct {
 	WorkerReceiveUnixNano     int64 `json:"workerReceiveUnixNano"`
 	WorkerDoneUnixNano        int64 `json:"workerDoneUnixNano"`
 	CallbackSendStartUnixNano int64 `json:"callbackSendStartUnixNano"`
-	CallbackSendEndUnixNano   int64 `json:"callbackSendEndUnixNano"`
 
-	SqsSentTimestampMs         int64 `json:"sqsSentTimestampMs"`
-	SqsFirstReceiveTimestampMs int64 `json:"sqsFirstReceiveTimestampMs"`
-	SqsApproxReceiveCount      int64 `json:"sqsApproxReceiveCount"`
+	SqsSentTimestampMs         int64    `json:"sqsSentTimestampMs"`
+	SqsFirstReceiveTimestampMs int64    `json:"sqsFirstReceiveTimestampMs"`
+	SqsApproxReceiveCount      int64    `json:"sqsApproxReceiveCount"`
+	EsmInvokeLatencyMs         *float64 `json:"esmInvokeLatencyMs,omitempty"`
+
+	WorkerInvocationClass       string `json:"workerInvocationClass"`
+	WorkerContainerRequestCount int64  `json:"workerContainerRequestCount"`
+	WorkerInitToFirstInvokeMs   int64  `json:"workerInitToFirstInvokeMs"`
+	WorkerColdStart             bool   `json:"workerColdStart,omitempty"`
+	WorkerInstanceID            string `json:"workerInstanceId"`
+	DispatcherVersion           string `json:"dispatcherVersion"`
+	WorkerVersion               string `json:"workerVersion"`
+	RouteVerified               *bool  `json:"routeVerified,omitempty"`
+	RouteMismatch               string `json:"routeMismatch,omitempty"`
+	EchoedPadding               string `json:"echoedPadding,omitempty"`
+	// TraceHeader：Worker 收到的 AWSTraceHeader（消息未携带时省略）。
+	TraceHeader string `json:"traceHeader,omitempty"`
 }
 
 var (
@@ -104,18 +445,38 @@ var (
 	initErr  error
 
 	awsCfg    = struct{ Region string }{}
-	sqsClient *sqs.Client
+	sqsClient sqsAPI
+
+	dispatcherVersion = deployVersion()
 )
 
 func initAWS() {
 	initOnce.Do(func() {
+		markColdStart()
+		initLogger()
 		cfg, err := config.LoadDefaultConfig(context.Background())
 		if err != nil {
 			initErr = fmt.Errorf("load aws config: %w", err)
 			return
 		}
 		awsCfg.Region = cfg.Region
-		sqsClient = sqs.NewFromConfig(cfg)
+		opts := append(httpProtocolOptions(), injectedLatencyOptions()...)
+		opts = append(opts, xrayOptions(&cfg)...)
+		opts = append(opts, withSDKLogging)
+		sqsClient = sqs.NewFromConfig(cfg, opts...)
+		s3Client = s3.NewFromConfig(cfg)
+		if strings.TrimSpace(os.Getenv("ONE_WAY_TABLE")) != "" {
+			dynamoDBClient = dynamodb.NewFromConfig(cfg)
+		}
+		if strings.TrimSpace(os.Getenv("WORKER_FUNCTION_NAME")) != "" {
+			lambdaClient = lambdasvc.NewFromConfig(cfg)
+		}
+		switch pushTransport() {
+		case pushTransportSNS:
+			snsClient = sns.NewFromConfig(cfg)
+		case pushTransportEventBridge:
+			eventBridgeClient = eventbridge.NewFromConfig(cfg)
+		}
 	})
 }
 
@@ -130,6 +491,30 @@ func jsonResp(status int, v any) (events.APIGatewayProxyResponse, error) {
 	}, nil
 }
 
+func nanosToUs(n int64) float64 {
+	return float64(n) / float64(time.Microsecond)
+}
+
+func nanosToMs(n int64) float64 {
+	return float64(n) / float64(time.Millisecond)
+}
+
+// durationUs：start/end 任一缺失（<=0）或倒序时返回 0（输出中省略）。
+func durationUs(start, end int64) float64 {
+	if start <= 0 || end < start {
+		return 0
+	}
+	return nanosToUs(end - start)
+}
+
+// crossClockUs：与 durationUs 相同，但两端来自不同时钟；两端都存在且倒序时置 skew。
+func crossClockUs(start, end int64, skew *bool) float64 {
+	if start > 0 && end > 0 && end < start {
+		*skew = true
+	}
+	return durationUs(start, end)
+}
+
 func clampInt(v, minV, maxV int) int {
 	if v < minV {
 		return minV
@@ -140,13 +525,16 @@ func clampInt(v, minV, maxV int) int {
 	return v
 }
 
+// maxEffectiveWait：API Gateway 最大 29s；本函数 Timeout 30s。
+const maxEffectiveWait = 28 * time.Second
+
 func effectiveTimeout(ctx context.Context, requested time.Duration) time.Duration {
-	// API Gateway 最大 29s；本函数 Timeout 30s；默认目标：25s。
+	// 默认目标：25s。
 	if requested <= 0 {
 		requested = 25 * time.Second
 	}
-	if requested > 28*time.Second {
-		requested = 28 * time.Second
+	if requested > maxEffectiveWait {
+		requested = maxEffectiveWait
 	}
 
 	deadline, ok := ctx.Deadline()
@@ -164,17 +552,30 @@ func effectiveTimeout(ctx context.Context, requested time.Duration) time.Duratio
 }
 
 func handler(ctx context.Context, req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	start := time.Now()
+	resp, err := handleRequest(ctx, req)
+	logRequest(req, resp, time.Since(start))
+	emitRequestMetrics(resp)
+	return resp, err
+}
+
+func handleRequest(ctx context.Context, req events.APIGatewayProxyRequest) (resp events.APIGatewayProxyResponse, err error) {
+	inv := trackInvocation()
 	initAWS()
+	inv.ColdStart = takeColdStart()
+	if isHealthCheck(req) {
+		return handleHealth(inv)
+	}
 	if initErr != nil {
 		return jsonResp(500, apiResponse{Status: "ERROR", Error: initErr.Error()})
 	}
 
-	pushQueueURL := strings.TrimSpace(os.Getenv("PUSH_QUEUE_URL"))
-	if pushQueueURL == "" {
+	pushQueueURLs := pushQueueURLsFromEnv()
+	if len(pushQueueURLs) == 0 {
 		return jsonResp(500, apiResponse{Status: "ERROR", Error: "missing env PUSH_QUEUE_URL"})
 	}
-	receiveQueueURL := strings.TrimSpace(os.Getenv("RECEIVE_QUEUE_URL"))
-	if receiveQueueURL == "" {
+	receiveQueueURLs := receiveQueueURLsFromEnv()
+	if len(receiveQueueURLs) == 0 {
 		return jsonResp(500, apiResponse{Status: "ERROR", Error: "missing env RECEIVE_QUEUE_URL"})
 	}
 
@@ -184,143 +585,774 @@ func handler(ctx context.Context, req events.APIGatewayProxyRequest) (events.API
 			return jsonResp(400, apiResponse{Status: "ERROR", Error: fmt.Sprintf("invalid json body: %v", err)})
 		}
 	}
+	switch body.OutputFormat {
+	case "", outputFormatNested, outputFormatFlat, outputFormatArrow:
+	default:
+		return jsonResp(400, apiResponse{Status: "ERROR", Error: fmt.Sprintf("invalid outputFormat: %q", body.OutputFormat)})
+	}
+	if body.Fetch {
+		return fetchResult(body)
+	}
+	// 维护模式：不再接收新的发送请求（fetch 与已在轮询中的请求不受影响），便于部署或迁移队列。
+	if maintenanceMode() {
+		resp, err := jsonResp(503, apiResponse{Status: "MAINTENANCE", Error: "dispatcher is in maintenance mode"})
+		resp.Headers["Retry-After"] = strconv.Itoa(envIntDefault("MAINTENANCE_RETRY_AFTER_SECONDS", 30))
+		return resp, err
+	}
+	body.requestTimeEpochMs = req.RequestContext.RequestTimeEpoch
 	if strings.TrimSpace(body.RunID) == "" {
 		body.RunID = fmt.Sprintf("run-%d", time.Now().UnixNano())
 	}
-	body.DelaySeconds = clampInt(body.DelaySeconds, 0, 900)
-	if body.MessageBodyBytes < 0 {
-		body.MessageBodyBytes = 0
+	if body.ResultWebhook != "" {
+		if !httpMode {
+			return jsonResp(400, apiResponse{Status: "ERROR", Error: "resultWebhook requires the HTTP variant (LISTEN_ADDR); Lambda freezes background work after responding"})
+		}
+		if err := validateResultWebhook(body.ResultWebhook); err != nil {
+			return jsonResp(400, apiResponse{Status: "ERROR", Error: err.Error()})
+		}
+		return acceptWithWebhook(body)
+	}
+	// 规范化过程中改动的字段随响应返回（apiResponse.adjustments），包括之后各处的错误响应。
+	var adj adjustments
+	defer func() { resp = withAdjustments(resp, adj) }()
+	body.DelaySeconds = adj.clamp("delaySeconds", body.DelaySeconds, 0, 900)
+	body.WorkMs = adj.clamp("workMs", body.WorkMs, 0, maxWorkMs)
+	if body.PollWaitSeconds != nil {
+		w := adj.clamp("pollWaitSeconds", *body.PollWaitSeconds, 0, maxPollWaitSeconds)
+		body.PollWaitSeconds = &w
+	}
+	body.MessageBodyBytes = int(adj.nonNegative("messageBodyBytes", int64(body.MessageBodyBytes)))
+	body.SendStartToleranceNs = adj.nonNegative("sendStartToleranceNs", body.SendStartToleranceNs)
+	if body.RetryBudgetMs == 0 {
+		body.RetryBudgetMs = defaultRetryBudgetMs
+	}
+	body.InitialJitterMs = int(adj.nonNegative("initialJitterMs", int64(body.InitialJitterMs)))
+	if body.FailMode != "" && body.FailMode != failModeAlwaysError {
+		return jsonResp(400, apiResponse{Status: "ERROR", Error: fmt.Sprintf("invalid failMode: %q", body.FailMode)})
+	}
+	if err := validateEchoPadding(body); err != nil {
+		return jsonResp(400, apiResponse{Status: "ERROR", Error: err.Error()})
+	}
+	if err := validateRouteAttributes(body.RouteAttributes); err != nil {
+		return jsonResp(400, apiResponse{Status: "ERROR", Error: err.Error()})
+	}
+	if err := validateMessageAttributeCount(body); err != nil {
+		return jsonResp(400, apiResponse{Status: "ERROR", Error: err.Error()})
+	}
+	if err := validateFIFO(body, pushQueueURLs); err != nil {
+		return jsonResp(400, apiResponse{Status: "ERROR", Error: err.Error()})
+	}
+	if err := validateBatchDelays(body.BatchDelaySeconds); err != nil {
+		return jsonResp(400, apiResponse{Status: "ERROR", Error: err.Error()})
+	}
+	if err := validateRegionCheck(body.RegionCheck); err != nil {
+		return jsonResp(400, apiResponse{Status: "ERROR", Error: err.Error()})
+	}
+	if err := validateRamp(body); err != nil {
+		return jsonResp(400, apiResponse{Status: "ERROR", Error: err.Error()})
+	}
+	if err := validateAttributeComparison(body); err != nil {
+		return jsonResp(400, apiResponse{Status: "ERROR", Error: err.Error()})
+	}
+	if err := validateOneWay(body); err != nil {
+		return jsonResp(400, apiResponse{Status: "ERROR", Error: err.Error()})
+	}
+	if err := validateIDFormat(body.IDFormat); err != nil {
+		return jsonResp(400, apiResponse{Status: "ERROR", Error: err.Error()})
+	}
+	if err := validateIterations(body); err != nil {
+		return jsonResp(400, apiResponse{Status: "ERROR", Error: err.Error()})
+	}
+	if err := validateVisibilitySweep(body); err != nil {
+		return jsonResp(400, apiResponse{Status: "ERROR", Error: err.Error()})
+	}
+	if err := validateEphemeral(body); err != nil {
+		return jsonResp(400, apiResponse{Status: "ERROR", Error: err.Error()})
+	}
+	if err := validatePushTransport(body); err != nil {
+		return jsonResp(400, apiResponse{Status: "ERROR", Error: err.Error()})
+	}
+	if err := validateFIFOBlocking(body, pushQueueURLs); err != nil {
+		return jsonResp(400, apiResponse{Status: "ERROR", Error: err.Error()})
+	}
+	if err := validateByWorkerInstance(body); err != nil {
+		return jsonResp(400, apiResponse{Status: "ERROR", Error: err.Error()})
+	}
+	if err := validateForceSample(body); err != nil {
+		return jsonResp(400, apiResponse{Status: "ERROR", Error: err.Error()})
+	}
+	if err := validateMonotonicTimeline(body); err != nil {
+		return jsonResp(400, apiResponse{Status: "ERROR", Error: err.Error()})
+	}
+	if err := validateParallel(body); err != nil {
+		return jsonResp(400, apiResponse{Status: "ERROR", Error: err.Error()})
+	}
+	if err := validateProbeWorker(body); err != nil {
+		return jsonResp(400, apiResponse{Status: "ERROR", Error: err.Error()})
+	}
+	if err := validateTimeoutDiagnostics(body); err != nil {
+		return jsonResp(400, apiResponse{Status: "ERROR", Error: err.Error()})
+	}
+	if err := validateSSEComparison(body); err != nil {
+		return jsonResp(400, apiResponse{Status: "ERROR", Error: err.Error()})
+	}
+	if body.BatchReceive < 0 || body.BatchReceive > maxBatchEntries {
+		return jsonResp(400, apiResponse{Status: "ERROR", Error: fmt.Sprintf("batchReceive must be 1..%d, got %d", maxBatchEntries, body.BatchReceive)})
+	}
+	resultS3, err := resultS3Location(body)
+	if err != nil {
+		return jsonResp(400, apiResponse{Status: "ERROR", Error: err.Error()})
+	}
+	body.resultS3 = resultS3
+	body.ids = newIDSet()
+	if httpMode || body.Debug {
+		body.reproCurl = reproCurl(req, body)
 	}
 
 	maxWait := 25 * time.Second
 	if body.MaxWaitMs > 0 {
 		maxWait = time.Duration(body.MaxWaitMs) * time.Millisecond
 	}
-	maxWait = effectiveTimeout(ctx, maxWait)
+	runLimit := runDurationLimit(body)
+	maxWait = httpRunMaxWait(ctx, body.MaxWaitMs, effectiveTimeout(ctx, maxWait), runLimit)
+	adj.maxWait(body.MaxWaitMs, maxWait, runLimit)
 	if maxWait <= 0 {
 		return jsonResp(504, apiResponse{Status: "TIMEOUT", Error: "deadline too close"})
 	}
+	if err := validateBatchDelaysWithin(body.BatchDelaySeconds, maxWait); err != nil {
+		return jsonResp(400, apiResponse{Status: "ERROR", Error: err.Error()})
+	}
 
 	callCtx, cancel := context.WithTimeout(ctx, maxWait)
 	defer cancel()
+	// 运行时长上限独立于 maxWait：到期时各模式按截止时间返回已收集的样本，再由 withRunDeadline 标记。
+	if runLimit > 0 {
+		runStart := time.Now()
+		var runCancel context.CancelFunc
+		callCtx, runCancel = context.WithTimeoutCause(callCtx, runLimit, errRunDeadline)
+		defer runCancel()
+		runCtx := callCtx
+		defer func() { resp = withRunDeadline(resp, runCtx, runStart) }()
+	}
+
+	// 具体发往池中哪个 Push 队列由各发送路径在每次发送时经 forSend 选出；这里的 pushURL 只是池中第一个。
+	q := queueTargets{pushURL: pushQueueURLs[0], pushURLs: pushQueueURLs, receiveURLs: receiveQueueURLs, pushName: queueNameFromURL(pushQueueURLs[0]), receiveName: queueNameFromURL(receiveQueueURLs[0])}
+
+	var mode string
+	if modes := benchmarkModes(body); len(modes) > 0 {
+		mode = modes[0]
+	}
+	// dryRun 先于一切会修改队列的操作（ephemeral 建队列、purgeReceiveQueue 清空）。
+	if body.DryRun {
+		return handleDryRun(callCtx, pushQueueURLs, receiveQueueURLs)
+	}
+	if mode == modeEphemeral {
+		return handleEphemeral(callCtx, body, inv)
+	}
+	if body.PurgeReceiveQueue {
+		if code, err := purgeReceiveQueues(callCtx, q.receiveURLs); err != nil {
+			return jsonResp(code, apiResponse{Status: "ERROR", Error: err.Error()})
+		}
+		q.purged = true
+	}
 
-	pushQueueName := queueNameFromURL(pushQueueURL)
-	receiveQueueName := queueNameFromURL(receiveQueueURL)
+	switch mode {
+	case modeFailMode:
+		return handleDLQProbe(callCtx, body, q)
+	case modeProbeWorker:
+		return handleProbeWorker(callCtx, body, q)
+	case modeBatchDelaySeconds:
+		return handleBatch(callCtx, body, q, inv)
+	case modeRampMaxConcurrency:
+		return handleRamp(callCtx, body, q, inv)
+	case modeCompareAttributes:
+		return handleAttributeComparison(callCtx, body, q, inv)
+	case modeCompareSSE:
+		return handleSSEComparison(callCtx, body, inv)
+	case modeOneWay:
+		return handleOneWay(callCtx, body, q)
+	case modeIterations:
+		return handleIterations(callCtx, body, q, inv)
+	case modeVisibilitySweep:
+		return handleVisibilitySweep(callCtx, body, q, inv)
+	case modeFifoBlocking:
+		return handleFIFOBlocking(callCtx, body, q, inv)
+	case modeCount:
+		return handleParallel(callCtx, body, q)
+	}
 
-	messageID := randHex(16)
+	var sdkLog *sdkLogCapture
+	if body.SDKLog {
+		callCtx, sdkLog = withSDKLogCapture(callCtx)
+	}
 	dispatchStart := time.Now().UnixNano()
-	sendUnixNano := time.Now().UnixNano()
-	sendStart := time.Now().UnixNano()
+	jitter, err := applyInitialJitter(callCtx, body.InitialJitterMs)
+	if err != nil {
+		elapsedNs := time.Now().UnixNano() - dispatchStart
+		elapsed := elapsedNs / int64(time.Millisecond)
+		return jsonResp(504, apiResponse{Status: "TIMEOUT", TotalMs: elapsed, TotalUs: nanosToUs(elapsedNs), Error: fmt.Sprintf("initial jitter: %v", err)})
+	}
+	// 在 jitter 之后生成 id，使 ulid 的嵌入时间贴近 sendStart。
+	messageID := newMessageID(body.IDFormat)
+	clock := startSendClock()
+	st := sendTimes{
+		dispatchStart: dispatchStart,
+		jitter:        jitter,
+		sendUnixNano:  clock.baseUnixNano,
+		sendStart:     clock.baseUnixNano,
+	}
 
 	bodyObj := msgBody{
 		ID:                messageID,
-		SendUnixNano:      sendUnixNano,
-		SendStartUnixNano: sendStart,
+		SendUnixNano:      st.sendUnixNano,
+		SendStartUnixNano: st.sendStart,
 		RunID:             body.RunID,
 		Padding:           makePadding(body.MessageBodyBytes),
+		DispatcherVersion: dispatcherVersion,
+		RouteAttributes:   body.RouteAttributes,
+		EchoPadding:       body.EchoPadding,
+		WorkMs:            body.WorkMs,
+	}
+	msgText, msgAttrs := encodeMessage(bodyObj, body.UltraMinimal)
+	rawBodyBytes := len(msgText)
+	if body.Compress {
+		msgText = compressBody(msgText)
 	}
-	bodyBytes, _ := json.Marshal(bodyObj)
 
-	_, err := sqsClient.SendMessage(callCtx, &sqs.SendMessageInput{
-		QueueUrl:     &pushQueueURL,
-		MessageBody:  awsString(string(bodyBytes)),
-		DelaySeconds: int32(body.DelaySeconds),
+	unregister := stash.register(body.RunID, messageID)
+	defer unregister()
+
+	budget := newRetryBudget(time.Duration(body.RetryBudgetMs) * time.Millisecond)
+	q = q.forSend()
+	groupID, dedupID := fifoParams(q.pushURL, messageGroupID(body), messageID)
+	in := &sqs.SendMessageInput{
+		QueueUrl:               &q.pushURL,
+		MessageBody:            awsString(msgText),
+		MessageAttributes:      msgAttrs,
+		DelaySeconds:           int32(body.DelaySeconds),
+		MessageGroupId:         groupID,
+		MessageDeduplicationId: dedupID,
+	}
+	if body.ForceSample {
+		body.traceHeader = forceSampleTraceHeader(req)
+		in.MessageSystemAttributes = traceHeaderSystemAttributes(body.traceHeader)
+	}
+	sendAttempts, err := budget.retrySend(callCtx, func(ctx context.Context) error {
+		return pushMessage(ctx, in, body.RunID, messageID, noSDKRetry)
 	})
-	sendEnd := time.Now().UnixNano()
+	st.sendEnd = clock.now()
 	if err != nil {
-		return jsonResp(502, apiResponse{Status: "ERROR", Error: fmt.Sprintf("send message: %v", err)})
+		if sendAttempts > 1 {
+			return sendErrorResp(fmt.Errorf("send message after %d attempts: %w", sendAttempts, err))
+		}
+		return sendErrorResp(fmt.Errorf("send message: %w", err))
 	}
+	noteSendOK()
 
-	pollStart := time.Now().UnixNano()
-	cb, receiveMessageUnixNano, pollEnd, err := pollForCallback(callCtx, receiveQueueURL, body.RunID, messageID)
+	st.pollStart = clock.now()
+	po := pollOptions{waitSeconds: maxPollWaitSeconds, sendStart: st.sendStart, sendStartToleranceNs: body.SendStartToleranceNs, retry: budget, maxMessages: int32(body.BatchReceive)}
+	if body.PollWaitSeconds != nil {
+		po.waitSeconds = int32(*body.PollWaitSeconds)
+	}
+	// 超时诊断需要逐次轮询记录；未开启 debug 时只用于诊断，不输出 debug 段。
+	if body.Debug || body.TimeoutDiagnostics {
+		po.debug = &pollDebug{}
+	}
+	pr, err := pollForCallback(callCtx, q.receiveURLs, body.RunID, messageID, po)
 	if err != nil {
-		elapsed := (time.Now().UnixNano() - dispatchStart) / int64(time.Millisecond)
-		code := 500
-		status := "ERROR"
-		if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) {
-			code = 504
-			status = "TIMEOUT"
+		elapsedNs := time.Now().UnixNano() - dispatchStart
+		elapsed := elapsedNs / int64(time.Millisecond)
+		code, status := pollErrorStatus(err)
+		apiOut := apiResponse{Status: status, TotalMs: elapsed, TotalUs: nanosToUs(elapsedNs), Error: err.Error(),
+			BudgetMs: maxWait.Milliseconds(), ElapsedSendMs: nanosToMs(st.sendEnd - st.sendStart), PollAttempts: pr.pollAttempts}
+		if body.TimeoutDiagnostics && status == "TIMEOUT" {
+			apiOut.TimeoutDiagnostics = newTimeoutDiagnostics(ctx, callCtx, st, pr, po.debug)
 		}
-		return jsonResp(code, apiResponse{Status: status, TotalMs: elapsed, Error: err.Error()})
-	}
-
-	outBytes, _ := json.Marshal(dispatcherOutput{
-		RunID:                      body.RunID,
-		ID:                         messageID,
-		Region:                     awsCfg.Region,
-		PushQueueName:              pushQueueName,
-		ReceiveQueueName:           receiveQueueName,
-		DispatchStartUnixNano:      dispatchStart,
-		SendUnixNano:               sendUnixNano,
-		SendStartUnixNano:          sendStart,
-		SendEndUnixNano:            sendEnd,
-		PollStartUnixNano:          pollStart,
-		PollEndUnixNano:            pollEnd,
-		ReceiveMessageUnixNano:     receiveMessageUnixNano,
-		WorkerReceiveUnixNano:      cb.WorkerReceiveUnixNano,
-		WorkerDoneUnixNano:         cb.WorkerDoneUnixNano,
-		CallbackSendStartUnixNano:  cb.CallbackSendStartUnixNano,
-		CallbackSendEndUnixNano:    cb.CallbackSendEndUnixNano,
-		SqsSentTimestampMs:         cb.SqsSentTimestampMs,
-		SqsFirstReceiveTimestampMs: cb.SqsFirstReceiveTimestampMs,
-		SqsApproxReceiveCount:      cb.SqsApproxReceiveCount,
-	})
+		return jsonResp(code, apiOut)
+	}
 
-	elapsedMs := (time.Now().UnixNano() - dispatchStart) / int64(time.Millisecond)
-	return jsonResp(200, apiResponse{Status: "OK", TotalMs: elapsedMs, Output: outBytes})
+	out := newDispatcherOutput(body.RunID, messageID, q, st, pr, inv)
+	out.BodyBytes = len(msgText)
+	if body.Compress {
+		out.UncompressedBodyBytes = rawBodyBytes
+	}
+	out.RetryBudgetUsedMs = budget.usedMs()
+	out.SendAttempts = sendAttempts
+	if body.ForceSample {
+		applyTraceSampling(&out, body.traceHeader, pr.cb.TraceHeader)
+	}
+	if body.MonotonicTimeline {
+		out.Timeline = newMonotonicTimeline(&out)
+	}
+	if body.QueueConfig {
+		qc := loadQueueConfig(callCtx, q.pushURL)
+		out.QueueConfig = &qc
+	}
+	code, status := 200, "OK"
+	if body.EchoPadding && !verifyEchoedPadding(&out, bodyObj.Padding, pr.cb.EchoedPadding) {
+		code, status = 502, statusPayloadMismatch
+	}
+	if body.PureForwardLeg {
+		applyPureForwardLeg(&out)
+	}
+	if applyRegionCheck(&out, body.RegionCheck) && code == 200 {
+		code, status = 502, statusRegionMismatch
+	}
+	if body.Debug {
+		out.Debug = &debugInfo{Poll: po.debug, SQSHTTPProtocol: sqsProtocol.Load(), TLS: tlsHandshakes.snapshot()}
+	}
+	if body.reproCurl != "" {
+		if out.Debug == nil {
+			out.Debug = &debugInfo{}
+		}
+		out.Debug.Curl = body.reproCurl
+	}
+	sendAckIfConfigured(callCtx, clock, &out)
+	if sdkLog != nil {
+		if out.Debug == nil {
+			out.Debug = &debugInfo{}
+		}
+		out.Debug.SDKLog = sdkLog.String()
+	}
+	outBytes, _ := json.Marshal(out)
+
+	elapsedNs := time.Now().UnixNano() - dispatchStart
+	elapsedMs := elapsedNs / int64(time.Millisecond)
+	results.put(storedResult{RunID: body.RunID, ID: messageID, StoredAt: time.Now(), TotalMs: elapsedMs, Output: outBytes})
+
+	var trimmed []byte
+	if out.Debug != nil {
+		summary := out
+		summary.Debug = nil
+		trimmed, _ = json.Marshal(summary)
+	}
+	outBytes, upload := persistResult(ctx, body.resultS3, body.RunID, outBytes, trimmed)
+
+	outBytes, err = formatOutput(outBytes, body.OutputFormat)
+	if err != nil {
+		return jsonResp(500, apiResponse{Status: "ERROR", Error: err.Error()})
+	}
+	apiOut := apiResponse{Status: status, TotalMs: elapsedMs, TotalUs: nanosToUs(elapsedNs), Output: outBytes, PerceivedLatencyMs: perceivedLatencyMs(body.requestTimeEpochMs),
+		BudgetMs: maxWait.Milliseconds(), ElapsedSendMs: nanosToMs(st.sendEnd - st.sendStart), PollAttempts: pr.pollAttempts}
+	upload.apply(&apiOut)
+	resp, err = jsonResp(code, apiOut)
+	if st := serverTiming(out); st != "" {
+		resp.Headers["Server-Timing"] = st
+	}
+	return resp, err
+}
+
+// 基准模式名（即开启该模式的请求字段名），按 handler 的分派优先级排列。
+const (
+	modeEphemeral          = "ephemeral"
+	modeFailMode           = "failMode"
+	modeProbeWorker        = "probeWorker"
+	modeBatchDelaySeconds  = "batchDelaySeconds"
+	modeRampMaxConcurrency = "rampMaxConcurrency"
+	modeCompareAttributes  = "compareAttributes"
+	modeCompareSSE         = "compareSse"
+	modeOneWay             = "oneWay"
+	modeIterations         = "iterations"
+	modeVisibilitySweep    = "visibilitySweep"
+	modeFifoBlocking       = "fifoBlocking"
+	modeCount              = "count"
+)
+
+// benchmarkModes 返回请求开启的基准模式（按分派优先级）；单条模式返回空。
+// 各模式校验互斥与 handler 分派都以它为准，新增模式只需在这里登记一处。
+func benchmarkModes(body apiRequest) []string {
+	var modes []string
+	add := func(on bool, mode string) {
+		if on {
+			modes = append(modes, mode)
+		}
+	}
+	add(body.Ephemeral, modeEphemeral)
+	add(body.FailMode != "", modeFailMode)
+	add(body.ProbeWorker, modeProbeWorker)
+	add(len(body.BatchDelaySeconds) > 0, modeBatchDelaySeconds)
+	add(body.RampMaxConcurrency > 0, modeRampMaxConcurrency)
+	add(body.CompareAttributes > 0, modeCompareAttributes)
+	add(body.CompareSSE > 0, modeCompareSSE)
+	add(body.OneWay, modeOneWay)
+	add(body.Iterations > 1, modeIterations)
+	add(len(body.VisibilitySweep) > 0, modeVisibilitySweep)
+	add(body.FifoBlocking > 0, modeFifoBlocking)
+	add(body.Count > 0, modeCount)
+	return modes
+}
+
+// queueTargets：本次请求使用的 Push/Receive 队列。
+type queueTargets struct {
+	pushURL string
+	// pushURLs：Push 队列池（PUSH_QUEUE_URLS）；为空时固定发往 pushURL。
+	pushURLs []string
+	// receiveURLs 按优先级排序；receiveName 为最高优先级队列名。
+	receiveURLs []string
+	pushName    string
+	receiveName string
+	// purged：发送前已对 receiveURLs 执行 PurgeQueue。
+	purged bool
+}
+
+// forSend 为一次发送（SendMessageBatch 为一整批）从 Push 队列池中选出队列并计数，
+// 返回 pushURL/pushName 指向该队列的副本；未配置池时原样返回。
+func (q queueTargets) forSend() queueTargets {
+	if len(q.pushURLs) == 0 {
+		return q
+	}
+	q.pushURL = pushPool.pick(q.pushURLs)
+	q.pushName = queueNameFromURL(q.pushURL)
+	return q
+}
+
+// sendTimes：Dispatcher 侧发送阶段的时间点（UnixNano）。
+type sendTimes struct {
+	dispatchStart int64
+	// jitter：dispatchStart 之后、发送之前的随机休眠。
+	jitter       time.Duration
+	sendUnixNano int64
+	sendStart    int64
+	sendEnd      int64
+	pollStart    int64
+}
+
+func maintenanceMode() bool {
+	v, _ := strconv.ParseBool(strings.TrimSpace(os.Getenv("MAINTENANCE")))
+	return v
+}
+
+// perceivedLatencyMs 以 API Gateway 的 RequestTimeEpoch 为起点（毫秒精度，与本机时钟存在偏差）；
+// 起点缺失或结果为负时返回 0。
+func perceivedLatencyMs(requestTimeEpochMs int64) int64 {
+	if requestTimeEpochMs <= 0 {
+		return 0
+	}
+	d := time.Now().UnixMilli() - requestTimeEpochMs
+	if d < 0 {
+		return 0
+	}
+	return d
+}
+
+func pollErrorStatus(err error) (int, string) {
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) {
+		return 504, "TIMEOUT"
+	}
+	return 500, "ERROR"
+}
+
+// newDispatcherOutput 由发送时间点与轮询结果组装单条样本的输出。
+func newDispatcherOutput(runID, id string, q queueTargets, st sendTimes, pr pollResult, inv invocationInfo) dispatcherOutput {
+	cb := pr.cb
+
+	// 回调发送结束时间：以回调消息的 SQS SentTimestamp 近似（精度毫秒，且与 Worker 时钟存在偏差）。
+	var callbackSendEnd, callbackSendMs int64
+	if pr.callbackSqsSentTimestampMs > 0 && cb.CallbackSendStartUnixNano > 0 {
+		callbackSendEnd = pr.callbackSqsSentTimestampMs * int64(time.Millisecond)
+		callbackSendMs = (callbackSendEnd - cb.CallbackSendStartUnixNano) / int64(time.Millisecond)
+		if callbackSendMs < 0 {
+			callbackSendMs = 0
+		}
+	}
+
+	stashLookups, stashHits := stash.stats()
+	receiveName := q.receiveName
+	if pr.receiveQueueName != "" {
+		receiveName = pr.receiveQueueName
+	}
+
+	var pushDistribution map[string]int64
+	if len(q.pushURLs) > 1 {
+		pushDistribution = pushPool.distribution()
+	}
+
+	var headOfLineDelayMs int64
+	if pr.callbackSqsSentTimestampMs > 0 && pr.receiveMessageUnixNano > 0 {
+		headOfLineDelayMs = pr.receiveMessageUnixNano/int64(time.Millisecond) - pr.callbackSqsSentTimestampMs
+		if headOfLineDelayMs < 0 {
+			headOfLineDelayMs = 0
+		}
+	}
+
+	var pipelineLatencyMs int64
+	if pr.receiveMessageUnixNano > 0 && st.sendEnd > 0 {
+		pipelineLatencyMs = (pr.receiveMessageUnixNano - st.sendEnd) / int64(time.Millisecond)
+	}
+
+	callbackSentNano := pr.callbackSqsSentTimestampMs * int64(time.Millisecond)
+	var skew bool
+	queueToWorkerUs := crossClockUs(st.sendEnd, cb.WorkerReceiveUnixNano, &skew)
+	var estimatedSkewMs, dispatcherSqsOffsetMs, workerSqsOffsetMs *float64
+	var queueToWorkerRawUs float64
+	if cs, ok := estimateClockSkew(st, pr); ok {
+		// 校正后的 queueToWorkerUs 决定是否仍判定为偏差；原值仅供对照。
+		skew = false
+		queueToWorkerRawUs = durationUs(st.sendEnd, cb.WorkerReceiveUnixNano)
+		queueToWorkerUs = crossClockUs(st.sendEnd, cs.toDispatcherClock(cb.WorkerReceiveUnixNano), &skew)
+		estimatedSkewMs, dispatcherSqsOffsetMs, workerSqsOffsetMs = &cs.skewMs, &cs.dispatcherOffsetMs, &cs.workerOffsetMs
+	}
+	callbackSendUs := crossClockUs(cb.CallbackSendStartUnixNano, callbackSentNano, &skew)
+	receiveLatencyUs := crossClockUs(callbackSentNano, pr.receiveMessageUnixNano, &skew)
+
+	var ulidTimeMs int64
+	var ulidSkewMs *float64
+	if ms, ok := decodeULIDTime(id); ok {
+		ulidTimeMs = ms
+		skew := float64(ms) - float64(st.sendStart)/float64(time.Millisecond)
+		ulidSkewMs = &skew
+	}
+
+	return dispatcherOutput{
+		RunID:                       runID,
+		ID:                          id,
+		Region:                      awsCfg.Region,
+		PushQueueName:               q.pushName,
+		ReceiveQueueName:            receiveName,
+		RetrievalMethod:             retrievalSQSCallback,
+		UlidEmbeddedTimeMs:          ulidTimeMs,
+		UlidSkewMs:                  ulidSkewMs,
+		DispatchStartUnixNano:       st.dispatchStart,
+		SendUnixNano:                st.sendUnixNano,
+		SendStartUnixNano:           st.sendStart,
+		SendEndUnixNano:             st.sendEnd,
+		PollStartUnixNano:           st.pollStart,
+		PollEndUnixNano:             pr.pollEnd,
+		ReceiveMessageUnixNano:      pr.receiveMessageUnixNano,
+		WorkerReceiveUnixNano:       cb.WorkerReceiveUnixNano,
+		WorkerDoneUnixNano:          cb.WorkerDoneUnixNano,
+		CallbackSendStartUnixNano:   cb.CallbackSendStartUnixNano,
+		CallbackSendEndUnixNano:     callbackSendEnd,
+		CallbackSqsSentTimestampMs:  pr.callbackSqsSentTimestampMs,
+		CallbackSendMs:              callbackSendMs,
+		PipelineLatencyMs:           pipelineLatencyMs,
+		PipelineLatencyUs:           durationUs(st.sendEnd, pr.receiveMessageUnixNano),
+		SendUs:                      durationUs(st.sendStart, st.sendEnd),
+		PollUs:                      durationUs(st.pollStart, pr.pollEnd),
+		WorkerUs:                    durationUs(cb.WorkerReceiveUnixNano, cb.WorkerDoneUnixNano),
+		QueueToWorkerUs:             queueToWorkerUs,
+		CallbackSendUs:              callbackSendUs,
+		ReceiveLatencyUs:            receiveLatencyUs,
+		SkewDetected:                skew,
+		EstimatedSkewMs:             estimatedSkewMs,
+		DispatcherSqsOffsetMs:       dispatcherSqsOffsetMs,
+		WorkerSqsOffsetMs:           workerSqsOffsetMs,
+		QueueToWorkerRawUs:          queueToWorkerRawUs,
+		SqsSentTimestampMs:          cb.SqsSentTimestampMs,
+		SqsFirstReceiveTimestampMs:  cb.SqsFirstReceiveTimestampMs,
+		SqsApproxReceiveCount:       cb.SqsApproxReceiveCount,
+		EsmInvokeLatencyMs:          cb.EsmInvokeLatencyMs,
+		EsmInvokeLatencySkew:        cb.EsmInvokeLatencyMs != nil && *cb.EsmInvokeLatencyMs < -forwardLegSkewToleranceMs,
+		InvocationClass:             inv.Class,
+		ColdStart:                   inv.ColdStart,
+		WorkerColdStart:             cb.WorkerColdStart,
+		ContainerRequestCount:       inv.ContainerRequestCount,
+		InitToFirstInvokeMs:         inv.InitToFirstInvokeMs,
+		WorkerInvocationClass:       cb.WorkerInvocationClass,
+		WorkerContainerRequestCount: cb.WorkerContainerRequestCount,
+		WorkerInitToFirstInvokeMs:   cb.WorkerInitToFirstInvokeMs,
+		WorkerInstanceID:            cb.WorkerInstanceID,
+		DispatcherVersion:           dispatcherVersion,
+		WorkerVersion:               cb.WorkerVersion,
+		VersionMismatch:             versionMismatch(dispatcherVersion, cb.WorkerVersion),
+		WorkerRegion:                cb.Region,
+		RouteVerified:               cb.RouteVerified,
+		RouteMismatch:               cb.RouteMismatch,
+		StashHit:                    pr.stashHit,
+		StashLookups:                stashLookups,
+		StashHits:                   stashHits,
+		SendStartRejects:            pr.sendStartRejects,
+		AppliedJitterMs:             float64(st.jitter) / float64(time.Millisecond),
+		DeleteFailed:                pr.deleteFailed,
+		QuarantinedCount:            pr.quarantined,
+		ForeignGrabCount:            pr.foreignGrabs,
+		UsedFallbackQueue:           pr.usedFallbackQueue,
+		HeadOfLineDelayMs:           headOfLineDelayMs,
+		PushQueueDistribution:       pushDistribution,
+		ReceiveQueuePurged:          q.purged,
+		Build:                       currentBuild,
+	}
+}
+
+// sendAckIfConfigured：配置了 CONFIRM_QUEUE_URL 时回写 ack，并记录耗时/错误。
+func sendAckIfConfigured(ctx context.Context, clock sendClock, out *dispatcherOutput) {
+	confirmQueueURL := strings.TrimSpace(os.Getenv("CONFIRM_QUEUE_URL"))
+	if confirmQueueURL == "" {
+		return
+	}
+	ackStart := clock.now()
+	if err := sendAck(ctx, confirmQueueURL, out.RunID, out.ID); err != nil {
+		out.AckError = err.Error()
+	}
+	out.AckSendMs = nanosToMs(clock.now() - ackStart)
+}
+
+// fetchResult 返回本容器 resultStore 中记录的结果（尽力而为，见 resultStore）。
+func fetchResult(body apiRequest) (events.APIGatewayProxyResponse, error) {
+	runID := strings.TrimSpace(body.RunID)
+	if runID == "" {
+		return jsonResp(400, apiResponse{Status: "ERROR", Error: "fetch requires runId"})
+	}
+	r, ok := results.get(runID, strings.TrimSpace(body.ID))
+	if !ok {
+		return jsonResp(404, apiResponse{Status: "NOT_FOUND", Error: fmt.Sprintf("no stored result for runId=%s in this container", runID)})
+	}
+	out, err := formatOutput(r.Output, body.OutputFormat)
+	if err != nil {
+		return jsonResp(500, apiResponse{Status: "ERROR", Error: err.Error()})
+	}
+	return jsonResp(200, apiResponse{Status: "OK", TotalMs: r.TotalMs, Output: out})
+}
+
+func formatOutput(out json.RawMessage, format string) (json.RawMessage, error) {
+	if format == outputFormatArrow {
+		return encodeArrowOutput(out)
+	}
+	if format != outputFormatFlat {
+		return out, nil
+	}
+	flat, err := flattenJSON(out)
+	if err != nil {
+		return nil, fmt.Errorf("flatten output: %w", err)
+	}
+	b, _ := json.Marshal(flat)
+	return b, nil
 }
 
 func awsString(s string) *string { return &s }
 
-func pollForCallback(ctx context.Context, receiveQueueURL string, runID string, id string) (callbackMessage, int64, int64, error) {
-	for {
-		if ctx.Err() != nil {
-			return callbackMessage{}, 0, 0, ctx.Err()
+// sendClock 在发送阶段开始时只读取一次墙钟，之后的时间点都以单调时钟偏移推导，
+// 避免多次独立调用 time.Now() 带来的抖动；导出的仍是 UnixNano。
+type sendClock struct {
+	base         time.Time
+	baseUnixNano int64
+}
+
+func startSendClock() sendClock {
+	now := time.Now()
+	return sendClock{base: now, baseUnixNano: now.UnixNano()}
+}
+
+func (c sendClock) now() int64 {
+	return c.baseUnixNano + int64(time.Since(c.base))
+}
+
+// defaultRetryBudgetMs：未指定 retryBudgetMs 时的共享重试预算。
+const defaultRetryBudgetMs = 500
+
+const (
+	outputFormatNested = "nested"
+	outputFormatFlat   = "flat"
+)
+
+// flattenJSON 把结构化输出展开为单层 map，嵌套字段用 "." 连接（如 "aggregate.p99Ms"），
+// 数组元素以下标作为 key。数值保持 json.Number，避免 UnixNano 经 float64 丢精度。
+// 空对象与空数组原样保留为 {} / []（如 "samples": []），否则展开后字段会消失；
+// 顶层必须是对象或数组，标量没有可用的 key。
+func flattenJSON(b []byte) (map[string]any, error) {
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	switch v.(type) {
+	case map[string]any, []any:
+	default:
+		return nil, fmt.Errorf("top-level value must be an object or array, got %s", bytes.TrimSpace(b))
+	}
+	flat := map[string]any{}
+	flattenInto(flat, "", v)
+	return flat, nil
+}
+
+func flattenInto(dst map[string]any, prefix string, v any) {
+	join := func(k string) string {
+		if prefix == "" {
+			return k
 		}
-		out, err := sqsClient.ReceiveMessage(ctx, &sqs.ReceiveMessageInput{
-			QueueUrl:            &receiveQueueURL,
-			MaxNumberOfMessages: 1,
-			WaitTimeSeconds:     20,
-			VisibilityTimeout:   10,
-		})
-		pollEnd := time.Now().UnixNano()
-		if err != nil {
-			return callbackMessage{}, 0, pollEnd, fmt.Errorf("receive message: %w", err)
+		return prefix + "." + k
+	}
+	switch t := v.(type) {
+	case map[string]any:
+		if len(t) == 0 && prefix != "" {
+			dst[prefix] = t
+			return
 		}
-		if len(out.Messages) == 0 {
-			continue
+		for k, child := range t {
+			flattenInto(dst, join(k), child)
 		}
-		m := out.Messages[0]
-		receiveMessageUnixNano := time.Now().UnixNano()
-
-		var cb callbackMessage
-		if m.Body != nil {
-			if err := json.Unmarshal([]byte(*m.Body), &cb); err != nil {
-				// 无法解析的消息：不阻塞；删除避免毒消息反复出现。
-				if m.ReceiptHandle != nil {
-					_, _ = sqsClient.DeleteMessage(ctx, &sqs.DeleteMessageInput{QueueUrl: &receiveQueueURL, ReceiptHandle: m.ReceiptHandle})
-				}
-				continue
-			}
+	case []any:
+		if len(t) == 0 && prefix != "" {
+			dst[prefix] = t
+			return
 		}
-
-		if strings.TrimSpace(cb.RunID) == runID && strings.TrimSpace(cb.ID) == id {
-			if m.ReceiptHandle != nil {
-				_, _ = sqsClient.DeleteMessage(ctx, &sqs.DeleteMessageInput{QueueUrl: &receiveQueueURL, ReceiptHandle: m.ReceiptHandle})
-			}
-			return cb, receiveMessageUnixNano, pollEnd, nil
+		for i, child := range t {
+			flattenInto(dst, join(strconv.Itoa(i)), child)
 		}
+	default:
+		dst[prefix] = t
+	}
+}
+
+func sendAck(ctx context.Context, confirmQueueURL string, runID string, id string) error {
+	b, _ := json.Marshal(ackMessage{ID: id, RunID: runID, AckUnixNano: time.Now().UnixNano()})
+	groupID, dedupID := fifoParams(confirmQueueURL, runID, id)
+	_, err := sqsClient.SendMessage(ctx, &sqs.SendMessageInput{
+		QueueUrl:               &confirmQueueURL,
+		MessageBody:            awsString(string(b)),
+		MessageGroupId:         groupID,
+		MessageDeduplicationId: dedupID,
+	})
+	if err != nil {
+		return fmt.Errorf("send ack: %w", err)
+	}
+	return nil
+}
+
+func parseInt64OrZero(s string) int64 {
+	if strings.TrimSpace(s) == "" {
+		return 0
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return 0
+	}
+	return n
+}
+
+// deployVersion：部署版本标识，优先取 VERSION 环境变量，否则退化为 Lambda 函数版本（如 $LATEST）。
+// 注意不同函数的版本号互不相关，只有 VERSION 才能用于跨函数比较。
+func deployVersion() string {
+	if v := strings.TrimSpace(os.Getenv("VERSION")); v != "" {
+		return v
+	}
+	return lambdacontext.FunctionVersion
+}
 
-		// 非本次请求的回调：不删除，立即释放可见性，避免影响并发请求。
-		if m.ReceiptHandle != nil {
-			_, _ = sqsClient.ChangeMessageVisibility(ctx, &sqs.ChangeMessageVisibilityInput{
-				QueueUrl:          &receiveQueueURL,
-				ReceiptHandle:     m.ReceiptHandle,
-				VisibilityTimeout: 0,
-			})
+// versionMismatch 仅在 Dispatcher 设置了 VERSION 时判定：未设置时双方都退化为各自函数的版本号，二者不可比较。
+func versionMismatch(dispatcher, worker string) bool {
+	if strings.TrimSpace(os.Getenv("VERSION")) == "" || worker == "" {
+		return false
+	}
+	return dispatcher != worker
+}
+
+// receiveQueueURLsFromEnv：优先读取 RECEIVE_QUEUE_URLS（逗号分隔，按优先级），否则回退到 RECEIVE_QUEUE_URL。
+func receiveQueueURLsFromEnv() []string {
+	var urls []string
+	for _, u := range strings.Split(os.Getenv("RECEIVE_QUEUE_URLS"), ",") {
+		if u = strings.TrimSpace(u); u != "" {
+			urls = append(urls, u)
 		}
-		time.Sleep(20 * time.Millisecond)
 	}
+	if len(urls) > 0 {
+		return urls
+	}
+	if u := strings.TrimSpace(os.Getenv("RECEIVE_QUEUE_URL")); u != "" {
+		return []string{u}
+	}
+	return nil
 }
 
 func queueNameFromURL(queueURL string) string {
@@ -346,5 +1378,11 @@ func randHex(n int) string {
 }
 
 func main() {
+	if addr := strings.TrimSpace(os.Getenv("LISTEN_ADDR")); addr != "" {
+		log.Fatal(serveHTTP(addr))
+	}
+	if localWorkerEnabled() {
+		log.Printf("WARNING: LOCAL_WORKER ignored: requires the HTTP variant (LISTEN_ADDR)")
+	}
 	lambda.Start(handler)
 }
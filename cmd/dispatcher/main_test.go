@@ -0,0 +1,2544 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	mrand "math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/apache/arrow-go/v18/arrow/array"
+	"github.com/apache/arrow-go/v18/arrow/ipc"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	ddbtypes "github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws/aws-sdk-go-v2/service/eventbridge"
+	ebtypes "github.com/aws/aws-sdk-go-v2/service/eventbridge/types"
+	lambdasvc "github.com/aws/aws-sdk-go-v2/service/lambda"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/sns"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"github.com/aws/smithy-go"
+	"github.com/aws/smithy-go/logging"
+)
+
+// fakeSQS 是实现 SQS JSON 协议的最小 HTTP 假服务，同时扮演 Worker：
+// 收到 SendMessage 后，后续的 ReceiveMessage 会返回与之匹配的回调消息。
+type fakeSQS struct {
+	mu      sync.Mutex
+	pending []string
+	// maxMessagesSeen：ReceiveMessage 请求中出现过的最大 MaxNumberOfMessages。
+	maxMessagesSeen int
+	// 最近一次 SendMessage 的 FIFO 参数。
+	lastGroupID, lastDedupID string
+	// 最近一次 SendMessage 消息体中的 workMs。
+	lastWorkMs int
+	// queueAttributes：GetQueueAttributes 返回的属性。
+	queueAttributes map[string]string
+	// visibilitiesSeen：ReceiveMessage 请求中出现过的 VisibilityTimeout。
+	visibilitiesSeen map[int]bool
+	// sendErrorType：非空时 SendMessage 返回该类型的 400 错误（如 ThrottlingException）。
+	sendErrorType string
+	// missingQueue：非空时对以该名称结尾的队列 ReceiveMessage 返回 QueueDoesNotExist。
+	missingQueue string
+	// esmInvokeLatencyMs：写入回调的 esmInvokeLatencyMs。
+	esmInvokeLatencyMs *float64
+	// createdQueues/deletedQueues：CreateQueue/DeleteQueue 涉及的队列名。
+	createdQueues, deletedQueues []string
+	// acks：发往 CONFIRM_QUEUE_URL 的 ack（按队列名记录），不生成回调。
+	acks map[string][]ackMessage
+	// fifoWorkMs：> 0 时模拟 FIFO 事件源映射与慢 Worker：SendMessageBatch 中同一分组的第 k 条回调的
+	// workerReceiveUnixNano 延后 k × fifoWorkMs。
+	fifoWorkMs int
+	// released：ChangeMessageVisibilityBatch 释放的条目数。
+	released int
+	// sendCalls：各 Push 队列（按队列名）收到的 SendMessage/SendMessageBatch 请求数。
+	sendCalls map[string]int
+}
+
+func (f *fakeSQS) countSend(in map[string]any) {
+	if f.sendCalls == nil {
+		f.sendCalls = map[string]int{}
+	}
+	queueURL, _ := in["QueueUrl"].(string)
+	f.sendCalls[queueNameFromURL(queueURL)]++
+}
+
+func (f *fakeSQS) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var in map[string]any
+	_ = json.NewDecoder(r.Body).Decode(&in)
+	w.Header().Set("Content-Type", "application/x-amz-json-1.0")
+
+	switch strings.TrimPrefix(r.Header.Get("X-Amz-Target"), "AmazonSQS.") {
+	case "SendMessage":
+		if f.sendErrorType != "" {
+			w.WriteHeader(400)
+			fmt.Fprintf(w, `{"__type":"com.amazonaws.sqs#%s","message":"injected"}`, f.sendErrorType)
+			return
+		}
+		f.lastGroupID, _ = in["MessageGroupId"].(string)
+		f.lastDedupID, _ = in["MessageDeduplicationId"].(string)
+		raw, _ := in["MessageBody"].(string)
+		if strings.Contains(raw, `"ackUnixNano"`) {
+			var ack ackMessage
+			_ = json.Unmarshal([]byte(raw), &ack)
+			if f.acks == nil {
+				f.acks = map[string][]ackMessage{}
+			}
+			queueURL, _ := in["QueueUrl"].(string)
+			f.acks[queueNameFromURL(queueURL)] = append(f.acks[queueNameFromURL(queueURL)], ack)
+			fmt.Fprint(w, `{"MessageId":"ack-1"}`)
+			return
+		}
+		f.countSend(in)
+		var mb msgBody
+		decoded, _ := decodeMessageBody(raw)
+		_ = json.Unmarshal(decoded, &mb)
+		f.lastWorkMs = mb.WorkMs
+		cb, _ := json.Marshal(callbackMessage{ID: mb.ID, RunID: mb.RunID, SendStartUnixNano: mb.SendStartUnixNano, EsmInvokeLatencyMs: f.esmInvokeLatencyMs})
+		f.pending = append(f.pending, string(cb))
+		fmt.Fprint(w, `{"MessageId":"push-1"}`)
+	case "SendMessageBatch":
+		f.countSend(in)
+		entries, _ := in["Entries"].([]any)
+		ok := []map[string]any{}
+		ahead := map[string]int{}
+		now := time.Now().UnixNano()
+		for _, e := range entries {
+			entry, _ := e.(map[string]any)
+			raw, _ := entry["MessageBody"].(string)
+			var mb msgBody
+			decoded, _ := decodeMessageBody(raw)
+			_ = json.Unmarshal(decoded, &mb)
+			var workerReceive int64
+			if f.fifoWorkMs > 0 {
+				group, _ := entry["MessageGroupId"].(string)
+				workerReceive = now + int64(ahead[group]*f.fifoWorkMs)*int64(time.Millisecond)
+				ahead[group]++
+			}
+			cb, _ := json.Marshal(callbackMessage{ID: mb.ID, RunID: mb.RunID, SendStartUnixNano: mb.SendStartUnixNano, WorkerReceiveUnixNano: workerReceive})
+			f.pending = append(f.pending, string(cb))
+			ok = append(ok, map[string]any{"Id": entry["Id"], "MessageId": "push-" + fmt.Sprint(entry["Id"])})
+		}
+		_ = json.NewEncoder(w).Encode(map[string]any{"Successful": ok, "Failed": []any{}})
+	case "ReceiveMessage":
+		if queueURL, _ := in["QueueUrl"].(string); f.missingQueue != "" && strings.HasSuffix(queueURL, "/"+f.missingQueue) {
+			w.WriteHeader(400)
+			fmt.Fprint(w, `{"__type":"com.amazonaws.sqs#QueueDoesNotExist","message":"The specified queue does not exist."}`)
+			return
+		}
+		msgs := []map[string]any{}
+		n, _ := in["MaxNumberOfMessages"].(float64)
+		f.maxMessagesSeen = max(f.maxMessagesSeen, int(n))
+		if v, ok := in["VisibilityTimeout"].(float64); ok {
+			if f.visibilitiesSeen == nil {
+				f.visibilitiesSeen = map[int]bool{}
+			}
+			f.visibilitiesSeen[int(v)] = true
+		}
+		for i := 0; i < max(int(n), 1) && len(f.pending) > 0; i++ {
+			msgs = append(msgs, map[string]any{"MessageId": fmt.Sprintf("cb-%d", i+1), "ReceiptHandle": fmt.Sprintf("rh-%d", i+1), "Body": f.pending[0]})
+			f.pending = f.pending[1:]
+		}
+		_ = json.NewEncoder(w).Encode(map[string]any{"Messages": msgs})
+	case "ChangeMessageVisibilityBatch":
+		entries, _ := in["Entries"].([]any)
+		ok := []map[string]any{}
+		for _, e := range entries {
+			entry, _ := e.(map[string]any)
+			ok = append(ok, map[string]any{"Id": entry["Id"]})
+		}
+		f.released += len(entries)
+		_ = json.NewEncoder(w).Encode(map[string]any{"Successful": ok, "Failed": []any{}})
+	case "GetQueueAttributes":
+		_ = json.NewEncoder(w).Encode(map[string]any{"Attributes": f.queueAttributes})
+	case "CreateQueue":
+		name, _ := in["QueueName"].(string)
+		f.createdQueues = append(f.createdQueues, name)
+		_ = json.NewEncoder(w).Encode(map[string]any{"QueueUrl": "http://" + r.Host + "/000000000000/" + name})
+	case "DeleteQueue":
+		queueURL, _ := in["QueueUrl"].(string)
+		f.deletedQueues = append(f.deletedQueues, queueNameFromURL(queueURL))
+		fmt.Fprint(w, `{}`)
+	default:
+		fmt.Fprint(w, `{}`)
+	}
+}
+
+// useFakeSQS 把包级 sqsClient 指向 fakeSQS，并设置 Dispatcher 所需的环境变量。
+func useFakeSQS(t *testing.T, optFns ...func(*sqs.Options)) *fakeSQS {
+	t.Helper()
+	f := &fakeSQS{}
+	srv := httptest.NewServer(f)
+	t.Cleanup(srv.Close)
+
+	initOnce.Do(func() {})
+	prev := sqsClient
+	sqsClient = sqs.New(sqs.Options{
+		Region:                           "us-east-1",
+		BaseEndpoint:                     aws.String(srv.URL),
+		Credentials:                      aws.AnonymousCredentials{},
+		DisableMessageChecksumValidation: true,
+	}, optFns...)
+	t.Cleanup(func() { sqsClient = prev })
+
+	t.Setenv("PUSH_QUEUE_URL", srv.URL+"/000000000000/push")
+	t.Setenv("RECEIVE_QUEUE_URL", srv.URL+"/000000000000/receive")
+	return f
+}
+
+func decodeOutput(t *testing.T, resp events.APIGatewayProxyResponse) dispatcherOutput {
+	t.Helper()
+	var api apiResponse
+	if err := json.Unmarshal([]byte(resp.Body), &api); err != nil {
+		t.Fatalf("unmarshal response: %v (body=%s)", err, resp.Body)
+	}
+	var out dispatcherOutput
+	if err := json.Unmarshal(api.Output, &out); err != nil {
+		t.Fatalf("unmarshal output: %v (body=%s)", err, resp.Body)
+	}
+	return out
+}
+
+func TestFlattenJSON(t *testing.T) {
+	cases := []struct {
+		in, want string
+	}{
+		{`{"a":{"b":1,"c":[2,3]}}`, `{"a.b":1,"a.c.0":2,"a.c.1":3}`},
+		{`{"id":"x","sendStartUnixNano":1700000000000000123}`, `{"id":"x","sendStartUnixNano":1700000000000000123}`},
+		// 空容器原样保留，而不是从结果中消失。
+		{`{"samples":[],"debug":{},"runId":"r"}`, `{"debug":{},"runId":"r","samples":[]}`},
+		{`{"a":{"b":[]}}`, `{"a.b":[]}`},
+		{`{"a":null}`, `{"a":null}`},
+		{`[{"x":1}]`, `{"0.x":1}`},
+		{`{}`, `{}`},
+		{`[]`, `{}`},
+	}
+	for _, c := range cases {
+		flat, err := flattenJSON([]byte(c.in))
+		if err != nil {
+			t.Errorf("flattenJSON(%s): %v", c.in, err)
+			continue
+		}
+		if got, _ := json.Marshal(flat); string(got) != c.want {
+			t.Errorf("flattenJSON(%s) = %s, want %s", c.in, got, c.want)
+		}
+	}
+	// 顶层标量没有可用的 key。
+	for _, in := range []string{`42`, `"x"`, `null`} {
+		if flat, err := flattenJSON([]byte(in)); err == nil {
+			t.Errorf("flattenJSON(%s) = %v, want error", in, flat)
+		}
+	}
+}
+
+func TestConfirmAck(t *testing.T) {
+	f := useFakeSQS(t)
+
+	// 未配置 CONFIRM_QUEUE_URL：不发送 ack，也不输出 ackSendMs。
+	t.Setenv("CONFIRM_QUEUE_URL", "")
+	resp, _ := handler(context.Background(), events.APIGatewayProxyRequest{Body: `{"runId":"run-noack","maxWaitMs":5000}`})
+	if resp.StatusCode != 200 || len(f.acks) != 0 || strings.Contains(resp.Body, `"ackSendMs"`) {
+		t.Fatalf("unconfigured: status=%d acks=%v body=%s", resp.StatusCode, f.acks, resp.Body)
+	}
+
+	// 配置后：匹配到回调再向该队列回写 ack；亚毫秒的耗时同样输出。
+	t.Setenv("CONFIRM_QUEUE_URL", strings.TrimSuffix(os.Getenv("PUSH_QUEUE_URL"), "/push")+"/confirm")
+	resp, _ = handler(context.Background(), events.APIGatewayProxyRequest{Body: `{"runId":"run-ack","maxWaitMs":5000}`})
+	if resp.StatusCode != 200 {
+		t.Fatalf("status=%d body=%s", resp.StatusCode, resp.Body)
+	}
+	out := decodeOutput(t, resp)
+	acks := f.acks["confirm"]
+	if len(acks) != 1 || acks[0].RunID != "run-ack" || acks[0].ID != out.ID || acks[0].AckUnixNano == 0 {
+		t.Fatalf("acks=%+v, want one ack for run-ack/%s", f.acks, out.ID)
+	}
+	if out.AckSendMs <= 0 || out.AckError != "" {
+		t.Fatalf("ackSendMs=%v ackError=%q", out.AckSendMs, out.AckError)
+	}
+}
+
+func TestSendClockMonotonic(t *testing.T) {
+	c := startSendClock()
+	sendStart := c.baseUnixNano
+	prev := sendStart
+	for i := 0; i < 1000; i++ {
+		n := c.now()
+		if n < prev {
+			t.Fatalf("non-monotonic: %d < %d at i=%d", n, prev, i)
+		}
+		prev = n
+	}
+	time.Sleep(2 * time.Millisecond)
+	sendEnd := c.now()
+	if d := time.Duration(sendEnd - sendStart); d < 2*time.Millisecond {
+		t.Fatalf("sendEnd-sendStart=%v, want >= 2ms", d)
+	}
+}
+
+func TestInjectedLatencyIsReported(t *testing.T) {
+	const injected = 50 * time.Millisecond
+	const tolerance = 40 * time.Millisecond
+	useFakeSQS(t, withInjectedLatency(injected))
+
+	resp, _ := handler(context.Background(), events.APIGatewayProxyRequest{Body: `{"runId":"run-calibrate","maxWaitMs":5000}`})
+	if resp.StatusCode != 200 {
+		t.Fatalf("status=%d body=%s", resp.StatusCode, resp.Body)
+	}
+	out := decodeOutput(t, resp)
+
+	for name, d := range map[string]time.Duration{
+		"send": time.Duration(out.SendEndUnixNano - out.SendStartUnixNano),
+		"poll": time.Duration(out.PollEndUnixNano - out.PollStartUnixNano),
+	} {
+		if d < injected || d > injected+tolerance {
+			t.Errorf("%s duration=%v, want %v (+%v)", name, d, injected, tolerance)
+		}
+	}
+}
+
+func TestBatchDelaySeconds(t *testing.T) {
+	useFakeSQS(t)
+
+	resp, _ := handler(context.Background(), events.APIGatewayProxyRequest{Body: `{"runId":"run-batch","maxWaitMs":5000,"batchDelaySeconds":[0,1,2]}`})
+	if resp.StatusCode != 200 {
+		t.Fatalf("status=%d body=%s", resp.StatusCode, resp.Body)
+	}
+	var api apiResponse
+	_ = json.Unmarshal([]byte(resp.Body), &api)
+	var out batchOutput
+	if err := json.Unmarshal(api.Output, &out); err != nil {
+		t.Fatalf("unmarshal output: %v", err)
+	}
+	if len(out.Samples) != 3 {
+		t.Fatalf("samples=%d, want 3", len(out.Samples))
+	}
+	for i, s := range out.Samples {
+		if s.Status != "OK" || s.RequestedDelaySeconds != i {
+			t.Errorf("sample[%d]: status=%s requestedDelaySeconds=%d", i, s.Status, s.RequestedDelaySeconds)
+		}
+	}
+
+	resp, _ = handler(context.Background(), events.APIGatewayProxyRequest{Body: `{"batchDelaySeconds":[0,901]}`})
+	if resp.StatusCode != 400 {
+		t.Fatalf("out-of-range delay: status=%d, want 400", resp.StatusCode)
+	}
+	// 延迟不小于生效的 maxWait 的条目必然超时。
+	resp, _ = handler(context.Background(), events.APIGatewayProxyRequest{Body: `{"maxWaitMs":2000,"batchDelaySeconds":[0,2]}`})
+	if resp.StatusCode != 400 || !strings.Contains(resp.Body, "batchDelaySeconds[1]") {
+		t.Fatalf("delay beyond maxWait: status=%d body=%s, want 400", resp.StatusCode, resp.Body)
+	}
+}
+
+func TestBatchStatus(t *testing.T) {
+	cases := []struct {
+		statuses []string
+		code     int
+		status   string
+	}{
+		{[]string{"OK", "OK"}, 200, "OK"},
+		{[]string{"OK", "ERROR"}, 200, "PARTIAL"},
+		{[]string{"ERROR", "ERROR"}, 502, "ERROR"},
+		// 超时不止看第一条样本。
+		{[]string{"OK", "TIMEOUT"}, 504, "TIMEOUT"},
+		{[]string{"ERROR", "TIMEOUT"}, 504, "TIMEOUT"},
+	}
+	for _, c := range cases {
+		samples := make([]batchSample, len(c.statuses))
+		for i, s := range c.statuses {
+			samples[i].Status = s
+		}
+		if code, status := batchStatus(samples); code != c.code || status != c.status {
+			t.Errorf("batchStatus(%v) = %d %s, want %d %s", c.statuses, code, status, c.code, c.status)
+		}
+	}
+}
+
+func TestBatchReceive(t *testing.T) {
+	f := useFakeSQS(t)
+
+	resp, _ := handler(context.Background(), events.APIGatewayProxyRequest{Body: `{"runId":"run-batch-receive","maxWaitMs":5000,"batchDelaySeconds":[0,0,0,0],"batchReceive":10}`})
+	if resp.StatusCode != 200 {
+		t.Fatalf("status=%d body=%s", resp.StatusCode, resp.Body)
+	}
+	var api apiResponse
+	_ = json.Unmarshal([]byte(resp.Body), &api)
+	var out batchOutput
+	if err := json.Unmarshal(api.Output, &out); err != nil {
+		t.Fatalf("unmarshal output: %v", err)
+	}
+	for i, s := range out.Samples {
+		if s.Status != "OK" {
+			t.Errorf("sample[%d]: status=%s error=%s", i, s.Status, s.Error)
+		}
+	}
+	f.mu.Lock()
+	seen := f.maxMessagesSeen
+	f.mu.Unlock()
+	if seen != 10 {
+		t.Errorf("MaxNumberOfMessages=%d, want 10", seen)
+	}
+
+	resp, _ = handler(context.Background(), events.APIGatewayProxyRequest{Body: `{"batchReceive":11}`})
+	if resp.StatusCode != 400 {
+		t.Fatalf("out-of-range batchReceive: status=%d, want 400", resp.StatusCode)
+	}
+}
+
+func TestIterations(t *testing.T) {
+	useFakeSQS(t)
+
+	resp, _ := handler(context.Background(), events.APIGatewayProxyRequest{Body: `{"runId":"run-iter","maxWaitMs":5000,"iterations":5}`})
+	if resp.StatusCode != 200 {
+		t.Fatalf("status=%d body=%s", resp.StatusCode, resp.Body)
+	}
+	var api apiResponse
+	_ = json.Unmarshal([]byte(resp.Body), &api)
+	if api.Stats == nil || api.Stats.Completed != 5 || api.Stats.Failed != 0 || api.Stats.StoppedEarly {
+		t.Fatalf("stats=%+v", api.Stats)
+	}
+	if s := api.Stats.TotalMs; s.Min > s.P50 || s.P50 > s.P99 || s.P99 > s.Max || s.Mean <= 0 {
+		t.Errorf("totalMs stats not ordered: %+v", s)
+	}
+	var out iterationsOutput
+	if err := json.Unmarshal(api.Output, &out); err != nil {
+		t.Fatalf("unmarshal output: %v", err)
+	}
+	ids := map[string]bool{}
+	for _, s := range out.Samples {
+		ids[s.ID] = true
+	}
+	if len(out.Samples) != 5 || len(ids) != 5 {
+		t.Fatalf("samples=%d distinct ids=%d, want 5 and 5", len(out.Samples), len(ids))
+	}
+
+	resp, _ = handler(context.Background(), events.APIGatewayProxyRequest{Body: `{"iterations":2,"compareAttributes":1}`})
+	if resp.StatusCode != 400 {
+		t.Fatalf("iterations+compareAttributes: status=%d, want 400", resp.StatusCode)
+	}
+}
+
+func TestColdStartPenalty(t *testing.T) {
+	var st iterationStats
+	st.summarize([]iterationSample{
+		{Status: "OK", TotalMs: 300, WorkerInvocationClass: invocationCold},
+		{Status: "OK", TotalMs: 60, WorkerInvocationClass: invocationNearCold},
+		{Status: "OK", TotalMs: 40, WorkerInvocationClass: invocationWarm},
+		{Status: "OK", TotalMs: 60, WorkerInvocationClass: invocationWarm},
+		{Status: "TIMEOUT", TotalMs: 5000, WorkerInvocationClass: invocationCold},
+	})
+	if st.ColdSamples != 1 || st.WarmSamples != 2 || st.ColdStartPenaltyMs == nil || *st.ColdStartPenaltyMs != 250 {
+		t.Fatalf("cold=%d warm=%d penalty=%v, want 1 2 250", st.ColdSamples, st.WarmSamples, st.ColdStartPenaltyMs)
+	}
+
+	st = iterationStats{}
+	st.summarize([]iterationSample{{Status: "OK", TotalMs: 40, WorkerInvocationClass: invocationWarm}})
+	if st.ColdStartPenaltyMs != nil {
+		t.Errorf("warm only: penalty=%v, want nil", *st.ColdStartPenaltyMs)
+	}
+}
+
+func TestStageBreakdownSkew(t *testing.T) {
+	ms := int64(time.Millisecond)
+	st := sendTimes{sendStart: 1000 * ms, sendEnd: 1010 * ms, pollStart: 1010 * ms}
+	pr := pollResult{
+		cb:                         callbackMessage{WorkerReceiveUnixNano: 1025 * ms, WorkerDoneUnixNano: 1026 * ms, CallbackSendStartUnixNano: 1026 * ms},
+		callbackSqsSentTimestampMs: 1030,
+		receiveMessageUnixNano:     1045 * ms,
+		pollEnd:                    1045 * ms,
+	}
+	out := newDispatcherOutput("run", "id", queueTargets{}, st, pr, invocationInfo{})
+	if out.QueueToWorkerUs != 15000 || out.CallbackSendUs != 4000 || out.ReceiveLatencyUs != 15000 || out.SkewDetected {
+		t.Fatalf("queueToWorker=%v callbackSend=%v receiveLatency=%v skew=%v", out.QueueToWorkerUs, out.CallbackSendUs, out.ReceiveLatencyUs, out.SkewDetected)
+	}
+
+	// Worker 时钟比 Dispatcher 慢 20ms：workerReceive 早于 sendEnd。
+	pr.cb.WorkerReceiveUnixNano = 1005 * ms
+	out = newDispatcherOutput("run", "id", queueTargets{}, st, pr, invocationInfo{})
+	if out.QueueToWorkerUs != 0 || !out.SkewDetected {
+		t.Fatalf("skewed: queueToWorker=%v skew=%v, want 0 true", out.QueueToWorkerUs, out.SkewDetected)
+	}
+
+	// 有 Push 消息的 SentTimestamp 时按 SQS 时钟估计偏差并校正：Dispatcher 比 SQS 快 2ms，Worker 比 SQS 慢 24ms。
+	pr.cb.SqsSentTimestampMs = 1008
+	pr.cb.CallbackSendStartUnixNano = 1006 * ms
+	out = newDispatcherOutput("run", "id", queueTargets{}, st, pr, invocationInfo{})
+	if out.EstimatedSkewMs == nil || *out.EstimatedSkewMs != -26 || *out.DispatcherSqsOffsetMs != 2 || *out.WorkerSqsOffsetMs != -24 ||
+		out.QueueToWorkerUs != 21000 || out.QueueToWorkerRawUs != 0 || out.SkewDetected {
+		t.Fatalf("corrected: skew=%v queueToWorker=%v raw=%v detected=%v", out.EstimatedSkewMs, out.QueueToWorkerUs, out.QueueToWorkerRawUs, out.SkewDetected)
+	}
+
+	// SNS 传输：SentTimestamp 是 SNS 投递到 Push 队列的时间，包含扇出耗时，不用于校正。
+	t.Setenv("PUSH_TRANSPORT", pushTransportSNS)
+	pr.cb.WorkerReceiveUnixNano = 1025 * ms
+	out = newDispatcherOutput("run", "id", queueTargets{}, st, pr, invocationInfo{})
+	if out.EstimatedSkewMs != nil || out.DispatcherSqsOffsetMs != nil || out.QueueToWorkerUs != 15000 || out.QueueToWorkerRawUs != 0 || out.SkewDetected {
+		t.Fatalf("sns: skew=%v queueToWorker=%v raw=%v detected=%v", out.EstimatedSkewMs, out.QueueToWorkerUs, out.QueueToWorkerRawUs, out.SkewDetected)
+	}
+}
+
+func TestMessageAttributeLimit(t *testing.T) {
+	prev := dispatcherVersion
+	dispatcherVersion = "v-test"
+	t.Cleanup(func() { dispatcherVersion = prev })
+
+	route := map[string]string{}
+	for i := 0; i < maxRouteAttributes; i++ {
+		route[fmt.Sprintf("r%d", i)] = "x"
+	}
+	// 6 个路由属性 + ultraMinimal 的 4 个内部属性 = 10，恰好在限制内。
+	if err := validateMessageAttributeCount(apiRequest{RouteAttributes: route, UltraMinimal: true}); err != nil {
+		t.Fatalf("10 attributes: %v", err)
+	}
+	err := validateMessageAttributeCount(apiRequest{RouteAttributes: route, UltraMinimal: true, CompareAttributes: 1})
+	if err == nil || !strings.HasPrefix(err.Error(), "too many message attributes: 11 > 10") || !strings.Contains(err.Error(), attrTraceHeader) {
+		t.Fatalf("11 attributes: err=%v", err)
+	}
+
+	useFakeSQS(t)
+	b, _ := json.Marshal(apiRequest{RouteAttributes: route, UltraMinimal: true, CompareAttributes: 1})
+	resp, _ := handler(context.Background(), events.APIGatewayProxyRequest{Body: string(b)})
+	if resp.StatusCode != 400 || !strings.Contains(resp.Body, "too many message attributes") {
+		t.Fatalf("status=%d body=%s", resp.StatusCode, resp.Body)
+	}
+}
+
+func TestFIFOPushQueue(t *testing.T) {
+	f := useFakeSQS(t)
+
+	resp, _ := handler(context.Background(), events.APIGatewayProxyRequest{Body: `{"runId":"run-std","maxWaitMs":5000}`})
+	if resp.StatusCode != 200 || f.lastGroupID != "" || f.lastDedupID != "" {
+		t.Fatalf("standard queue: status=%d groupId=%q dedupId=%q", resp.StatusCode, f.lastGroupID, f.lastDedupID)
+	}
+
+	t.Setenv("PUSH_QUEUE_URL", os.Getenv("PUSH_QUEUE_URL")+".fifo")
+	resp, _ = handler(context.Background(), events.APIGatewayProxyRequest{Body: `{"runId":"run-fifo","maxWaitMs":5000}`})
+	out := decodeOutput(t, resp)
+	if resp.StatusCode != 200 || f.lastGroupID != "run-fifo" || f.lastDedupID != out.ID {
+		t.Fatalf("fifo: status=%d groupId=%q dedupId=%q id=%q", resp.StatusCode, f.lastGroupID, f.lastDedupID, out.ID)
+	}
+	resp, _ = handler(context.Background(), events.APIGatewayProxyRequest{Body: `{"runId":"run-fifo","maxWaitMs":5000,"messageGroupId":"g1"}`})
+	if resp.StatusCode != 200 || f.lastGroupID != "g1" {
+		t.Fatalf("fifo override: status=%d groupId=%q", resp.StatusCode, f.lastGroupID)
+	}
+	resp, _ = handler(context.Background(), events.APIGatewayProxyRequest{Body: `{"delaySeconds":5}`})
+	if resp.StatusCode != 400 {
+		t.Fatalf("fifo delaySeconds: status=%d, want 400", resp.StatusCode)
+	}
+}
+
+func TestWorkMs(t *testing.T) {
+	f := useFakeSQS(t)
+
+	for _, tc := range []struct {
+		workMs, want int
+	}{{250, 250}, {maxWorkMs * 2, maxWorkMs}, {-5, 0}} {
+		resp, _ := handler(context.Background(), events.APIGatewayProxyRequest{Body: fmt.Sprintf(`{"maxWaitMs":5000,"workMs":%d}`, tc.workMs)})
+		if resp.StatusCode != 200 || f.lastWorkMs != tc.want {
+			t.Errorf("workMs=%d: status=%d sent=%d, want %d", tc.workMs, resp.StatusCode, f.lastWorkMs, tc.want)
+		}
+	}
+}
+
+func TestDuplicateIDs(t *testing.T) {
+	useFakeSQS(t)
+	orig := idRandRead
+	t.Cleanup(func() { idRandRead = orig })
+
+	// 第 n 次调用用种子 n，第 2 次复用种子 1，与第 1 条 id 碰撞。
+	calls := 0
+	idRandRead = func(b []byte) (int, error) {
+		calls++
+		seed := int64(calls)
+		if calls == 2 {
+			seed = 1
+		}
+		return mrand.New(mrand.NewSource(seed)).Read(b)
+	}
+	resp, _ := handler(context.Background(), events.APIGatewayProxyRequest{Body: `{"runId":"run-dup","maxWaitMs":5000,"batchDelaySeconds":[0,0,0]}`})
+	if resp.StatusCode != 200 {
+		t.Fatalf("status=%d body=%s", resp.StatusCode, resp.Body)
+	}
+	var api struct {
+		Output batchOutput `json:"output"`
+	}
+	_ = json.Unmarshal([]byte(resp.Body), &api)
+	ids := map[string]bool{}
+	for _, s := range api.Output.Samples {
+		ids[s.ID] = true
+	}
+	if api.Output.DuplicateIDCount != 1 || len(ids) != 3 {
+		t.Fatalf("duplicateIdCount=%d distinct=%d, want 1 and 3", api.Output.DuplicateIDCount, len(ids))
+	}
+
+	// 随机源始终相同：重新生成也无法避免碰撞，整批不发送。
+	idRandRead = func(b []byte) (int, error) { return mrand.New(mrand.NewSource(1)).Read(b) }
+	resp, _ = handler(context.Background(), events.APIGatewayProxyRequest{Body: `{"runId":"run-dup2","maxWaitMs":5000,"batchDelaySeconds":[0,0]}`})
+	if resp.StatusCode != 502 || !strings.Contains(resp.Body, "duplicate message id") {
+		t.Fatalf("stuck generator: status=%d body=%s", resp.StatusCode, resp.Body)
+	}
+}
+
+func TestSendThrottled(t *testing.T) {
+	f := useFakeSQS(t)
+	consecutiveThrottles.Store(0)
+
+	f.sendErrorType = "ThrottlingException"
+	for _, want := range []string{"1", "2", "4"} {
+		resp, _ := handler(context.Background(), events.APIGatewayProxyRequest{Body: `{"maxWaitMs":5000,"retryBudgetMs":-1}`})
+		if resp.StatusCode != 429 || resp.Headers["Retry-After"] != want || !strings.Contains(resp.Body, "THROTTLED") {
+			t.Fatalf("throttled: status=%d Retry-After=%q body=%s, want 429 and %s", resp.StatusCode, resp.Headers["Retry-After"], resp.Body, want)
+		}
+	}
+
+	f.sendErrorType = "InvalidParameterValue"
+	resp, _ := handler(context.Background(), events.APIGatewayProxyRequest{Body: `{"maxWaitMs":5000,"retryBudgetMs":-1}`})
+	if resp.StatusCode != 502 || resp.Headers["Retry-After"] != "" {
+		t.Fatalf("other error: status=%d Retry-After=%q, want 502", resp.StatusCode, resp.Headers["Retry-After"])
+	}
+
+	f.sendErrorType = ""
+	if resp, _ := handler(context.Background(), events.APIGatewayProxyRequest{Body: `{"maxWaitMs":5000}`}); resp.StatusCode != 200 || consecutiveThrottles.Load() != 0 {
+		t.Fatalf("recovered: status=%d consecutiveThrottles=%d", resp.StatusCode, consecutiveThrottles.Load())
+	}
+}
+
+func TestReceiveQueueFallback(t *testing.T) {
+	f := useFakeSQS(t)
+	f.missingQueue = "receive"
+
+	resp, _ := handler(context.Background(), events.APIGatewayProxyRequest{Body: `{"maxWaitMs":5000}`})
+	if resp.StatusCode != 500 || !strings.Contains(resp.Body, "receive queue does not exist") {
+		t.Fatalf("no fallback: status=%d body=%s", resp.StatusCode, resp.Body)
+	}
+
+	f.pending = nil
+	t.Setenv("RECEIVE_QUEUE_URL_FALLBACK", strings.TrimSuffix(os.Getenv("RECEIVE_QUEUE_URL"), "receive")+"receive-b")
+	resp, _ = handler(context.Background(), events.APIGatewayProxyRequest{Body: `{"maxWaitMs":5000}`})
+	if resp.StatusCode != 200 {
+		t.Fatalf("fallback: status=%d body=%s", resp.StatusCode, resp.Body)
+	}
+	out := decodeOutput(t, resp)
+	if !out.UsedFallbackQueue || out.ReceiveQueueName != "receive-b" {
+		t.Fatalf("usedFallbackQueue=%v receiveQueueName=%q, want true and receive-b", out.UsedFallbackQueue, out.ReceiveQueueName)
+	}
+}
+
+func TestEsmInvokeLatency(t *testing.T) {
+	f := useFakeSQS(t)
+	for _, tc := range []struct {
+		latency  float64
+		wantSkew bool
+	}{{12.5, false}, {-0.5, false}, {-30, true}} {
+		f.esmInvokeLatencyMs = &tc.latency
+		resp, _ := handler(context.Background(), events.APIGatewayProxyRequest{Body: `{"maxWaitMs":5000}`})
+		out := decodeOutput(t, resp)
+		if out.EsmInvokeLatencyMs == nil || *out.EsmInvokeLatencyMs != tc.latency || out.EsmInvokeLatencySkew != tc.wantSkew {
+			t.Fatalf("latency %v: esmInvokeLatencyMs=%v skew=%v, want skew=%v", tc.latency, out.EsmInvokeLatencyMs, out.EsmInvokeLatencySkew, tc.wantSkew)
+		}
+	}
+}
+
+func TestHealthCheck(t *testing.T) {
+	f := useFakeSQS(t)
+	resp, _ := handler(context.Background(), events.APIGatewayProxyRequest{HTTPMethod: "GET", Path: "/health"})
+	var api struct {
+		Status string       `json:"status"`
+		Output healthOutput `json:"output"`
+	}
+	_ = json.Unmarshal([]byte(resp.Body), &api)
+	if resp.StatusCode != 200 || !api.Output.InitOK || api.Output.PushQueueName != "push" || api.Output.ReceiveQueueName != "receive" {
+		t.Fatalf("healthy: status=%d body=%s", resp.StatusCode, resp.Body)
+	}
+	if len(f.pending) != 0 {
+		t.Fatalf("health check sent %d messages", len(f.pending))
+	}
+
+	t.Setenv("RECEIVE_QUEUE_URL", "")
+	resp, _ = handler(context.Background(), events.APIGatewayProxyRequest{HTTPMethod: "GET", Resource: "/health"})
+	_ = json.Unmarshal([]byte(resp.Body), &api)
+	if resp.StatusCode != 503 || api.Output.ReceiveQueueURLSet || !api.Output.PushQueueURLSet {
+		t.Fatalf("missing receive queue: status=%d body=%s", resp.StatusCode, resp.Body)
+	}
+}
+
+func TestSDKLog(t *testing.T) {
+	useFakeSQS(t, withSDKLogging)
+	resp, _ := handler(context.Background(), events.APIGatewayProxyRequest{Body: `{"maxWaitMs":5000,"sdkLog":true}`})
+	out := decodeOutput(t, resp)
+	if out.Debug == nil || !strings.Contains(out.Debug.SDKLog, "AmazonSQS.SendMessage") || !strings.Contains(out.Debug.SDKLog, "AmazonSQS.ReceiveMessage") || !strings.Contains(out.Debug.SDKLog, "200 OK") {
+		t.Fatalf("sdkLog missing SQS calls: %+v", out.Debug)
+	}
+	resp, _ = handler(context.Background(), events.APIGatewayProxyRequest{Body: `{"maxWaitMs":5000}`})
+	if out := decodeOutput(t, resp); out.Debug != nil {
+		t.Fatalf("sdkLog captured without the flag: %+v", out.Debug)
+	}
+
+	c := &sdkLogCapture{}
+	c.Logf(logging.Debug, "Request\nAuthorization: AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/x\nX-Amz-Security-Token: secret\nX-Amz-Target: AmazonSQS.SendMessage")
+	if s := c.String(); strings.Contains(s, "AKIDEXAMPLE") || strings.Contains(s, "secret") || !strings.Contains(s, "Authorization: REDACTED") {
+		t.Fatalf("credentials not redacted: %s", s)
+	}
+	c.Logf(logging.Debug, "%s", strings.Repeat("x", maxSDKLogBytes))
+	if s := c.String(); len(s) > maxSDKLogBytes+64 || !strings.Contains(s, "[truncated ") {
+		t.Fatalf("sdkLog not bounded: len=%d", len(s))
+	}
+}
+
+func TestEphemeralTeardownOnSetupFailure(t *testing.T) {
+	f := useFakeSQS(t)
+	if resp, _ := handler(context.Background(), events.APIGatewayProxyRequest{Body: `{"ephemeral":true}`}); resp.StatusCode != 400 || !strings.Contains(resp.Body, "WORKER_FUNCTION_NAME") {
+		t.Fatalf("missing worker function: status=%d body=%s", resp.StatusCode, resp.Body)
+	}
+
+	// 两个队列建好后创建事件源映射失败，已建的队列必须被删除。
+	fl := &fakeLambda{createErr: errors.New("AccessDeniedException")}
+	prev := lambdaClient
+	lambdaClient = fl
+	t.Cleanup(func() { lambdaClient = prev })
+	t.Setenv("WORKER_FUNCTION_NAME", "worker")
+	resp, _ := handler(context.Background(), events.APIGatewayProxyRequest{Body: `{"ephemeral":true,"maxWaitMs":12000}`})
+	var api struct {
+		Output ephemeralOutput `json:"output"`
+	}
+	_ = json.Unmarshal([]byte(resp.Body), &api)
+	if resp.StatusCode != 502 || !strings.Contains(api.Output.SetupError, "create event source mapping") {
+		t.Fatalf("setup failure: status=%d body=%s", resp.StatusCode, resp.Body)
+	}
+	if len(f.createdQueues) != 2 || strings.Join(f.deletedQueues, ",") != strings.Join(f.createdQueues, ",") {
+		t.Fatalf("created=%v deleted=%v, want every created queue deleted", f.createdQueues, f.deletedQueues)
+	}
+	for _, name := range f.createdQueues {
+		if !strings.HasPrefix(name, ephemeralQueuePrefix) {
+			t.Fatalf("queue %q lacks prefix %q", name, ephemeralQueuePrefix)
+		}
+	}
+
+	// 映射已创建但查询状态失败：拆除时同样删除映射。
+	f.createdQueues, f.deletedQueues = nil, nil
+	fl.createErr, fl.getErr = nil, errors.New("throttled")
+	resp, _ = handler(context.Background(), events.APIGatewayProxyRequest{Body: `{"ephemeral":true,"maxWaitMs":12000}`})
+	if resp.StatusCode != 502 || len(f.deletedQueues) != 2 || strings.Join(fl.deleted, ",") != "esm-1" {
+		t.Fatalf("get failure: status=%d deletedQueues=%v deletedMappings=%v", resp.StatusCode, f.deletedQueues, fl.deleted)
+	}
+}
+
+// fakeLambda：事件源映射的 mock；创建后状态为 Creating，之后的 Get 返回 Enabled。
+type fakeLambda struct {
+	createErr, getErr error
+	deleted           []string
+}
+
+func (f *fakeLambda) CreateEventSourceMapping(ctx context.Context, in *lambdasvc.CreateEventSourceMappingInput, _ ...func(*lambdasvc.Options)) (*lambdasvc.CreateEventSourceMappingOutput, error) {
+	if f.createErr != nil {
+		return nil, f.createErr
+	}
+	return &lambdasvc.CreateEventSourceMappingOutput{UUID: aws.String("esm-1"), State: aws.String("Creating")}, nil
+}
+
+func (f *fakeLambda) GetEventSourceMapping(ctx context.Context, in *lambdasvc.GetEventSourceMappingInput, _ ...func(*lambdasvc.Options)) (*lambdasvc.GetEventSourceMappingOutput, error) {
+	if f.getErr != nil {
+		return nil, f.getErr
+	}
+	return &lambdasvc.GetEventSourceMappingOutput{UUID: in.UUID, State: aws.String("Enabled")}, nil
+}
+
+func (f *fakeLambda) DeleteEventSourceMapping(ctx context.Context, in *lambdasvc.DeleteEventSourceMappingInput, _ ...func(*lambdasvc.Options)) (*lambdasvc.DeleteEventSourceMappingOutput, error) {
+	f.deleted = append(f.deleted, aws.ToString(in.UUID))
+	return &lambdasvc.DeleteEventSourceMappingOutput{}, nil
+}
+
+func TestEphemeralStatus(t *testing.T) {
+	// 超时由任一样本决定，而不只是第一条。
+	if code, status := ephemeralStatus([]batchSample{{Status: "OK"}, {Status: "TIMEOUT"}}, nil); code != 504 || status != "TIMEOUT" {
+		t.Fatalf("got %d %s, want 504 TIMEOUT", code, status)
+	}
+	if code, _ := ephemeralStatus(nil, errors.New("send")); code != 502 {
+		t.Fatalf("send error: got %d, want 502", code)
+	}
+}
+
+func TestColdStartFlag(t *testing.T) {
+	useFakeSQS(t)
+	coldStartPending.Store(false)
+	markColdStart()
+	for i, want := range []bool{true, false, false} {
+		resp, _ := handler(context.Background(), events.APIGatewayProxyRequest{Body: `{"maxWaitMs":5000}`})
+		if out := decodeOutput(t, resp); out.ColdStart != want {
+			t.Fatalf("call %d: coldStart=%v, want %v", i+1, out.ColdStart, want)
+		}
+	}
+}
+
+func TestBootstrapCI(t *testing.T) {
+	if ci := bootstrapCI([]float64{1, 2, 3}, "run"); ci != nil {
+		t.Fatalf("too few samples: %+v", ci)
+	}
+	v := make([]float64, 200)
+	for i := range v {
+		v[i] = 20 + float64(i%50)
+	}
+	ci := bootstrapCI(v, "run-ci")
+	if ci.Method != ciMethodBootstrap || ci.Samples != 200 || ci.Confidence != 0.95 {
+		t.Fatalf("metadata: %+v", ci)
+	}
+	for name, c := range map[string]confidenceInterval{"mean": ci.Mean, "p99": ci.P99} {
+		if !(c.Lower <= c.Estimate && c.Estimate <= c.Upper) {
+			t.Fatalf("%s: %+v does not bracket the estimate", name, c)
+		}
+	}
+	if ci.Mean.Upper-ci.Mean.Lower > 5 {
+		t.Fatalf("mean interval too wide for 200 samples: %+v", ci.Mean)
+	}
+	if again := bootstrapCI(v, "run-ci"); *again != *ci {
+		t.Fatalf("same runId gave different intervals: %+v vs %+v", again, ci)
+	}
+
+	useFakeSQS(t)
+	if resp, _ := handler(context.Background(), events.APIGatewayProxyRequest{Body: `{"confidenceIntervals":true}`}); resp.StatusCode != 400 {
+		t.Fatalf("without iterations: status=%d body=%s", resp.StatusCode, resp.Body)
+	}
+	resp, _ := handler(context.Background(), events.APIGatewayProxyRequest{Body: `{"iterations":6,"confidenceIntervals":true,"maxWaitMs":5000}`})
+	var api struct {
+		Stats iterationStats `json:"stats"`
+	}
+	_ = json.Unmarshal([]byte(resp.Body), &api)
+	if api.Stats.TotalMsCI == nil || api.Stats.TotalMsCI.Samples != 6 || api.Stats.PipelineLatencyMsCI == nil {
+		t.Fatalf("iterations stats missing intervals: %s", resp.Body)
+	}
+}
+
+func TestXRaySubsegments(t *testing.T) {
+	for params, want := range map[any]string{
+		&sqs.SendMessageInput{}:             "send",
+		&sqs.SendMessageBatchInput{}:        "send",
+		&sqs.ReceiveMessageInput{}:          "poll",
+		&sqs.DeleteMessageInput{}:           "delete",
+		&sqs.ChangeMessageVisibilityInput{}: "visibility",
+		&sqs.GetQueueAttributesInput{}:      "",
+	} {
+		if got := xraySubsegmentName(params); got != want {
+			t.Errorf("%T: got %q, want %q", params, got, want)
+		}
+	}
+
+	// 没有 Lambda 段（本地运行）时子段开启失败只记录日志，调用本身照常完成。
+	useFakeSQS(t, withXRaySubsegments)
+	if resp, _ := handler(context.Background(), events.APIGatewayProxyRequest{Body: `{"maxWaitMs":5000}`}); resp.StatusCode != 200 {
+		t.Fatalf("status=%d body=%s", resp.StatusCode, resp.Body)
+	}
+}
+
+func TestVisibilitySweep(t *testing.T) {
+	f := useFakeSQS(t)
+
+	resp, _ := handler(context.Background(), events.APIGatewayProxyRequest{Body: `{"runId":"run-vis","maxWaitMs":20000,"visibilitySweep":[2,5],"visibilitySweepConcurrency":3}`})
+	if resp.StatusCode != 200 {
+		t.Fatalf("status=%d body=%s", resp.StatusCode, resp.Body)
+	}
+	var api struct {
+		Output visibilitySweepOutput `json:"output"`
+	}
+	_ = json.Unmarshal([]byte(resp.Body), &api)
+	out := api.Output
+	if out.Concurrency != 3 || len(out.Levels) != 2 || out.RecommendedVisibilityTimeoutSeconds == nil {
+		t.Fatalf("output=%+v", out)
+	}
+	for _, l := range out.Levels {
+		if l.OK != 3 || !f.visibilitiesSeen[l.VisibilityTimeoutSeconds] {
+			t.Errorf("level %+v: visibility not used or samples failed (seen=%v)", l, f.visibilitiesSeen)
+		}
+	}
+
+	levels := []visibilitySweepLevel{
+		{VisibilityTimeoutSeconds: 0, OK: 3, ForeignGrabCount: 4, P99Ms: 10},
+		{VisibilityTimeoutSeconds: 5, OK: 3, ForeignGrabCount: 1, P99Ms: 30},
+		{VisibilityTimeoutSeconds: 10, OK: 3, ForeignGrabCount: 1, P99Ms: 20},
+		{VisibilityTimeoutSeconds: 20, OK: 2, Failed: 1},
+	}
+	if got := recommendVisibilityTimeout(levels); got == nil || *got != 10 {
+		t.Errorf("recommended=%v, want 10", got)
+	}
+
+	resp, _ = handler(context.Background(), events.APIGatewayProxyRequest{Body: `{"visibilitySweep":[31]}`})
+	if resp.StatusCode != 400 {
+		t.Fatalf("out of range: status=%d, want 400", resp.StatusCode)
+	}
+}
+
+func TestReproCurl(t *testing.T) {
+	useFakeSQS(t)
+
+	resp, _ := handler(context.Background(), events.APIGatewayProxyRequest{Body: `{"maxWaitMs":5000}`})
+	if out := decodeOutput(t, resp); out.Debug != nil {
+		t.Fatalf("debug without debug=true: %+v", out.Debug)
+	}
+
+	resp, _ = handler(context.Background(), events.APIGatewayProxyRequest{
+		Headers:        map[string]string{"authorization": "Bearer secret", "accept": "application/json", "x-forwarded-for": "10.0.0.1"},
+		RequestContext: events.APIGatewayProxyRequestContext{DomainName: "abc.execute-api.us-east-1.amazonaws.com", Path: "/dev/dispatch"},
+		Body:           `{"runId":"run-curl","maxWaitMs":5000,"debug":true,"delaySeconds":5000,"workMs":-1}`,
+	})
+	out := decodeOutput(t, resp)
+	if out.Debug == nil {
+		t.Fatalf("missing debug: status=%d body=%s", resp.StatusCode, resp.Body)
+	}
+	curl := out.Debug.Curl
+	for _, want := range []string{
+		"curl -X POST 'https://abc.execute-api.us-east-1.amazonaws.com/dev/dispatch'",
+		"-H 'Authorization: REDACTED'",
+		"-H 'Accept: application/json'",
+		`"runId":"run-curl"`,
+		`"delaySeconds":900`,
+		`"retryBudgetMs":500`,
+	} {
+		if !strings.Contains(curl, want) {
+			t.Errorf("curl missing %q: %s", want, curl)
+		}
+	}
+	if strings.Contains(curl, "secret") || strings.Contains(curl, "workMs") || strings.Contains(strings.ToLower(curl), "x-forwarded-for") {
+		t.Errorf("curl leaks or keeps unresolved values: %s", curl)
+	}
+	if got := shellQuote(`{"a":"it's"}`); got != `'{"a":"it'\''s"}'` {
+		t.Errorf("shellQuote=%s", got)
+	}
+}
+
+func TestRequestLog(t *testing.T) {
+	useFakeSQS(t)
+	prev := slog.Default()
+	t.Cleanup(func() { slog.SetDefault(prev) })
+	var buf bytes.Buffer
+	slog.SetDefault(slog.New(slog.NewJSONHandler(&buf, nil)))
+
+	resp, _ := handler(context.Background(), events.APIGatewayProxyRequest{Body: `{"runId":"run-log","maxWaitMs":5000}`})
+	var line struct {
+		Level      string `json:"level"`
+		Msg        string `json:"msg"`
+		RunID      string `json:"runId"`
+		HTTPStatus int    `json:"httpStatus"`
+		Status     string `json:"status"`
+		TotalMs    *int64 `json:"totalMs"`
+	}
+	for _, l := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		if strings.Contains(l, `"dispatcher request"`) {
+			_ = json.Unmarshal([]byte(l), &line)
+		}
+	}
+	if line.Level != "INFO" || line.RunID != "run-log" || line.HTTPStatus != resp.StatusCode || line.Status != "OK" || line.TotalMs == nil {
+		t.Fatalf("request log=%+v (logs=%s)", line, buf.String())
+	}
+
+	for v, want := range map[string]slog.Level{"": slog.LevelInfo, "debug": slog.LevelDebug, "WARN": slog.LevelWarn, "error": slog.LevelError, "bogus": slog.LevelInfo} {
+		t.Setenv("LOG_LEVEL", v)
+		if got := logLevelFromEnv(); got != want {
+			t.Errorf("LOG_LEVEL=%q: got %v, want %v", v, got, want)
+		}
+	}
+}
+
+func TestFIFOGroups(t *testing.T) {
+	f := useFakeSQS(t)
+	body := `{"runId":"run-ht","maxWaitMs":5000,"batchDelaySeconds":[0,0,0,0,0],"fifoGroupCount":2}`
+
+	if resp, _ := handler(context.Background(), events.APIGatewayProxyRequest{Body: body}); resp.StatusCode != 400 {
+		t.Fatalf("standard queue: status=%d, want 400", resp.StatusCode)
+	}
+
+	t.Setenv("PUSH_QUEUE_URL", os.Getenv("PUSH_QUEUE_URL")+".fifo")
+	f.queueAttributes = map[string]string{"DeduplicationScope": "messageGroup", "FifoThroughputLimit": "perMessageGroupId"}
+	resp, _ := handler(context.Background(), events.APIGatewayProxyRequest{Body: body})
+	if resp.StatusCode != 200 {
+		t.Fatalf("status=%d body=%s", resp.StatusCode, resp.Body)
+	}
+	var api struct {
+		Output batchOutput `json:"output"`
+	}
+	_ = json.Unmarshal([]byte(resp.Body), &api)
+	fg := api.Output.FifoGroups
+	if fg == nil || fg.HighThroughput == nil || !*fg.HighThroughput || len(fg.Groups) != 2 {
+		t.Fatalf("fifoGroups=%+v", fg)
+	}
+	if g := fg.Groups[0]; g.MessageGroupID != "run-ht-g0" || g.Samples != 3 || g.OK != 3 {
+		t.Errorf("group 0=%+v, want run-ht-g0 with 3 samples", g)
+	}
+	if g := fg.Groups[1]; g.MessageGroupID != "run-ht-g1" || g.Samples != 2 {
+		t.Errorf("group 1=%+v, want run-ht-g1 with 2 samples", g)
+	}
+
+	if resp, _ := handler(context.Background(), events.APIGatewayProxyRequest{Body: `{"fifoGroupCount":2}`}); resp.StatusCode != 400 {
+		t.Fatalf("without batch: status=%d, want 400", resp.StatusCode)
+	}
+}
+
+func TestCompressBody(t *testing.T) {
+	want := msgBody{ID: "id-1", RunID: "run-gz", SendStartUnixNano: 1700000000123456789, Padding: makePadding(200 * 1024), DispatcherVersion: "v1"}
+	text, _ := encodeMessage(want, false)
+	compressed := compressBody(text)
+	if !strings.HasPrefix(compressed, compressedBodyPrefix) || len(compressed) >= len(text)/10 {
+		t.Fatalf("compressed %d -> %d bytes", len(text), len(compressed))
+	}
+	raw, err := decodeMessageBody(compressed)
+	if err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	var got msgBody
+	if err := json.Unmarshal(raw, &got); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	if got.ID != want.ID || got.RunID != want.RunID || got.SendStartUnixNano != want.SendStartUnixNano || got.Padding != want.Padding || got.DispatcherVersion != want.DispatcherVersion {
+		t.Fatalf("round trip mismatch: id=%q runId=%q sendStart=%d padding=%d", got.ID, got.RunID, got.SendStartUnixNano, len(got.Padding))
+	}
+	if raw, _ := decodeMessageBody(text); string(raw) != text {
+		t.Errorf("uncompressed body not passed through")
+	}
+
+	useFakeSQS(t)
+	resp, _ := handler(context.Background(), events.APIGatewayProxyRequest{Body: `{"maxWaitMs":5000,"messageBodyBytes":204800,"compress":true}`})
+	out := decodeOutput(t, resp)
+	if resp.StatusCode != 200 || out.UncompressedBodyBytes <= 204800 || out.BodyBytes >= out.UncompressedBodyBytes/10 {
+		t.Fatalf("status=%d bodyBytes=%d uncompressed=%d", resp.StatusCode, out.BodyBytes, out.UncompressedBodyBytes)
+	}
+}
+
+func TestArrowOutput(t *testing.T) {
+	useFakeSQS(t)
+
+	resp, _ := handler(context.Background(), events.APIGatewayProxyRequest{Body: `{"runId":"run-arrow","maxWaitMs":5000,"batchDelaySeconds":[0,0,0],"outputFormat":"arrow"}`})
+	if resp.StatusCode != 200 {
+		t.Fatalf("status=%d body=%s", resp.StatusCode, resp.Body)
+	}
+	var api struct {
+		Output arrowOutput `json:"output"`
+	}
+	_ = json.Unmarshal([]byte(resp.Body), &api)
+	if api.Output.Format != arrowStreamFormat || api.Output.Rows != 3 {
+		t.Fatalf("format=%q rows=%d", api.Output.Format, api.Output.Rows)
+	}
+	data, err := base64.StdEncoding.DecodeString(api.Output.Data)
+	if err != nil {
+		t.Fatalf("decode data: %v", err)
+	}
+	r, err := ipc.NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("arrow reader: %v", err)
+	}
+	defer r.Release()
+	if !r.Next() {
+		t.Fatal("no record batch")
+	}
+	rec := r.Record()
+	idx := rec.Schema().FieldIndices("sendStartUnixNano")
+	if rec.NumRows() != 3 || len(idx) != 1 {
+		t.Fatalf("rows=%d sendStartUnixNano columns=%v", rec.NumRows(), idx)
+	}
+	if s := rec.Column(0).(*array.String).Value(0); s != "run-arrow" {
+		t.Errorf("runId=%q", s)
+	}
+
+	// UnixNano 超出 float64 的精确整数范围，必须原样保留。
+	enc, err := encodeArrowOutput(json.RawMessage(`{"runId":"r","id":"i","sendStartUnixNano":1700000000123456789}`))
+	if err != nil {
+		t.Fatalf("encode: %v", err)
+	}
+	_ = json.Unmarshal(enc, &api.Output)
+	data, _ = base64.StdEncoding.DecodeString(api.Output.Data)
+	r2, err := ipc.NewReader(bytes.NewReader(data))
+	if err != nil || !r2.Next() {
+		t.Fatalf("single-row reader: %v", err)
+	}
+	defer r2.Release()
+	if v := r2.Record().Column(3).(*array.Int64).Value(0); api.Output.Rows != 1 || v != 1700000000123456789 {
+		t.Errorf("rows=%d sendStartUnixNano=%d", api.Output.Rows, v)
+	}
+}
+
+func TestMsgBodyMarshalStable(t *testing.T) {
+	b := msgBody{ID: "abc", SendUnixNano: 1, SendStartUnixNano: 2, RunID: "run-1", Padding: "xx", FailMode: failModeAlwaysError}
+	want := `{"id":"abc","sendUnixNano":1,"sendStartUnixNano":2,"runId":"run-1","padding":"xx","failMode":"always-error"}`
+	for i := 0; i < 100; i++ {
+		if got := string(b.marshal()); got != want {
+			t.Fatalf("marshal #%d = %s, want %s", i, got, want)
+		}
+	}
+}
+
+func TestServerTiming(t *testing.T) {
+	ms := int64(time.Millisecond)
+	got := serverTiming(dispatcherOutput{
+		SendStartUnixNano:          1000 * ms,
+		SendEndUnixNano:            1010 * ms,
+		WorkerReceiveUnixNano:      1030 * ms,
+		WorkerDoneUnixNano:         1031 * ms,
+		CallbackSqsSentTimestampMs: 1040,
+		CallbackSendMs:             9,
+		PollStartUnixNano:          1010 * ms,
+		PollEndUnixNano:            1050 * ms,
+	})
+	want := `send;desc="SendMessage";dur=10.000, queue;desc="SQS to Worker";dur=20.000, worker;desc="Worker processing";dur=1.000, callback;desc="Callback SendMessage";dur=9, poll;desc="Receive poll";dur=40.000`
+	if got != want {
+		t.Fatalf("serverTiming =\n%s\nwant\n%s", got, want)
+	}
+	if got := serverTiming(dispatcherOutput{}); got != "" {
+		t.Fatalf("serverTiming(empty) = %q, want empty", got)
+	}
+}
+
+func TestMaintenanceMode(t *testing.T) {
+	useFakeSQS(t)
+	t.Setenv("MAINTENANCE", "1")
+
+	resp, err := handler(context.Background(), events.APIGatewayProxyRequest{Body: `{}`})
+	if err != nil {
+		t.Fatalf("handler: %v", err)
+	}
+	if resp.StatusCode != 503 || resp.Headers["Retry-After"] != "30" {
+		t.Fatalf("status=%d Retry-After=%q, want 503 and 30", resp.StatusCode, resp.Headers["Retry-After"])
+	}
+}
+
+func TestResultWebhook(t *testing.T) {
+	useFakeSQS(t)
+	delivered := make(chan apiResponse, 1)
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var api apiResponse
+		_ = json.NewDecoder(r.Body).Decode(&api)
+		delivered <- api
+	}))
+	t.Cleanup(hook.Close)
+	t.Setenv("RESULT_WEBHOOK_ALLOWLIST", "127.0.0.1")
+
+	req := events.APIGatewayProxyRequest{Body: `{"runId":"hook-1","maxWaitMs":2000,"resultWebhook":"` + hook.URL + `"}`}
+	if resp, _ := handler(context.Background(), req); resp.StatusCode != 400 {
+		t.Fatalf("lambda mode status=%d, want 400", resp.StatusCode)
+	}
+
+	httpMode = true
+	t.Cleanup(func() { httpMode = false })
+	resp, _ := handler(context.Background(), req)
+	if resp.StatusCode != 202 {
+		t.Fatalf("status=%d body=%s, want 202", resp.StatusCode, resp.Body)
+	}
+	select {
+	case api := <-delivered:
+		var out dispatcherOutput
+		_ = json.Unmarshal(api.Output, &out)
+		if api.Status != "OK" || out.RunID != "hook-1" {
+			t.Fatalf("webhook got status=%s runId=%s", api.Status, out.RunID)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("webhook not called")
+	}
+}
+
+func TestFirstDiff(t *testing.T) {
+	cases := []struct {
+		sent, echoed string
+		want         int
+	}{
+		{"xxxx", "xxxx", -1},
+		{"", "", -1},
+		{"xxxx", "xxyx", 2},
+		{"xxxx", "xx", 2},
+		{"xx", "xxx", 2},
+	}
+	for _, c := range cases {
+		if got := firstDiff(c.sent, c.echoed); got != c.want {
+			t.Errorf("firstDiff(%q, %q) = %d, want %d", c.sent, c.echoed, got, c.want)
+		}
+	}
+}
+
+func TestRampLevels(t *testing.T) {
+	cases := []struct {
+		max  int
+		step string
+		want string
+	}{
+		{1, rampStepDouble, "[1]"},
+		{10, rampStepDouble, "[1 2 4 8 10]"},
+		{8, rampStepDouble, "[1 2 4 8]"},
+		{4, rampStepLinear, "[1 2 3 4]"},
+	}
+	for _, c := range cases {
+		if got := fmt.Sprint(rampLevels(c.max, c.step)); got != c.want {
+			t.Errorf("rampLevels(%d, %q) = %s, want %s", c.max, c.step, got, c.want)
+		}
+	}
+}
+
+func TestResultS3Location(t *testing.T) {
+	at := time.Date(2026, 1, 2, 3, 4, 5, 6e6, time.UTC)
+	cases := []struct {
+		uri, env, want string
+	}{
+		{"", "", ""},
+		{"", "results-bucket", "results-bucket/run-1/20260102T030405.006Z.json"},
+		{"", "s3://results-bucket/bench/", "results-bucket/bench/run-1/20260102T030405.006Z.json"},
+		{"s3://other/a/b", "results-bucket", "other/a/b/run-1/20260102T030405.006Z.json"},
+	}
+	for _, c := range cases {
+		t.Setenv("RESULT_BUCKET", c.env)
+		loc, err := resultS3Location(apiRequest{ResultS3Uri: c.uri})
+		if err != nil {
+			t.Fatalf("resultS3Location(%q, env=%q): %v", c.uri, c.env, err)
+		}
+		got := ""
+		if loc != nil {
+			got = loc.bucket + "/" + loc.key("run-1", at)
+		}
+		if got != c.want {
+			t.Errorf("resultS3Location(%q, env=%q) = %q, want %q", c.uri, c.env, got, c.want)
+		}
+	}
+	if _, err := resultS3Location(apiRequest{ResultS3Uri: "https://bucket/x"}); err == nil {
+		t.Error("non-s3 scheme: want error")
+	}
+}
+
+// fakeDynamoDB：按 id 返回预置条目的 GetItem mock。
+type fakeDynamoDB struct {
+	items map[string]map[string]ddbtypes.AttributeValue
+	calls []*dynamodb.GetItemInput
+}
+
+func (f *fakeDynamoDB) GetItem(ctx context.Context, in *dynamodb.GetItemInput, _ ...func(*dynamodb.Options)) (*dynamodb.GetItemOutput, error) {
+	f.calls = append(f.calls, in)
+	id := in.Key["id"].(*ddbtypes.AttributeValueMemberS).Value
+	return &dynamodb.GetItemOutput{Item: f.items[id]}, nil
+}
+
+func TestGetOneWayItem(t *testing.T) {
+	f := &fakeDynamoDB{items: map[string]map[string]ddbtypes.AttributeValue{"m-1": {
+		"id":                    &ddbtypes.AttributeValueMemberS{Value: "m-1"},
+		"workerReceiveUnixNano": &ddbtypes.AttributeValueMemberN{Value: "1700000000000000123"},
+		"sqsSentTimestampMs":    &ddbtypes.AttributeValueMemberN{Value: "1700000000000"},
+		"workerInstanceId":      &ddbtypes.AttributeValueMemberS{Value: "w-1"},
+	}}}
+	prev := dynamoDBClient
+	dynamoDBClient = f
+	t.Cleanup(func() { dynamoDBClient = prev })
+
+	item, err := getOneWayItem(context.Background(), "one-way", "m-1")
+	if err != nil || item == nil || item.WorkerReceiveUnixNano != 1700000000000000123 || item.SqsSentTimestampMs != 1700000000000 || item.WorkerInstanceID != "w-1" {
+		t.Fatalf("item=%+v err=%v", item, err)
+	}
+	if in := f.calls[0]; aws.ToString(in.TableName) != "one-way" || !aws.ToBool(in.ConsistentRead) {
+		t.Fatalf("table=%s consistentRead=%v", aws.ToString(in.TableName), aws.ToBool(in.ConsistentRead))
+	}
+	// 条目尚未写入时返回 nil, nil，由调用方继续轮询。
+	if item, err := getOneWayItem(context.Background(), "one-way", "m-2"); item != nil || err != nil {
+		t.Fatalf("missing item: item=%+v err=%v", item, err)
+	}
+}
+
+// fakeS3：记录 PutObject 的桶、key 与内容。
+type fakeS3 struct {
+	puts []*s3.PutObjectInput
+	data [][]byte
+}
+
+func (f *fakeS3) PutObject(ctx context.Context, in *s3.PutObjectInput, _ ...func(*s3.Options)) (*s3.PutObjectOutput, error) {
+	b, _ := io.ReadAll(in.Body)
+	f.puts, f.data = append(f.puts, in), append(f.data, b)
+	return &s3.PutObjectOutput{}, nil
+}
+
+func TestPersistResultS3(t *testing.T) {
+	f := &fakeS3{}
+	prev := s3Client
+	s3Client = f
+	t.Cleanup(func() { s3Client = prev })
+
+	// runId 中的空格与 % 原样写入 key（由 SDK 转义签名），带点的桶名也直接交给 SDK。
+	loc := &s3Location{bucket: "results.example.com", prefix: "bench"}
+	inline, up := persistResult(context.Background(), loc, "run 1%", []byte(`{"full":true}`), []byte(`{}`))
+	if up.err != nil || string(inline) != `{}` || len(f.puts) != 1 || string(f.data[0]) != `{"full":true}` {
+		t.Fatalf("inline=%s err=%v puts=%d", inline, up.err, len(f.puts))
+	}
+	key := aws.ToString(f.puts[0].Key)
+	if aws.ToString(f.puts[0].Bucket) != "results.example.com" || !strings.HasPrefix(key, "bench/run 1%/") || up.uri != "s3://results.example.com/"+key {
+		t.Fatalf("bucket=%s key=%s uri=%s", aws.ToString(f.puts[0].Bucket), key, up.uri)
+	}
+}
+
+func TestApplyRegionCheck(t *testing.T) {
+	cases := []struct {
+		worker, mode         string
+		wantFlag, wantStrict bool
+	}{
+		{"us-east-1", "", false, false},
+		{"", regionCheckStrict, false, false},
+		{"eu-west-1", "", true, false},
+		{"eu-west-1", regionCheckStrict, true, true},
+		{"eu-west-1", regionCheckOff, false, false},
+	}
+	for _, c := range cases {
+		out := dispatcherOutput{Region: "us-east-1", WorkerRegion: c.worker}
+		if strict := applyRegionCheck(&out, c.mode); strict != c.wantStrict || out.RegionMismatch != c.wantFlag {
+			t.Errorf("worker=%q mode=%q: strict=%v regionMismatch=%v, want %v %v", c.worker, c.mode, strict, out.RegionMismatch, c.wantStrict, c.wantFlag)
+		}
+	}
+}
+
+func TestApplyPureForwardLeg(t *testing.T) {
+	const sent = int64(1_700_000_000_000)
+	ms := int64(time.Millisecond)
+	cases := []struct {
+		workerNs, firstReceive int64
+		wantPure               float64
+		wantSkew               bool
+	}{
+		{sent*ms + 12_500_000, sent + 10, 12.5, false},
+		{sent*ms + 9_500_000, sent + 10, 9.5, false},
+		{sent*ms + 5_000_000, sent + 10, 5, true},
+		{sent*ms - 2_000_000, 0, -2, true},
+	}
+	for _, c := range cases {
+		out := dispatcherOutput{SqsSentTimestampMs: sent, SqsFirstReceiveTimestampMs: c.firstReceive, WorkerReceiveUnixNano: c.workerNs}
+		applyPureForwardLeg(&out)
+		if out.PureForwardLegMs == nil || *out.PureForwardLegMs != c.wantPure || out.ForwardLegSkew != c.wantSkew {
+			t.Errorf("worker=%d firstReceive=%d: pure=%v skew=%v, want %v %v", c.workerNs, c.firstReceive, out.PureForwardLegMs, out.ForwardLegSkew, c.wantPure, c.wantSkew)
+		}
+	}
+	var out dispatcherOutput
+	if applyPureForwardLeg(&out); out.PureForwardLegMs != nil {
+		t.Error("missing timestamps: want no pureForwardLegMs")
+	}
+}
+
+func TestFitPollToDeadline(t *testing.T) {
+	if w, v := fitPollToDeadline(context.Background(), 20, pollVisibilityTimeoutSeconds); w != 20 || v != pollVisibilityTimeoutSeconds {
+		t.Errorf("no deadline: wait=%d visibility=%d", w, v)
+	}
+	cases := []struct {
+		remaining      time.Duration
+		wait           int32
+		wantW, wantVis int32
+	}{
+		{25 * time.Second, 20, 20, 10},
+		{2500 * time.Millisecond, 20, 2, 3},
+		{2500 * time.Millisecond, 1, 1, 3},
+		{300 * time.Millisecond, 20, 0, 1},
+	}
+	for _, c := range cases {
+		ctx, cancel := context.WithTimeout(context.Background(), c.remaining)
+		w, v := fitPollToDeadline(ctx, c.wait, pollVisibilityTimeoutSeconds)
+		cancel()
+		if w != c.wantW || v != c.wantVis {
+			t.Errorf("remaining=%v wait=%d: got wait=%d visibility=%d, want %d %d", c.remaining, c.wait, w, v, c.wantW, c.wantVis)
+		}
+	}
+
+	// 收缩到 0 秒后的空短轮询之间有 shortPollBackoff 间隔，而不是忙等。
+	for _, c := range []struct {
+		name      string
+		remaining time.Duration
+		wait      int32
+	}{
+		{"pollWaitSeconds=0", 500 * time.Millisecond, 0},
+		{"deadline under 1s", 500 * time.Millisecond, 20},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			s := &instantEmptySQS{}
+			prev := sqsClient
+			sqsClient = s
+			t.Cleanup(func() { sqsClient = prev })
+			ctx, cancel := context.WithTimeout(context.Background(), c.remaining)
+			defer cancel()
+			_, err := pollForCallback(ctx, []string{"https://sqs.us-east-1.amazonaws.com/000000000000/receive"}, "run", "id", pollOptions{waitSeconds: c.wait})
+			if !errors.Is(err, context.DeadlineExceeded) {
+				t.Fatalf("err=%v, want deadline exceeded", err)
+			}
+			if maxCalls := int(c.remaining/shortPollBackoff) + 2; s.receives > maxCalls {
+				t.Fatalf("receive calls=%d in %v, want <= %d", s.receives, c.remaining, maxCalls)
+			}
+		})
+	}
+}
+
+// instantEmptySQS：ReceiveMessage 立即返回空结果（模拟 WaitTimeSeconds=0 的短轮询），并统计调用次数。
+type instantEmptySQS struct {
+	sqsAPI
+	receives int
+}
+
+func (s *instantEmptySQS) ReceiveMessage(ctx context.Context, in *sqs.ReceiveMessageInput, _ ...func(*sqs.Options)) (*sqs.ReceiveMessageOutput, error) {
+	s.receives++
+	return &sqs.ReceiveMessageOutput{}, nil
+}
+
+func TestProcessLocally(t *testing.T) {
+	f := useFakeSQS(t)
+	body, attrs := encodeMessage(msgBody{ID: "id-local", RunID: "run-local", SendStartUnixNano: 42}, true)
+	m := types.Message{Body: aws.String(body), ReceiptHandle: aws.String("rh-push"), MessageAttributes: attrs,
+		Attributes: map[string]string{string(types.MessageSystemAttributeNameSentTimestamp): "1700000000000"}}
+	processLocally(context.Background(), os.Getenv("PUSH_QUEUE_URL"), os.Getenv("RECEIVE_QUEUE_URL"), m)
+
+	if len(f.pending) != 1 {
+		t.Fatalf("pending callbacks=%d, want 1", len(f.pending))
+	}
+	var cb callbackMessage
+	_ = json.Unmarshal([]byte(f.pending[0]), &cb)
+	if cb.ID != "id-local" || cb.RunID != "run-local" || cb.SendStartUnixNano != 42 {
+		t.Fatalf("callback=%+v", cb)
+	}
+}
+
+func TestCompareAttributes(t *testing.T) {
+	useFakeSQS(t)
+
+	resp, _ := handler(context.Background(), events.APIGatewayProxyRequest{Body: `{"runId":"run-attrs","maxWaitMs":5000,"compareAttributes":2}`})
+	if resp.StatusCode != 200 {
+		t.Fatalf("status=%d body=%s", resp.StatusCode, resp.Body)
+	}
+	var api apiResponse
+	_ = json.Unmarshal([]byte(resp.Body), &api)
+	var out attributeComparisonOutput
+	if err := json.Unmarshal(api.Output, &out); err != nil {
+		t.Fatalf("unmarshal output: %v", err)
+	}
+	if out.Rounds != 2 || out.User.OK != 2 || out.System.OK != 2 || out.DeltaMeanMs == nil {
+		t.Fatalf("rounds=%d user.ok=%d system.ok=%d deltaMeanMs=%v", out.Rounds, out.User.OK, out.System.OK, out.DeltaMeanMs)
+	}
+}
+
+func TestCompareSSE(t *testing.T) {
+	f := useFakeSQS(t)
+	if resp, _ := handler(context.Background(), events.APIGatewayProxyRequest{Body: `{"compareSse":2}`}); resp.StatusCode != 400 {
+		t.Fatalf("without queue env: status=%d", resp.StatusCode)
+	}
+	base := strings.TrimSuffix(os.Getenv("PUSH_QUEUE_URL"), "/push")
+	t.Setenv("SSE_PUSH_QUEUE_URL", base+"/push-sse")
+	t.Setenv("SSE_RECEIVE_QUEUE_URL", base+"/receive-sse")
+	t.Setenv("PLAIN_PUSH_QUEUE_URL", base+"/push-plain")
+	t.Setenv("PLAIN_RECEIVE_QUEUE_URL", base+"/receive-plain")
+	// fakeSQS 对所有队列返回同样的属性：两组都显示为 SSE-SQS，非加密组应给出警告。
+	f.queueAttributes = map[string]string{"SqsManagedSseEnabled": "true"}
+
+	resp, _ := handler(context.Background(), events.APIGatewayProxyRequest{Body: `{"runId":"run-sse","maxWaitMs":5000,"compareSse":2,"ssePrimeRounds":1}`})
+	if resp.StatusCode != 200 {
+		t.Fatalf("status=%d body=%s", resp.StatusCode, resp.Body)
+	}
+	var api apiResponse
+	_ = json.Unmarshal([]byte(resp.Body), &api)
+	var out sseComparisonOutput
+	if err := json.Unmarshal(api.Output, &out); err != nil {
+		t.Fatalf("unmarshal output: %v", err)
+	}
+	if out.Rounds != 2 || out.Encrypted.OK != 2 || out.Plain.OK != 2 || len(out.Encrypted.PrimeLatenciesMs) != 1 || out.OverheadMeanMs == nil || out.Note == "" {
+		t.Fatalf("out=%+v", out)
+	}
+	if out.Encrypted.PushQueueName != "push-sse" || out.Plain.ReceiveQueueName != "receive-plain" || out.Encrypted.PushEncryption != encryptionSSESQS || len(out.Warnings) != 1 {
+		t.Fatalf("queues/encryption: encrypted=%+v plain=%+v warnings=%v", out.Encrypted, out.Plain, out.Warnings)
+	}
+	if resp, _ := handler(context.Background(), events.APIGatewayProxyRequest{Body: `{"compareSse":2,"iterations":3}`}); resp.StatusCode != 400 {
+		t.Fatalf("with iterations: status=%d", resp.StatusCode)
+	}
+}
+
+func TestULID(t *testing.T) {
+	// ULID 规范中的示例。
+	if ms, ok := decodeULIDTime("01ARYZ6S41TSV4RRFFQ69G5FAV"); !ok || ms != 1469918176385 {
+		t.Fatalf("decodeULIDTime(spec example) = %d, %v", ms, ok)
+	}
+	id := encodeULID(1469918176385, [10]byte{0xff, 0, 0xff, 0, 0xff, 0, 0xff, 0, 0xff, 0})
+	if len(id) != 26 || id[:10] != "01ARYZ6S41" {
+		t.Fatalf("encodeULID = %s", id)
+	}
+	if ms, ok := decodeULIDTime(id); !ok || ms != 1469918176385 {
+		t.Fatalf("round trip = %d, %v", ms, ok)
+	}
+	if _, ok := decodeULIDTime(randHex(16)); ok {
+		t.Fatal("hex id decoded as ulid")
+	}
+}
+
+// fakeSNS：PUSH_TRANSPORT=sns 的 mock，把发布的消息按 RawMessageDelivery 投递给 fakeSQS（由其生成回调）。
+type fakeSNS struct {
+	sqs       *fakeSQS
+	published []*sns.PublishInput
+}
+
+func (f *fakeSNS) Publish(ctx context.Context, in *sns.PublishInput, _ ...func(*sns.Options)) (*sns.PublishOutput, error) {
+	f.published = append(f.published, in)
+	var mb msgBody
+	decoded, _ := decodeMessageBody(aws.ToString(in.Message))
+	_ = json.Unmarshal(decoded, &mb)
+	cb, _ := json.Marshal(callbackMessage{ID: mb.ID, RunID: mb.RunID, SendStartUnixNano: mb.SendStartUnixNano})
+	f.sqs.mu.Lock()
+	f.sqs.pending = append(f.sqs.pending, string(cb))
+	f.sqs.mu.Unlock()
+	return &sns.PublishOutput{MessageId: aws.String("sns-1")}, nil
+}
+
+// fakeEventBridge：PUSH_TRANSPORT=eventbridge 的 mock，按规则目标 InputPath=$.detail 把 detail 投递给 fakeSQS。
+type fakeEventBridge struct {
+	sqs     *fakeSQS
+	entries []ebtypes.PutEventsRequestEntry
+}
+
+func (f *fakeEventBridge) PutEvents(ctx context.Context, in *eventbridge.PutEventsInput, _ ...func(*eventbridge.Options)) (*eventbridge.PutEventsOutput, error) {
+	f.entries = append(f.entries, in.Entries...)
+	for _, e := range in.Entries {
+		var mb msgBody
+		_ = json.Unmarshal([]byte(aws.ToString(e.Detail)), &mb)
+		cb, _ := json.Marshal(callbackMessage{ID: mb.ID, RunID: mb.RunID, SendStartUnixNano: mb.SendStartUnixNano})
+		f.sqs.mu.Lock()
+		f.sqs.pending = append(f.sqs.pending, string(cb))
+		f.sqs.mu.Unlock()
+	}
+	return &eventbridge.PutEventsOutput{Entries: []ebtypes.PutEventsResultEntry{{EventId: aws.String("event-1")}}}, nil
+}
+
+func TestPushTransport(t *testing.T) {
+	for _, transport := range []string{pushTransportSQS, pushTransportSNS, pushTransportEventBridge} {
+		t.Run(transport, func(t *testing.T) {
+			f := useFakeSQS(t)
+			mock, eb := &fakeSNS{sqs: f}, &fakeEventBridge{sqs: f}
+			prevSNS, prevEB := snsClient, eventBridgeClient
+			snsClient, eventBridgeClient = mock, eb
+			t.Cleanup(func() { snsClient, eventBridgeClient = prevSNS, prevEB })
+			t.Setenv("PUSH_TRANSPORT", transport)
+			t.Setenv("PUSH_TOPIC_ARN", "arn:aws:sns:us-east-1:000000000000:push")
+			t.Setenv("EVENT_BUS_NAME", "bench-bus")
+
+			resp, _ := handler(context.Background(), events.APIGatewayProxyRequest{Body: `{"runId":"run-sns","maxWaitMs":5000}`})
+			if resp.StatusCode != 200 {
+				t.Fatalf("status=%d body=%s", resp.StatusCode, resp.Body)
+			}
+			out := decodeOutput(t, resp)
+			switch transport {
+			case pushTransportSQS:
+				if len(mock.published) != 0 || len(eb.entries) != 0 {
+					t.Fatalf("sqs transport used SNS/EventBridge: %d/%d", len(mock.published), len(eb.entries))
+				}
+				return
+			case pushTransportEventBridge:
+				if len(eb.entries) != 1 || len(mock.published) != 0 {
+					t.Fatalf("entries=%d published=%d, want 1/0", len(eb.entries), len(mock.published))
+				}
+				e := eb.entries[0]
+				if aws.ToString(e.EventBusName) != "bench-bus" || aws.ToString(e.Source) != defaultEventSource || aws.ToString(e.DetailType) != defaultEventDetailType {
+					t.Fatalf("entry=%+v", e)
+				}
+				if resp, _ := handler(context.Background(), events.APIGatewayProxyRequest{Body: `{"compress":true}`}); resp.StatusCode != 400 {
+					t.Fatalf("eventbridge with compress: status=%d body=%s", resp.StatusCode, resp.Body)
+				}
+				return
+			}
+			if len(mock.published) != 1 {
+				t.Fatalf("published=%d, want 1", len(mock.published))
+			}
+			in := mock.published[0]
+			if aws.ToString(in.TopicArn) != "arn:aws:sns:us-east-1:000000000000:push" {
+				t.Fatalf("topicArn=%s", aws.ToString(in.TopicArn))
+			}
+			if got := aws.ToString(in.MessageAttributes[attrRunID].StringValue); got != "run-sns" {
+				t.Fatalf("runId attribute=%q", got)
+			}
+			if got := aws.ToString(in.MessageAttributes[attrID].StringValue); got != out.ID {
+				t.Fatalf("id attribute=%q, want %q", got, out.ID)
+			}
+			if resp, _ := handler(context.Background(), events.APIGatewayProxyRequest{Body: `{"batchDelaySeconds":[0,0]}`}); resp.StatusCode != 400 {
+				t.Fatalf("sns with batch: status=%d body=%s", resp.StatusCode, resp.Body)
+			}
+		})
+	}
+}
+
+func TestFIFOBlocking(t *testing.T) {
+	f := useFakeSQS(t)
+	f.fifoWorkMs = 100
+	if resp, _ := handler(context.Background(), events.APIGatewayProxyRequest{Body: `{"fifoBlocking":3,"workMs":100}`}); resp.StatusCode != 400 {
+		t.Fatalf("standard queue: status=%d body=%s", resp.StatusCode, resp.Body)
+	}
+	t.Setenv("PUSH_QUEUE_URL", os.Getenv("PUSH_QUEUE_URL")+".fifo")
+	if resp, _ := handler(context.Background(), events.APIGatewayProxyRequest{Body: `{"fifoBlocking":3}`}); resp.StatusCode != 400 {
+		t.Fatalf("without workMs: status=%d body=%s", resp.StatusCode, resp.Body)
+	}
+
+	resp, _ := handler(context.Background(), events.APIGatewayProxyRequest{Body: `{"runId":"run-hol","fifoBlocking":3,"workMs":100,"maxWaitMs":5000}`})
+	if resp.StatusCode != 200 {
+		t.Fatalf("status=%d body=%s", resp.StatusCode, resp.Body)
+	}
+	var api apiResponse
+	_ = json.Unmarshal([]byte(resp.Body), &api)
+	var out fifoBlockingOutput
+	if err := json.Unmarshal(api.Output, &out); err != nil {
+		t.Fatalf("unmarshal output: %v", err)
+	}
+	if out.SameGroup.OK != 3 || out.Spread.OK != 3 || len(out.Positions) != 3 {
+		t.Fatalf("phases: %+v %+v positions=%d", out.SameGroup, out.Spread, len(out.Positions))
+	}
+	for _, p := range out.Positions {
+		if p.BlockingDelayMs == nil || math.Abs(*p.BlockingDelayMs-float64(p.ExpectedBlockingMs)) > 20 {
+			t.Fatalf("position %d: blockingDelayMs=%v, want ~%d", p.Position, p.BlockingDelayMs, p.ExpectedBlockingMs)
+		}
+	}
+}
+
+func TestRequestAdjustments(t *testing.T) {
+	useFakeSQS(t)
+	resp, _ := handler(context.Background(), events.APIGatewayProxyRequest{Body: `{"maxWaitMs":5000}`})
+	if strings.Contains(resp.Body, `"adjustments"`) {
+		t.Fatalf("unexpected adjustments: %s", resp.Body)
+	}
+
+	resp, _ = handler(context.Background(), events.APIGatewayProxyRequest{Body: `{"delaySeconds":1000,"messageBodyBytes":-5,"maxWaitMs":40000}`})
+	var api apiResponse
+	if err := json.Unmarshal([]byte(resp.Body), &api); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	want := []requestAdjustment{
+		{Field: "delaySeconds", Requested: 1000, Applied: 900, Reason: "out of range 0..900"},
+		{Field: "messageBodyBytes", Requested: -5, Applied: 0, Reason: "negative value treated as 0"},
+		{Field: "maxWaitMs", Requested: 40000, Applied: 28000, Reason: "capped at 28000 (API Gateway limit)"},
+	}
+	if fmt.Sprint(api.Adjustments) != fmt.Sprint(want) {
+		t.Fatalf("adjustments=%+v, want %+v", api.Adjustments, want)
+	}
+}
+
+// memSQS：直接实现 sqsAPI 的内存 fake（不经过 HTTP），未覆盖的方法调用时 panic。
+// SendMessage 成功后，除非 dropCallbacks，下一次 ReceiveMessage 返回匹配的回调。
+type memSQS struct {
+	sqsAPI
+	mu      sync.Mutex
+	pending []string
+	sendErr error
+	// sendFailures：> 0 时只有前 sendFailures 次 SendMessage 返回 sendErr。
+	sendFailures int
+	// attrErrs：按队列名返回的 GetQueueAttributes 错误。
+	attrErrs      map[string]error
+	dropCallbacks bool
+	sends         int
+	// lastTraceHeader：最近一次 SendMessage 的 AWSTraceHeader 系统属性；回调像 Worker 一样回显它。
+	lastTraceHeader string
+	// lastSignature：最近一次 SendMessage 的 signature 属性。
+	lastSignature string
+	// foreign：先于回调返回的其他消息；deletes/releases 统计删除与释放（ChangeMessageVisibilityBatch 条目）次数。
+	foreign  []types.Message
+	deletes  int
+	releases int
+}
+
+func (m *memSQS) SendMessage(ctx context.Context, in *sqs.SendMessageInput, _ ...func(*sqs.Options)) (*sqs.SendMessageOutput, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sends++
+	if m.sendErr != nil && (m.sendFailures == 0 || m.sends <= m.sendFailures) {
+		return nil, m.sendErr
+	}
+	var mb msgBody
+	decoded, _ := decodeMessageBody(aws.ToString(in.MessageBody))
+	_ = json.Unmarshal(decoded, &mb)
+	m.lastTraceHeader = aws.ToString(in.MessageSystemAttributes[string(types.MessageSystemAttributeNameForSendsAWSTraceHeader)].StringValue)
+	m.lastSignature = aws.ToString(in.MessageAttributes[attrSignature].StringValue)
+	if !m.dropCallbacks {
+		cb, _ := json.Marshal(callbackMessage{ID: mb.ID, RunID: mb.RunID, Probe: mb.Probe, SendStartUnixNano: mb.SendStartUnixNano, TraceHeader: m.lastTraceHeader})
+		m.pending = append(m.pending, string(cb))
+	}
+	return &sqs.SendMessageOutput{MessageId: aws.String("push-1")}, nil
+}
+
+func (m *memSQS) ReceiveMessage(ctx context.Context, in *sqs.ReceiveMessageInput, _ ...func(*sqs.Options)) (*sqs.ReceiveMessageOutput, error) {
+	m.mu.Lock()
+	if len(m.foreign) > 0 {
+		msg := m.foreign[0]
+		m.foreign = m.foreign[1:]
+		m.mu.Unlock()
+		return &sqs.ReceiveMessageOutput{Messages: []types.Message{msg}}, nil
+	}
+	if len(m.pending) > 0 {
+		body := m.pending[0]
+		m.pending = m.pending[1:]
+		m.mu.Unlock()
+		// 像 Worker 一样：配置了 SHARED_SECRET 时为回调签名。
+		return &sqs.ReceiveMessageOutput{Messages: []types.Message{{MessageId: aws.String("cb-1"), ReceiptHandle: aws.String("rh-1"), Body: aws.String(body), MessageAttributes: signedAttributes(nil, body)}}}, nil
+	}
+	m.mu.Unlock()
+	// 空队列：模拟一次短暂的长轮询，避免轮询循环空转。
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-time.After(10 * time.Millisecond):
+	}
+	return &sqs.ReceiveMessageOutput{}, nil
+}
+
+func (m *memSQS) GetQueueAttributes(ctx context.Context, in *sqs.GetQueueAttributesInput, _ ...func(*sqs.Options)) (*sqs.GetQueueAttributesOutput, error) {
+	if err := m.attrErrs[queueNameFromURL(aws.ToString(in.QueueUrl))]; err != nil {
+		return nil, err
+	}
+	return &sqs.GetQueueAttributesOutput{Attributes: map[string]string{"QueueArn": "arn:aws:sqs:us-east-1:000000000000:" + queueNameFromURL(aws.ToString(in.QueueUrl))}}, nil
+}
+
+func (m *memSQS) DeleteMessage(ctx context.Context, in *sqs.DeleteMessageInput, _ ...func(*sqs.Options)) (*sqs.DeleteMessageOutput, error) {
+	m.mu.Lock()
+	m.deletes++
+	m.mu.Unlock()
+	return &sqs.DeleteMessageOutput{}, nil
+}
+
+func (m *memSQS) ChangeMessageVisibility(ctx context.Context, in *sqs.ChangeMessageVisibilityInput, _ ...func(*sqs.Options)) (*sqs.ChangeMessageVisibilityOutput, error) {
+	return &sqs.ChangeMessageVisibilityOutput{}, nil
+}
+
+func (m *memSQS) ChangeMessageVisibilityBatch(ctx context.Context, in *sqs.ChangeMessageVisibilityBatchInput, _ ...func(*sqs.Options)) (*sqs.ChangeMessageVisibilityBatchOutput, error) {
+	m.mu.Lock()
+	m.releases += len(in.Entries)
+	m.mu.Unlock()
+	return &sqs.ChangeMessageVisibilityBatchOutput{}, nil
+}
+
+func TestHandlerWithMemSQS(t *testing.T) {
+	cases := []struct {
+		name       string
+		env        map[string]string
+		fake       *memSQS
+		body       string
+		wantCode   int
+		wantStatus string
+		wantSends  int
+	}{
+		{name: "missing push queue", env: map[string]string{"PUSH_QUEUE_URL": ""}, body: `{}`, wantCode: 500, wantStatus: "ERROR"},
+		{name: "missing receive queue", env: map[string]string{"RECEIVE_QUEUE_URL": ""}, body: `{}`, wantCode: 500, wantStatus: "ERROR"},
+		{name: "invalid json", body: `{"maxWaitMs":`, wantCode: 400, wantStatus: "ERROR"},
+		{name: "send failure", fake: &memSQS{sendErr: errors.New("injected send failure")}, body: `{"maxWaitMs":2000,"retryBudgetMs":-1}`, wantCode: 502, wantStatus: "ERROR", wantSends: 1},
+		{name: "timeout", fake: &memSQS{dropCallbacks: true}, body: `{"maxWaitMs":300}`, wantCode: 504, wantStatus: "TIMEOUT", wantSends: 1},
+		{name: "happy path", body: `{"runId":"run-mem","maxWaitMs":2000}`, wantCode: 200, wantStatus: "OK", wantSends: 1},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			initOnce.Do(func() {})
+			fake := tc.fake
+			if fake == nil {
+				fake = &memSQS{}
+			}
+			prev := sqsClient
+			sqsClient = fake
+			t.Cleanup(func() { sqsClient = prev })
+			t.Setenv("PUSH_QUEUE_URL", "https://sqs.us-east-1.amazonaws.com/000000000000/push")
+			t.Setenv("PUSH_QUEUE_URLS", "")
+			t.Setenv("RECEIVE_QUEUE_URL", "https://sqs.us-east-1.amazonaws.com/000000000000/receive")
+			for k, v := range tc.env {
+				t.Setenv(k, v)
+			}
+
+			resp, err := handler(context.Background(), events.APIGatewayProxyRequest{Body: tc.body})
+			if err != nil {
+				t.Fatalf("handler error: %v", err)
+			}
+			var api apiResponse
+			_ = json.Unmarshal([]byte(resp.Body), &api)
+			if resp.StatusCode != tc.wantCode || api.Status != tc.wantStatus {
+				t.Fatalf("status=%d/%s, want %d/%s (body=%s)", resp.StatusCode, api.Status, tc.wantCode, tc.wantStatus, resp.Body)
+			}
+			if fake.sends != tc.wantSends {
+				t.Fatalf("sends=%d, want %d", fake.sends, tc.wantSends)
+			}
+			if tc.wantStatus == "OK" {
+				if out := decodeOutput(t, resp); out.RunID != "run-mem" || out.ID == "" {
+					t.Fatalf("output: runId=%q id=%q", out.RunID, out.ID)
+				}
+			}
+		})
+	}
+}
+
+func TestCallbackAttributeMatching(t *testing.T) {
+	initOnce.Do(func() {})
+	// 其他运行的回调：body 无法解析，只有属性可用；应按属性释放，而不是隔离或删除。
+	fake := &memSQS{foreign: []types.Message{{
+		MessageId:     aws.String("cb-other"),
+		ReceiptHandle: aws.String("rh-other"),
+		Body:          aws.String("not json"),
+		MessageAttributes: map[string]types.MessageAttributeValue{
+			attrRunID: {DataType: aws.String("String"), StringValue: aws.String("run-other")},
+			attrID:    {DataType: aws.String("String"), StringValue: aws.String("id-other")},
+		},
+	}}}
+	prev := sqsClient
+	sqsClient = fake
+	t.Cleanup(func() { sqsClient = prev })
+	t.Setenv("PUSH_QUEUE_URL", "https://sqs.us-east-1.amazonaws.com/000000000000/push")
+	t.Setenv("PUSH_QUEUE_URLS", "")
+	t.Setenv("RECEIVE_QUEUE_URL", "https://sqs.us-east-1.amazonaws.com/000000000000/receive")
+
+	resp, _ := handler(context.Background(), events.APIGatewayProxyRequest{Body: `{"runId":"run-mem","maxWaitMs":2000,"debug":true}`})
+	if resp.StatusCode != 200 {
+		t.Fatalf("status=%d body=%s", resp.StatusCode, resp.Body)
+	}
+	var out struct {
+		Debug struct {
+			Poll pollDebug `json:"poll"`
+		} `json:"debug"`
+	}
+	var api apiResponse
+	_ = json.Unmarshal([]byte(resp.Body), &api)
+	_ = json.Unmarshal(api.Output, &out)
+	if out.Debug.Poll.AttributeSkips != 1 || fake.releases != 1 {
+		t.Fatalf("attributeSkips=%d releases=%d deletes=%d", out.Debug.Poll.AttributeSkips, fake.releases, fake.deletes)
+	}
+}
+
+func TestSharedSecretSignature(t *testing.T) {
+	sig := signBody("s3cret", []byte(`{"id":"a"}`))
+	if !verifySig("s3cret", []byte(`{"id":"a"}`), sig) || verifySig("s3cret", []byte(`{"id":"b"}`), sig) || verifySig("other", []byte(`{"id":"a"}`), sig) || verifySig("s3cret", []byte(`{"id":"a"}`), "") {
+		t.Fatalf("verifySig mismatch for sig=%s", sig)
+	}
+
+	initOnce.Do(func() {})
+	// 未签名的外部回调先于本次回调返回：应被忽略（释放），签名正确的回调正常匹配。
+	fake := &memSQS{foreign: []types.Message{{MessageId: aws.String("cb-unsigned"), ReceiptHandle: aws.String("rh-unsigned"), Body: aws.String(`{"runId":"run-mem","id":"x"}`)}}}
+	prev := sqsClient
+	sqsClient = fake
+	t.Cleanup(func() { sqsClient = prev })
+	t.Setenv("PUSH_QUEUE_URL", "https://sqs.us-east-1.amazonaws.com/000000000000/push")
+	t.Setenv("PUSH_QUEUE_URLS", "")
+	t.Setenv("RECEIVE_QUEUE_URL", "https://sqs.us-east-1.amazonaws.com/000000000000/receive")
+	t.Setenv("SHARED_SECRET", "s3cret")
+
+	resp, _ := handler(context.Background(), events.APIGatewayProxyRequest{Body: `{"runId":"run-mem","maxWaitMs":2000,"debug":true}`})
+	if resp.StatusCode != 200 {
+		t.Fatalf("status=%d body=%s", resp.StatusCode, resp.Body)
+	}
+	var out struct {
+		Debug struct {
+			Poll pollDebug `json:"poll"`
+		} `json:"debug"`
+	}
+	var api apiResponse
+	_ = json.Unmarshal([]byte(resp.Body), &api)
+	_ = json.Unmarshal(api.Output, &out)
+	if out.Debug.Poll.SignatureRejects != 1 || fake.releases != 1 || fake.lastSignature == "" {
+		t.Fatalf("signatureRejects=%d releases=%d pushSignature=%q", out.Debug.Poll.SignatureRejects, fake.releases, fake.lastSignature)
+	}
+}
+
+func TestRunDeadline(t *testing.T) {
+	t.Setenv("MAX_RUN_DURATION_MS", "5000")
+	if got := runDurationLimit(apiRequest{MaxRunDurationMs: 9000}); got != 5*time.Second {
+		t.Fatalf("request above env cap: %v", got)
+	}
+	if got := runDurationLimit(apiRequest{MaxRunDurationMs: 300}); got != 300*time.Millisecond {
+		t.Fatalf("request below env cap: %v", got)
+	}
+
+	initOnce.Do(func() {})
+	fake := &memSQS{dropCallbacks: true}
+	prev := sqsClient
+	sqsClient = fake
+	t.Cleanup(func() { sqsClient = prev })
+	t.Setenv("PUSH_QUEUE_URL", "https://sqs.us-east-1.amazonaws.com/000000000000/push")
+	t.Setenv("PUSH_QUEUE_URLS", "")
+	t.Setenv("RECEIVE_QUEUE_URL", "https://sqs.us-east-1.amazonaws.com/000000000000/receive")
+
+	resp, _ := handler(context.Background(), events.APIGatewayProxyRequest{Body: `{"maxWaitMs":3000,"maxRunDurationMs":300}`})
+	var api apiResponse
+	_ = json.Unmarshal([]byte(resp.Body), &api)
+	if api.Status != "RUN_DEADLINE" || !api.RunDeadlineExceeded || api.RunDurationMs < 300 || api.RunDurationMs > 2000 {
+		t.Fatalf("status=%s exceeded=%v runDurationMs=%d body=%s", api.Status, api.RunDeadlineExceeded, api.RunDurationMs, resp.Body)
+	}
+
+	fake.dropCallbacks = false
+	resp, _ = handler(context.Background(), events.APIGatewayProxyRequest{Body: `{"maxWaitMs":2000}`})
+	api = apiResponse{}
+	_ = json.Unmarshal([]byte(resp.Body), &api)
+	if resp.StatusCode != 200 || api.Status != "OK" || api.RunDeadlineExceeded || api.RunDurationMs > 2000 {
+		t.Fatalf("within limit: status=%d/%s exceeded=%v", resp.StatusCode, api.Status, api.RunDeadlineExceeded)
+	}
+}
+
+func TestMonotonicTimeline(t *testing.T) {
+	// Worker 时钟慢 1.4ms：原始 workerReceive 早于 sendEnd。
+	o := &dispatcherOutput{SendStartUnixNano: 1000, SendEndUnixNano: 2000, WorkerReceiveUnixNano: 1500, WorkerDoneUnixNano: 1600, CallbackSendStartUnixNano: 1700, ReceiveMessageUnixNano: 4000}
+	tl := newMonotonicTimeline(o)
+	if tl == nil || tl.RawMonotonic || tl.EstimatedSkewMs != -0.0014 || tl.SkewMethod != skewMethodSymmetric || len(tl.Events) != 6 {
+		t.Fatalf("timeline=%+v", tl)
+	}
+	for i, ev := range tl.Events {
+		if i > 0 && ev.UnixNano < tl.Events[i-1].UnixNano {
+			t.Fatalf("not monotonic at %s: %+v", ev.Name, tl.Events)
+		}
+		if ev.Corrected != (ev.Clock == clockWorker) {
+			t.Fatalf("corrected flag for %s: %+v", ev.Name, ev)
+		}
+	}
+	if wr := tl.Events[2]; wr.RawUnixNano != 1500 || wr.UnixNano != 2900 {
+		t.Fatalf("workerReceive=%+v", wr)
+	}
+	if newMonotonicTimeline(&dispatcherOutput{SendEndUnixNano: 1}) != nil {
+		t.Fatal("expected nil timeline without worker timestamps")
+	}
+
+	// 已有按 SQS SentTimestamp 估计的偏差时沿用它，与顶层 estimatedSkewMs 一致。
+	sqsSkew := -0.001
+	o.EstimatedSkewMs = &sqsSkew
+	tl = newMonotonicTimeline(o)
+	if tl == nil || tl.EstimatedSkewMs != sqsSkew || tl.SkewMethod != skewMethodSQSSentTimestamp || tl.Events[2].UnixNano != 2500 {
+		t.Fatalf("timeline=%+v", tl)
+	}
+}
+
+func TestParallelDispatch(t *testing.T) {
+	initOnce.Do(func() {})
+	fake := &memSQS{foreign: []types.Message{{MessageId: aws.String("cb-other"), ReceiptHandle: aws.String("rh-other"), Body: aws.String(`{"runId":"run-other","id":"x"}`)}}}
+	prev := sqsClient
+	sqsClient = fake
+	t.Cleanup(func() { sqsClient = prev })
+	t.Cleanup(stopSharedReceivers)
+	t.Setenv("PUSH_QUEUE_URL", "https://sqs.us-east-1.amazonaws.com/000000000000/push")
+	t.Setenv("PUSH_QUEUE_URLS", "")
+	t.Setenv("RECEIVE_QUEUE_URL", "https://sqs.us-east-1.amazonaws.com/000000000000/receive")
+
+	if resp, _ := handler(context.Background(), events.APIGatewayProxyRequest{Body: `{"count":5,"iterations":3}`}); resp.StatusCode != 400 {
+		t.Fatalf("count with iterations: status=%d", resp.StatusCode)
+	}
+	resp, _ := handler(context.Background(), events.APIGatewayProxyRequest{Body: `{"runId":"run-par","count":12,"concurrency":4,"maxWaitMs":5000}`})
+	if resp.StatusCode != 200 {
+		t.Fatalf("status=%d body=%s", resp.StatusCode, resp.Body)
+	}
+	var out parallelOutput
+	var api apiResponse
+	_ = json.Unmarshal([]byte(resp.Body), &api)
+	_ = json.Unmarshal(api.Output, &out)
+	st := out.Stats
+	if api.Status != "OK" || st.Completed != 12 || st.Demux.Routed != 12 || st.Demux.Buffered != 1 || fake.sends != 12 || len(out.Samples) != 12 {
+		t.Fatalf("status=%s stats=%+v sends=%d samples=%d", api.Status, st, fake.sends, len(out.Samples))
+	}
+	seen := map[string]bool{}
+	for _, s := range out.Samples {
+		if s.Status != "OK" || seen[s.ID] {
+			t.Fatalf("sample %+v (duplicate=%v)", s, seen[s.ID])
+		}
+		seen[s.ID] = true
+	}
+}
+
+// stopSharedReceivers 停止所有共享接收循环并等待退出，避免残留的循环在后续测试中读走替换后的 sqsClient 上的消息。
+func stopSharedReceivers() {
+	receiversMu.Lock()
+	defer receiversMu.Unlock()
+	for k, r := range receivers {
+		r.lifecycle.Lock()
+		if r.stop != nil {
+			r.stop()
+			<-r.done
+			r.stop, r.done, r.users = nil, nil, 0
+		}
+		r.lifecycle.Unlock()
+		r.mu.Lock()
+		for _, b := range r.buffered {
+			b.timer.Stop()
+		}
+		r.mu.Unlock()
+		delete(receivers, k)
+	}
+}
+
+func TestSharedReceiver(t *testing.T) {
+	initOnce.Do(func() {})
+	fake := &memSQS{foreign: []types.Message{{MessageId: aws.String("cb-other"), ReceiptHandle: aws.String("rh-other"), Body: aws.String(`{"runId":"run-other","id":"x"}`)}}}
+	prev := sqsClient
+	sqsClient = fake
+	t.Cleanup(func() { sqsClient = prev })
+	t.Cleanup(stopSharedReceivers)
+	t.Setenv("SHARED_RECEIVER", "1")
+	t.Setenv("SHARED_RECEIVER_BUFFER_MS", "50")
+	t.Setenv("PUSH_QUEUE_URL", "https://sqs.us-east-1.amazonaws.com/000000000000/push")
+	t.Setenv("PUSH_QUEUE_URLS", "")
+	t.Setenv("RECEIVE_QUEUE_URL", "https://sqs.us-east-1.amazonaws.com/000000000000/receive")
+
+	resp, _ := handler(context.Background(), events.APIGatewayProxyRequest{Body: `{"runId":"run-shared","maxWaitMs":2000}`})
+	if resp.StatusCode != 200 {
+		t.Fatalf("status=%d body=%s", resp.StatusCode, resp.Body)
+	}
+	r := receiverFor([]string{"https://sqs.us-east-1.amazonaws.com/000000000000/receive"})
+	if st := r.snapshot(); st.Routed != 1 || st.Buffered != 1 {
+		t.Fatalf("stats=%+v", st)
+	}
+	// 无人认领的回调在缓冲期后释放。
+	deadline := time.Now().Add(time.Second)
+	for r.snapshot().Released == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if st := r.snapshot(); st.Released != 1 {
+		t.Fatalf("not released: stats=%+v", st)
+	}
+
+	// 等待者登记之前到达的回调留在缓冲中，登记时直接取回。
+	queueURL := "https://sqs.us-east-1.amazonaws.com/000000000000/receive"
+	t.Setenv("SHARED_RECEIVER_BUFFER_MS", "5000")
+	r.dispatch(context.Background(), &poller{}, queueURL, []types.Message{{ReceiptHandle: aws.String("rh-late"), Body: aws.String(`{"runId":"run-late","id":"late"}`)}})
+	w, done := r.wait(context.Background(), "run-late", "late", 0, 0)
+	defer done()
+	select {
+	case d := <-w.ch:
+		if d.sc.cb.ID != "late" || r.snapshot().BufferHits != 1 {
+			t.Fatalf("delivery=%+v stats=%+v", d, r.snapshot())
+		}
+	case <-time.After(time.Second):
+		t.Fatal("buffered callback not delivered on registration")
+	}
+}
+
+func TestSharedReceiverBuffer(t *testing.T) {
+	initOnce.Do(func() {})
+	fake := &memSQS{}
+	prev := sqsClient
+	sqsClient = fake
+	t.Cleanup(func() { sqsClient = prev })
+	t.Cleanup(stopSharedReceivers)
+	queueURL := "https://sqs.us-east-1.amazonaws.com/000000000000/receive-buffer"
+	r := receiverFor([]string{queueURL})
+
+	// 缓冲期过后无人认领：释放可见性，之后登记的等待者拿不到它。
+	t.Setenv("SHARED_RECEIVER_BUFFER_MS", "30")
+	r.dispatch(context.Background(), &poller{}, queueURL, []types.Message{{ReceiptHandle: aws.String("rh-expire"), Body: aws.String(`{"runId":"run-expire","id":"e"}`)}})
+	deadline := time.Now().Add(time.Second)
+	for r.snapshot().Released == 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	w, done := r.wait(context.Background(), "run-expire", "e", 0, 0)
+	select {
+	case d := <-w.ch:
+		t.Fatalf("expired callback delivered: %+v", d)
+	case <-time.After(50 * time.Millisecond):
+	}
+	done()
+	if st := r.snapshot(); st.Buffered != 1 || st.Released != 1 || st.BufferHits != 0 || fake.deletes != 0 {
+		t.Fatalf("stats=%+v deletes=%d", st, fake.deletes)
+	}
+
+	// 最后一个使用者离开时循环停止，缓冲中剩余的回调立即批量释放，而不是等到缓冲期结束。
+	t.Setenv("SHARED_RECEIVER_BUFFER_MS", "5000")
+	stop := r.acquire(context.Background())
+	r.dispatch(context.Background(), &poller{}, queueURL, []types.Message{
+		{ReceiptHandle: aws.String("rh-a"), Body: aws.String(`{"runId":"run-left","id":"a"}`)},
+		{ReceiptHandle: aws.String("rh-b"), Body: aws.String(`{"runId":"run-left","id":"b"}`)},
+	})
+	stop()
+	r.lifecycle.Lock()
+	running := r.stop != nil
+	r.lifecycle.Unlock()
+	if running || fake.releases != 2 || len(r.buffered) != 0 || r.snapshot().Released != 3 {
+		t.Fatalf("running=%v releases=%d buffered=%d stats=%+v", running, fake.releases, len(r.buffered), r.snapshot())
+	}
+}
+
+func TestSharedReceiverSendStartReject(t *testing.T) {
+	initOnce.Do(func() {})
+	fake := &memSQS{}
+	prev := sqsClient
+	sqsClient = fake
+	t.Cleanup(func() { sqsClient = prev })
+	t.Cleanup(stopSharedReceivers)
+	t.Setenv("SHARED_RECEIVER_BUFFER_MS", "5000")
+	queueURL := "https://sqs.us-east-1.amazonaws.com/000000000000/receive-reject"
+	r := receiverFor([]string{queueURL})
+	msg := func(rh string, sendStart int64) types.Message {
+		return types.Message{ReceiptHandle: aws.String(rh), Body: aws.String(fmt.Sprintf(`{"runId":"run-rej","id":"x","sendStartUnixNano":%d}`, sendStart))}
+	}
+
+	// 缓冲中的回调 sendStart 对不上：先核对再删除，不投递，等待者继续等待。
+	r.dispatch(context.Background(), &poller{}, queueURL, []types.Message{msg("rh-old", 100)})
+	w, done := r.wait(context.Background(), "run-rej", "x", 999, 0)
+	defer done()
+	select {
+	case d := <-w.ch:
+		t.Fatalf("mismatched buffered callback delivered: %+v", d)
+	default:
+	}
+	if st := r.snapshot(); st.SendStartRejects != 1 || st.BufferHits != 0 || fake.deletes != 1 || r.rejectsOf(w) != 1 {
+		t.Fatalf("buffered reject: stats=%+v deletes=%d", st, fake.deletes)
+	}
+
+	// 接收循环收到的回调同样先核对：对不上的删除并计数，匹配的投递。
+	r.dispatch(context.Background(), &poller{}, queueURL, []types.Message{msg("rh-dup", 100), msg("rh-new", 999)})
+	select {
+	case d := <-w.ch:
+		if d.sc.cb.SendStartUnixNano != 999 {
+			t.Fatalf("delivery=%+v", d)
+		}
+	default:
+		t.Fatal("matching callback not delivered")
+	}
+	if st := r.snapshot(); st.SendStartRejects != 2 || st.Routed != 1 || fake.deletes != 3 || r.rejectsOf(w) != 2 {
+		t.Fatalf("routed reject: stats=%+v deletes=%d", st, fake.deletes)
+	}
+}
+
+// receiveRecordingSQS：记录每次 ReceiveMessage 请求的 WaitTimeSeconds 与 MaxNumberOfMessages。
+type receiveRecordingSQS struct {
+	*memSQS
+	waits, maxMessages []int32
+}
+
+func (s *receiveRecordingSQS) ReceiveMessage(ctx context.Context, in *sqs.ReceiveMessageInput, optFns ...func(*sqs.Options)) (*sqs.ReceiveMessageOutput, error) {
+	s.mu.Lock()
+	s.waits = append(s.waits, in.WaitTimeSeconds)
+	s.maxMessages = append(s.maxMessages, in.MaxNumberOfMessages)
+	s.mu.Unlock()
+	return s.memSQS.ReceiveMessage(ctx, in, optFns...)
+}
+
+func TestSharedReceiverPollOptions(t *testing.T) {
+	// 共享接收循环不采用请求的 pollWaitSeconds/batchReceive，但照常返回 pollAttempts，且请求返回前停止并释放缓冲。
+	initOnce.Do(func() {})
+	fake := &receiveRecordingSQS{memSQS: &memSQS{foreign: []types.Message{{MessageId: aws.String("cb-other"), ReceiptHandle: aws.String("rh-other"), Body: aws.String(`{"runId":"run-other","id":"x"}`)}}}}
+	prev := sqsClient
+	sqsClient = fake
+	t.Cleanup(func() { sqsClient = prev })
+	t.Cleanup(stopSharedReceivers)
+	t.Setenv("SHARED_RECEIVER", "1")
+	t.Setenv("PUSH_QUEUE_URL", "https://sqs.us-east-1.amazonaws.com/000000000000/push")
+	t.Setenv("PUSH_QUEUE_URLS", "")
+	t.Setenv("RECEIVE_QUEUE_URL", "https://sqs.us-east-1.amazonaws.com/000000000000/receive-opts")
+
+	resp, _ := handler(context.Background(), events.APIGatewayProxyRequest{Body: `{"runId":"run-opts","maxWaitMs":2000,"pollWaitSeconds":0,"batchReceive":1}`})
+	var api apiResponse
+	_ = json.Unmarshal([]byte(resp.Body), &api)
+	if resp.StatusCode != 200 || api.PollAttempts < 2 {
+		t.Fatalf("status=%d pollAttempts=%d body=%s", resp.StatusCode, api.PollAttempts, resp.Body)
+	}
+	fake.mu.Lock()
+	for i := range fake.waits {
+		if fake.waits[i] != maxPollWaitSeconds || fake.maxMessages[i] != maxBatchEntries {
+			fake.mu.Unlock()
+			t.Fatalf("receive %d: wait=%d maxMessages=%d", i, fake.waits[i], fake.maxMessages[i])
+		}
+	}
+	fake.mu.Unlock()
+	r := receiverFor([]string{"https://sqs.us-east-1.amazonaws.com/000000000000/receive-opts"})
+	r.lifecycle.Lock()
+	running := r.stop != nil
+	r.lifecycle.Unlock()
+	if running || fake.releases != 1 {
+		t.Fatalf("running=%v releases=%d after handler returned", running, fake.releases)
+	}
+}
+
+func TestProbeWorker(t *testing.T) {
+	initOnce.Do(func() {})
+	fake := &memSQS{}
+	prev := sqsClient
+	sqsClient = fake
+	t.Cleanup(func() { sqsClient = prev })
+	t.Setenv("PUSH_QUEUE_URL", "https://sqs.us-east-1.amazonaws.com/000000000000/push")
+	t.Setenv("PUSH_QUEUE_URLS", "")
+	t.Setenv("RECEIVE_QUEUE_URL", "https://sqs.us-east-1.amazonaws.com/000000000000/receive")
+
+	resp, _ := handler(context.Background(), events.APIGatewayProxyRequest{Body: `{"runId":"run-probe","probeWorker":true,"workMs":5000,"maxWaitMs":2000}`})
+	var out probeOutput
+	var api apiResponse
+	_ = json.Unmarshal([]byte(resp.Body), &api)
+	_ = json.Unmarshal(api.Output, &out)
+	if resp.StatusCode != 200 || !out.Alive || !out.Probe || !out.ProbeAcknowledged || out.ProbeLatencyMs <= 0 {
+		t.Fatalf("status=%d out=%+v body=%s", resp.StatusCode, out, resp.Body)
+	}
+	// 探测结果不进入 fetch 存储。
+	if resp, _ := handler(context.Background(), events.APIGatewayProxyRequest{Body: `{"fetch":true,"runId":"run-probe"}`}); resp.StatusCode != 404 {
+		t.Fatalf("fetch probe: status=%d", resp.StatusCode)
+	}
+	if resp, _ := handler(context.Background(), events.APIGatewayProxyRequest{Body: `{"probeWorker":true,"iterations":3}`}); resp.StatusCode != 400 {
+		t.Fatalf("probe with iterations: status=%d", resp.StatusCode)
+	}
+}
+
+func TestTimeoutBudgetFields(t *testing.T) {
+	initOnce.Do(func() {})
+	fake := &memSQS{dropCallbacks: true}
+	prev := sqsClient
+	sqsClient = fake
+	t.Cleanup(func() { sqsClient = prev })
+	t.Setenv("PUSH_QUEUE_URL", "https://sqs.us-east-1.amazonaws.com/000000000000/push")
+	t.Setenv("PUSH_QUEUE_URLS", "")
+	t.Setenv("RECEIVE_QUEUE_URL", "https://sqs.us-east-1.amazonaws.com/000000000000/receive")
+
+	resp, _ := handler(context.Background(), events.APIGatewayProxyRequest{Body: `{"maxWaitMs":300}`})
+	var api apiResponse
+	_ = json.Unmarshal([]byte(resp.Body), &api)
+	if resp.StatusCode != 504 || api.BudgetMs != 300 || api.PollAttempts < 2 || !strings.Contains(resp.Body, `"elapsedSendMs"`) {
+		t.Fatalf("timeout: status=%d budgetMs=%d pollAttempts=%d body=%s", resp.StatusCode, api.BudgetMs, api.PollAttempts, resp.Body)
+	}
+
+	fake.dropCallbacks = false
+	resp, _ = handler(context.Background(), events.APIGatewayProxyRequest{Body: `{"maxWaitMs":2000}`})
+	api = apiResponse{}
+	_ = json.Unmarshal([]byte(resp.Body), &api)
+	if resp.StatusCode != 200 || api.BudgetMs != 2000 || api.PollAttempts != 1 {
+		t.Fatalf("ok: status=%d budgetMs=%d pollAttempts=%d", resp.StatusCode, api.BudgetMs, api.PollAttempts)
+	}
+}
+
+func TestWorkerInstanceBreakdown(t *testing.T) {
+	got := summarizeWorkerInstances(map[string][]float64{
+		"fast": {10, 11, 12},
+		"slow": {10, 50, 90},
+		"":     {500},
+	})
+	if len(got) != 2 || got[0].WorkerInstanceID != "slow" || got[0].P99Ms != 90 || got[1].Samples != 3 || got[1].P50Ms != 11 {
+		t.Fatalf("breakdown=%+v", got)
+	}
+
+	useFakeSQS(t)
+	if resp, _ := handler(context.Background(), events.APIGatewayProxyRequest{Body: `{"byWorkerInstance":true}`}); resp.StatusCode != 400 {
+		t.Fatalf("single mode: status=%d body=%s", resp.StatusCode, resp.Body)
+	}
+}
+
+func TestForceSample(t *testing.T) {
+	t.Setenv("_X_AMZN_TRACE_ID", "")
+	if h := forceSampleTraceHeader(events.APIGatewayProxyRequest{}); traceHeaderField(h, "Root") == "" || traceHeaderField(h, "Sampled") != "1" {
+		t.Fatalf("generated header=%q", h)
+	}
+	req := events.APIGatewayProxyRequest{Headers: map[string]string{"x-amzn-trace-id": "Root=1-5f84c7a1-000000000000000000000001"}}
+	if h := forceSampleTraceHeader(req); h != "Root=1-5f84c7a1-000000000000000000000001;Sampled=1" {
+		t.Fatalf("from API Gateway header=%q", h)
+	}
+
+	initOnce.Do(func() {})
+	fake := &memSQS{}
+	prev := sqsClient
+	sqsClient = fake
+	t.Cleanup(func() { sqsClient = prev })
+	t.Setenv("PUSH_QUEUE_URL", "https://sqs.us-east-1.amazonaws.com/000000000000/push")
+	t.Setenv("PUSH_QUEUE_URLS", "")
+	t.Setenv("RECEIVE_QUEUE_URL", "https://sqs.us-east-1.amazonaws.com/000000000000/receive")
+	t.Setenv("_X_AMZN_TRACE_ID", "Root=1-5f84c7a1-0123456789abcdef01234567;Parent=53995c3f42cd8ad8;Sampled=0;Lineage=a87bd80c:0")
+
+	resp, _ := handler(context.Background(), events.APIGatewayProxyRequest{Body: `{"forceSample":true,"maxWaitMs":2000}`})
+	if resp.StatusCode != 200 {
+		t.Fatalf("status=%d body=%s", resp.StatusCode, resp.Body)
+	}
+	if want := "Root=1-5f84c7a1-0123456789abcdef01234567;Parent=53995c3f42cd8ad8;Sampled=1;Lineage=a87bd80c:0"; fake.lastTraceHeader != want {
+		t.Fatalf("sent AWSTraceHeader=%q, want %q", fake.lastTraceHeader, want)
+	}
+	out := decodeOutput(t, resp)
+	if out.TraceID != "1-5f84c7a1-0123456789abcdef01234567" || out.TraceSampled == nil || !*out.TraceSampled || out.WorkerTraceSampled == nil || !*out.WorkerTraceSampled {
+		t.Fatalf("traceId=%q traceSampled=%v workerTraceSampled=%v", out.TraceID, out.TraceSampled, out.WorkerTraceSampled)
+	}
+
+	if resp, _ := handler(context.Background(), events.APIGatewayProxyRequest{Body: `{"forceSample":true,"iterations":3}`}); resp.StatusCode != 400 {
+		t.Fatalf("with iterations: status=%d body=%s", resp.StatusCode, resp.Body)
+	}
+}
+
+func TestEMFMetrics(t *testing.T) {
+	var buf bytes.Buffer
+	prev := emfOut
+	emfOut = &buf
+	t.Cleanup(func() { emfOut = prev })
+	t.Setenv("METRICS_NAMESPACE", "Bench")
+
+	out, _ := json.Marshal(dispatcherOutput{RunID: "run-emf", Region: "us-east-1", PushQueueName: "push",
+		SendStartUnixNano: 1_000_000_000, SendEndUnixNano: 1_002_000_000, WorkerReceiveUnixNano: 1_010_000_000,
+		WorkerDoneUnixNano: 1_015_000_000, ReceiveMessageUnixNano: 1_030_000_000})
+	body, _ := json.Marshal(apiResponse{Status: "OK", Output: out})
+	resp := events.APIGatewayProxyResponse{StatusCode: 200, Body: string(body)}
+
+	emitRequestMetrics(resp)
+	if buf.Len() != 0 {
+		t.Fatalf("emitted without ENABLE_EMF: %q", buf.String())
+	}
+	t.Setenv("ENABLE_EMF", "1")
+	emitRequestMetrics(resp)
+	line := buf.String()
+	if strings.Count(line, "\n") != 1 || !strings.HasSuffix(line, "\n") {
+		t.Fatalf("want exactly one line, got %q", line)
+	}
+	var doc struct {
+		AWS struct {
+			CloudWatchMetrics []emfDirective `json:"CloudWatchMetrics"`
+		} `json:"_aws"`
+		Region             string  `json:"Region"`
+		PushQueueName      string  `json:"PushQueueName"`
+		EndToEndMs         float64 `json:"EndToEndMs"`
+		QueueToWorkerMs    float64 `json:"QueueToWorkerMs"`
+		WorkerProcessingMs float64 `json:"WorkerProcessingMs"`
+	}
+	if err := json.Unmarshal([]byte(line), &doc); err != nil {
+		t.Fatalf("invalid EMF JSON: %v", err)
+	}
+	if len(doc.AWS.CloudWatchMetrics) != 1 || doc.AWS.CloudWatchMetrics[0].Namespace != "Bench" || len(doc.AWS.CloudWatchMetrics[0].Metrics) != 3 ||
+		fmt.Sprint(doc.AWS.CloudWatchMetrics[0].Dimensions) != "[[Region PushQueueName]]" {
+		t.Fatalf("directive=%+v", doc.AWS.CloudWatchMetrics)
+	}
+	if doc.Region != "us-east-1" || doc.PushQueueName != "push" || doc.EndToEndMs != 30 || doc.QueueToWorkerMs != 8 || doc.WorkerProcessingMs != 5 {
+		t.Fatalf("doc=%+v", doc)
+	}
+
+	// 失败的请求不输出。
+	buf.Reset()
+	failed, _ := json.Marshal(apiResponse{Status: "TIMEOUT", Output: out})
+	emitRequestMetrics(events.APIGatewayProxyResponse{StatusCode: 504, Body: string(failed)})
+	if buf.Len() != 0 {
+		t.Fatalf("emitted for TIMEOUT: %q", buf.String())
+	}
+}
+
+func TestTimeoutDiagnostics(t *testing.T) {
+	initOnce.Do(func() {})
+	fake := &memSQS{dropCallbacks: true}
+	prev := sqsClient
+	sqsClient = fake
+	t.Cleanup(func() { sqsClient = prev })
+	t.Setenv("PUSH_QUEUE_URL", "https://sqs.us-east-1.amazonaws.com/000000000000/push")
+	t.Setenv("PUSH_QUEUE_URLS", "")
+	t.Setenv("RECEIVE_QUEUE_URL", "https://sqs.us-east-1.amazonaws.com/000000000000/receive")
+
+	// maxWaitMs 不足 1 秒时长轮询收缩为 0（短轮询），空轮询不算过早返回。
+	resp, _ := handler(context.Background(), events.APIGatewayProxyRequest{Body: `{"runId":"run-td","maxWaitMs":300,"timeoutDiagnostics":true}`})
+	var api apiResponse
+	_ = json.Unmarshal([]byte(resp.Body), &api)
+	td := api.TimeoutDiagnostics
+	if resp.StatusCode != 504 || td == nil {
+		t.Fatalf("status=%d body=%s", resp.StatusCode, resp.Body)
+	}
+	if td.Phase != "poll" || td.PollAttempts < 2 || td.EmptyPolls == 0 || len(td.EmptyPollWaitMs) != td.EmptyPolls ||
+		td.Diagnosis != diagnosisNoReply || td.PollWindowMs <= 0 || strings.Contains(resp.Body, `"debug"`) {
+		t.Fatalf("diagnostics=%+v", td)
+	}
+
+	// 同 runId、不同 id 的回调计为近似匹配。
+	fake.foreign = []types.Message{{MessageId: aws.String("cb-near"), ReceiptHandle: aws.String("rh-near"), Body: aws.String(`{"runId":"run-td","id":"someone-else"}`)}}
+	resp, _ = handler(context.Background(), events.APIGatewayProxyRequest{Body: `{"runId":"run-td","maxWaitMs":300,"timeoutDiagnostics":true}`})
+	api = apiResponse{}
+	_ = json.Unmarshal([]byte(resp.Body), &api)
+	if td := api.TimeoutDiagnostics; td == nil || td.NearMisses != 1 || td.Diagnosis != diagnosisMismatchedReply {
+		t.Fatalf("near miss: diagnostics=%+v", td)
+	}
+
+	// 长轮询过早返回视为轮询效率低。
+	d := &pollDebug{}
+	d.record(20, 5*time.Millisecond, 0, nil)
+	if td := newTimeoutDiagnostics(context.Background(), context.Background(), sendTimes{pollStart: time.Now().UnixNano()}, pollResult{pollAttempts: 1}, d); td.PrematureReturns != 1 || td.Diagnosis != diagnosisInefficientPolling {
+		t.Fatalf("premature: diagnostics=%+v", td)
+	}
+
+	// 不请求时不输出；批量类模式不支持。
+	resp, _ = handler(context.Background(), events.APIGatewayProxyRequest{Body: `{"maxWaitMs":200}`})
+	if strings.Contains(resp.Body, "timeoutDiagnostics") {
+		t.Fatalf("unexpected diagnostics: %s", resp.Body)
+	}
+	if resp, _ := handler(context.Background(), events.APIGatewayProxyRequest{Body: `{"timeoutDiagnostics":true,"iterations":3}`}); resp.StatusCode != 400 {
+		t.Fatalf("with iterations: status=%d", resp.StatusCode)
+	}
+}
+
+func TestSendRetries(t *testing.T) {
+	initOnce.Do(func() {})
+	prev := sqsClient
+	t.Cleanup(func() { sqsClient = prev })
+	t.Setenv("PUSH_QUEUE_URL", "https://sqs.us-east-1.amazonaws.com/000000000000/push")
+	t.Setenv("PUSH_QUEUE_URLS", "")
+	t.Setenv("RECEIVE_QUEUE_URL", "https://sqs.us-east-1.amazonaws.com/000000000000/receive")
+	internal := &smithy.GenericAPIError{Code: "InternalError", Fault: smithy.FaultServer}
+
+	// 前两次服务端错误，第三次成功。
+	fake := &memSQS{sendErr: internal, sendFailures: 2}
+	sqsClient = fake
+	resp, _ := handler(context.Background(), events.APIGatewayProxyRequest{Body: `{"maxWaitMs":2000}`})
+	if out := decodeOutput(t, resp); resp.StatusCode != 200 || out.SendAttempts != 3 || fake.sends != 3 {
+		t.Fatalf("status=%d sendAttempts=%d sends=%d", resp.StatusCode, out.SendAttempts, fake.sends)
+	}
+
+	// SEND_MAX_RETRIES 限制重试次数。
+	t.Setenv("SEND_MAX_RETRIES", "1")
+	fake = &memSQS{sendErr: internal}
+	sqsClient = fake
+	resp, _ = handler(context.Background(), events.APIGatewayProxyRequest{Body: `{"maxWaitMs":2000}`})
+	if resp.StatusCode != 502 || fake.sends != 2 || !strings.Contains(resp.Body, "after 2 attempts") {
+		t.Fatalf("max retries: status=%d sends=%d body=%s", resp.StatusCode, fake.sends, resp.Body)
+	}
+
+	// 队列不存在等客户端错误不重试。
+	t.Setenv("SEND_MAX_RETRIES", "")
+	fake = &memSQS{sendErr: &smithy.GenericAPIError{Code: "AWS.SimpleQueueService.NonExistentQueue", Fault: smithy.FaultClient}}
+	sqsClient = fake
+	resp, _ = handler(context.Background(), events.APIGatewayProxyRequest{Body: `{"maxWaitMs":2000}`})
+	if resp.StatusCode != 502 || fake.sends != 1 {
+		t.Fatalf("non-retryable: status=%d sends=%d", resp.StatusCode, fake.sends)
+	}
+}
+
+func TestStaleCallbackDeleted(t *testing.T) {
+	initOnce.Do(func() {})
+	prev := sqsClient
+	t.Cleanup(func() { sqsClient = prev })
+	t.Setenv("PUSH_QUEUE_URL", "https://sqs.us-east-1.amazonaws.com/000000000000/push")
+	t.Setenv("PUSH_QUEUE_URLS", "")
+	t.Setenv("RECEIVE_QUEUE_URL", "https://sqs.us-east-1.amazonaws.com/000000000000/receive")
+	t.Setenv("STALE_CALLBACK_MS", "60000")
+	sent := func(age time.Duration) map[string]string {
+		return map[string]string{string(types.MessageSystemAttributeNameSentTimestamp): strconv.FormatInt(time.Now().Add(-age).UnixMilli(), 10)}
+	}
+	fake := &memSQS{foreign: []types.Message{
+		{MessageId: aws.String("cb-old"), ReceiptHandle: aws.String("rh-old"), Body: aws.String(`{"runId":"run-old","id":"x"}`), Attributes: sent(10 * time.Minute)},
+		{MessageId: aws.String("cb-new"), ReceiptHandle: aws.String("rh-new"), Body: aws.String(`{"runId":"run-new","id":"y"}`), Attributes: sent(time.Second)},
+	}}
+	sqsClient = fake
+
+	resp, _ := handler(context.Background(), events.APIGatewayProxyRequest{Body: `{"runId":"run-stale","maxWaitMs":2000,"debug":true}`})
+	out := decodeOutput(t, resp)
+	// 过期的外部回调删除，未过期的照常释放；匹配的回调另计一次删除。
+	if resp.StatusCode != 200 || out.Debug == nil || out.Debug.Poll.StaleDeletes != 1 || fake.deletes != 2 || fake.releases != 1 {
+		t.Fatalf("status=%d debug=%+v deletes=%d releases=%d", resp.StatusCode, out.Debug, fake.deletes, fake.releases)
+	}
+}
+
+func TestBenchmarkModes(t *testing.T) {
+	if m := benchmarkModes(apiRequest{}); len(m) != 0 {
+		t.Fatalf("single-message mode: %v", m)
+	}
+	if m := benchmarkModes(apiRequest{Ephemeral: true, BatchDelaySeconds: []int{0}, Iterations: 1}); strings.Join(m, ",") != modeEphemeral+","+modeBatchDelaySeconds {
+		t.Fatalf("modes=%v", m)
+	}
+
+	// 各校验共用 benchmarkModes，不会再因各自的排除列表漏掉某个模式。
+	useFakeSQS(t)
+	for _, c := range []struct{ body, want string }{
+		{`{"compareSse":2,"monotonicTimeline":true}`, "monotonicTimeline is only supported in single-message mode"},
+		{`{"probeWorker":true,"forceSample":true}`, "forceSample is only supported in single-message mode"},
+		{`{"compareSse":2,"timeoutDiagnostics":true}`, "timeoutDiagnostics is only supported in single-message mode"},
+		{`{"count":2,"compareSse":2}`, "count/concurrency cannot be combined with other benchmark modes"},
+		{`{"probeWorker":true,"compareSse":2}`, "probeWorker cannot be combined with other benchmark modes"},
+		{`{"compareSse":2,"iterations":3}`, "compareSse cannot be combined with other benchmark modes"},
+	} {
+		resp, _ := handler(context.Background(), events.APIGatewayProxyRequest{Body: c.body})
+		if resp.StatusCode != 400 || !strings.Contains(resp.Body, c.want) {
+			t.Errorf("%s: status=%d body=%s, want 400 %q", c.body, resp.StatusCode, resp.Body, c.want)
+		}
+	}
+}
+
+func TestPushQueuePoolPerSend(t *testing.T) {
+	f := useFakeSQS(t)
+	t.Cleanup(stopSharedReceivers)
+	base := strings.TrimSuffix(os.Getenv("PUSH_QUEUE_URL"), "/push")
+	t.Setenv("PUSH_QUEUE_URLS", base+"/push-a,"+base+"/push-b,"+base+"/push-c")
+	t.Setenv("PUSH_QUEUE_SELECTION", "")
+
+	before := pushPool.distribution()
+	delta := func() map[string]int64 {
+		d := map[string]int64{}
+		for k, v := range pushPool.distribution() {
+			if v != before[k] {
+				d[k] = v - before[k]
+			}
+		}
+		return d
+	}
+
+	// dryRun 不发送，不应计入分布。
+	if resp, _ := handler(context.Background(), events.APIGatewayProxyRequest{Body: `{"dryRun":true}`}); resp.StatusCode != 200 {
+		t.Fatalf("dryRun status=%d body=%s", resp.StatusCode, resp.Body)
+	}
+	if d := delta(); len(d) != 0 {
+		t.Fatalf("dryRun changed distribution: %v", d)
+	}
+
+	// count：每条消息单独选队列；iterations：每轮一次 SendMessageBatch，每批选一次。
+	for _, c := range []struct {
+		body string
+		want int
+	}{
+		{`{"runId":"run-pool-par","count":6,"concurrency":2,"maxWaitMs":5000}`, 2},
+		{`{"runId":"run-pool-it","iterations":3,"maxWaitMs":5000}`, 1},
+	} {
+		before, f.sendCalls = pushPool.distribution(), nil
+		resp, _ := handler(context.Background(), events.APIGatewayProxyRequest{Body: c.body})
+		if resp.StatusCode != 200 {
+			t.Fatalf("%s: status=%d body=%s", c.body, resp.StatusCode, resp.Body)
+		}
+		d := delta()
+		for _, name := range []string{"push-a", "push-b", "push-c"} {
+			if d[name] != int64(c.want) || f.sendCalls[name] != c.want {
+				t.Fatalf("%s: distribution delta=%v sendCalls=%v, want %d per queue", c.body, d, f.sendCalls, c.want)
+			}
+		}
+	}
+}
+
+func TestDLQProbe(t *testing.T) {
+	f := useFakeSQS(t)
+	t.Setenv("DLQ_URL", strings.Replace(os.Getenv("PUSH_QUEUE_URL"), "/push", "/dlq", 1))
+	// DLQ 中先积压其他运行的死信：应一次取满一批，用一次 ChangeMessageVisibilityBatch 释放。
+	f.pending = []string{`{"runId":"run-other","id":"a"}`, `{"runId":"run-other","id":"b"}`, `{"runId":"run-other","id":"c"}`}
+
+	resp, _ := handler(context.Background(), events.APIGatewayProxyRequest{Body: `{"runId":"run-dlq","failMode":"always-error","maxWaitMs":2500}`})
+	if resp.StatusCode != 200 {
+		t.Fatalf("status=%d body=%s", resp.StatusCode, resp.Body)
+	}
+	var api apiResponse
+	_ = json.Unmarshal([]byte(resp.Body), &api)
+	var out dlqOutput
+	if err := json.Unmarshal(api.Output, &out); err != nil || out.RunID != "run-dlq" || out.DlqQueueName != "dlq" {
+		t.Fatalf("output=%s err=%v", api.Output, err)
+	}
+	if f.maxMessagesSeen != dlqPollMaxMessages || f.released != 3 {
+		t.Fatalf("maxMessages=%d released=%d, want %d and 3", f.maxMessagesSeen, f.released, dlqPollMaxMessages)
+	}
+	// 2.5s 的截止时间下可见性超时收缩到剩余时间，而不是固定的 pollVisibilityTimeoutSeconds。
+	for v := range f.visibilitiesSeen {
+		if v >= pollVisibilityTimeoutSeconds {
+			t.Fatalf("visibilities=%v, want fitted to the 2.5s deadline", f.visibilitiesSeen)
+		}
+	}
+}
+
+func TestDryRun(t *testing.T) {
+	f := useFakeSQS(t)
+	f.queueAttributes = map[string]string{"QueueArn": "arn:aws:sqs:us-east-1:000000000000:push", "ApproximateNumberOfMessages": "7"}
+	resp, _ := handler(context.Background(), events.APIGatewayProxyRequest{Body: `{"dryRun":true,"purgeReceiveQueue":true}`})
+	var api apiResponse
+	_ = json.Unmarshal([]byte(resp.Body), &api)
+	var out dryRunOutput
+	_ = json.Unmarshal(api.Output, &out)
+	if resp.StatusCode != 200 || len(out.Queues) != 2 || out.Queues[0].Role != "push" || out.Queues[1].Role != "receive" ||
+		out.Queues[0].QueueArn == "" || out.Queues[0].ApproximateNumberOfMessages != 7 || len(f.pending) != 0 {
+		t.Fatalf("status=%d out=%+v pending=%d", resp.StatusCode, out, len(f.pending))
+	}
+
+	// 权限不足与队列不存在分别返回 403 / 404，且不发送。
+	prev := sqsClient
+	t.Cleanup(func() { sqsClient = prev })
+	for _, tc := range []struct {
+		err      error
+		wantCode int
+		wantKind string
+	}{
+		{&smithy.GenericAPIError{Code: "AccessDenied", Fault: smithy.FaultClient}, 403, dryRunErrorAccessDenied},
+		{&types.QueueDoesNotExist{}, 404, dryRunErrorNotFound},
+	} {
+		m := &memSQS{attrErrs: map[string]error{"receive": tc.err}}
+		sqsClient = m
+		resp, _ := handler(context.Background(), events.APIGatewayProxyRequest{Body: `{"dryRun":true}`})
+		api, out = apiResponse{}, dryRunOutput{}
+		_ = json.Unmarshal([]byte(resp.Body), &api)
+		_ = json.Unmarshal(api.Output, &out)
+		if resp.StatusCode != tc.wantCode || len(out.Queues) != 2 || out.Queues[1].ErrorKind != tc.wantKind || out.Queues[0].ErrorKind != "" || m.sends != 0 {
+			t.Fatalf("%T: status=%d out=%+v sends=%d", tc.err, resp.StatusCode, out, m.sends)
+		}
+	}
+}
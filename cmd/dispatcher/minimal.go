@@ -0,0 +1,42 @@
+package main
+
+import (
+	"encoding/json"
+	"strconv"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+// ultraMinimal 模式下除 id 外的字段改由 MessageAttributes 携带（Worker 侧同名读取）。
+const (
+	attrRunID = "runId"
+	// attrID：sns 传输的 Push 消息与 Worker 回调上携带的消息 id 属性。
+	attrID                = "id"
+	attrSendUnixNano      = "sendUnixNano"
+	attrSendStartUnixNano = "sendStartUnixNano"
+	attrDispatcherVersion = "dispatcherVersion"
+)
+
+// encodeMessage 生成 Push 消息的 body 与 MessageAttributes。
+// ultraMinimal 时 body 只保留 id（忽略 padding），用于测量最小负载下的 SQS 往返延迟下限。
+// routeAttributes 在两种模式下都作为 String 类型的 MessageAttributes 发送。
+func encodeMessage(b msgBody, ultraMinimal bool) (string, map[string]types.MessageAttributeValue) {
+	attrs := routeMessageAttributes(b.RouteAttributes)
+	if !ultraMinimal {
+		return string(b.marshal()), attrs
+	}
+	if attrs == nil {
+		attrs = map[string]types.MessageAttributeValue{}
+	}
+	attrs[attrRunID] = types.MessageAttributeValue{DataType: aws.String("String"), StringValue: aws.String(b.RunID)}
+	attrs[attrSendUnixNano] = types.MessageAttributeValue{DataType: aws.String("Number"), StringValue: aws.String(strconv.FormatInt(b.SendUnixNano, 10))}
+	attrs[attrSendStartUnixNano] = types.MessageAttributeValue{DataType: aws.String("Number"), StringValue: aws.String(strconv.FormatInt(b.SendStartUnixNano, 10))}
+	if b.DispatcherVersion != "" {
+		attrs[attrDispatcherVersion] = types.MessageAttributeValue{DataType: aws.String("String"), StringValue: aws.String(b.DispatcherVersion)}
+	}
+	body, _ := json.Marshal(struct {
+		ID string `json:"id"`
+	}{ID: b.ID})
+	return string(body), attrs
+}
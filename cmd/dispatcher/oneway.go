@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	ddbtypes "github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+)
+
+const (
+	retrievalSQSCallback = "sqs-callback"
+	retrievalDynamoDB    = "dynamodb-getitem"
+
+	// oneWayPollInterval：两次 GetItem 之间的间隔，决定取回一侧引入的最大额外延迟。
+	oneWayPollInterval = 20 * time.Millisecond
+)
+
+// oneWayOutput：oneWay 模式的输出。Worker 收到消息后把 workerReceiveUnixNano 写入 ONE_WAY_TABLE，
+// 不发送回调；Dispatcher 用强一致 GetItem 轮询取回。
+type oneWayOutput struct {
+	RunID           string `json:"runId"`
+	ID              string `json:"id"`
+	Region          string `json:"region"`
+	PushQueueName   string `json:"pushQueueName"`
+	Table           string `json:"table"`
+	RetrievalMethod string `json:"retrievalMethod"`
+
+	SendStartUnixNano     int64  `json:"sendStartUnixNano"`
+	SendEndUnixNano       int64  `json:"sendEndUnixNano"`
+	WorkerReceiveUnixNano int64  `json:"workerReceiveUnixNano"`
+	FoundUnixNano         int64  `json:"foundUnixNano"`
+	SqsSentTimestampMs    int64  `json:"sqsSentTimestampMs"`
+	WorkerInstanceID      string `json:"workerInstanceId"`
+
+	// 单程延迟：sendEnd -> workerReceive（跨 Dispatcher/Worker 时钟）。
+	OneWayLatencyMs float64 `json:"oneWayLatencyMs"`
+	// 取回耗时：sendEnd -> GetItem 首次返回该条目（Dispatcher 本地时钟），可与回调模式的 pipelineLatencyMs 对比。
+	RetrievalMs  float64 `json:"retrievalMs"`
+	GetItemCalls int     `json:"getItemCalls"`
+}
+
+// oneWayItem：Worker 写入 ONE_WAY_TABLE 的条目（DynamoDB JSON 中的 S/N 值）。
+type oneWayItem struct {
+	WorkerReceiveUnixNano int64
+	SqsSentTimestampMs    int64
+	WorkerInstanceID      string
+}
+
+func validateOneWay(body apiRequest) error {
+	if !body.OneWay {
+		return nil
+	}
+	if strings.TrimSpace(os.Getenv("ONE_WAY_TABLE")) == "" {
+		return errors.New("oneWay requires env ONE_WAY_TABLE")
+	}
+	if len(body.BatchDelaySeconds) > 0 || body.RampMaxConcurrency > 0 || body.CompareAttributes > 0 || body.FailMode != "" {
+		return errors.New("oneWay cannot be combined with batchDelaySeconds, rampMaxConcurrency, compareAttributes or failMode")
+	}
+	return nil
+}
+
+// handleOneWay 发送一条 oneWay 消息，并轮询 ONE_WAY_TABLE 直到 Worker 写入对应条目。
+func handleOneWay(ctx context.Context, body apiRequest, q queueTargets) (events.APIGatewayProxyResponse, error) {
+	table := strings.TrimSpace(os.Getenv("ONE_WAY_TABLE"))
+	messageID := newMessageID(body.IDFormat)
+	dispatchStart := time.Now().UnixNano()
+	clock := startSendClock()
+	msgText, msgAttrs := encodeMessage(msgBody{
+		ID:                messageID,
+		SendUnixNano:      clock.baseUnixNano,
+		SendStartUnixNano: clock.baseUnixNano,
+		RunID:             body.RunID,
+		Padding:           makePadding(body.MessageBodyBytes),
+		DispatcherVersion: dispatcherVersion,
+		OneWay:            true,
+	}, false)
+	if body.Compress {
+		msgText = compressBody(msgText)
+	}
+	q = q.forSend()
+	groupID, dedupID := fifoParams(q.pushURL, messageGroupID(body), messageID)
+	err := pushMessage(ctx, &sqs.SendMessageInput{
+		QueueUrl:               &q.pushURL,
+		MessageBody:            awsString(msgText),
+		MessageAttributes:      msgAttrs,
+		DelaySeconds:           int32(body.DelaySeconds),
+		MessageGroupId:         groupID,
+		MessageDeduplicationId: dedupID,
+	}, body.RunID, messageID)
+	sendEnd := clock.now()
+	if err != nil {
+		return sendErrorResp(fmt.Errorf("send message: %w", err))
+	}
+
+	calls := 0
+	var item *oneWayItem
+	for err == nil && item == nil {
+		calls++
+		if item, err = getOneWayItem(ctx, table, messageID); err == nil && item == nil {
+			select {
+			case <-ctx.Done():
+				err = ctx.Err()
+			case <-time.After(oneWayPollInterval):
+			}
+		}
+	}
+	found := clock.now()
+	if err != nil {
+		elapsedNs := time.Now().UnixNano() - dispatchStart
+		elapsed := elapsedNs / int64(time.Millisecond)
+		code, status := pollErrorStatus(err)
+		return jsonResp(code, apiResponse{Status: status, TotalMs: elapsed, TotalUs: nanosToUs(elapsedNs), Error: err.Error()})
+	}
+
+	out := oneWayOutput{
+		RunID:                 body.RunID,
+		ID:                    messageID,
+		Region:                awsCfg.Region,
+		PushQueueName:         q.pushName,
+		Table:                 table,
+		RetrievalMethod:       retrievalDynamoDB,
+		SendStartUnixNano:     clock.baseUnixNano,
+		SendEndUnixNano:       sendEnd,
+		WorkerReceiveUnixNano: item.WorkerReceiveUnixNano,
+		FoundUnixNano:         found,
+		SqsSentTimestampMs:    item.SqsSentTimestampMs,
+		WorkerInstanceID:      item.WorkerInstanceID,
+		OneWayLatencyMs:       float64(item.WorkerReceiveUnixNano-sendEnd) / float64(time.Millisecond),
+		RetrievalMs:           float64(found-sendEnd) / float64(time.Millisecond),
+		GetItemCalls:          calls,
+	}
+	outBytes, _ := json.Marshal(out)
+	elapsedNs := time.Now().UnixNano() - dispatchStart
+	elapsedMs := elapsedNs / int64(time.Millisecond)
+	results.put(storedResult{RunID: body.RunID, ID: messageID, StoredAt: time.Now(), TotalMs: elapsedMs, Output: outBytes})
+
+	outBytes, err = formatOutput(outBytes, body.OutputFormat)
+	if err != nil {
+		return jsonResp(500, apiResponse{Status: "ERROR", Error: err.Error()})
+	}
+	return jsonResp(200, apiResponse{Status: "OK", TotalMs: elapsedMs, TotalUs: nanosToUs(elapsedNs), Output: outBytes, PerceivedLatencyMs: perceivedLatencyMs(body.requestTimeEpochMs)})
+}
+
+// dynamoDBGetAPI：oneWay 模式用到的 DynamoDB 调用，测试中替换为 mock。
+type dynamoDBGetAPI interface {
+	GetItem(ctx context.Context, params *dynamodb.GetItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.GetItemOutput, error)
+}
+
+// dynamoDBClient：initAWS 只在配置了 ONE_WAY_TABLE 时创建。
+var dynamoDBClient dynamoDBGetAPI
+
+// getOneWayItem 对 ONE_WAY_TABLE 做一次强一致 GetItem；条目尚不存在时返回 nil, nil。
+func getOneWayItem(ctx context.Context, table, id string) (*oneWayItem, error) {
+	out, err := dynamoDBClient.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName:      aws.String(table),
+		Key:            map[string]ddbtypes.AttributeValue{"id": &ddbtypes.AttributeValueMemberS{Value: id}},
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("get one-way item: %w", err)
+	}
+	if out.Item == nil {
+		return nil, nil
+	}
+	return &oneWayItem{
+		WorkerReceiveUnixNano: parseInt64OrZero(ddbNumber(out.Item["workerReceiveUnixNano"])),
+		SqsSentTimestampMs:    parseInt64OrZero(ddbNumber(out.Item["sqsSentTimestampMs"])),
+		WorkerInstanceID:      ddbString(out.Item["workerInstanceId"]),
+	}, nil
+}
+
+// ddbNumber/ddbString：取 N/S 类型属性的值，类型不符或缺失时返回空串。
+func ddbNumber(v ddbtypes.AttributeValue) string {
+	if n, ok := v.(*ddbtypes.AttributeValueMemberN); ok {
+		return n.Value
+	}
+	return ""
+}
+
+func ddbString(v ddbtypes.AttributeValue) string {
+	if s, ok := v.(*ddbtypes.AttributeValueMemberS); ok {
+		return s.Value
+	}
+	return ""
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+)
+
+const (
+	// maxParallelCount/maxParallelConcurrency：count 与 concurrency 的上限；实际能完成多少由 maxWaitMs 决定。
+	maxParallelCount       = 1000
+	maxParallelConcurrency = 100
+	// defaultParallelConcurrency：未指定 concurrency 时的在途上限（不超过 count）。
+	defaultParallelConcurrency = 10
+)
+
+// parallelOutput：并行在途模式的输出。concurrency 个发送者各自循环"发送一条 → 等到回调"，共发送 count 条；
+// 回调由进程共享的接收循环（sharedReceiver）按 runId/id 分发给对应的等待者。
+type parallelOutput struct {
+	RunID       string           `json:"runId"`
+	Count       int              `json:"count"`
+	Concurrency int              `json:"concurrency"`
+	Stats       parallelStats    `json:"stats"`
+	Samples     []parallelSample `json:"samples,omitempty"`
+	// DuplicateIDCount：生成 id 时发生碰撞并重新生成的次数。
+	DuplicateIDCount int `json:"duplicateIdCount,omitempty"`
+}
+
+// parallelSample：单条消息的结果；latencyMs = receiveMessage - sendStart（Dispatcher 本地时钟）。
+type parallelSample struct {
+	ID               string  `json:"id"`
+	Status           string  `json:"status"`
+	Error            string  `json:"error,omitempty"`
+	SendMs           float64 `json:"sendMs"`
+	LatencyMs        float64 `json:"latencyMs"`
+	WorkerInstanceID string  `json:"workerInstanceId,omitempty"`
+}
+
+// parallelStats：只统计成功样本；throughputPerSec = completed / wallMs。
+type parallelStats struct {
+	Completed        int          `json:"completed"`
+	Failed           int          `json:"failed"`
+	WallMs           float64      `json:"wallMs"`
+	ThroughputPerSec float64      `json:"throughputPerSec"`
+	SendMs           latencyStats `json:"sendMs"`
+	LatencyMs        latencyStats `json:"latencyMs"`
+	// Demux：本次运行期间共享接收循环的计数增量（同一容器内并发的请求也会计入）。
+	Demux demuxStats `json:"demux"`
+}
+
+func validateParallel(body apiRequest) error {
+	if body.Count == 0 && body.Concurrency == 0 {
+		return nil
+	}
+	if body.Count < 1 || body.Count > maxParallelCount {
+		return fmt.Errorf("count must be 1..%d, got %d", maxParallelCount, body.Count)
+	}
+	if body.Concurrency < 0 || body.Concurrency > maxParallelConcurrency {
+		return fmt.Errorf("concurrency must be 1..%d, got %d", maxParallelConcurrency, body.Concurrency)
+	}
+	if len(benchmarkModes(body)) > 1 {
+		return errors.New("count/concurrency cannot be combined with other benchmark modes")
+	}
+	return nil
+}
+
+// handleParallel：concurrency 个发送者共发送 count 条消息（每条独立 id），每个发送者在收到自己的回调后才发送下一条，
+// 因此任意时刻最多 concurrency 条在途；ctx 结束时所有发送者与接收循环一起退出，未完成的样本记为 TIMEOUT。
+func handleParallel(ctx context.Context, body apiRequest, q queueTargets) (events.APIGatewayProxyResponse, error) {
+	dispatchStart := time.Now().UnixNano()
+	concurrency := body.Concurrency
+	if concurrency == 0 {
+		concurrency = defaultParallelConcurrency
+	}
+	concurrency = min(concurrency, body.Count)
+	out := parallelOutput{RunID: body.RunID, Count: body.Count, Concurrency: concurrency}
+	samples := make([]parallelSample, body.Count)
+
+	receiver := receiverFor(q.receiveURLs)
+	stopReceiver := receiver.acquire(ctx)
+	defer stopReceiver()
+	before := receiver.snapshot()
+	budget := newRetryBudget(time.Duration(body.RetryBudgetMs) * time.Millisecond)
+
+	start := time.Now()
+	next := make(chan int, body.Count)
+	for i := range samples {
+		next <- i
+	}
+	close(next)
+	var wg sync.WaitGroup
+	for w := 0; w < concurrency; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				if ctx.Err() != nil {
+					samples[i].Status, samples[i].Error = "TIMEOUT", ctx.Err().Error()
+					continue
+				}
+				samples[i] = sendParallelOne(ctx, body, q, receiver, budget)
+			}
+		}()
+	}
+	wg.Wait()
+	wall := time.Since(start)
+	// 先停止接收循环并释放缓冲，使 demux 计数包含本次运行留下的释放。
+	stopReceiver()
+
+	st := &out.Stats
+	var send, latency []float64
+	for _, s := range samples {
+		if s.Status != "OK" {
+			st.Failed++
+			continue
+		}
+		st.Completed++
+		send = append(send, s.SendMs)
+		latency = append(latency, s.LatencyMs)
+	}
+	st.WallMs = float64(wall) / float64(time.Millisecond)
+	if st.WallMs > 0 {
+		st.ThroughputPerSec = float64(st.Completed) / (st.WallMs / 1000)
+	}
+	st.SendMs, st.LatencyMs = newLatencyStats(send), newLatencyStats(latency)
+	st.Demux = receiver.snapshot().sub(before)
+	out.Samples = samples
+	out.DuplicateIDCount = body.ids.duplicateCount()
+
+	code, status := 200, "OK"
+	switch {
+	case st.Completed == 0 && ctx.Err() != nil:
+		code, status = 504, "TIMEOUT"
+	case st.Completed == 0:
+		code, status = 502, "ERROR"
+	case st.Failed > 0:
+		status = "PARTIAL"
+	}
+	outBytes, _ := json.Marshal(out)
+	elapsedNs := time.Now().UnixNano() - dispatchStart
+	elapsedMs := elapsedNs / int64(time.Millisecond)
+	results.put(storedResult{RunID: body.RunID, StoredAt: time.Now(), TotalMs: elapsedMs, Output: outBytes})
+
+	out.Samples = nil
+	trimmed, _ := json.Marshal(out)
+	outBytes, upload := persistResult(ctx, body.resultS3, body.RunID, outBytes, trimmed)
+
+	outBytes, err := formatOutput(outBytes, body.OutputFormat)
+	if err != nil {
+		return jsonResp(500, apiResponse{Status: "ERROR", Error: err.Error()})
+	}
+	apiOut := apiResponse{Status: status, TotalMs: elapsedMs, TotalUs: nanosToUs(elapsedNs), Output: outBytes, PerceivedLatencyMs: perceivedLatencyMs(body.requestTimeEpochMs)}
+	upload.apply(&apiOut)
+	return jsonResp(code, apiOut)
+}
+
+// sendParallelOne 发送一条消息并等待接收循环分发的回调。
+func sendParallelOne(ctx context.Context, body apiRequest, q queueTargets, receiver *sharedReceiver, budget *retryBudget) parallelSample {
+	id, err := body.ids.next(body.IDFormat)
+	if err != nil {
+		return parallelSample{Status: "ERROR", Error: err.Error()}
+	}
+	s := parallelSample{ID: id}
+	clock := startSendClock()
+	msgText, msgAttrs := encodeMessage(msgBody{
+		ID:                id,
+		SendUnixNano:      clock.baseUnixNano,
+		SendStartUnixNano: clock.baseUnixNano,
+		RunID:             body.RunID,
+		Padding:           makePadding(body.MessageBodyBytes),
+		DispatcherVersion: dispatcherVersion,
+		RouteAttributes:   body.RouteAttributes,
+		EchoPadding:       body.EchoPadding,
+		WorkMs:            body.WorkMs,
+	}, body.UltraMinimal)
+	if body.Compress {
+		msgText = compressBody(msgText)
+	}
+	// 发送前登记（回调可能在 SendMessage 返回前就到达）；同时登记到进程内暂存区：本进程其他请求的轮询收到该回调时会转交，而不是释放。
+	unregister := stash.register(body.RunID, id)
+	defer unregister()
+	w, stopWaiting := receiver.wait(ctx, body.RunID, id, clock.baseUnixNano, body.SendStartToleranceNs)
+	defer stopWaiting()
+
+	q = q.forSend()
+	groupID, dedupID := fifoParams(q.pushURL, messageGroupID(body), id)
+	in := &sqs.SendMessageInput{
+		QueueUrl:               &q.pushURL,
+		MessageBody:            awsString(msgText),
+		MessageAttributes:      msgAttrs,
+		DelaySeconds:           int32(body.DelaySeconds),
+		MessageGroupId:         groupID,
+		MessageDeduplicationId: dedupID,
+	}
+	_, err = budget.retrySend(ctx, func(ctx context.Context) error {
+		return pushMessage(ctx, in, body.RunID, id, noSDKRetry)
+	})
+	s.SendMs = nanosToMs(clock.now() - clock.baseUnixNano)
+	if err != nil {
+		s.Status, s.Error = "ERROR", fmt.Sprintf("send message: %v", err)
+		return s
+	}
+	select {
+	case d := <-w.ch:
+		s.Status = "OK"
+		s.LatencyMs = nanosToMs(d.sc.receiveMessageUnixNano - clock.baseUnixNano)
+		s.WorkerInstanceID = d.sc.cb.WorkerInstanceID
+	case <-ctx.Done():
+		s.Status, s.Error = "TIMEOUT", ctx.Err().Error()
+	}
+	return s
+}
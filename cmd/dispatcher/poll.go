@@ -0,0 +1,429 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"github.com/aws/smithy-go"
+)
+
+// multiQueuePollWaitSeconds：轮询多个 Receive 队列时，单个队列的长轮询上限，
+// 避免在高优先级队列上阻塞太久而错过低优先级队列中的回调。
+const multiQueuePollWaitSeconds = 1
+
+// maxPollWaitSeconds：SQS 允许的最长 WaitTimeSeconds，也是单条模式的默认值。
+const maxPollWaitSeconds = 20
+
+// pollVisibilityTimeoutSeconds：收到的消息在处理期间的可见性超时（不超过剩余截止时间）。
+const pollVisibilityTimeoutSeconds = 10
+
+// shortPollBackoff：WaitTimeSeconds=0（pollWaitSeconds=0 或剩余不足 1 秒）的空轮询会立即返回，
+// 下一次 ReceiveMessage 前至少等待该时长（不超过剩余截止时间），避免对 SQS 忙等产生费用与限流。
+const shortPollBackoff = 50 * time.Millisecond
+
+// pollResult：pollForCallback 的结果。
+type pollResult struct {
+	cb                     callbackMessage
+	receiveMessageUnixNano int64
+	pollEnd                int64
+	// 回调消息自身的 SQS SentTimestamp（系统属性）。
+	callbackSqsSentTimestampMs int64
+	// 回调实际来自的 Receive 队列（多队列时用于区分）。
+	receiveQueueName string
+	stashHit         bool
+	sendStartRejects int
+	deleteFailed     bool
+	// 转移到隔离队列的无法解析的消息数。
+	quarantined int
+	// 收到的不属于本请求的回调数（暂存转交或释放可见性）。
+	foreignGrabs int
+	// Receive 队列不存在，本次请求改为轮询 RECEIVE_QUEUE_URL_FALLBACK。
+	usedFallbackQueue bool
+	// pollAttempts：本次调用发出的 ReceiveMessage 次数（超时返回时同样填写）。
+	pollAttempts int
+}
+
+// pollOptions：pollForCallback 的可选参数。
+type pollOptions struct {
+	// waitSeconds：单次 ReceiveMessage 的长轮询时长。
+	waitSeconds int32
+	// debug 非 nil 时记录每次 ReceiveMessage 的耗时与返回条数。
+	debug *pollDebug
+	// sendStart：发送时写入消息体的 sendStartUnixNano；回调回显值需在 sendStartToleranceNs 内。
+	sendStart            int64
+	sendStartToleranceNs int64
+	// retry：本次请求共享的重试预算（nil 表示不重试）。
+	retry *retryBudget
+	// maxMessages：单次 ReceiveMessage 的 MaxNumberOfMessages（1..10，0 视为 1）。
+	maxMessages int32
+	// visibilityTimeout：非 nil 时替代 pollVisibilityTimeoutSeconds（visibilitySweep 模式）。
+	visibilityTimeout *int32
+}
+
+// pollDebug：轮询过程的原始统计（不含暂存区命中），揭示匹配前经历了多少次空轮询。
+type pollDebug struct {
+	Polls                int       `json:"polls"`
+	PollLatenciesMs      []float64 `json:"pollLatenciesMs"`
+	MessagesPerPoll      []int     `json:"messagesPerPoll"`
+	RequestedWaitSeconds []int32   `json:"requestedWaitSeconds"`
+	// 过早返回：未出错、0 条消息，且耗时明显短于请求的 WaitTimeSeconds（不足 prematureReturnRatio）。
+	PrematureReturns  int       `json:"prematureReturns"`
+	PrematureReturnMs []float64 `json:"prematureReturnMs,omitempty"`
+	// 近似匹配：非本进程在途请求的回调，runId 与 id 恰有一个相同（id 复用、runId 冲突等）。
+	NearMisses int `json:"nearMisses"`
+	// AttributeSkips：凭回调的 runId/id 消息属性判定为外部消息、未解析 body 就释放的条数。
+	AttributeSkips int `json:"attributeSkips"`
+	// SignatureRejects：SHARED_SECRET 设置时因缺少或不匹配 signature 属性而被忽略的回调数。
+	SignatureRejects int `json:"signatureRejects"`
+	// StaleDeletes：超过 STALE_CALLBACK_MS 而被删除的外部回调数。
+	StaleDeletes int `json:"staleDeletes"`
+	// ShortPollBackoffMs：WaitTimeSeconds=0 的空轮询之后等待 shortPollBackoff 的累计时长。
+	ShortPollBackoffMs float64 `json:"shortPollBackoffMs,omitempty"`
+}
+
+// prematureReturnRatio：空轮询耗时低于 WaitTimeSeconds 的该比例即视为过早返回。
+const prematureReturnRatio = 0.9
+
+func (d *pollDebug) record(waitSeconds int32, elapsed time.Duration, messages int, err error) {
+	if d == nil {
+		return
+	}
+	ms := float64(elapsed) / float64(time.Millisecond)
+	d.Polls++
+	d.PollLatenciesMs = append(d.PollLatenciesMs, ms)
+	d.MessagesPerPoll = append(d.MessagesPerPoll, messages)
+	d.RequestedWaitSeconds = append(d.RequestedWaitSeconds, waitSeconds)
+	if err == nil && messages == 0 && waitSeconds > 0 && ms < float64(waitSeconds)*1000*prematureReturnRatio {
+		d.PrematureReturns++
+		d.PrematureReturnMs = append(d.PrematureReturnMs, ms)
+	}
+}
+
+// sendStartMatches：回显为 0（旧版 Worker 未回显）时不做校验。
+func sendStartMatches(echoed, sent, toleranceNs int64) bool {
+	if echoed == 0 || sent == 0 {
+		return true
+	}
+	d := echoed - sent
+	if d < 0 {
+		d = -d
+	}
+	return d <= toleranceNs
+}
+
+// poller：一次 pollForCallback 调用的匹配条件与累计状态。
+type poller struct {
+	runID       string
+	id          string
+	opts        pollOptions
+	rejects     int
+	quarantined int
+	foreign     int
+	// usedFallback：某个 Receive 队列不存在，已替换为 RECEIVE_QUEUE_URL_FALLBACK。
+	usedFallback bool
+	attempts     int
+}
+
+// isQueueDoesNotExist：队列已被删除或 URL 错误（JSON 协议为 QueueDoesNotExist，query 兼容模式为 AWS.SimpleQueueService.NonExistentQueue）。
+func isQueueDoesNotExist(err error) bool {
+	var notExist *types.QueueDoesNotExist
+	if errors.As(err, &notExist) {
+		return true
+	}
+	var apiErr smithy.APIError
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+	switch apiErr.ErrorCode() {
+	case "QueueDoesNotExist", "AWS.SimpleQueueService.NonExistentQueue":
+		return true
+	}
+	return false
+}
+
+// fallbackReceiveQueue：queueURL 不存在时，在本次请求剩余时间内改为轮询 RECEIVE_QUEUE_URL_FALLBACK（只切换一次）。
+// 未配置备用队列（或备用队列同样不存在）时返回明确的错误，不再重试。
+func (p *poller) fallbackReceiveQueue(receiveQueueURLs []string, i int, err error) ([]string, error) {
+	fallback := strings.TrimSpace(os.Getenv("RECEIVE_QUEUE_URL_FALLBACK"))
+	if p.usedFallback || fallback == "" || fallback == receiveQueueURLs[i] {
+		return nil, fmt.Errorf("receive queue does not exist: %s: %w", queueNameFromURL(receiveQueueURLs[i]), err)
+	}
+	log.Printf("WARNING: receive queue does not exist, switching to fallback: queue=%s fallback=%s id=%s", queueNameFromURL(receiveQueueURLs[i]), queueNameFromURL(fallback), p.id)
+	p.usedFallback = true
+	urls := append([]string(nil), receiveQueueURLs...)
+	urls[i] = fallback
+	return urls, nil
+}
+
+// pollForCallback 按优先级顺序轮询 receiveQueueURLs，直到收到与 runID/id 匹配的回调或 ctx 结束。
+// 每一轮都从最高优先级的队列开始。SHARED_RECEIVER 开启且未指定可见性超时时改由共享接收循环分发（见 pollShared）。
+func pollForCallback(ctx context.Context, receiveQueueURLs []string, runID string, id string, opts pollOptions) (pollResult, error) {
+	if sharedReceiverEnabled() && opts.visibilityTimeout == nil {
+		return pollShared(ctx, receiveQueueURLs, runID, id, opts)
+	}
+	p := &poller{runID: runID, id: id, opts: opts}
+	for {
+		if ctx.Err() != nil {
+			return pollResult{sendStartRejects: p.rejects, quarantined: p.quarantined, foreignGrabs: p.foreign, pollAttempts: p.attempts}, ctx.Err()
+		}
+		// 先查进程内暂存区：其他在途请求的轮询可能已经替本请求收到了回调。
+		if sc, ok := stash.take(runID, id); ok {
+			if sendStartMatches(sc.cb.SendStartUnixNano, opts.sendStart, opts.sendStartToleranceNs) {
+				return pollResult{
+					cb:                         sc.cb,
+					receiveMessageUnixNano:     sc.receiveMessageUnixNano,
+					pollEnd:                    time.Now().UnixNano(),
+					callbackSqsSentTimestampMs: sc.sqsSentTimestampMs,
+					receiveQueueName:           sc.receiveQueueName,
+					stashHit:                   true,
+					sendStartRejects:           p.rejects,
+					quarantined:                p.quarantined,
+					foreignGrabs:               p.foreign,
+					usedFallbackQueue:          p.usedFallback,
+					pollAttempts:               p.attempts,
+				}, nil
+			}
+			p.rejects++
+		}
+		for i := 0; i < len(receiveQueueURLs); i++ {
+			queueURL := receiveQueueURLs[i]
+			wait := opts.waitSeconds
+			if len(receiveQueueURLs) > 1 && wait > multiQueuePollWaitSeconds {
+				wait = multiQueuePollWaitSeconds
+			}
+			visibility := int32(pollVisibilityTimeoutSeconds)
+			if opts.visibilityTimeout != nil {
+				visibility = *opts.visibilityTimeout
+			}
+			wait, visibility = fitPollToDeadline(ctx, wait, visibility)
+			p.attempts++
+			pr, matched, err := p.receiveOnce(ctx, queueURL, wait, visibility)
+			if err != nil && isQueueDoesNotExist(err) {
+				if receiveQueueURLs, err = p.fallbackReceiveQueue(receiveQueueURLs, i, err); err == nil {
+					i--
+					continue
+				}
+			}
+			if err != nil || matched {
+				pr.quarantined, pr.foreignGrabs, pr.usedFallbackQueue, pr.pollAttempts = p.quarantined, p.foreign, p.usedFallback, p.attempts
+				return pr, err
+			}
+		}
+	}
+}
+
+// fitPollToDeadline：长轮询时长收缩到剩余截止时间（向下取整，绝不超出 maxWait），
+// 可见性超时 visibility 收缩到剩余截止时间（向上取整，至少 1 秒）。ctx 无截止时间时原样返回。
+func fitPollToDeadline(ctx context.Context, wait, visibility int32) (int32, int32) {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		return wait, visibility
+	}
+	remaining := time.Until(deadline)
+	if s := int32(remaining / time.Second); s < wait {
+		wait = max(s, 0)
+	}
+	if s := int32((remaining + time.Second - 1) / time.Second); s < visibility {
+		visibility = max(s, 1)
+	}
+	return wait, visibility
+}
+
+// quarantineOrDelete：设置 QUARANTINE_QUEUE_URL 时先原样 SendMessage 到隔离队列再删除；
+// 转移失败时不删除，消息在可见性超时后重新出现，避免丢失证据。
+func (p *poller) quarantineOrDelete(ctx context.Context, receiveQueueURL string, m types.Message) {
+	if m.ReceiptHandle == nil {
+		return
+	}
+	if quarantineURL := strings.TrimSpace(os.Getenv("QUARANTINE_QUEUE_URL")); quarantineURL != "" {
+		groupID, dedupID := fifoParams(quarantineURL, "quarantine", aws.ToString(m.MessageId))
+		if _, err := sqsClient.SendMessage(ctx, &sqs.SendMessageInput{QueueUrl: &quarantineURL, MessageBody: m.Body, MessageGroupId: groupID, MessageDeduplicationId: dedupID}); err != nil {
+			log.Printf("quarantine unparseable message failed: queue=%s messageId=%s err=%v", queueNameFromURL(receiveQueueURL), aws.ToString(m.MessageId), err)
+			return
+		}
+		p.quarantined++
+	}
+	_, _ = sqsClient.DeleteMessage(ctx, &sqs.DeleteMessageInput{QueueUrl: &receiveQueueURL, ReceiptHandle: m.ReceiptHandle})
+}
+
+// callbackAttributes：Worker 在回调上设置的 runId/id 消息属性；任一缺失时 ok=false。
+func callbackAttributes(m types.Message) (runID, id string, ok bool) {
+	runID = strings.TrimSpace(aws.ToString(m.MessageAttributes[attrRunID].StringValue))
+	id = strings.TrimSpace(aws.ToString(m.MessageAttributes[attrID].StringValue))
+	return runID, id, runID != "" && id != ""
+}
+
+// receiveOnce 对单个队列做一次 ReceiveMessage，并按匹配/暂存/外部消息分别处理。
+func (p *poller) receiveOnce(ctx context.Context, receiveQueueURL string, waitSeconds, visibilityTimeout int32) (pollResult, bool, error) {
+	receiveStart := time.Now()
+	var out *sqs.ReceiveMessageOutput
+	err := p.opts.retry.retry(ctx, func(ctx context.Context) error {
+		var err error
+		out, err = sqsClient.ReceiveMessage(ctx, &sqs.ReceiveMessageInput{
+			QueueUrl:            &receiveQueueURL,
+			MaxNumberOfMessages: max(p.opts.maxMessages, 1),
+			WaitTimeSeconds:     waitSeconds,
+			VisibilityTimeout:   visibilityTimeout,
+			MessageSystemAttributeNames: []types.MessageSystemAttributeName{
+				types.MessageSystemAttributeNameSentTimestamp,
+			},
+			MessageAttributeNames: []string{attrRunID, attrID, attrSignature},
+		}, noSDKRetry)
+		return err
+	})
+	pollEnd := time.Now().UnixNano()
+	if err != nil {
+		p.opts.debug.record(waitSeconds, time.Since(receiveStart), 0, err)
+		return pollResult{pollEnd: pollEnd, sendStartRejects: p.rejects}, false, fmt.Errorf("receive message: %w", err)
+	}
+	p.opts.debug.record(waitSeconds, time.Since(receiveStart), len(out.Messages), nil)
+	receiveMessageUnixNano := time.Now().UnixNano()
+	receiveQueueName := queueNameFromURL(receiveQueueURL)
+
+	// 一次返回多条时逐条处理：匹配的消息单独删除，其余外部消息最后用一次 ChangeMessageVisibilityBatch 释放。
+	var (
+		matched pollResult
+		found   bool
+		release []types.ChangeMessageVisibilityBatchRequestEntry
+	)
+	// releaseForeign：非本次请求、也不属于本进程在途请求的回调，不删除，立即释放可见性，避免影响并发请求。
+	releaseForeign := func(m types.Message, runID, id string) {
+		if p.opts.debug != nil && (runID == p.runID) != (id == p.id) {
+			p.opts.debug.NearMisses++
+			log.Printf("near-miss callback: want runId=%s id=%s got runId=%s id=%s queue=%s", p.runID, p.id, runID, id, receiveQueueName)
+		}
+		if m.ReceiptHandle != nil {
+			release = append(release, types.ChangeMessageVisibilityBatchRequestEntry{
+				Id:                aws.String(strconv.Itoa(len(release))),
+				ReceiptHandle:     m.ReceiptHandle,
+				VisibilityTimeout: 0,
+			})
+		}
+	}
+	// deleteStale：其他运行的过期回调删除而不是释放（见 STALE_CALLBACK_MS）。
+	deleteStale := func(m types.Message, runID, id string, pushSentMs int64) bool {
+		if runID == p.runID || !deleteStaleCallback(ctx, receiveQueueURL, m, runID, id, pushSentMs) {
+			return false
+		}
+		if p.opts.debug != nil {
+			p.opts.debug.StaleDeletes++
+		}
+		return true
+	}
+	for _, m := range out.Messages {
+		// 回调带 runId/id 消息属性时先比较属性，外部回调不必解析 body；不带属性（旧版 Worker）时按 body 匹配。
+		if runID, id, ok := callbackAttributes(m); ok && !(runID == p.runID && id == p.id) && !stash.inFlightFor(runID, id) {
+			p.foreign++
+			if p.opts.debug != nil {
+				p.opts.debug.AttributeSkips++
+			}
+			if !signatureValid(m) || !deleteStale(m, runID, id, 0) {
+				releaseForeign(m, runID, id)
+			}
+			continue
+		}
+		// SHARED_SECRET 设置时，未签名或签名不符的回调视为外部消息，释放而不解析。
+		if !signatureValid(m) {
+			p.foreign++
+			if p.opts.debug != nil {
+				p.opts.debug.SignatureRejects++
+			}
+			log.Printf("callback signature rejected: messageId=%s queue=%s", aws.ToString(m.MessageId), receiveQueueName)
+			runID, id, _ := callbackAttributes(m)
+			releaseForeign(m, runID, id)
+			continue
+		}
+		var cb callbackMessage
+		if m.Body != nil {
+			if err := json.Unmarshal([]byte(*m.Body), &cb); err != nil {
+				// 无法解析的消息：不阻塞；配置了隔离队列时转移过去保留现场，否则直接删除避免毒消息反复出现。
+				p.quarantineOrDelete(ctx, receiveQueueURL, m)
+				continue
+			}
+		}
+		sentMs := parseInt64OrZero(m.Attributes[string(types.MessageSystemAttributeNameSentTimestamp)])
+
+		if !found && strings.TrimSpace(cb.RunID) == p.runID && strings.TrimSpace(cb.ID) == p.id {
+			deleteFailed := false
+			if m.ReceiptHandle != nil {
+				if err := deleteWithRetry(ctx, p.opts.retry, receiveQueueURL, m.ReceiptHandle); err != nil {
+					deleteFailed = true
+					log.Printf("delete matched callback failed: id=%s receiptHandle=%s err=%v", p.id, *m.ReceiptHandle, err)
+				}
+			}
+			// id 属于本请求但 sendStart 对不上：视为重复/陈旧回调，已删除，继续等待。
+			if !sendStartMatches(cb.SendStartUnixNano, p.opts.sendStart, p.opts.sendStartToleranceNs) {
+				p.rejects++
+				continue
+			}
+			matched = pollResult{
+				cb:                         cb,
+				receiveMessageUnixNano:     receiveMessageUnixNano,
+				pollEnd:                    pollEnd,
+				callbackSqsSentTimestampMs: sentMs,
+				receiveQueueName:           receiveQueueName,
+				deleteFailed:               deleteFailed,
+			}
+			found = true
+			continue
+		}
+
+		p.foreign++
+		// 属于本进程另一个在途请求的回调：删除并暂存，交给对应请求直接取用。
+		if stash.offer(stashedCallback{cb: cb, receiveMessageUnixNano: receiveMessageUnixNano, sqsSentTimestampMs: sentMs, receiveQueueName: receiveQueueName}) {
+			if m.ReceiptHandle != nil {
+				_, _ = sqsClient.DeleteMessage(ctx, &sqs.DeleteMessageInput{QueueUrl: &receiveQueueURL, ReceiptHandle: m.ReceiptHandle})
+			}
+			continue
+		}
+
+		if deleteStale(m, strings.TrimSpace(cb.RunID), strings.TrimSpace(cb.ID), cb.SqsSentTimestampMs) {
+			continue
+		}
+		releaseForeign(m, strings.TrimSpace(cb.RunID), strings.TrimSpace(cb.ID))
+	}
+	if len(release) > 0 {
+		_, _ = sqsClient.ChangeMessageVisibilityBatch(ctx, &sqs.ChangeMessageVisibilityBatchInput{QueueUrl: &receiveQueueURL, Entries: release})
+	}
+	if found {
+		matched.sendStartRejects = p.rejects
+		return matched, true, nil
+	}
+	if len(release) > 0 {
+		time.Sleep(20 * time.Millisecond)
+	} else if waitSeconds == 0 && len(out.Messages) == 0 {
+		backoffStart := time.Now()
+		sleepShortPoll(ctx)
+		if p.opts.debug != nil {
+			p.opts.debug.ShortPollBackoffMs += float64(time.Since(backoffStart)) / float64(time.Millisecond)
+		}
+	}
+	return pollResult{}, false, nil
+}
+
+// sleepShortPoll 等待 shortPollBackoff，截止时间更近时只等到截止时间；
+// 截止时间已过时等待 ctx 结束（其计时器可能略晚于截止时间触发，期间不能继续空转）。
+func sleepShortPoll(ctx context.Context) {
+	d := shortPollBackoff
+	if deadline, ok := ctx.Deadline(); ok {
+		d = min(d, time.Until(deadline))
+	}
+	var wait <-chan time.Time
+	if d > 0 {
+		wait = time.After(d)
+	}
+	select {
+	case <-ctx.Done():
+	case <-wait:
+	}
+}
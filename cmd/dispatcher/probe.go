@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+)
+
+// probeOutput：probeWorker 模式的输出。探测消息带 probe=true，Worker 跳过 workMs 等处理立即回调，
+// 只用于确认 Worker 存活并测量最小往返延迟；结果不写入 fetch 存储与 S3，不混入正常测量。
+type probeOutput struct {
+	RunID string `json:"runId"`
+	ID    string `json:"id"`
+	Probe bool   `json:"probe"`
+	Alive bool   `json:"alive"`
+	// ProbeLatencyMs：receiveMessage - sendStart（Dispatcher 本地时钟）。
+	ProbeLatencyMs float64 `json:"probeLatencyMs"`
+	SendMs         float64 `json:"sendMs"`
+	// ProbeAcknowledged：回调带 probe=true；旧版 Worker 不识别探测消息时为 false（按普通消息处理，workMs 为 0）。
+	ProbeAcknowledged     bool   `json:"probeAcknowledged"`
+	WorkerInstanceID      string `json:"workerInstanceId,omitempty"`
+	WorkerInvocationClass string `json:"workerInvocationClass,omitempty"`
+	WorkerVersion         string `json:"workerVersion,omitempty"`
+	PushQueueName         string `json:"pushQueueName"`
+	ReceiveQueueName      string `json:"receiveQueueName"`
+}
+
+func validateProbeWorker(body apiRequest) error {
+	if !body.ProbeWorker {
+		return nil
+	}
+	if len(benchmarkModes(body)) > 1 {
+		return errors.New("probeWorker cannot be combined with other benchmark modes")
+	}
+	return nil
+}
+
+// handleProbeWorker 发送一条最小的探测消息（不带 padding、workMs 与路由属性）并等待回调。
+func handleProbeWorker(ctx context.Context, body apiRequest, q queueTargets) (events.APIGatewayProxyResponse, error) {
+	dispatchStart := time.Now().UnixNano()
+	messageID := newMessageID(body.IDFormat)
+	clock := startSendClock()
+	bodyBytes := msgBody{
+		ID:                messageID,
+		SendUnixNano:      clock.baseUnixNano,
+		SendStartUnixNano: clock.baseUnixNano,
+		RunID:             body.RunID,
+		DispatcherVersion: dispatcherVersion,
+		Probe:             true,
+	}.marshal()
+
+	unregister := stash.register(body.RunID, messageID)
+	defer unregister()
+
+	budget := newRetryBudget(time.Duration(body.RetryBudgetMs) * time.Millisecond)
+	q = q.forSend()
+	groupID, dedupID := fifoParams(q.pushURL, messageGroupID(body), messageID)
+	in := &sqs.SendMessageInput{
+		QueueUrl:               &q.pushURL,
+		MessageBody:            awsString(string(bodyBytes)),
+		MessageGroupId:         groupID,
+		MessageDeduplicationId: dedupID,
+	}
+	_, err := budget.retrySend(ctx, func(ctx context.Context) error {
+		return pushMessage(ctx, in, body.RunID, messageID, noSDKRetry)
+	})
+	sendEnd := clock.now()
+	if err != nil {
+		return sendErrorResp(fmt.Errorf("send probe: %w", err))
+	}
+
+	po := pollOptions{waitSeconds: maxPollWaitSeconds, sendStart: clock.baseUnixNano, sendStartToleranceNs: body.SendStartToleranceNs, retry: budget, maxMessages: int32(body.BatchReceive)}
+	pr, err := pollForCallback(ctx, q.receiveURLs, body.RunID, messageID, po)
+	out := probeOutput{RunID: body.RunID, ID: messageID, Probe: true, SendMs: nanosToMs(sendEnd - clock.baseUnixNano), PushQueueName: q.pushName, ReceiveQueueName: q.receiveName}
+	code, status, errMsg := 200, "OK", ""
+	if err != nil {
+		code, status = pollErrorStatus(err)
+		errMsg = err.Error()
+	} else {
+		out.Alive = true
+		out.ProbeLatencyMs = nanosToMs(pr.receiveMessageUnixNano - clock.baseUnixNano)
+		out.ProbeAcknowledged = pr.cb.Probe
+		out.WorkerInstanceID, out.WorkerInvocationClass, out.WorkerVersion = pr.cb.WorkerInstanceID, pr.cb.WorkerInvocationClass, pr.cb.WorkerVersion
+		if pr.receiveQueueName != "" {
+			out.ReceiveQueueName = pr.receiveQueueName
+		}
+	}
+	outBytes, _ := json.Marshal(out)
+	elapsedNs := time.Now().UnixNano() - dispatchStart
+	return jsonResp(code, apiResponse{Status: status, TotalMs: elapsedNs / int64(time.Millisecond), TotalUs: nanosToUs(elapsedNs), Output: outBytes, Error: errMsg})
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+// purgeReceiveQueues 在发送前清空所有 Receive 队列，避免上一轮遗留的回调干扰匹配。
+// SQS 每个队列 60 秒内只允许一次 PurgeQueue；处于冷却期时返回 409。
+//
+// 注意：这会删除共享该队列的其他使用者的消息，只应在专用测试队列上使用。
+func purgeReceiveQueues(ctx context.Context, receiveQueueURLs []string) (int, error) {
+	for _, queueURL := range receiveQueueURLs {
+		log.Printf("WARNING: purging receive queue %s (affects all users of this queue)", queueNameFromURL(queueURL))
+		if _, err := sqsClient.PurgeQueue(ctx, &sqs.PurgeQueueInput{QueueUrl: &queueURL}); err != nil {
+			var inProgress *types.PurgeQueueInProgress
+			if errors.As(err, &inProgress) {
+				return 409, fmt.Errorf("purge receive queue %s: purge already in progress (SQS allows one PurgeQueue per queue every 60s), retry later", queueNameFromURL(queueURL))
+			}
+			return 502, fmt.Errorf("purge receive queue %s: %w", queueNameFromURL(queueURL), err)
+		}
+	}
+	return 0, nil
+}
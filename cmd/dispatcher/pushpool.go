@@ -0,0 +1,69 @@
+package main
+
+import (
+	"math/rand/v2"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	pushSelectionRoundRobin = "round-robin"
+	pushSelectionRandom     = "random"
+)
+
+// pushQueuePool：PUSH_QUEUE_URLS 配置了多个 Push 队列时，每次发送从中选一个（分片队列测试）。
+// 计数按容器累计，用于观察消息在各队列之间的分布。
+type pushQueuePool struct {
+	next atomic.Uint64
+
+	mu     sync.Mutex
+	counts map[string]int64
+}
+
+var pushPool = &pushQueuePool{counts: map[string]int64{}}
+
+// pushQueueURLsFromEnv：优先读取 PUSH_QUEUE_URLS（逗号分隔），否则回退到 PUSH_QUEUE_URL。
+func pushQueueURLsFromEnv() []string {
+	var urls []string
+	for _, u := range strings.Split(os.Getenv("PUSH_QUEUE_URLS"), ",") {
+		if u = strings.TrimSpace(u); u != "" {
+			urls = append(urls, u)
+		}
+	}
+	if len(urls) > 0 {
+		return urls
+	}
+	if u := strings.TrimSpace(os.Getenv("PUSH_QUEUE_URL")); u != "" {
+		return []string{u}
+	}
+	return nil
+}
+
+// pick 按 PUSH_QUEUE_SELECTION（round-robin 默认 | random）选出本次发送的队列并计数。
+func (p *pushQueuePool) pick(urls []string) string {
+	i := 0
+	if len(urls) > 1 {
+		if strings.TrimSpace(os.Getenv("PUSH_QUEUE_SELECTION")) == pushSelectionRandom {
+			i = rand.IntN(len(urls))
+		} else {
+			i = int((p.next.Add(1) - 1) % uint64(len(urls)))
+		}
+	}
+	p.mu.Lock()
+	p.counts[queueNameFromURL(urls[i])]++
+	p.mu.Unlock()
+	return urls[i]
+}
+
+// distribution 返回本容器内各 Push 队列累计的发送次数（队列名 -> 次数）。
+func (p *pushQueuePool) distribution() map[string]int64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	out := make(map[string]int64, len(p.counts))
+	for k, v := range p.counts {
+		out[k] = v
+	}
+	return out
+}
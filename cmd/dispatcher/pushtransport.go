@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/eventbridge"
+	ebtypes "github.com/aws/aws-sdk-go-v2/service/eventbridge/types"
+	"github.com/aws/aws-sdk-go-v2/service/sns"
+	snstypes "github.com/aws/aws-sdk-go-v2/service/sns/types"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+)
+
+const (
+	pushTransportSQS = "sqs"
+	pushTransportSNS = "sns"
+	// pushTransportEventBridge：PutEvents 到 EVENT_BUS_NAME，由规则把 detail 投递到 Push 队列。
+	pushTransportEventBridge = "eventbridge"
+
+	defaultEventDetailType = "TestFastServerlessPush"
+	defaultEventSource     = "test-fast-serverless.dispatcher"
+)
+
+// snsPublishAPI：pushMessage 用到的 SNS 调用，测试中替换为 mock。
+type snsPublishAPI interface {
+	Publish(ctx context.Context, params *sns.PublishInput, optFns ...func(*sns.Options)) (*sns.PublishOutput, error)
+}
+
+// eventBridgePutAPI：pushMessage 用到的 EventBridge 调用，测试中替换为 mock。
+type eventBridgePutAPI interface {
+	PutEvents(ctx context.Context, params *eventbridge.PutEventsInput, optFns ...func(*eventbridge.Options)) (*eventbridge.PutEventsOutput, error)
+}
+
+// snsClient/eventBridgeClient：initAWS 只创建 PUSH_TRANSPORT 选中的那一个。
+var (
+	snsClient         snsPublishAPI
+	eventBridgeClient eventBridgePutAPI
+)
+
+// pushTransport：PUSH_TRANSPORT（sqs|sns|eventbridge），缺省 sqs。
+func pushTransport() string {
+	if v := strings.ToLower(strings.TrimSpace(os.Getenv("PUSH_TRANSPORT"))); v != "" {
+		return v
+	}
+	return pushTransportSQS
+}
+
+// eventDetailType/eventSource：EVENT_DETAIL_TYPE / EVENT_SOURCE，供规则的 event pattern 匹配。
+func eventDetailType() string {
+	if v := strings.TrimSpace(os.Getenv("EVENT_DETAIL_TYPE")); v != "" {
+		return v
+	}
+	return defaultEventDetailType
+}
+
+func eventSource() string {
+	if v := strings.TrimSpace(os.Getenv("EVENT_SOURCE")); v != "" {
+		return v
+	}
+	return defaultEventSource
+}
+
+func validatePushTransport(body apiRequest) error {
+	t := pushTransport()
+	switch t {
+	case pushTransportSQS:
+		return nil
+	case pushTransportSNS:
+		if strings.TrimSpace(os.Getenv("PUSH_TOPIC_ARN")) == "" {
+			return errors.New("PUSH_TRANSPORT=sns requires env PUSH_TOPIC_ARN")
+		}
+	case pushTransportEventBridge:
+		if strings.TrimSpace(os.Getenv("EVENT_BUS_NAME")) == "" {
+			return errors.New("PUSH_TRANSPORT=eventbridge requires env EVENT_BUS_NAME")
+		}
+		// 事件 detail 必须是 JSON 对象，且投递到 SQS 时不带 MessageAttributes（因此也无法携带 SHARED_SECRET 签名）。
+		if body.Compress || body.UltraMinimal || len(body.RouteAttributes) > 0 {
+			return errors.New("PUSH_TRANSPORT=eventbridge cannot be combined with compress, ultraMinimal or routeAttributes")
+		}
+		if sharedSecret() != "" {
+			return errors.New("PUSH_TRANSPORT=eventbridge cannot be used with SHARED_SECRET (no message attributes for the signature)")
+		}
+	default:
+		return fmt.Errorf("PUSH_TRANSPORT must be %s, %s or %s, got %q", pushTransportSQS, pushTransportSNS, pushTransportEventBridge, t)
+	}
+	// SNS/EventBridge 没有批量发送到 SQS 的等价接口，也不支持逐条延迟；批量类模式只走 SQS。
+	if len(body.BatchDelaySeconds) > 0 || body.RampMaxConcurrency > 0 || body.CompareAttributes > 0 || body.CompareSSE > 0 || body.Iterations > 1 ||
+		len(body.VisibilitySweep) > 0 || body.Ephemeral || body.FifoBlocking > 0 || body.DelaySeconds > 0 {
+		return fmt.Errorf("PUSH_TRANSPORT=%s cannot be combined with batchDelaySeconds, rampMaxConcurrency, compareAttributes, compareSse, iterations, visibilitySweep, ephemeral, fifoBlocking or delaySeconds", t)
+	}
+	return nil
+}
+
+// pushMessage 按 PUSH_TRANSPORT 发送一条 Push 消息：sqs 直接 SendMessage；sns 以同样的 body 与 MessageAttributes
+// Publish 到 PUSH_TOPIC_ARN，并补上 runId/id 属性。订阅需开启 RawMessageDelivery，Worker 收到的消息与 sqs 传输一致。
+// eventbridge 见 putPushEvent。
+func pushMessage(ctx context.Context, in *sqs.SendMessageInput, runID, id string, optFns ...func(*sqs.Options)) error {
+	in.MessageAttributes = signedAttributes(in.MessageAttributes, aws.ToString(in.MessageBody))
+	switch pushTransport() {
+	case pushTransportSNS:
+		return publishPushMessage(ctx, in, runID, id)
+	case pushTransportEventBridge:
+		return putPushEvent(ctx, in)
+	}
+	_, err := sqsClient.SendMessage(ctx, in, optFns...)
+	return err
+}
+
+func publishPushMessage(ctx context.Context, in *sqs.SendMessageInput, runID, id string) error {
+	attrs := make(map[string]snstypes.MessageAttributeValue, len(in.MessageAttributes)+2)
+	for k, v := range in.MessageAttributes {
+		attrs[k] = snstypes.MessageAttributeValue{DataType: v.DataType, StringValue: v.StringValue, BinaryValue: v.BinaryValue}
+	}
+	// SNS 不接受空字符串属性值。
+	for k, v := range map[string]string{attrRunID: runID, attrID: id} {
+		if v != "" {
+			attrs[k] = snstypes.MessageAttributeValue{DataType: aws.String("String"), StringValue: aws.String(v)}
+		}
+	}
+	_, err := snsClient.Publish(ctx, &sns.PublishInput{
+		TopicArn:               aws.String(strings.TrimSpace(os.Getenv("PUSH_TOPIC_ARN"))),
+		Message:                in.MessageBody,
+		MessageAttributes:      attrs,
+		MessageGroupId:         in.MessageGroupId,
+		MessageDeduplicationId: in.MessageDeduplicationId,
+	})
+	return err
+}
+
+// putPushEvent：以消息 body 作为 detail PutEvents 一条事件；规则目标需设置 InputPath=$.detail，
+// Push 队列收到的 body 即原始 msgBody。PutEvents 对单条失败返回 200，需检查 FailedEntryCount。
+func putPushEvent(ctx context.Context, in *sqs.SendMessageInput) error {
+	out, err := eventBridgeClient.PutEvents(ctx, &eventbridge.PutEventsInput{
+		Entries: []ebtypes.PutEventsRequestEntry{{
+			EventBusName: aws.String(strings.TrimSpace(os.Getenv("EVENT_BUS_NAME"))),
+			Source:       aws.String(eventSource()),
+			DetailType:   aws.String(eventDetailType()),
+			Detail:       in.MessageBody,
+		}},
+	})
+	if err != nil {
+		return err
+	}
+	if out.FailedEntryCount > 0 && len(out.Entries) > 0 {
+		return fmt.Errorf("put events: %s: %s", aws.ToString(out.Entries[0].ErrorCode), aws.ToString(out.Entries[0].ErrorMessage))
+	}
+	return nil
+}
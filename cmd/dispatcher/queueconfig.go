@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+// queueConfigTTL：队列属性缓存时长。属性很少变化，没必要每次请求都额外调用 GetQueueAttributes。
+const queueConfigTTL = 5 * time.Minute
+
+// queueConfig：测量时 Push 队列的有效重投配置，使结果自带"在什么重试配置下测得"的说明。
+type queueConfig struct {
+	QueueName           string `json:"queueName"`
+	VisibilityTimeout   int    `json:"visibilityTimeout"`
+	MaxReceiveCount     int    `json:"maxReceiveCount,omitempty"`
+	DeadLetterTargetArn string `json:"deadLetterTargetArn,omitempty"`
+	// FIFO 队列的高吞吐配置：两者分别为 messageGroup 与 perMessageGroupId 时即开启高吞吐模式。
+	DeduplicationScope  string `json:"deduplicationScope,omitempty"`
+	FifoThroughputLimit string `json:"fifoThroughputLimit,omitempty"`
+	Error               string `json:"error,omitempty"`
+}
+
+// highThroughputFIFO：队列配置为 FIFO 高吞吐模式。
+func (c queueConfig) highThroughputFIFO() bool {
+	return c.DeduplicationScope == "messageGroup" && c.FifoThroughputLimit == "perMessageGroupId"
+}
+
+type cachedQueueConfig struct {
+	cfg       queueConfig
+	fetchedAt time.Time
+}
+
+// queueAttrCache：按队列 URL 缓存 GetQueueAttributes 的结果（容器内）。
+var queueAttrCache = struct {
+	mu      sync.Mutex
+	entries map[string]cachedQueueConfig
+}{entries: map[string]cachedQueueConfig{}}
+
+// loadQueueConfig 读取队列的 RedrivePolicy 与 VisibilityTimeout；失败时只在结果中记录错误，不影响测量。
+func loadQueueConfig(ctx context.Context, queueURL string) queueConfig {
+	queueAttrCache.mu.Lock()
+	c, ok := queueAttrCache.entries[queueURL]
+	queueAttrCache.mu.Unlock()
+	if ok && time.Since(c.fetchedAt) < queueConfigTTL {
+		return c.cfg
+	}
+
+	cfg := queueConfig{QueueName: queueNameFromURL(queueURL)}
+	names := []types.QueueAttributeName{types.QueueAttributeNameRedrivePolicy, types.QueueAttributeNameVisibilityTimeout}
+	if isFIFOQueue(queueURL) {
+		// 标准队列不认识这两个属性，只对 FIFO 队列请求。
+		names = append(names, types.QueueAttributeNameDeduplicationScope, types.QueueAttributeNameFifoThroughputLimit)
+	}
+	out, err := sqsClient.GetQueueAttributes(ctx, &sqs.GetQueueAttributesInput{
+		QueueUrl:       &queueURL,
+		AttributeNames: names,
+	})
+	if err != nil {
+		cfg.Error = err.Error()
+		return cfg
+	}
+	cfg.VisibilityTimeout, _ = strconv.Atoi(out.Attributes[string(types.QueueAttributeNameVisibilityTimeout)])
+	cfg.DeduplicationScope = out.Attributes[string(types.QueueAttributeNameDeduplicationScope)]
+	cfg.FifoThroughputLimit = out.Attributes[string(types.QueueAttributeNameFifoThroughputLimit)]
+	if rp := out.Attributes[string(types.QueueAttributeNameRedrivePolicy)]; rp != "" {
+		// maxReceiveCount 在不同 API 版本中可能是数字或字符串。
+		var policy struct {
+			DeadLetterTargetArn string      `json:"deadLetterTargetArn"`
+			MaxReceiveCount     json.Number `json:"maxReceiveCount"`
+		}
+		if err := json.Unmarshal([]byte(rp), &policy); err != nil {
+			cfg.Error = "parse RedrivePolicy: " + err.Error()
+		} else {
+			cfg.DeadLetterTargetArn = policy.DeadLetterTargetArn
+			n, _ := policy.MaxReceiveCount.Int64()
+			cfg.MaxReceiveCount = int(n)
+		}
+	}
+
+	queueAttrCache.mu.Lock()
+	queueAttrCache.entries[queueURL] = cachedQueueConfig{cfg: cfg, fetchedAt: time.Now()}
+	queueAttrCache.mu.Unlock()
+	return cfg
+}
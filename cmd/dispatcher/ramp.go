@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+const (
+	rampStepDouble = "double"
+	rampStepLinear = "linear"
+
+	// rampKneeFactor：某级 p99 超过上一级 p99 的该倍数（或出现未成功样本）即视为拐点。
+	rampKneeFactor = 1.5
+	// rampMinLevelBudget：剩余时间不足时不再启动下一级，避免最后一级全部超时污染结果。
+	rampMinLevelBudget = 3 * time.Second
+)
+
+// rampOutput：并发爬坡模式的输出。
+type rampOutput struct {
+	RunID  string      `json:"runId"`
+	Step   string      `json:"step"`
+	Levels []rampLevel `json:"levels"`
+	// KneeConcurrency：p99 开始劣化的并发级别；KneeStatus 为 "found" 或 "not reached"。
+	KneeConcurrency *int   `json:"kneeConcurrency,omitempty"`
+	KneeStatus      string `json:"kneeStatus"`
+	// StoppedEarly：因截止时间不足而没有跑完全部级别。
+	StoppedEarly bool `json:"stoppedEarly,omitempty"`
+	// DuplicateIDCount：各级生成 id 时发生碰撞并重新生成的次数。
+	DuplicateIDCount int `json:"duplicateIdCount,omitempty"`
+}
+
+// rampLevel：单个并发级别的结果；延迟为 receiveMessageUnixNano - sendStartUnixNano（Dispatcher 单调时钟）。
+type rampLevel struct {
+	Concurrency      int     `json:"concurrency"`
+	OK               int     `json:"ok"`
+	Failed           int     `json:"failed"`
+	WallMs           float64 `json:"wallMs"`
+	ThroughputPerSec float64 `json:"throughputPerSec"`
+	P50Ms            float64 `json:"p50Ms"`
+	P99Ms            float64 `json:"p99Ms"`
+	Error            string  `json:"error,omitempty"`
+}
+
+func validateRamp(body apiRequest) error {
+	if body.RampMaxConcurrency == 0 {
+		return nil
+	}
+	if body.RampMaxConcurrency < 1 || body.RampMaxConcurrency > maxBatchEntries {
+		return fmt.Errorf("rampMaxConcurrency must be 1..%d, got %d", maxBatchEntries, body.RampMaxConcurrency)
+	}
+	if len(body.BatchDelaySeconds) > 0 {
+		return fmt.Errorf("rampMaxConcurrency cannot be combined with batchDelaySeconds")
+	}
+	switch body.RampStep {
+	case "", rampStepDouble, rampStepLinear:
+		return nil
+	default:
+		return fmt.Errorf("rampStep must be %q or %q, got %q", rampStepDouble, rampStepLinear, body.RampStep)
+	}
+}
+
+// rampLevels：从 1 开始按倍增或 +1 递增，最后一级固定为 max。
+func rampLevels(max int, step string) []int {
+	var levels []int
+	for c := 1; c < max; {
+		levels = append(levels, c)
+		if step == rampStepLinear {
+			c++
+		} else {
+			c *= 2
+		}
+	}
+	return append(levels, max)
+}
+
+// handleRamp 依次以 1..rampMaxConcurrency 的并发跑批量发送（DelaySeconds 均为 0），
+// 记录每级的吞吐与 p50/p99，并找出 p99 开始劣化的级别。
+func handleRamp(ctx context.Context, body apiRequest, q queueTargets, inv invocationInfo) (events.APIGatewayProxyResponse, error) {
+	dispatchStart := time.Now().UnixNano()
+	step := body.RampStep
+	if step == "" {
+		step = rampStepDouble
+	}
+	out := rampOutput{RunID: body.RunID, Step: step, KneeStatus: "not reached"}
+
+	var prevP99 float64
+	for _, c := range rampLevels(body.RampMaxConcurrency, step) {
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < rampMinLevelBudget {
+			out.StoppedEarly = true
+			break
+		}
+		level := runRampLevel(ctx, body, q, inv, c)
+		out.Levels = append(out.Levels, level)
+		if level.Failed > 0 || (prevP99 > 0 && level.P99Ms > prevP99*rampKneeFactor) {
+			knee := c
+			out.KneeConcurrency = &knee
+			out.KneeStatus = "found"
+			break
+		}
+		prevP99 = level.P99Ms
+	}
+
+	out.DuplicateIDCount = body.ids.duplicateCount()
+	code, status := 200, "OK"
+	if len(out.Levels) == 0 {
+		code, status = 504, "TIMEOUT"
+	}
+	outBytes, _ := json.Marshal(out)
+	elapsedNs := time.Now().UnixNano() - dispatchStart
+	elapsedMs := elapsedNs / int64(time.Millisecond)
+	results.put(storedResult{RunID: body.RunID, StoredAt: time.Now(), TotalMs: elapsedMs, Output: outBytes})
+
+	// 每级只有汇总数据，内联输出本身已足够精简。
+	outBytes, upload := persistResult(ctx, body.resultS3, body.RunID, outBytes, nil)
+
+	outBytes, err := formatOutput(outBytes, body.OutputFormat)
+	if err != nil {
+		return jsonResp(500, apiResponse{Status: "ERROR", Error: err.Error()})
+	}
+	apiOut := apiResponse{Status: status, TotalMs: elapsedMs, TotalUs: nanosToUs(elapsedNs), Output: outBytes, PerceivedLatencyMs: perceivedLatencyMs(body.requestTimeEpochMs)}
+	upload.apply(&apiOut)
+	return jsonResp(code, apiOut)
+}
+
+func runRampLevel(ctx context.Context, body apiRequest, q queueTargets, inv invocationInfo, c int) rampLevel {
+	level := rampLevel{Concurrency: c}
+	start := time.Now()
+	st := sendTimes{dispatchStart: start.UnixNano()}
+	samples, err := sendBatch(ctx, body, q, inv, st, make([]int, c))
+	level.WallMs = float64(time.Since(start)) / float64(time.Millisecond)
+	if err != nil {
+		level.Failed = c
+		level.Error = err.Error()
+		return level
+	}
+
+	var latencies []float64
+	for _, s := range samples {
+		if s.Status != "OK" {
+			level.Failed++
+			if level.Error == "" {
+				level.Error = s.Error
+			}
+			continue
+		}
+		level.OK++
+		latencies = append(latencies, float64(s.ReceiveMessageUnixNano-s.SendStartUnixNano)/float64(time.Millisecond))
+	}
+	if level.WallMs > 0 {
+		level.ThroughputPerSec = float64(level.OK) / (level.WallMs / 1000)
+	}
+	if len(latencies) > 0 {
+		sort.Float64s(latencies)
+		// 最近秩（nearest-rank）百分位。
+		rank := func(p float64) float64 {
+			i := int(math.Ceil(p/100*float64(len(latencies)))) - 1
+			return latencies[clampInt(i, 0, len(latencies)-1)]
+		}
+		level.P50Ms = rank(50)
+		level.P99Ms = rank(99)
+	}
+	return level
+}
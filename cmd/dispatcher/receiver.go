@@ -0,0 +1,481 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+const (
+	// defaultReceiverBufferMs：无人等待的回调在内存中保留的时长，超时后才释放可见性（SHARED_RECEIVER_BUFFER_MS）。
+	defaultReceiverBufferMs = 500
+	// receiverErrorBackoff：接收循环 ReceiveMessage 出错后的退避，避免错误时空转。
+	receiverErrorBackoff = 100 * time.Millisecond
+	// receiverReleaseTimeout：接收循环停止时释放缓冲回调的时限（请求的截止时间可能已过）。
+	receiverReleaseTimeout = 2 * time.Second
+)
+
+// sharedReceiverEnabled：SHARED_RECEIVER=1/true 时 pollForCallback 改由进程共享的接收循环分发回调（见 pollShared）；
+// 并行在途模式（count/concurrency）总是使用共享接收循环。
+func sharedReceiverEnabled() bool {
+	switch strings.ToLower(strings.TrimSpace(os.Getenv("SHARED_RECEIVER"))) {
+	case "1", "true":
+		return true
+	}
+	return false
+}
+
+func receiverBufferWindow() time.Duration {
+	return time.Duration(max(envIntDefault("SHARED_RECEIVER_BUFFER_MS", defaultReceiverBufferMs), 0)) * time.Millisecond
+}
+
+// demuxStats：共享接收循环的累计计数。routed 为直接分发给等待者的回调，stashRouted 为经进程内暂存区转交的回调，
+// bufferHits 为登记时从缓冲中取到的回调，offered 为属于本进程其他在途请求（未登记到本接收器）、已转交暂存区的回调，
+// buffered 为进入缓冲的未知回调，released 为释放回队列的消息，staleDeletes 为超过 STALE_CALLBACK_MS 而删除的回调，
+// received 为 ReceiveMessage 返回的消息总数。
+type demuxStats struct {
+	Polls            int `json:"polls"`
+	Received         int `json:"received"`
+	Routed           int `json:"routed"`
+	StashRouted      int `json:"stashRouted"`
+	BufferHits       int `json:"bufferHits"`
+	Offered          int `json:"offered"`
+	Buffered         int `json:"buffered"`
+	Released         int `json:"released"`
+	StaleDeletes     int `json:"staleDeletes"`
+	SendStartRejects int `json:"sendStartRejects"`
+	Quarantined      int `json:"quarantined"`
+	ReceiveErrors    int `json:"receiveErrors"`
+}
+
+// sub：s 相对 base 的增量（接收器在多个请求间共享，单个请求只报告自己运行期间的变化）。
+func (s demuxStats) sub(base demuxStats) demuxStats {
+	return demuxStats{
+		Polls:            s.Polls - base.Polls,
+		Received:         s.Received - base.Received,
+		Routed:           s.Routed - base.Routed,
+		StashRouted:      s.StashRouted - base.StashRouted,
+		BufferHits:       s.BufferHits - base.BufferHits,
+		Offered:          s.Offered - base.Offered,
+		Buffered:         s.Buffered - base.Buffered,
+		Released:         s.Released - base.Released,
+		StaleDeletes:     s.StaleDeletes - base.StaleDeletes,
+		SendStartRejects: s.SendStartRejects - base.SendStartRejects,
+		Quarantined:      s.Quarantined - base.Quarantined,
+		ReceiveErrors:    s.ReceiveErrors - base.ReceiveErrors,
+	}
+}
+
+// demuxDelivery：交给等待者的回调；stashHit 表示经进程内暂存区转交，bufferHit 表示登记时从缓冲中取到。
+type demuxDelivery struct {
+	sc        stashedCallback
+	stashHit  bool
+	bufferHit bool
+}
+
+// demuxWaiter：一条在途消息的等待者；ch 缓冲 1，投递后即从等待表移除。
+// rejects 为 sendStart 对不上而未投递的回调数（受 sharedReceiver.mu 保护）。
+type demuxWaiter struct {
+	sendStart   int64
+	toleranceNs int64
+	ch          chan demuxDelivery
+	rejects     int
+}
+
+// bufferedCallback：收到时还没有等待者的回调，保留 receiverBufferWindow 后释放可见性。
+type bufferedCallback struct {
+	sc            stashedCallback
+	queueURL      string
+	receiptHandle *string
+	timer         *time.Timer
+}
+
+// sharedReceiver：一组 Receive 队列上唯一的接收循环，按 runId/id 把回调分发给登记的等待者。
+// 取代"每个请求各自轮询、把不属于自己的消息可见性置 0"的做法，避免并发时的惊群与反复重投。
+type sharedReceiver struct {
+	queueURLs []string
+
+	// lifecycle 串行化接收循环的启停，保护 users/stop/done。
+	lifecycle sync.Mutex
+	// users：持有 acquire 的请求数，降为 0 时循环停止。
+	users int
+	stop  context.CancelFunc
+	// done：当前接收循环退出时关闭。
+	done chan struct{}
+
+	mu       sync.Mutex
+	waiters  map[string]*demuxWaiter
+	buffered map[string]*bufferedCallback
+	stats    demuxStats
+}
+
+var (
+	receiversMu sync.Mutex
+	receivers   = map[string]*sharedReceiver{}
+)
+
+// receiverFor：按 Receive 队列列表（优先级顺序）取得进程内共享的接收器。
+func receiverFor(receiveQueueURLs []string) *sharedReceiver {
+	key := strings.Join(receiveQueueURLs, ",")
+	receiversMu.Lock()
+	defer receiversMu.Unlock()
+	r := receivers[key]
+	if r == nil {
+		r = &sharedReceiver{queueURLs: append([]string(nil), receiveQueueURLs...), waiters: map[string]*demuxWaiter{}, buffered: map[string]*bufferedCallback{}}
+		receivers[key] = r
+	}
+	return r
+}
+
+// acquire 在第一个使用者到来时启动接收循环。循环本身不属于任何一个请求，运行在独立的 ctx 上；
+// 返回的函数在最后一个使用者离开时取消循环、等待其退出，再用请求的 ctx 释放缓冲中无人认领的回调，
+// 保证请求返回前不留下运行中的循环或被占住的消息（被取消的那次长轮询中已取出的消息会等到可见性超时）。
+func (r *sharedReceiver) acquire(ctx context.Context) func() {
+	r.lifecycle.Lock()
+	defer r.lifecycle.Unlock()
+	r.users++
+	if r.users == 1 {
+		loopCtx, cancel := context.WithCancel(context.Background())
+		r.stop, r.done = cancel, make(chan struct{})
+		go r.run(loopCtx, r.done)
+	}
+	var once sync.Once
+	return func() { once.Do(func() { r.leave(ctx) }) }
+}
+
+func (r *sharedReceiver) leave(ctx context.Context) {
+	r.lifecycle.Lock()
+	defer r.lifecycle.Unlock()
+	if r.users--; r.users > 0 {
+		return
+	}
+	r.stop()
+	<-r.done
+	r.stop, r.done = nil, nil
+
+	r.mu.Lock()
+	buffered := r.buffered
+	r.buffered = map[string]*bufferedCallback{}
+	r.mu.Unlock()
+	r.releaseBuffered(ctx, buffered)
+}
+
+// releaseBuffered：按队列用 ChangeMessageVisibilityBatch（每批最多 maxBatchEntries 条）把缓冲的回调释放回队列。
+func (r *sharedReceiver) releaseBuffered(ctx context.Context, buffered map[string]*bufferedCallback) {
+	if len(buffered) == 0 {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), receiverReleaseTimeout)
+	defer cancel()
+	byQueue := map[string][]types.ChangeMessageVisibilityBatchRequestEntry{}
+	for _, b := range buffered {
+		b.timer.Stop()
+		entries := byQueue[b.queueURL]
+		byQueue[b.queueURL] = append(entries, types.ChangeMessageVisibilityBatchRequestEntry{Id: aws.String(strconv.Itoa(len(entries))), ReceiptHandle: b.receiptHandle, VisibilityTimeout: 0})
+	}
+	for queueURL, entries := range byQueue {
+		for len(entries) > 0 {
+			n := min(len(entries), maxBatchEntries)
+			if _, err := sqsClient.ChangeMessageVisibilityBatch(ctx, &sqs.ChangeMessageVisibilityBatchInput{QueueUrl: &queueURL, Entries: entries[:n]}); err != nil {
+				log.Printf("shared receiver: release buffered callbacks failed: queue=%s n=%d err=%v", queueNameFromURL(queueURL), n, err)
+			}
+			entries = entries[n:]
+		}
+	}
+	r.count(func(s *demuxStats) { s.Released += len(buffered) })
+}
+
+// wait 登记 runID/id 的等待者（调用方需持有 acquire）；缓冲或进程内暂存区中已有的回调立即投递。
+// 缓冲中的回调先在锁内核对 sendStart 再认领，之后才用请求的 ctx 删除；对不上的与 dispatch 一样视为重复/陈旧回调删除，继续等待。
+func (r *sharedReceiver) wait(ctx context.Context, runID, id string, sendStart, toleranceNs int64) (*demuxWaiter, func()) {
+	k := stashKey(runID, id)
+	w := &demuxWaiter{sendStart: sendStart, toleranceNs: toleranceNs, ch: make(chan demuxDelivery, 1)}
+	r.mu.Lock()
+	r.waiters[k] = w
+	b := r.buffered[k]
+	hit := false
+	if b != nil {
+		delete(r.buffered, k)
+		b.timer.Stop()
+		if hit = sendStartMatches(b.sc.cb.SendStartUnixNano, sendStart, toleranceNs); hit {
+			delete(r.waiters, k)
+			r.stats.BufferHits++
+		} else {
+			w.rejects++
+			r.stats.SendStartRejects++
+		}
+	}
+	r.mu.Unlock()
+
+	if b != nil {
+		_, _ = sqsClient.DeleteMessage(ctx, &sqs.DeleteMessageInput{QueueUrl: &b.queueURL, ReceiptHandle: b.receiptHandle})
+	}
+	if hit {
+		w.ch <- demuxDelivery{sc: b.sc, bufferHit: true}
+		return w, func() { r.unregister(k, w) }
+	}
+	// 登记之前到达、被转交暂存区的回调（其他请求的轮询或接收循环在登记前收到）。
+	if sc, ok := stash.take(runID, id); ok {
+		r.complete(k, w, demuxDelivery{sc: sc, stashHit: true}, func(s *demuxStats) { s.StashRouted++ })
+	}
+	return w, func() { r.unregister(k, w) }
+}
+
+func (r *sharedReceiver) unregister(k string, w *demuxWaiter) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.waiters[k] == w {
+		delete(r.waiters, k)
+	}
+}
+
+// complete：w 仍在等待且 sendStart 匹配时投递并移除；sendStart 对不上的视为重复/陈旧回调，继续等待。
+func (r *sharedReceiver) complete(k string, w *demuxWaiter, d demuxDelivery, count func(*demuxStats)) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.waiters[k] != w {
+		return false
+	}
+	if !sendStartMatches(d.sc.cb.SendStartUnixNano, w.sendStart, w.toleranceNs) {
+		w.rejects++
+		r.stats.SendStartRejects++
+		return false
+	}
+	delete(r.waiters, k)
+	w.ch <- d
+	count(&r.stats)
+	return true
+}
+
+func (r *sharedReceiver) rejectsOf(w *demuxWaiter) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return w.rejects
+}
+
+func (r *sharedReceiver) count(f func(*demuxStats)) {
+	r.mu.Lock()
+	f(&r.stats)
+	r.mu.Unlock()
+}
+
+func (r *sharedReceiver) snapshot() demuxStats {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.stats
+}
+
+// drainStash：非共享模式的轮询（SHARED_RECEIVER 未开启的请求）可能替等待者收到了回调并放进暂存区，每轮取回。
+func (r *sharedReceiver) drainStash() {
+	r.mu.Lock()
+	pending := make(map[string]*demuxWaiter, len(r.waiters))
+	for k, w := range r.waiters {
+		pending[k] = w
+	}
+	r.mu.Unlock()
+	for k, w := range pending {
+		runID, id, _ := strings.Cut(k, "/")
+		if sc, ok := stash.take(runID, id); ok {
+			r.complete(k, w, demuxDelivery{sc: sc, stashHit: true}, func(s *demuxStats) { s.StashRouted++ })
+		}
+	}
+}
+
+// run：按优先级依次长轮询各 Receive 队列，直到 ctx 被取消（最后一个 acquire 的使用者离开）。
+func (r *sharedReceiver) run(ctx context.Context, done chan struct{}) {
+	defer close(done)
+	p := &poller{}
+	for ctx.Err() == nil {
+		r.drainStash()
+		for _, queueURL := range r.queueURLs {
+			if ctx.Err() != nil {
+				return
+			}
+			wait := int32(maxPollWaitSeconds)
+			if len(r.queueURLs) > 1 {
+				wait = multiQueuePollWaitSeconds
+			}
+			r.count(func(s *demuxStats) { s.Polls++ })
+			out, err := sqsClient.ReceiveMessage(ctx, &sqs.ReceiveMessageInput{
+				QueueUrl:            &queueURL,
+				MaxNumberOfMessages: maxBatchEntries,
+				WaitTimeSeconds:     wait,
+				VisibilityTimeout:   pollVisibilityTimeoutSeconds,
+				MessageSystemAttributeNames: []types.MessageSystemAttributeName{
+					types.MessageSystemAttributeNameSentTimestamp,
+				},
+				MessageAttributeNames: []string{attrRunID, attrID, attrSignature},
+			}, noSDKRetry)
+			if err != nil {
+				if ctx.Err() != nil {
+					return
+				}
+				r.count(func(s *demuxStats) { s.ReceiveErrors++ })
+				log.Printf("shared receiver: receive failed: queue=%s err=%v", queueNameFromURL(queueURL), err)
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(receiverErrorBackoff):
+				}
+				continue
+			}
+			r.dispatch(ctx, p, queueURL, out.Messages)
+		}
+	}
+}
+
+// dispatch：逐条解析并分发。命中等待者的删除后投递；属于本进程其他在途请求的转交暂存区；
+// 其余放入缓冲，等待者可能尚未登记（例如发送与登记之间），receiverBufferWindow 内无人认领再释放可见性。
+func (r *sharedReceiver) dispatch(ctx context.Context, p *poller, queueURL string, msgs []types.Message) {
+	receiveMessageUnixNano := time.Now().UnixNano()
+	receiveQueueName := queueNameFromURL(queueURL)
+	window := receiverBufferWindow()
+	var release []types.ChangeMessageVisibilityBatchRequestEntry
+	releaseMsg := func(m types.Message) {
+		if m.ReceiptHandle != nil {
+			release = append(release, types.ChangeMessageVisibilityBatchRequestEntry{Id: aws.String(strconv.Itoa(len(release))), ReceiptHandle: m.ReceiptHandle, VisibilityTimeout: 0})
+		}
+	}
+	var deletes []*string
+	// isStale：无人认领的过期回调删除而不是缓冲/释放（见 STALE_CALLBACK_MS）。
+	isStale := func(m types.Message, cb callbackMessage) bool {
+		age, stale := staleCallbackAge(m, cb.SqsSentTimestampMs)
+		if stale {
+			slog.Debug("deleted stale callback", "runId", cb.RunID, "id", cb.ID, "ageMs", age.Milliseconds(), "queue", receiveQueueName)
+		}
+		return stale
+	}
+	for _, m := range msgs {
+		if !signatureValid(m) {
+			releaseMsg(m)
+			continue
+		}
+		var cb callbackMessage
+		if err := json.Unmarshal([]byte(aws.ToString(m.Body)), &cb); err != nil {
+			p.quarantineOrDelete(ctx, queueURL, m)
+			continue
+		}
+		sc := stashedCallback{
+			cb:                     cb,
+			receiveMessageUnixNano: receiveMessageUnixNano,
+			sqsSentTimestampMs:     parseInt64OrZero(m.Attributes[string(types.MessageSystemAttributeNameSentTimestamp)]),
+			receiveQueueName:       receiveQueueName,
+		}
+		k := stashKey(strings.TrimSpace(cb.RunID), strings.TrimSpace(cb.ID))
+
+		// 查等待表、转交暂存区与放入缓冲在同一把锁内完成，与 wait 的登记互斥，回调不会落在两者之间的空档。
+		r.mu.Lock()
+		switch w := r.waiters[k]; {
+		case w != nil:
+			if sendStartMatches(cb.SendStartUnixNano, w.sendStart, w.toleranceNs) {
+				delete(r.waiters, k)
+				w.ch <- demuxDelivery{sc: sc}
+				r.stats.Routed++
+			} else {
+				w.rejects++
+				r.stats.SendStartRejects++
+			}
+			deletes = append(deletes, m.ReceiptHandle)
+		case stash.offer(sc):
+			r.stats.Offered++
+			deletes = append(deletes, m.ReceiptHandle)
+		case isStale(m, cb):
+			r.stats.StaleDeletes++
+			deletes = append(deletes, m.ReceiptHandle)
+		case window > 0 && r.buffered[k] == nil && m.ReceiptHandle != nil:
+			b := &bufferedCallback{sc: sc, queueURL: queueURL, receiptHandle: m.ReceiptHandle}
+			b.timer = time.AfterFunc(window, func() { r.expire(k, b) })
+			r.buffered[k] = b
+			r.stats.Buffered++
+		default:
+			releaseMsg(m)
+		}
+		r.mu.Unlock()
+	}
+	for _, rh := range deletes {
+		if rh != nil {
+			_, _ = sqsClient.DeleteMessage(ctx, &sqs.DeleteMessageInput{QueueUrl: &queueURL, ReceiptHandle: rh})
+		}
+	}
+	r.count(func(s *demuxStats) {
+		s.Received += len(msgs)
+		s.Released += len(release)
+		s.Quarantined += p.quarantined
+	})
+	p.quarantined = 0
+	if len(release) > 0 {
+		_, _ = sqsClient.ChangeMessageVisibilityBatch(ctx, &sqs.ChangeMessageVisibilityBatchInput{QueueUrl: &queueURL, Entries: release})
+	}
+}
+
+// expire：缓冲期内无人认领的回调释放可见性，交给其他容器的请求。
+func (r *sharedReceiver) expire(k string, b *bufferedCallback) {
+	r.mu.Lock()
+	if r.buffered[k] != b {
+		r.mu.Unlock()
+		return
+	}
+	delete(r.buffered, k)
+	r.mu.Unlock()
+	_, _ = sqsClient.ChangeMessageVisibility(context.Background(), &sqs.ChangeMessageVisibilityInput{QueueUrl: &b.queueURL, ReceiptHandle: b.receiptHandle, VisibilityTimeout: 0})
+	r.count(func(s *demuxStats) { s.Released++ })
+}
+
+// pollShared：SHARED_RECEIVER 开启时 pollForCallback 的实现——登记等待者后阻塞到回调投递或 ctx 结束，返回前停止无人使用的接收循环。
+// 发送前的 stash.register 保证登记之前到达的回调先进入暂存区，登记时取回。
+//
+// 接收循环由容器内所有等待者共享，与逐请求轮询的差别：
+//   - opts.waitSeconds、opts.maxMessages 与重试预算不生效：循环固定长轮询 maxPollWaitSeconds（多队列时 multiQueuePollWaitSeconds）、
+//     每次最多 maxBatchEntries 条，ReceiveMessage 出错时按 receiverErrorBackoff 退避后一直重试（计入 demux.receiveErrors）；
+//   - pollAttempts、foreignGrabs、quarantined 与 debug 的 polls/staleDeletes 取本次等待期间循环计数的增量，
+//     包含同一容器内同时在等的其他请求；debug 没有逐次轮询记录；
+//   - 不切换 RECEIVE_QUEUE_URL_FALLBACK。
+func pollShared(ctx context.Context, receiveQueueURLs []string, runID, id string, opts pollOptions) (pollResult, error) {
+	r := receiverFor(receiveQueueURLs)
+	stop := r.acquire(ctx)
+	defer stop()
+	before := r.snapshot()
+	w, done := r.wait(ctx, runID, id, opts.sendStart, opts.sendStartToleranceNs)
+	defer done()
+
+	// fill：own 为本请求的回调是否由这段时间内的 ReceiveMessage 取到（不计入 foreignGrabs）。
+	fill := func(pr pollResult, own int) pollResult {
+		delta := r.snapshot().sub(before)
+		pr.pollAttempts = delta.Polls
+		pr.foreignGrabs = max(delta.Received-own, 0)
+		pr.quarantined = delta.Quarantined
+		pr.sendStartRejects = r.rejectsOf(w)
+		if opts.debug != nil {
+			opts.debug.Polls = delta.Polls
+			opts.debug.StaleDeletes = delta.StaleDeletes
+		}
+		return pr
+	}
+	select {
+	case d := <-w.ch:
+		own := 0
+		if !d.stashHit && !d.bufferHit {
+			own = 1
+		}
+		return fill(pollResult{
+			cb:                         d.sc.cb,
+			receiveMessageUnixNano:     d.sc.receiveMessageUnixNano,
+			pollEnd:                    time.Now().UnixNano(),
+			callbackSqsSentTimestampMs: d.sc.sqsSentTimestampMs,
+			receiveQueueName:           d.sc.receiveQueueName,
+			stashHit:                   d.stashHit,
+		}, own), nil
+	case <-ctx.Done():
+		return fill(pollResult{}, 0), ctx.Err()
+	}
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"fmt"
+	"log"
+)
+
+const (
+	// regionCheckFlag（默认）：只输出 regionMismatch；regionCheckStrict：不一致时样本判为失败；regionCheckOff：不校验。
+	regionCheckFlag   = "flag"
+	regionCheckStrict = "strict"
+	regionCheckOff    = "off"
+
+	statusRegionMismatch = "REGION_MISMATCH"
+)
+
+func validateRegionCheck(mode string) error {
+	switch mode {
+	case "", regionCheckFlag, regionCheckStrict, regionCheckOff:
+		return nil
+	default:
+		return fmt.Errorf("regionCheck must be %q, %q or %q, got %q", regionCheckFlag, regionCheckStrict, regionCheckOff, mode)
+	}
+}
+
+// applyRegionCheck 比较 Dispatcher 与回调中 Worker 的区域，结果写入 out；
+// 返回 true 表示 strict 模式下应把样本判为 REGION_MISMATCH。旧版 Worker 未回写区域时不校验。
+func applyRegionCheck(out *dispatcherOutput, mode string) bool {
+	if mode == regionCheckOff || out.WorkerRegion == "" || out.Region == "" || out.WorkerRegion == out.Region {
+		return false
+	}
+	out.RegionMismatch = true
+	log.Printf("region mismatch: runId=%s id=%s dispatcher=%s worker=%s", out.RunID, out.ID, out.Region, out.WorkerRegion)
+	return mode == regionCheckStrict
+}
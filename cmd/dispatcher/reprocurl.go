@@ -0,0 +1,52 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// reproHeaders：复现命令中保留的请求头；其余（Host、X-Forwarded-*、API Gateway 注入的头等）与复现无关。
+var reproHeaders = map[string]bool{"Accept": true, "Authorization": true, "X-Api-Key": true}
+
+// redactedHeaders：只保留头名，值替换为 REDACTED，避免凭证随输出或日志外泄。
+var redactedHeaders = map[string]bool{"Authorization": true, "X-Api-Key": true}
+
+// reproCurl 把请求渲染为可直接粘贴的 curl 命令：body 为校验与收紧（clamp）之后的 apiRequest，
+// 因而与实际生效的参数一致。Lambda 中 URL 由 API Gateway 的域名与路径拼出，取不到时用 $API 占位。
+func reproCurl(req events.APIGatewayProxyRequest, body apiRequest) string {
+	url := "$API"
+	switch {
+	case httpMode && req.Headers["Host"] != "":
+		url = "http://" + req.Headers["Host"] + req.Path
+	case req.RequestContext.DomainName != "":
+		url = "https://" + req.RequestContext.DomainName + req.RequestContext.Path
+	}
+	b, _ := json.Marshal(body)
+
+	parts := []string{"curl", "-X", "POST", shellQuote(url), "-H", shellQuote("Content-Type: application/json")}
+	var names []string
+	for k := range req.Headers {
+		if reproHeaders[http.CanonicalHeaderKey(k)] {
+			names = append(names, k)
+		}
+	}
+	sort.Strings(names)
+	for _, k := range names {
+		v := req.Headers[k]
+		if redactedHeaders[http.CanonicalHeaderKey(k)] {
+			v = "REDACTED"
+		}
+		parts = append(parts, "-H", shellQuote(http.CanonicalHeaderKey(k)+": "+v))
+	}
+	parts = append(parts, "-d", shellQuote(string(b)))
+	return strings.Join(parts, " ")
+}
+
+// shellQuote：POSIX shell 单引号转义。
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/smithy-go"
+)
+
+const (
+	// throttleRetryAfterBase/Max：429 的 Retry-After 按容器内连续限流次数指数增长（1, 2, 4, ... 秒），不超过上限。
+	throttleRetryAfterBase = time.Second
+	throttleRetryAfterMax  = 60 * time.Second
+
+	// defaultSendMaxRetries：SEND_MAX_RETRIES 的缺省值，发送到 Push 队列的重试次数上限（仍受 retryBudget 与截止时间约束）。
+	defaultSendMaxRetries = 3
+	// retryBaseBackoff：第一次重试的退避上限，之后每次翻倍。
+	retryBaseBackoff = 20 * time.Millisecond
+)
+
+// sendMaxRetries：SEND_MAX_RETRIES（0 表示发送不重试）。
+func sendMaxRetries() int {
+	return max(envIntDefault("SEND_MAX_RETRIES", defaultSendMaxRetries), 0)
+}
+
+// consecutiveThrottles：本容器连续被限流的发送次数，任一次发送成功即清零。
+var consecutiveThrottles atomic.Int32
+
+// classifySendError 把发送到 Push 队列的错误映射为 HTTP 状态码：限流为 429，其余（含重试后仍失败的服务端错误）为 502。
+func classifySendError(err error) int {
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) {
+		switch apiErr.ErrorCode() {
+		case "ThrottlingException", "RequestThrottled", "KmsThrottled":
+			return 429
+		}
+	}
+	return 502
+}
+
+// sendErrorResp：Push 队列发送失败时的响应；429 附带 Retry-After（秒），提示调用方退避。
+func sendErrorResp(err error) (events.APIGatewayProxyResponse, error) {
+	code := classifySendError(err)
+	if code != 429 {
+		return jsonResp(code, apiResponse{Status: "ERROR", Error: err.Error()})
+	}
+	n := consecutiveThrottles.Add(1)
+	retryAfter := throttleRetryAfterMax
+	if n <= 6 {
+		retryAfter = min(throttleRetryAfterBase<<(n-1), throttleRetryAfterMax)
+	}
+	resp, _ := jsonResp(429, apiResponse{Status: "THROTTLED", Error: err.Error()})
+	resp.Headers["Retry-After"] = strconv.Itoa(int(retryAfter / time.Second))
+	return resp, nil
+}
+
+// noteSendOK：发送成功后清零连续限流计数。
+func noteSendOK() {
+	consecutiveThrottles.Store(0)
+}
+
+// isRetryableSQSError：限流、服务端错误与网络错误可重试；参数/权限/队列不存在等客户端错误，
+// 以及 context 取消/超时都不重试。
+func isRetryableSQSError(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	var apiErr smithy.APIError
+	if !errors.As(err, &apiErr) {
+		return true
+	}
+	switch apiErr.ErrorCode() {
+	case "ThrottlingException", "RequestThrottled", "ServiceUnavailable", "InternalError", "InternalFailure", "KmsThrottled":
+		return true
+	}
+	return apiErr.ErrorFault() == smithy.FaultServer
+}
+
+// retryBudget：一次请求内发送、接收、删除的所有重试共享的时间预算，使重试总耗时有界且可预测。
+// 计入预算的是退避等待与失败的重试本身的耗时；首次尝试不计入。nil 表示不重试。
+type retryBudget struct {
+	mu    sync.Mutex
+	limit time.Duration
+	used  time.Duration
+}
+
+func newRetryBudget(limit time.Duration) *retryBudget {
+	if limit <= 0 {
+		return nil
+	}
+	return &retryBudget{limit: limit}
+}
+
+// noSDKRetry：由 retryBudget 接管的调用关闭 SDK 自带的重试，避免两层重试叠加。
+func noSDKRetry(o *sqs.Options) { o.RetryMaxAttempts = 1 }
+
+// retry 执行 op；遇到可重试错误时指数退避重试，直到成功、预算用尽或退避会越过 ctx 截止时间。
+func (b *retryBudget) retry(ctx context.Context, op func(context.Context) error) error {
+	_, err := b.retryLimited(ctx, -1, false, op)
+	return err
+}
+
+// retrySend：发送专用的重试，最多 sendMaxRetries 次，退避取 [0, 20ms·2^n) 的全抖动，
+// 避免多个容器同时被限流后同步重试。返回实际尝试次数（含首次）。
+func (b *retryBudget) retrySend(ctx context.Context, op func(context.Context) error) (int, error) {
+	return b.retryLimited(ctx, sendMaxRetries(), true, op)
+}
+
+// retryLimited：maxRetries < 0 表示只受预算与截止时间限制；jitter 为 true 时使用全抖动退避。
+func (b *retryBudget) retryLimited(ctx context.Context, maxRetries int, jitter bool, op func(context.Context) error) (int, error) {
+	err := op(ctx)
+	attempts := 1
+	backoff := retryBaseBackoff
+	for err != nil && isRetryableSQSError(err) && (maxRetries < 0 || attempts <= maxRetries) {
+		wait := backoff
+		if jitter {
+			wait = rand.N(backoff)
+		}
+		if !b.allow(ctx, wait) {
+			break
+		}
+		start := time.Now()
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			b.charge(time.Since(start))
+			return attempts, err
+		}
+		attempts++
+		err = op(ctx)
+		if err != nil {
+			b.charge(time.Since(start))
+		} else {
+			b.charge(wait)
+		}
+		backoff *= 2
+	}
+	return attempts, err
+}
+
+func (b *retryBudget) allow(ctx context.Context, backoff time.Duration) bool {
+	if b == nil {
+		return false
+	}
+	if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) <= backoff {
+		return false
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.used+backoff <= b.limit
+}
+
+func (b *retryBudget) charge(d time.Duration) {
+	b.mu.Lock()
+	b.used += d
+	b.mu.Unlock()
+}
+
+func (b *retryBudget) usedMs() float64 {
+	if b == nil {
+		return 0
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return float64(b.used) / float64(time.Millisecond)
+}
+
+// deleteWithRetry 删除已匹配的回调；可重试错误在共享的 retryBudget 内重试。
+func deleteWithRetry(ctx context.Context, budget *retryBudget, queueURL string, receiptHandle *string) error {
+	err := budget.retry(ctx, func(ctx context.Context) error {
+		_, err := sqsClient.DeleteMessage(ctx, &sqs.DeleteMessageInput{QueueUrl: &queueURL, ReceiptHandle: receiptHandle}, noSDKRetry)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("delete message: %w", err)
+	}
+	return nil
+}
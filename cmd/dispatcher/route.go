@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+// maxRouteAttributes：SQS 每条消息最多 10 个 MessageAttributes，为 ultraMinimal 的内部属性预留 4 个。
+const maxRouteAttributes = 6
+
+// maxMessageAttributes：SQS 对单条消息 MessageAttributes 数量的硬限制（MessageSystemAttributes 不计入）。
+const maxMessageAttributes = 10
+
+func validateRouteAttributes(attrs map[string]string) error {
+	if len(attrs) > maxRouteAttributes {
+		return fmt.Errorf("routeAttributes supports at most %d entries, got %d", maxRouteAttributes, len(attrs))
+	}
+	for k := range attrs {
+		switch {
+		case k == "" || strings.HasPrefix(strings.ToLower(k), "aws.") || strings.HasPrefix(strings.ToLower(k), "amazon."):
+			return fmt.Errorf("invalid routeAttributes name: %q", k)
+		case k == attrRunID || k == attrID || k == attrSendUnixNano || k == attrSendStartUnixNano || k == attrDispatcherVersion || k == attrTraceHeader || k == attrSignature:
+			return fmt.Errorf("routeAttributes name %q is reserved", k)
+		}
+	}
+	return nil
+}
+
+// plannedMessageAttributes：本次请求每条 Push 消息将携带的 MessageAttributes 名称（排序后），
+// 与 encodeMessage/placementAttributes 的实际行为保持一致。
+func plannedMessageAttributes(body apiRequest) []string {
+	_, attrs := encodeMessage(msgBody{RunID: body.RunID, DispatcherVersion: dispatcherVersion, RouteAttributes: body.RouteAttributes}, body.UltraMinimal)
+	names := make([]string, 0, len(attrs)+1)
+	for k := range attrs {
+		names = append(names, k)
+	}
+	// compareAttributes 的用户属性轮次额外携带 traceHeader（系统属性轮次不计入）。
+	if body.CompareAttributes > 0 {
+		names = append(names, attrTraceHeader)
+	}
+	if sharedSecret() != "" {
+		names = append(names, attrSignature)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// validateMessageAttributeCount 在发送前检查组合后的属性总数，避免 SQS 返回难以理解的错误。
+func validateMessageAttributeCount(body apiRequest) error {
+	names := plannedMessageAttributes(body)
+	if len(names) > maxMessageAttributes {
+		return fmt.Errorf("too many message attributes: %d > %d (%s)", len(names), maxMessageAttributes, strings.Join(names, ", "))
+	}
+	return nil
+}
+
+func routeMessageAttributes(route map[string]string) map[string]types.MessageAttributeValue {
+	if len(route) == 0 {
+		return nil
+	}
+	attrs := make(map[string]types.MessageAttributeValue, len(route))
+	for k, v := range route {
+		attrs[k] = types.MessageAttributeValue{DataType: aws.String("String"), StringValue: aws.String(v)}
+	}
+	return attrs
+}
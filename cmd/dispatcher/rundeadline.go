@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// errRunDeadline：整轮运行时长上限（MAX_RUN_DURATION_MS / maxRunDurationMs）到期时 runCtx 的 cause，
+// 用于与 maxWaitMs（单次请求截止时间）到期区分。
+var errRunDeadline = errors.New("run deadline exceeded")
+
+// runDurationLimit：MAX_RUN_DURATION_MS 为上限，请求中的 maxRunDurationMs 只能收紧；都未设置（或 <= 0）时返回 0（不限制）。
+func runDurationLimit(body apiRequest) time.Duration {
+	limit := time.Duration(max(envIntDefault("MAX_RUN_DURATION_MS", 0), 0)) * time.Millisecond
+	if body.MaxRunDurationMs > 0 {
+		if req := time.Duration(body.MaxRunDurationMs) * time.Millisecond; limit == 0 || req < limit {
+			limit = req
+		}
+	}
+	return limit
+}
+
+// httpRunMaxWait：HTTP 变体没有 API Gateway 的 29 秒限制，配置了运行时长上限时 maxWaitMs 可以超过 maxEffectiveWait，
+// 但不超过该上限；Lambda 中（ctx 有截止时间）或未配置上限时原样返回 maxWait。
+func httpRunMaxWait(ctx context.Context, requestedMs int, maxWait, runLimit time.Duration) time.Duration {
+	if _, ok := ctx.Deadline(); ok || !httpMode || runLimit <= 0 || requestedMs <= 0 {
+		return maxWait
+	}
+	return max(maxWait, min(time.Duration(requestedMs)*time.Millisecond, runLimit))
+}
+
+// withRunDeadline 在 JSON apiResponse 中写入 runDurationMs；运行被上限截断（状态不是 OK 且 cause 为 errRunDeadline）时
+// 改写为 status=RUN_DEADLINE、runDeadlineExceeded=true，已收集的 output 原样保留，HTTP 状态码不变。
+func withRunDeadline(resp events.APIGatewayProxyResponse, runCtx context.Context, start time.Time) events.APIGatewayProxyResponse {
+	if resp.Headers["Content-Type"] != "application/json" {
+		return resp
+	}
+	var api apiResponse
+	if err := json.Unmarshal([]byte(resp.Body), &api); err != nil {
+		return resp
+	}
+	api.RunDurationMs = time.Since(start).Milliseconds()
+	if api.Status != "OK" && errors.Is(context.Cause(runCtx), errRunDeadline) {
+		api.Status = "RUN_DEADLINE"
+		api.RunDeadlineExceeded = true
+	}
+	b, _ := json.Marshal(api)
+	resp.Body = string(b)
+	return resp
+}
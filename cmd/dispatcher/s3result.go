@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// s3PutTimeout：结果写入 S3 的超时。测量阶段可能已用完 callCtx，因此单独计时，
+// 只与 Lambda 截止时间之间的余量（maxWaitMs 上限 28s、函数超时 30s）竞争。
+const s3PutTimeout = 2 * time.Second
+
+// s3PutAPI：persistResult 用到的 S3 调用，测试中替换为 mock。
+type s3PutAPI interface {
+	PutObject(ctx context.Context, params *s3.PutObjectInput, optFns ...func(*s3.Options)) (*s3.PutObjectOutput, error)
+}
+
+// s3Client：initAWS 创建；请求可以用 resultS3Uri 临时指定桶，因此不依赖 RESULT_BUCKET 是否配置。
+var s3Client s3PutAPI
+
+// s3Location：s3://bucket/prefix 解析结果，prefix 不含首尾的 "/"。
+type s3Location struct {
+	bucket string
+	prefix string
+}
+
+// resultS3Location：请求中的 resultS3Uri 优先，否则使用 RESULT_BUCKET（桶名或 s3://bucket/prefix）；
+// 都未配置时返回 nil，结果只内联返回。
+func resultS3Location(body apiRequest) (*s3Location, error) {
+	raw := strings.TrimSpace(body.ResultS3Uri)
+	if raw == "" {
+		raw = strings.TrimSpace(os.Getenv("RESULT_BUCKET"))
+		if raw == "" {
+			return nil, nil
+		}
+		if !strings.HasPrefix(raw, "s3://") {
+			raw = "s3://" + raw
+		}
+	}
+	u, err := url.Parse(raw)
+	if err != nil || u.Scheme != "s3" || u.Host == "" {
+		return nil, fmt.Errorf("invalid resultS3Uri: %q", raw)
+	}
+	return &s3Location{bucket: u.Host, prefix: strings.Trim(u.Path, "/")}, nil
+}
+
+// key：<prefix>/<runId>/<UTC 时间>.json；同一 runId 多次运行按时间区分。runId 原样作为 key 的一部分，转义由 SDK 负责。
+func (l *s3Location) key(runID string, at time.Time) string {
+	k := runID + "/" + at.UTC().Format("20060102T150405.000Z") + ".json"
+	if l.prefix != "" {
+		k = l.prefix + "/" + k
+	}
+	return k
+}
+
+// s3Upload：写入 S3 的结果，合并到 apiResponse 中。
+type s3Upload struct {
+	uri   string
+	putMs float64
+	err   error
+}
+
+// persistResult 把完整结果写入 S3。成功时内联返回 trimmed（为 nil 时仍返回 full），
+// 失败时只记录错误并内联返回完整结果，不影响测量本身。
+func persistResult(ctx context.Context, loc *s3Location, runID string, full, trimmed []byte) ([]byte, *s3Upload) {
+	if loc == nil {
+		return full, nil
+	}
+	key := loc.key(runID, time.Now())
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), s3PutTimeout)
+	defer cancel()
+	start := time.Now()
+	err := putS3Object(ctx, loc.bucket, key, full)
+	up := &s3Upload{uri: "s3://" + loc.bucket + "/" + key, putMs: float64(time.Since(start)) / float64(time.Millisecond), err: err}
+	if err != nil || trimmed == nil {
+		return full, up
+	}
+	return trimmed, up
+}
+
+func (u *s3Upload) apply(r *apiResponse) {
+	if u == nil {
+		return
+	}
+	r.S3PutMs = u.putMs
+	if u.err != nil {
+		r.S3Error = u.err.Error()
+		return
+	}
+	r.ResultS3Uri = u.uri
+}
+
+// putS3Object：单次 PutObject；寻址方式（含带点的桶名回退到路径风格）由 SDK 决定。
+func putS3Object(ctx context.Context, bucket, key string, data []byte) error {
+	_, err := s3Client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:      aws.String(bucket),
+		Key:         aws.String(key),
+		Body:        bytes.NewReader(data),
+		ContentType: aws.String("application/json"),
+	})
+	if err != nil {
+		return fmt.Errorf("put s3 object: %w", err)
+	}
+	return nil
+}
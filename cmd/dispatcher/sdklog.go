@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/smithy-go/logging"
+	"github.com/aws/smithy-go/middleware"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+)
+
+// maxSDKLogBytes：debug.sdkLog 的上限，超出部分丢弃并注明丢弃的字节数。
+const maxSDKLogBytes = 32 << 10
+
+// sdkLogRedactedHeaders：写入 sdkLog 前替换为 REDACTED 的请求头（签名与临时凭证）。
+var sdkLogRedactedHeaders = []string{"authorization", "x-amz-security-token"}
+
+type sdkLogCaptureKey struct{}
+
+// sdkLogCapture：sdkLog=true 时收集本次请求内 SQS 调用的 HTTP 请求（含 body）与响应头，实现 logging.Logger。
+type sdkLogCapture struct {
+	mu      sync.Mutex
+	buf     bytes.Buffer
+	dropped int
+}
+
+// withSDKLogCapture：在 ctx 上挂载捕获缓冲，只影响使用该 ctx 的 SDK 调用。
+func withSDKLogCapture(ctx context.Context) (context.Context, *sdkLogCapture) {
+	c := &sdkLogCapture{}
+	return context.WithValue(ctx, sdkLogCaptureKey{}, c), c
+}
+
+func (c *sdkLogCapture) Logf(_ logging.Classification, format string, v ...any) {
+	entry := redactSDKLog(fmt.Sprintf(format, v...)) + "\n"
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if room := maxSDKLogBytes - c.buf.Len(); len(entry) > room {
+		c.buf.WriteString(entry[:max(room, 0)])
+		c.dropped += len(entry) - max(room, 0)
+		return
+	}
+	c.buf.WriteString(entry)
+}
+
+func (c *sdkLogCapture) String() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.dropped > 0 {
+		return fmt.Sprintf("%s...[truncated %d bytes]", c.buf.String(), c.dropped)
+	}
+	return c.buf.String()
+}
+
+// redactSDKLog 逐行替换敏感请求头的值。
+func redactSDKLog(s string) string {
+	lines := strings.Split(s, "\n")
+	for i, line := range lines {
+		name, _, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		for _, h := range sdkLogRedactedHeaders {
+			if strings.EqualFold(strings.TrimSpace(name), h) {
+				lines[i] = name + ": REDACTED"
+			}
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// withSDKLogging：与 ClientLogMode=LogRequestWithBody|LogResponse 输出相同的内容，但只对挂载了 sdkLogCapture 的 ctx 生效。
+// 共享的 sqsClient 无法按请求切换 ClientLogMode；未挂载时只多一次 ctx 查找，其他请求不受影响。
+func withSDKLogging(o *sqs.Options) {
+	o.APIOptions = append(o.APIOptions, func(stack *middleware.Stack) error {
+		logger := &smithyhttp.RequestResponseLogger{LogRequestWithBody: true, LogResponse: true}
+		return stack.Deserialize.Add(middleware.DeserializeMiddlewareFunc("SDKLogCapture", func(ctx context.Context, in middleware.DeserializeInput, next middleware.DeserializeHandler) (middleware.DeserializeOutput, middleware.Metadata, error) {
+			c, ok := ctx.Value(sdkLogCaptureKey{}).(*sdkLogCapture)
+			if !ok {
+				return next.HandleDeserialize(ctx, in)
+			}
+			return logger.HandleDeserialize(middleware.SetLogger(ctx, c), in, next)
+		}), middleware.After)
+	})
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// serverTiming 由单条样本的时间戳生成 Server-Timing 响应头，供浏览器 devtools / HTTP 追踪工具直接展示。
+// queue/worker/callback 跨 Dispatcher 与 Worker 两台主机的时钟，仅供参考；缺少时间戳的阶段省略。
+func serverTiming(out dispatcherOutput) string {
+	var parts []string
+	add := func(name, desc string, startNano, endNano int64) {
+		if startNano <= 0 || endNano <= 0 || endNano < startNano {
+			return
+		}
+		ms := float64(endNano-startNano) / float64(time.Millisecond)
+		parts = append(parts, fmt.Sprintf("%s;desc=%q;dur=%.3f", name, desc, ms))
+	}
+	add("send", "SendMessage", out.SendStartUnixNano, out.SendEndUnixNano)
+	add("queue", "SQS to Worker", out.SendEndUnixNano, out.WorkerReceiveUnixNano)
+	add("worker", "Worker processing", out.WorkerReceiveUnixNano, out.WorkerDoneUnixNano)
+	if out.CallbackSendMs > 0 || out.CallbackSqsSentTimestampMs > 0 {
+		parts = append(parts, fmt.Sprintf("callback;desc=%q;dur=%d", "Callback SendMessage", out.CallbackSendMs))
+	}
+	add("poll", "Receive poll", out.PollStartUnixNano, out.PollEndUnixNano)
+	return strings.Join(parts, ", ")
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+// attrSignature：SHARED_SECRET 设置时 Push 消息与回调携带的 HMAC-SHA256（hex）消息属性，对象为实际发送的 MessageBody。
+const attrSignature = "signature"
+
+// sharedSecret：SHARED_SECRET，为空表示不签名也不校验。
+func sharedSecret() string {
+	return strings.TrimSpace(os.Getenv("SHARED_SECRET"))
+}
+
+// signBody 返回 b 的 HMAC-SHA256（hex）。
+func signBody(secret string, b []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(b)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// verifySig：常数时间比较 sig 与 signBody(secret, b)；sig 为空（未签名）时返回 false。
+func verifySig(secret string, b []byte, sig string) bool {
+	want, err := hex.DecodeString(strings.TrimSpace(sig))
+	if err != nil || len(want) == 0 {
+		return false
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(b)
+	return hmac.Equal(mac.Sum(nil), want)
+}
+
+// signedAttributes：配置了 SHARED_SECRET 时返回加上 signature 的属性副本（不修改 attrs），否则原样返回。
+func signedAttributes(attrs map[string]types.MessageAttributeValue, body string) map[string]types.MessageAttributeValue {
+	secret := sharedSecret()
+	if secret == "" {
+		return attrs
+	}
+	out := make(map[string]types.MessageAttributeValue, len(attrs)+1)
+	for k, v := range attrs {
+		out[k] = v
+	}
+	out[attrSignature] = types.MessageAttributeValue{DataType: aws.String("String"), StringValue: aws.String(signBody(secret, []byte(body)))}
+	return out
+}
+
+// signatureValid：未配置 SHARED_SECRET 时总是有效；否则要求消息带有与 body 匹配的 signature 属性。
+func signatureValid(m types.Message) bool {
+	secret := sharedSecret()
+	if secret == "" {
+		return true
+	}
+	return verifySig(secret, []byte(aws.ToString(m.Body)), aws.ToString(m.MessageAttributes[attrSignature].StringValue))
+}
@@ -0,0 +1,39 @@
+package main
+
+import "time"
+
+// clockSkew：以 SQS 服务端时间为公共参照，分别估计 Dispatcher 与 Worker 时钟相对 SQS 的偏移（本地时钟 - SQS 时钟）。
+//
+//   - dispatcherOffsetMs = sendEnd - Push 消息 SentTimestamp：SQS 在 SendMessage 返回前打点，
+//     因此估计值偏大（多出 SQS 打点到 sendEnd 的网络回程）。
+//   - workerOffsetMs = callbackSendStart - 回调消息 SentTimestamp：Worker 在 SQS 打点前取时间，
+//     因此估计值偏小（少了回调发送的去程）。
+//
+// skewMs = workerOffsetMs - dispatcherOffsetMs，即 Worker 时钟 - Dispatcher 时钟；两端的误差方向相反，
+// 会一起使 skewMs 偏小，量级约为两次 SendMessage 的单程网络耗时，另有 SentTimestamp 的毫秒截断。
+type clockSkew struct {
+	dispatcherOffsetMs float64
+	workerOffsetMs     float64
+	skewMs             float64
+}
+
+// estimateClockSkew：PUSH_TRANSPORT=sqs 且四个时间戳都存在时返回估计值，否则 ok=false。
+// SNS/EventBridge 传输时 Push 消息的 SentTimestamp 是主题/总线投递到 Push 队列的时间，
+// sendEnd - SentTimestamp 会包含整段扇出耗时，不能当作时钟偏移（否则会从 queueToWorkerUs 中扣掉要测量的那一段）。
+func estimateClockSkew(st sendTimes, pr pollResult) (clockSkew, bool) {
+	cb := pr.cb
+	if pushTransport() != pushTransportSQS || st.sendEnd <= 0 || cb.SqsSentTimestampMs <= 0 || cb.CallbackSendStartUnixNano <= 0 || pr.callbackSqsSentTimestampMs <= 0 {
+		return clockSkew{}, false
+	}
+	d := nanosToMs(st.sendEnd - cb.SqsSentTimestampMs*int64(time.Millisecond))
+	w := nanosToMs(cb.CallbackSendStartUnixNano - pr.callbackSqsSentTimestampMs*int64(time.Millisecond))
+	return clockSkew{dispatcherOffsetMs: d, workerOffsetMs: w, skewMs: w - d}, true
+}
+
+// toDispatcherClock 把 Worker 时钟的时间戳换算到 Dispatcher 时钟（减去 skewMs）。
+func (s clockSkew) toDispatcherClock(workerUnixNano int64) int64 {
+	if workerUnixNano <= 0 {
+		return workerUnixNano
+	}
+	return workerUnixNano - int64(s.skewMs*float64(time.Millisecond))
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+const (
+	// maxSSEComparisonRounds：compareSse 的轮数上限，每轮在两组队列上各往返一条消息。
+	maxSSEComparisonRounds = 10
+	// defaultSSEPrimeRounds/maxSSEPrimeRounds：正式测量前每组队列不计入结果的预热往返次数。
+	defaultSSEPrimeRounds = 1
+	maxSSEPrimeRounds     = 5
+
+	encryptionSSESQS  = "sse-sqs"
+	encryptionSSEKMS  = "sse-kms"
+	encryptionNone    = "none"
+	encryptionUnknown = "unknown"
+
+	sseKMSWarmupNote = "SSE-KMS queues fetch data keys from KMS and cache them for KmsDataKeyReusePeriodSeconds; the first round trips after a cold container or key expiry pay the KMS call. Prime rounds warm the data key cache before measuring; SSE-SQS uses SQS-owned keys and has no KMS call."
+)
+
+// sseComparisonOutput：compareSse 模式的输出；overhead 为 encrypted - plain（正值即加密带来的额外延迟）。
+type sseComparisonOutput struct {
+	RunID            string         `json:"runId"`
+	Rounds           int            `json:"rounds"`
+	PrimeRounds      int            `json:"primeRounds"`
+	Encrypted        sseQueueResult `json:"encrypted"`
+	Plain            sseQueueResult `json:"plain"`
+	OverheadMeanMs   *float64       `json:"overheadMeanMs,omitempty"`
+	OverheadP50Ms    *float64       `json:"overheadP50Ms,omitempty"`
+	Note             string         `json:"note"`
+	Warnings         []string       `json:"warnings,omitempty"`
+	DuplicateIDCount int            `json:"duplicateIdCount,omitempty"`
+}
+
+// sseQueueResult：一组 Push/Receive 队列的往返延迟（receiveMessageUnixNano - sendStartUnixNano）；
+// pushEncryption/receiveEncryption 为 GetQueueAttributes 读到的实际加密配置，用于确认对比的前提成立。
+type sseQueueResult struct {
+	PushQueueName     string       `json:"pushQueueName"`
+	ReceiveQueueName  string       `json:"receiveQueueName"`
+	PushEncryption    string       `json:"pushEncryption"`
+	ReceiveEncryption string       `json:"receiveEncryption"`
+	OK                int          `json:"ok"`
+	Failed            int          `json:"failed"`
+	PrimeLatenciesMs  []float64    `json:"primeLatenciesMs,omitempty"`
+	LatenciesMs       []float64    `json:"latenciesMs"`
+	LatencyMs         latencyStats `json:"latencyMs"`
+	Error             string       `json:"error,omitempty"`
+
+	q queueTargets
+}
+
+// sseQueuePairs：SSE_PUSH_QUEUE_URL/SSE_RECEIVE_QUEUE_URL 与 PLAIN_PUSH_QUEUE_URL/PLAIN_RECEIVE_QUEUE_URL。
+func sseQueuePairs() (encrypted, plain queueTargets, err error) {
+	env := map[string]string{}
+	for _, k := range []string{"SSE_PUSH_QUEUE_URL", "SSE_RECEIVE_QUEUE_URL", "PLAIN_PUSH_QUEUE_URL", "PLAIN_RECEIVE_QUEUE_URL"} {
+		if env[k] = strings.TrimSpace(os.Getenv(k)); env[k] == "" {
+			return queueTargets{}, queueTargets{}, fmt.Errorf("compareSse requires env %s", k)
+		}
+	}
+	pair := func(push, receive string) queueTargets {
+		return queueTargets{pushURL: push, receiveURLs: []string{receive}, pushName: queueNameFromURL(push), receiveName: queueNameFromURL(receive)}
+	}
+	return pair(env["SSE_PUSH_QUEUE_URL"], env["SSE_RECEIVE_QUEUE_URL"]), pair(env["PLAIN_PUSH_QUEUE_URL"], env["PLAIN_RECEIVE_QUEUE_URL"]), nil
+}
+
+func validateSSEComparison(body apiRequest) error {
+	if body.CompareSSE == 0 {
+		if body.SSEPrimeRounds != nil {
+			return errors.New("ssePrimeRounds requires compareSse")
+		}
+		return nil
+	}
+	if body.CompareSSE < 1 || body.CompareSSE > maxSSEComparisonRounds {
+		return fmt.Errorf("compareSse must be 1..%d, got %d", maxSSEComparisonRounds, body.CompareSSE)
+	}
+	if p := body.SSEPrimeRounds; p != nil && (*p < 0 || *p > maxSSEPrimeRounds) {
+		return fmt.Errorf("ssePrimeRounds must be 0..%d, got %d", maxSSEPrimeRounds, *p)
+	}
+	if len(benchmarkModes(body)) > 1 {
+		return errors.New("compareSse cannot be combined with other benchmark modes")
+	}
+	_, _, err := sseQueuePairs()
+	return err
+}
+
+// queueEncryption：队列的服务端加密方式（KmsMasterKeyId 优先于 SqsManagedSseEnabled）；读取失败时为 unknown。
+func queueEncryption(ctx context.Context, queueURL string) string {
+	out, err := sqsClient.GetQueueAttributes(ctx, &sqs.GetQueueAttributesInput{
+		QueueUrl:       &queueURL,
+		AttributeNames: []types.QueueAttributeName{types.QueueAttributeNameKmsMasterKeyId, types.QueueAttributeNameSqsManagedSseEnabled},
+	})
+	switch {
+	case err != nil:
+		return encryptionUnknown
+	case out.Attributes[string(types.QueueAttributeNameKmsMasterKeyId)] != "":
+		return encryptionSSEKMS
+	case out.Attributes[string(types.QueueAttributeNameSqsManagedSseEnabled)] == "true":
+		return encryptionSSESQS
+	}
+	return encryptionNone
+}
+
+// roundTrip 在 r.q 上往返一条消息，返回 receiveMessage - sendStart（毫秒）。
+func (r *sseQueueResult) roundTrip(ctx context.Context, body apiRequest, inv invocationInfo) (float64, error) {
+	samples, err := sendBatch(ctx, body, r.q, inv, sendTimes{dispatchStart: time.Now().UnixNano()}, []int{0})
+	switch {
+	case err != nil:
+		return 0, err
+	case samples[0].Status != "OK":
+		return 0, errors.New(samples[0].Error)
+	}
+	return nanosToMs(samples[0].ReceiveMessageUnixNano - samples[0].SendStartUnixNano), nil
+}
+
+// handleSSEComparison 在加密与不加密两组队列上交替往返（每轮交换先后顺序以抵消预热偏差），消息内容与参数完全相同，
+// 两者的延迟差即为 SSE 的开销。正式测量前先各做 ssePrimeRounds 次不计入结果的往返，排除 KMS 数据密钥冷启动。
+func handleSSEComparison(ctx context.Context, body apiRequest, inv invocationInfo) (events.APIGatewayProxyResponse, error) {
+	dispatchStart := time.Now().UnixNano()
+	encryptedQ, plainQ, err := sseQueuePairs()
+	if err != nil {
+		return jsonResp(400, apiResponse{Status: "ERROR", Error: err.Error()})
+	}
+	prime := defaultSSEPrimeRounds
+	if body.SSEPrimeRounds != nil {
+		prime = *body.SSEPrimeRounds
+	}
+	out := sseComparisonOutput{RunID: body.RunID, PrimeRounds: prime, Note: sseKMSWarmupNote}
+	out.Encrypted = sseQueueResult{PushQueueName: encryptedQ.pushName, ReceiveQueueName: encryptedQ.receiveName, q: encryptedQ}
+	out.Plain = sseQueueResult{PushQueueName: plainQ.pushName, ReceiveQueueName: plainQ.receiveName, q: plainQ}
+	for _, r := range []*sseQueueResult{&out.Encrypted, &out.Plain} {
+		r.PushEncryption = queueEncryption(ctx, r.q.pushURL)
+		r.ReceiveEncryption = queueEncryption(ctx, r.q.receiveURLs[0])
+	}
+	// 对比的前提：一组加密、一组不加密；不成立时仍照常测量，但在 warnings 中说明。
+	if out.Encrypted.PushEncryption == encryptionNone && out.Encrypted.ReceiveEncryption == encryptionNone {
+		out.Warnings = append(out.Warnings, "SSE queue pair has no server-side encryption")
+	}
+	if out.Plain.PushEncryption != encryptionNone && out.Plain.PushEncryption != encryptionUnknown ||
+		out.Plain.ReceiveEncryption != encryptionNone && out.Plain.ReceiveEncryption != encryptionUnknown {
+		out.Warnings = append(out.Warnings, "plain queue pair has server-side encryption enabled")
+	}
+
+	for _, r := range []*sseQueueResult{&out.Encrypted, &out.Plain} {
+		for i := 0; i < prime && ctx.Err() == nil; i++ {
+			if ms, err := r.roundTrip(ctx, body, inv); err == nil {
+				r.PrimeLatenciesMs = append(r.PrimeLatenciesMs, ms)
+			}
+		}
+	}
+	for round := 0; round < body.CompareSSE && ctx.Err() == nil; round++ {
+		order := []*sseQueueResult{&out.Encrypted, &out.Plain}
+		if round%2 == 1 {
+			order[0], order[1] = order[1], order[0]
+		}
+		for _, r := range order {
+			ms, err := r.roundTrip(ctx, body, inv)
+			if err != nil {
+				r.Failed++
+				r.Error = err.Error()
+				continue
+			}
+			r.OK++
+			r.LatenciesMs = append(r.LatenciesMs, ms)
+		}
+		out.Rounds++
+	}
+	out.Encrypted.LatencyMs, out.Plain.LatencyMs = newLatencyStats(out.Encrypted.LatenciesMs), newLatencyStats(out.Plain.LatenciesMs)
+	if out.Encrypted.OK > 0 && out.Plain.OK > 0 {
+		mean, p50 := out.Encrypted.LatencyMs.Mean-out.Plain.LatencyMs.Mean, out.Encrypted.LatencyMs.P50-out.Plain.LatencyMs.P50
+		out.OverheadMeanMs, out.OverheadP50Ms = &mean, &p50
+	}
+	out.DuplicateIDCount = body.ids.duplicateCount()
+
+	code, status := 200, "OK"
+	switch {
+	case out.Encrypted.OK == 0 && out.Plain.OK == 0 && ctx.Err() != nil:
+		code, status = 504, "TIMEOUT"
+	case out.Encrypted.OK == 0 && out.Plain.OK == 0:
+		code, status = 502, "ERROR"
+	case out.Encrypted.Failed > 0 || out.Plain.Failed > 0 || out.Rounds < body.CompareSSE:
+		status = "PARTIAL"
+	}
+	outBytes, _ := json.Marshal(out)
+	elapsedNs := time.Now().UnixNano() - dispatchStart
+	elapsedMs := elapsedNs / int64(time.Millisecond)
+	results.put(storedResult{RunID: body.RunID, StoredAt: time.Now(), TotalMs: elapsedMs, Output: outBytes})
+
+	outBytes, upload := persistResult(ctx, body.resultS3, body.RunID, outBytes, nil)
+
+	outBytes, err = formatOutput(outBytes, body.OutputFormat)
+	if err != nil {
+		return jsonResp(500, apiResponse{Status: "ERROR", Error: err.Error()})
+	}
+	apiOut := apiResponse{Status: status, TotalMs: elapsedMs, TotalUs: nanosToUs(elapsedNs), Output: outBytes, PerceivedLatencyMs: perceivedLatencyMs(body.requestTimeEpochMs)}
+	upload.apply(&apiOut)
+	return jsonResp(code, apiOut)
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+// staleCallbackThreshold：STALE_CALLBACK_MS，外部回调超过该年龄即删除而不是释放可见性；0（默认）表示不删除。
+// 需大于任何在途请求的最长等待（含 delaySeconds），否则会删掉其他容器仍在等待的回调。
+func staleCallbackThreshold() time.Duration {
+	return time.Duration(max(envIntDefault("STALE_CALLBACK_MS", 0), 0)) * time.Millisecond
+}
+
+// staleCallbackAge：回调消息自身的 SentTimestamp 距今的时长；缺失时退回 body 中回显的 Push 消息 sqsSentTimestampMs（更早，偏保守地放大年龄）。
+// 超过 STALE_CALLBACK_MS 时 stale=true。
+func staleCallbackAge(m types.Message, pushSentMs int64) (time.Duration, bool) {
+	threshold := staleCallbackThreshold()
+	if threshold <= 0 {
+		return 0, false
+	}
+	sentMs := parseInt64OrZero(m.Attributes[string(types.MessageSystemAttributeNameSentTimestamp)])
+	if sentMs == 0 {
+		sentMs = pushSentMs
+	}
+	if sentMs == 0 {
+		return 0, false
+	}
+	age := time.Since(time.UnixMilli(sentMs))
+	return age, age > threshold
+}
+
+// deleteStaleCallback：runId 不属于本次请求且已过期的外部回调直接删除（超时后才到达的回调无人认领，释放只会让它在队列中反复出现）。
+// 返回 true 表示已删除。
+func deleteStaleCallback(ctx context.Context, receiveQueueURL string, m types.Message, runID, id string, pushSentMs int64) bool {
+	age, stale := staleCallbackAge(m, pushSentMs)
+	if !stale || m.ReceiptHandle == nil {
+		return false
+	}
+	if _, err := sqsClient.DeleteMessage(ctx, &sqs.DeleteMessageInput{QueueUrl: &receiveQueueURL, ReceiptHandle: m.ReceiptHandle}); err != nil {
+		return false
+	}
+	slog.Debug("deleted stale callback", "runId", runID, "id", id, "ageMs", age.Milliseconds(), "queue", queueNameFromURL(receiveQueueURL))
+	return true
+}
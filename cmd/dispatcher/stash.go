@@ -0,0 +1,85 @@
+package main
+
+import "sync"
+
+// callbackStash 是同一进程内并发请求共享的回调暂存区。
+//
+// 轮询时收到的回调如果属于本进程的另一个在途请求，就从队列删除并暂存到这里，
+// 对应请求在发起 ReceiveMessage 之前先查暂存区，命中即可省去一次网络往返。
+// 不属于本进程在途请求的回调不会被暂存（仍按原逻辑释放可见性），避免"截走"其他容器的消息。
+type callbackStash struct {
+	mu       sync.Mutex
+	inFlight map[string]bool
+	stashed  map[string]stashedCallback
+
+	lookups int64
+	hits    int64
+}
+
+type stashedCallback struct {
+	cb                     callbackMessage
+	receiveMessageUnixNano int64
+	sqsSentTimestampMs     int64
+	receiveQueueName       string
+}
+
+var stash = newCallbackStash()
+
+func newCallbackStash() *callbackStash {
+	return &callbackStash{inFlight: map[string]bool{}, stashed: map[string]stashedCallback{}}
+}
+
+func stashKey(runID, id string) string { return runID + "/" + id }
+
+// register 在发送前登记在途请求；返回的函数用于请求结束时注销并清理残留暂存。
+func (s *callbackStash) register(runID, id string) func() {
+	k := stashKey(runID, id)
+	s.mu.Lock()
+	s.inFlight[k] = true
+	s.mu.Unlock()
+	return func() {
+		s.mu.Lock()
+		delete(s.inFlight, k)
+		delete(s.stashed, k)
+		s.mu.Unlock()
+	}
+}
+
+// offer 暂存属于本进程在途请求的回调；返回 false 表示不属于本进程，调用方应按外部消息处理。
+func (s *callbackStash) offer(sc stashedCallback) bool {
+	k := stashKey(sc.cb.RunID, sc.cb.ID)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.inFlight[k] {
+		return false
+	}
+	s.stashed[k] = sc
+	return true
+}
+
+// inFlightFor：runID/id 是否为本进程的在途请求（决定外部回调是否需要解析后暂存）。
+func (s *callbackStash) inFlightFor(runID, id string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.inFlight[stashKey(runID, id)]
+}
+
+func (s *callbackStash) take(runID, id string) (stashedCallback, bool) {
+	k := stashKey(runID, id)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lookups++
+	sc, ok := s.stashed[k]
+	if ok {
+		s.hits++
+		delete(s.stashed, k)
+	}
+	return sc, ok
+}
+
+// stats 返回本容器累计的暂存区查询次数与命中次数。
+func (s *callbackStash) stats() (lookups, hits int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.lookups, s.hits
+}
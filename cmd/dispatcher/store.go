@@ -0,0 +1,92 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// resultStore 在热容器内保留最近 N 条完整的 dispatcherOutput，按 runId/id 索引，
+// 供客户端在丢失响应（例如 API Gateway 超时）时通过 fetch 请求取回。
+//
+// 注意：这是尽力而为的调试手段。每个容器各自独立，容器回收或冷启动后即丢失；
+// 同一个 runId 的 fetch 也可能被路由到另一个容器而查不到。
+type resultStore struct {
+	mu         sync.Mutex
+	maxEntries int
+	ttl        time.Duration
+	entries    []storedResult // 按写入时间升序
+}
+
+type storedResult struct {
+	RunID    string
+	ID       string
+	StoredAt time.Time
+	TotalMs  int64
+	Output   json.RawMessage
+}
+
+var results = newResultStore(
+	envIntDefault("RESULT_STORE_MAX_ENTRIES", 100),
+	time.Duration(envIntDefault("RESULT_STORE_TTL_SECONDS", 900))*time.Second,
+)
+
+func newResultStore(maxEntries int, ttl time.Duration) *resultStore {
+	if maxEntries < 0 {
+		maxEntries = 0
+	}
+	return &resultStore{maxEntries: maxEntries, ttl: ttl}
+}
+
+func (s *resultStore) put(r storedResult) {
+	if s.maxEntries == 0 {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.evictLocked(r.StoredAt)
+	if len(s.entries) >= s.maxEntries {
+		s.entries = s.entries[len(s.entries)-s.maxEntries+1:]
+	}
+	s.entries = append(s.entries, r)
+}
+
+// get 按 runId（必填）与 id（可选）查找；id 为空时返回该 runId 最近的一条。
+func (s *resultStore) get(runID, id string) (storedResult, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.evictLocked(time.Now())
+	for i := len(s.entries) - 1; i >= 0; i-- {
+		e := s.entries[i]
+		if e.RunID == runID && (id == "" || e.ID == id) {
+			return e, true
+		}
+	}
+	return storedResult{}, false
+}
+
+func (s *resultStore) evictLocked(now time.Time) {
+	if s.ttl <= 0 {
+		return
+	}
+	n := 0
+	for n < len(s.entries) && now.Sub(s.entries[n].StoredAt) > s.ttl {
+		n++
+	}
+	s.entries = s.entries[n:]
+}
+
+func envIntDefault(key string, def int) int {
+	v := strings.TrimSpace(os.Getenv(key))
+	if v == "" {
+		return def
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		return def
+	}
+	return n
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"errors"
+	"time"
+)
+
+const (
+	clockDispatcher = "dispatcher"
+	clockWorker     = "worker"
+)
+
+// skewMethod 的取值：sqsSentTimestamp 复用顶层的 estimatedSkewMs（见 estimateClockSkew），symmetric 为对称往返假设（见 estimateWorkerSkewNs）。
+const (
+	skewMethodSQSSentTimestamp = "sqsSentTimestamp"
+	skewMethodSymmetric        = "symmetric"
+)
+
+// monotonicTimeline：monotonicTimeline=true 时的展示用时间线。Dispatcher 与 Worker 时钟独立，原始序列可能因偏差倒挂
+// （例如 workerReceive 早于 sendEnd）；这里按估计的偏差平移 Worker 侧时间戳，原始值保留在 rawUnixNano 与顶层字段中。
+type monotonicTimeline struct {
+	// EstimatedSkewMs：Worker 时钟减 Dispatcher 时钟的估计值；有顶层 estimatedSkewMs 时与之相同。
+	EstimatedSkewMs float64 `json:"estimatedSkewMs"`
+	// SkewMethod：EstimatedSkewMs 的来源（sqsSentTimestamp | symmetric）。
+	SkewMethod string `json:"skewMethod"`
+	// RawMonotonic：未修正的原始序列本身是否单调（false 说明原始时间线会显示倒挂）。
+	RawMonotonic bool            `json:"rawMonotonic"`
+	Events       []timelineEvent `json:"events"`
+}
+
+// timelineEvent：sinceSendStartMs 以修正后的时间戳相对 sendStart 计算；corrected=true 表示该值经过平移。
+type timelineEvent struct {
+	Name             string  `json:"name"`
+	Clock            string  `json:"clock"`
+	UnixNano         int64   `json:"unixNano"`
+	RawUnixNano      int64   `json:"rawUnixNano"`
+	SinceSendStartMs float64 `json:"sinceSendStartMs"`
+	Corrected        bool    `json:"corrected,omitempty"`
+}
+
+func validateMonotonicTimeline(body apiRequest) error {
+	if !body.MonotonicTimeline {
+		return nil
+	}
+	if len(benchmarkModes(body)) > 0 {
+		return errors.New("monotonicTimeline is only supported in single-message mode")
+	}
+	return nil
+}
+
+// estimateWorkerSkewNs：假设去程（sendEnd→workerReceive）与回程（callbackSendStart→receiveMessage）耗时相等（同 NTP），
+// 偏差 = ((workerReceive - sendEnd) + (callbackSendStart - receiveMessage)) / 2。平移后两段都等于
+// (往返 - Worker 内耗时) / 2 ≥ 0，因此修正后的序列一定单调。缺少任一时间戳时返回 false。
+func estimateWorkerSkewNs(o *dispatcherOutput) (int64, bool) {
+	if o.SendEndUnixNano <= 0 || o.WorkerReceiveUnixNano <= 0 || o.CallbackSendStartUnixNano <= 0 || o.ReceiveMessageUnixNano <= 0 {
+		return 0, false
+	}
+	return ((o.WorkerReceiveUnixNano - o.SendEndUnixNano) + (o.CallbackSendStartUnixNano - o.ReceiveMessageUnixNano)) / 2, true
+}
+
+// newMonotonicTimeline 由单条样本的原始时间戳构建修正后的时间线；无法估计偏差时返回 nil。
+// 已按 SQS SentTimestamp 估计出偏差（o.EstimatedSkewMs）时沿用它，使同一响应只有一个偏差值；否则退回对称估计。
+// 前者不保证平移后严格单调（估计本身偏小约两次单程网络耗时），后者一定单调。
+func newMonotonicTimeline(o *dispatcherOutput) *monotonicTimeline {
+	skew, ok := estimateWorkerSkewNs(o)
+	if !ok {
+		return nil
+	}
+	method := skewMethodSymmetric
+	if o.EstimatedSkewMs != nil {
+		skew, method = int64(*o.EstimatedSkewMs*float64(time.Millisecond)), skewMethodSQSSentTimestamp
+	}
+	raw := []struct {
+		name  string
+		clock string
+		ns    int64
+	}{
+		{"sendStart", clockDispatcher, o.SendStartUnixNano},
+		{"sendEnd", clockDispatcher, o.SendEndUnixNano},
+		{"workerReceive", clockWorker, o.WorkerReceiveUnixNano},
+		{"workerDone", clockWorker, o.WorkerDoneUnixNano},
+		{"callbackSendStart", clockWorker, o.CallbackSendStartUnixNano},
+		{"receiveMessage", clockDispatcher, o.ReceiveMessageUnixNano},
+	}
+	tl := &monotonicTimeline{EstimatedSkewMs: float64(skew) / float64(time.Millisecond), SkewMethod: method, RawMonotonic: true}
+	var prevRaw int64
+	for _, r := range raw {
+		if r.ns <= 0 {
+			continue
+		}
+		if r.ns < prevRaw {
+			tl.RawMonotonic = false
+		}
+		prevRaw = r.ns
+		ev := timelineEvent{Name: r.name, Clock: r.clock, UnixNano: r.ns, RawUnixNano: r.ns}
+		if r.clock == clockWorker && skew != 0 {
+			ev.UnixNano, ev.Corrected = r.ns-skew, true
+		}
+		ev.SinceSendStartMs = nanosToMs(ev.UnixNano - o.SendStartUnixNano)
+		tl.Events = append(tl.Events, ev)
+	}
+	return tl
+}
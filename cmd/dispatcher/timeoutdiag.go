@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+const (
+	diagnosisNoReply            = "no_reply"
+	diagnosisMismatchedReply    = "mismatched_reply"
+	diagnosisInefficientPolling = "inefficient_polling"
+
+	// inefficientPollOverheadRatio：轮询窗口中不在 ReceiveMessage 内的时间占比超过该值即视为轮询效率低。
+	inefficientPollOverheadRatio = 0.2
+)
+
+// timeoutDiagnostics：单条模式超时时的部分时间线（timeoutDiagnostics=true），由 pollAttempts 与 pollDebug 的逐次记录汇总而来。
+// diagnosis：no_reply（轮询正常但一直没有回调）、mismatched_reply（收到过 sendStart 不匹配或 runId/id 只对上一半的回调，
+// 多为上一次尝试的迟到回调或 id 复用）、inefficient_polling（过早返回或轮询之间的空档占比过高）。
+type timeoutDiagnostics struct {
+	Phase        string  `json:"phase"`
+	SendMs       float64 `json:"sendMs"`
+	PollWindowMs float64 `json:"pollWindowMs"`
+	PollAttempts int     `json:"pollAttempts"`
+	// EmptyPollWaitMs：每次返回 0 条消息的 ReceiveMessage 的耗时；pollTimeMs 为全部 ReceiveMessage 耗时之和，
+	// shortPollBackoffMs 为短轮询之间有意的等待（见 shortPollBackoff），
+	// pollOverheadMs = pollWindowMs - pollTimeMs - shortPollBackoffMs（删除、释放可见性、重试退避等）。
+	EmptyPolls         int       `json:"emptyPolls"`
+	EmptyPollWaitMs    []float64 `json:"emptyPollWaitMs,omitempty"`
+	PollTimeMs         float64   `json:"pollTimeMs"`
+	ShortPollBackoffMs float64   `json:"shortPollBackoffMs,omitempty"`
+	PollOverheadMs     float64   `json:"pollOverheadMs"`
+	PrematureReturns   int       `json:"prematureReturns"`
+	ForeignGrabs       int       `json:"foreignGrabs"`
+	SendStartRejects   int       `json:"sendStartRejects"`
+	NearMisses         int       `json:"nearMisses"`
+	// RemainingBudgetMs：放弃时 maxWait 截止时间的剩余；RemainingInvocationMs：Lambda 调用截止时间的剩余（无截止时间时省略）。
+	RemainingBudgetMs     float64  `json:"remainingBudgetMs"`
+	RemainingInvocationMs *float64 `json:"remainingInvocationMs,omitempty"`
+	// SharedReceiver：由共享接收循环等待（SHARED_RECEIVER），没有逐次轮询记录。
+	SharedReceiver bool   `json:"sharedReceiver,omitempty"`
+	Diagnosis      string `json:"diagnosis"`
+}
+
+func validateTimeoutDiagnostics(body apiRequest) error {
+	if !body.TimeoutDiagnostics {
+		return nil
+	}
+	if len(benchmarkModes(body)) > 0 {
+		return errors.New("timeoutDiagnostics is only supported in single-message mode")
+	}
+	return nil
+}
+
+// newTimeoutDiagnostics 在轮询超时后调用：callCtx 为带 maxWait 截止时间的 ctx，ctx 为 Lambda 调用的 ctx。
+func newTimeoutDiagnostics(ctx, callCtx context.Context, st sendTimes, pr pollResult, d *pollDebug) *timeoutDiagnostics {
+	now := time.Now()
+	td := &timeoutDiagnostics{
+		Phase:            "poll",
+		SendMs:           nanosToMs(st.sendEnd - st.sendStart),
+		PollWindowMs:     nanosToMs(now.UnixNano() - st.pollStart),
+		PollAttempts:     pr.pollAttempts,
+		ForeignGrabs:     pr.foreignGrabs,
+		SendStartRejects: pr.sendStartRejects,
+		SharedReceiver:   sharedReceiverEnabled(),
+	}
+	if deadline, ok := callCtx.Deadline(); ok {
+		td.RemainingBudgetMs = max(nanosToMs(int64(deadline.Sub(now))), 0)
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		ms := max(nanosToMs(int64(deadline.Sub(now))), 0)
+		td.RemainingInvocationMs = &ms
+	}
+	if d != nil {
+		for i, ms := range d.PollLatenciesMs {
+			td.PollTimeMs += ms
+			if d.MessagesPerPoll[i] == 0 {
+				td.EmptyPolls++
+				td.EmptyPollWaitMs = append(td.EmptyPollWaitMs, ms)
+			}
+		}
+		td.PrematureReturns, td.NearMisses, td.ShortPollBackoffMs = d.PrematureReturns, d.NearMisses, d.ShortPollBackoffMs
+		if !td.SharedReceiver {
+			td.PollOverheadMs = max(td.PollWindowMs-td.PollTimeMs-td.ShortPollBackoffMs, 0)
+		}
+	}
+	switch {
+	case td.SendStartRejects > 0 || td.NearMisses > 0:
+		td.Diagnosis = diagnosisMismatchedReply
+	case td.PrematureReturns > 0 || (td.PollWindowMs > 0 && td.PollOverheadMs/td.PollWindowMs > inefficientPollOverheadRatio):
+		td.Diagnosis = diagnosisInefficientPolling
+	default:
+		td.Diagnosis = diagnosisNoReply
+	}
+	return td
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"strings"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+func validateForceSample(body apiRequest) error {
+	if !body.ForceSample {
+		return nil
+	}
+	if pushTransport() != pushTransportSQS {
+		return errors.New("forceSample requires PUSH_TRANSPORT=sqs (AWSTraceHeader is an SQS system attribute)")
+	}
+	if len(benchmarkModes(body)) > 0 {
+		return errors.New("forceSample is only supported in single-message mode")
+	}
+	return nil
+}
+
+// forceSampleTraceHeader：forceSample=true 时 Push 消息携带的 AWSTraceHeader。沿用本次调用所在的 trace
+// （Lambda 的 _X_AMZN_TRACE_ID，其次 API Gateway 的 X-Amzn-Trace-Id），保留 Root/Parent 与其余字段（如 Lineage），
+// 只把 Sampled 改为 1；两者都没有时生成新的 Root。
+func forceSampleTraceHeader(req events.APIGatewayProxyRequest) string {
+	base := strings.TrimSpace(os.Getenv("_X_AMZN_TRACE_ID"))
+	if traceHeaderField(base, "Root") == "" {
+		base = headerValue(req.Headers, "X-Amzn-Trace-Id")
+	}
+	if traceHeaderField(base, "Root") == "" {
+		base = newTraceHeader()
+	}
+	var fields []string
+	sampled := false
+	for _, f := range strings.Split(base, ";") {
+		k, _, _ := strings.Cut(strings.TrimSpace(f), "=")
+		switch {
+		case k == "":
+			continue
+		case k == "Sampled":
+			f, sampled = "Sampled=1", true
+		}
+		fields = append(fields, strings.TrimSpace(f))
+	}
+	if !sampled {
+		fields = append(fields, "Sampled=1")
+	}
+	return strings.Join(fields, ";")
+}
+
+// traceHeaderField：X-Ray trace header（key=value;...）中 key 的值，不存在时返回空串。
+func traceHeaderField(h, key string) string {
+	for _, f := range strings.Split(h, ";") {
+		if k, v, ok := strings.Cut(strings.TrimSpace(f), "="); ok && k == key {
+			return v
+		}
+	}
+	return ""
+}
+
+func headerValue(headers map[string]string, name string) string {
+	for k, v := range headers {
+		if strings.EqualFold(k, name) {
+			return strings.TrimSpace(v)
+		}
+	}
+	return ""
+}
+
+func traceHeaderSystemAttributes(traceHeader string) map[string]types.MessageSystemAttributeValue {
+	return map[string]types.MessageSystemAttributeValue{
+		string(types.MessageSystemAttributeNameForSendsAWSTraceHeader): {DataType: aws.String("String"), StringValue: aws.String(traceHeader)},
+	}
+}
+
+// applyTraceSampling：输出实际发送的 trace id 与采样决定；Worker 回显了收到的 AWSTraceHeader 时一并给出它看到的采样位。
+func applyTraceSampling(out *dispatcherOutput, sent, workerReceived string) {
+	sampled := traceHeaderField(sent, "Sampled") == "1"
+	out.TraceID, out.TraceSampled = traceHeaderField(sent, "Root"), &sampled
+	if workerReceived != "" {
+		workerSampled := traceHeaderField(workerReceived, "Sampled") == "1"
+		out.WorkerTraceSampled = &workerSampled
+	}
+}
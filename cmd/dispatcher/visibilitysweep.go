@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+const (
+	// maxVisibilitySweepValues：visibilitySweep 最多的取值个数。
+	maxVisibilitySweepValues = 10
+	// maxVisibilitySweepSeconds：单个取值的上限；更长的可见性超时会超出一次请求的截止时间，无法观察到效果。
+	maxVisibilitySweepSeconds = 30
+	// defaultVisibilitySweepConcurrency：未指定 visibilitySweepConcurrency 时每级的并发请求数。
+	defaultVisibilitySweepConcurrency = 4
+	// visibilitySweepMinLevelBudget：剩余时间不足时不再启动下一级。
+	visibilitySweepMinLevelBudget = 3 * time.Second
+)
+
+// visibilitySweepOutput：可见性超时扫描模式的输出。
+type visibilitySweepOutput struct {
+	RunID       string                 `json:"runId"`
+	Concurrency int                    `json:"concurrency"`
+	Levels      []visibilitySweepLevel `json:"levels"`
+	// RecommendedVisibilityTimeoutSeconds：全部成功的取值中 foreignGrabCount 最少者（相同时取 p99 更低者）。
+	RecommendedVisibilityTimeoutSeconds *int `json:"recommendedVisibilityTimeoutSeconds,omitempty"`
+	StoppedEarly                        bool `json:"stoppedEarly,omitempty"`
+	DuplicateIDCount                    int  `json:"duplicateIdCount,omitempty"`
+}
+
+// visibilitySweepLevel：单个可见性超时取值的结果；延迟为 receiveMessageUnixNano - sendStartUnixNano（Dispatcher 单调时钟）。
+type visibilitySweepLevel struct {
+	VisibilityTimeoutSeconds int `json:"visibilityTimeoutSeconds"`
+	OK                       int `json:"ok"`
+	Failed                   int `json:"failed"`
+	// ForeignGrabCount：本级所有样本轮询时收到的其他请求的回调数之和。
+	ForeignGrabCount int     `json:"foreignGrabCount"`
+	P50Ms            float64 `json:"p50Ms"`
+	P99Ms            float64 `json:"p99Ms"`
+	Error            string  `json:"error,omitempty"`
+}
+
+func validateVisibilitySweep(body apiRequest) error {
+	if len(body.VisibilitySweep) == 0 {
+		if body.VisibilitySweepConcurrency != 0 {
+			return fmt.Errorf("visibilitySweepConcurrency requires visibilitySweep")
+		}
+		return nil
+	}
+	if len(body.VisibilitySweep) > maxVisibilitySweepValues {
+		return fmt.Errorf("visibilitySweep supports at most %d values, got %d", maxVisibilitySweepValues, len(body.VisibilitySweep))
+	}
+	for i, v := range body.VisibilitySweep {
+		if v < 0 || v > maxVisibilitySweepSeconds {
+			return fmt.Errorf("visibilitySweep[%d]=%d out of range 0..%d", i, v, maxVisibilitySweepSeconds)
+		}
+	}
+	if body.VisibilitySweepConcurrency < 0 || body.VisibilitySweepConcurrency > maxBatchEntries {
+		return fmt.Errorf("visibilitySweepConcurrency must be 1..%d, got %d", maxBatchEntries, body.VisibilitySweepConcurrency)
+	}
+	if len(body.BatchDelaySeconds) > 0 || body.RampMaxConcurrency > 0 || body.CompareAttributes > 0 || body.OneWay || body.FailMode != "" || body.Iterations > 1 {
+		return fmt.Errorf("visibilitySweep cannot be combined with batchDelaySeconds, rampMaxConcurrency, compareAttributes, oneWay, failMode or iterations")
+	}
+	return nil
+}
+
+// handleVisibilitySweep 以固定并发（共享 Receive 队列）依次用 visibilitySweep 中的每个可见性超时轮询回调，
+// 记录各取值下的 foreignGrabCount 与匹配延迟，并给出争用最少的取值。
+func handleVisibilitySweep(ctx context.Context, body apiRequest, q queueTargets, inv invocationInfo) (events.APIGatewayProxyResponse, error) {
+	dispatchStart := time.Now().UnixNano()
+	c := body.VisibilitySweepConcurrency
+	if c == 0 {
+		c = defaultVisibilitySweepConcurrency
+	}
+	out := visibilitySweepOutput{RunID: body.RunID, Concurrency: c}
+
+	for _, v := range body.VisibilitySweep {
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < visibilitySweepMinLevelBudget {
+			out.StoppedEarly = true
+			break
+		}
+		out.Levels = append(out.Levels, runVisibilitySweepLevel(ctx, body, q, inv, c, v))
+	}
+	out.RecommendedVisibilityTimeoutSeconds = recommendVisibilityTimeout(out.Levels)
+	out.DuplicateIDCount = body.ids.duplicateCount()
+
+	code, status := 200, "OK"
+	switch {
+	case len(out.Levels) == 0:
+		code, status = 504, "TIMEOUT"
+	case out.StoppedEarly || out.RecommendedVisibilityTimeoutSeconds == nil:
+		status = "PARTIAL"
+	}
+	outBytes, _ := json.Marshal(out)
+	elapsedNs := time.Now().UnixNano() - dispatchStart
+	elapsedMs := elapsedNs / int64(time.Millisecond)
+	results.put(storedResult{RunID: body.RunID, StoredAt: time.Now(), TotalMs: elapsedMs, Output: outBytes})
+
+	// 每级只有汇总数据，内联输出本身已足够精简。
+	outBytes, upload := persistResult(ctx, body.resultS3, body.RunID, outBytes, nil)
+
+	outBytes, err := formatOutput(outBytes, body.OutputFormat)
+	if err != nil {
+		return jsonResp(500, apiResponse{Status: "ERROR", Error: err.Error()})
+	}
+	apiOut := apiResponse{Status: status, TotalMs: elapsedMs, TotalUs: nanosToUs(elapsedNs), Output: outBytes, PerceivedLatencyMs: perceivedLatencyMs(body.requestTimeEpochMs)}
+	upload.apply(&apiOut)
+	return jsonResp(code, apiOut)
+}
+
+func runVisibilitySweepLevel(ctx context.Context, body apiRequest, q queueTargets, inv invocationInfo, c, visibility int) visibilitySweepLevel {
+	level := visibilitySweepLevel{VisibilityTimeoutSeconds: visibility}
+	v := int32(visibility)
+	body.visibilityTimeout = &v
+	samples, err := sendBatch(ctx, body, q, inv, sendTimes{dispatchStart: time.Now().UnixNano()}, make([]int, c))
+	if err != nil {
+		level.Failed = c
+		level.Error = err.Error()
+		return level
+	}
+
+	var latencies []float64
+	for _, s := range samples {
+		level.ForeignGrabCount += s.ForeignGrabCount
+		if s.Status != "OK" {
+			level.Failed++
+			if level.Error == "" {
+				level.Error = s.Error
+			}
+			continue
+		}
+		level.OK++
+		latencies = append(latencies, nanosToMs(s.ReceiveMessageUnixNano-s.SendStartUnixNano))
+	}
+	stats := newLatencyStats(latencies)
+	level.P50Ms, level.P99Ms = stats.P50, stats.P99
+	return level
+}
+
+// recommendVisibilityTimeout：只考虑没有失败样本的取值；都有失败时返回 nil。
+func recommendVisibilityTimeout(levels []visibilitySweepLevel) *int {
+	var best *visibilitySweepLevel
+	for i := range levels {
+		l := &levels[i]
+		if l.Failed > 0 || l.OK == 0 {
+			continue
+		}
+		if best == nil || l.ForeignGrabCount < best.ForeignGrabCount || (l.ForeignGrabCount == best.ForeignGrabCount && l.P99Ms < best.P99Ms) {
+			best = l
+		}
+	}
+	if best == nil {
+		return nil
+	}
+	v := best.VisibilityTimeoutSeconds
+	return &v
+}
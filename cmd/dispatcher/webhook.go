@@ -0,0 +1,66 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// webhookTimeout：后台投递结果的 POST 超时。
+const webhookTimeout = 10 * time.Second
+
+// validateResultWebhook：只允许 http/https，且主机必须在 RESULT_WEBHOOK_ALLOWLIST（逗号分隔的主机名）中；
+// 未配置允许列表时拒绝所有 webhook。
+func validateResultWebhook(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Hostname() == "" {
+		return fmt.Errorf("invalid resultWebhook: %q", raw)
+	}
+	for _, h := range strings.Split(os.Getenv("RESULT_WEBHOOK_ALLOWLIST"), ",") {
+		if strings.EqualFold(strings.TrimSpace(h), u.Hostname()) {
+			return nil
+		}
+	}
+	return fmt.Errorf("resultWebhook host %q not in RESULT_WEBHOOK_ALLOWLIST", u.Hostname())
+}
+
+// acceptWithWebhook 立即返回 202，并在后台 goroutine 中完成整个往返后把最终响应 POST 到 webhook。
+// 仅用于 HTTP 变体：Lambda 在返回响应后会冻结执行环境，后台 goroutine 无法可靠完成。
+func acceptWithWebhook(body apiRequest) (events.APIGatewayProxyResponse, error) {
+	webhook := body.ResultWebhook
+	body.ResultWebhook = ""
+	reqBytes, _ := json.Marshal(body)
+
+	go func() {
+		resp, _ := handler(context.Background(), events.APIGatewayProxyRequest{Body: string(reqBytes)})
+		ctx, cancel := context.WithTimeout(context.Background(), webhookTimeout)
+		defer cancel()
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhook, bytes.NewReader([]byte(resp.Body)))
+		if err != nil {
+			log.Printf("result webhook: runId=%s err=%v", body.RunID, err)
+			return
+		}
+		req.Header.Set("Content-Type", "application/json")
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			log.Printf("result webhook: runId=%s err=%v", body.RunID, err)
+			return
+		}
+		_ = res.Body.Close()
+		if res.StatusCode >= 300 {
+			log.Printf("result webhook: runId=%s status=%d", body.RunID, res.StatusCode)
+		}
+	}()
+
+	out, _ := json.Marshal(map[string]string{"runId": body.RunID})
+	return jsonResp(202, apiResponse{Status: "ACCEPTED", Output: out})
+}
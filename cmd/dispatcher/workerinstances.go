@@ -0,0 +1,62 @@
+package main
+
+import (
+	"errors"
+	"sort"
+)
+
+// workerInstanceStats：byWorkerInstance=true 时按 workerInstanceId 分组的管线延迟（receiveMessage - sendStart，Dispatcher 单调时钟），
+// 按 p99 从高到低排序，持续偏慢的容器（邻居干扰、硬件退化）排在最前。
+type workerInstanceStats struct {
+	WorkerInstanceID string  `json:"workerInstanceId"`
+	Samples          int     `json:"samples"`
+	P50Ms            float64 `json:"p50Ms"`
+	P99Ms            float64 `json:"p99Ms"`
+	MeanMs           float64 `json:"meanMs"`
+}
+
+func validateByWorkerInstance(body apiRequest) error {
+	if body.ByWorkerInstance && len(body.BatchDelaySeconds) == 0 && body.Iterations <= 1 {
+		return errors.New("byWorkerInstance requires batchDelaySeconds or iterations > 1")
+	}
+	return nil
+}
+
+// summarizeWorkerInstances：latencies 为 workerInstanceId -> 成功样本的延迟（毫秒）；缺少 workerInstanceId 的样本不计入。
+func summarizeWorkerInstances(latencies map[string][]float64) []workerInstanceStats {
+	out := make([]workerInstanceStats, 0, len(latencies))
+	for id, v := range latencies {
+		if id == "" || len(v) == 0 {
+			continue
+		}
+		s := newLatencyStats(v)
+		out = append(out, workerInstanceStats{WorkerInstanceID: id, Samples: len(v), P50Ms: s.P50, P99Ms: s.P99, MeanMs: s.Mean})
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].P99Ms != out[j].P99Ms {
+			return out[i].P99Ms > out[j].P99Ms
+		}
+		return out[i].WorkerInstanceID < out[j].WorkerInstanceID
+	})
+	return out
+}
+
+func batchWorkerInstances(samples []batchSample) []workerInstanceStats {
+	latencies := map[string][]float64{}
+	for _, s := range samples {
+		if s.Status == "OK" {
+			latencies[s.WorkerInstanceID] = append(latencies[s.WorkerInstanceID], nanosToMs(s.ReceiveMessageUnixNano-s.SendStartUnixNano))
+		}
+	}
+	return summarizeWorkerInstances(latencies)
+}
+
+func iterationWorkerInstances(samples []iterationSample) []workerInstanceStats {
+	latencies := map[string][]float64{}
+	for _, s := range samples {
+		if s.Status == "OK" {
+			latencies[s.WorkerInstanceID] = append(latencies[s.WorkerInstanceID], s.PipelineLatencyMs)
+		}
+	}
+	return summarizeWorkerInstances(latencies)
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-xray-sdk-go/instrumentation/awsv2"
+	"github.com/aws/aws-xray-sdk-go/xray"
+	"github.com/aws/smithy-go/middleware"
+)
+
+// xrayEnabled：ENABLE_XRAY=1/true 时接入 X-Ray。默认关闭，本地运行（没有 X-Ray daemon）不受影响。
+func xrayEnabled() bool {
+	v, _ := strconv.ParseBool(strings.TrimSpace(os.Getenv("ENABLE_XRAY")))
+	return v
+}
+
+// xrayOptions：启用时对 cfg 做 SDK 级插桩（每次调用一个 SQS 子段），并返回按阶段命名子段的 SQS 客户端选项。
+func xrayOptions(cfg *aws.Config) []func(*sqs.Options) {
+	if !xrayEnabled() {
+		return nil
+	}
+	awsv2.AWSV2Instrumentor(&cfg.APIOptions)
+	return []func(*sqs.Options){withXRaySubsegments}
+}
+
+// xraySubsegmentName：SQS 操作对应的阶段子段名；不在列表中的操作不单独建子段。
+func xraySubsegmentName(params any) string {
+	switch params.(type) {
+	case *sqs.SendMessageInput, *sqs.SendMessageBatchInput:
+		return "send"
+	case *sqs.ReceiveMessageInput:
+		return "poll"
+	case *sqs.DeleteMessageInput, *sqs.DeleteMessageBatchInput:
+		return "delete"
+	case *sqs.ChangeMessageVisibilityInput, *sqs.ChangeMessageVisibilityBatchInput:
+		return "visibility"
+	}
+	return ""
+}
+
+// withXRaySubsegments 在中间件栈最外层开关子段，包含 SDK 重试在内的整次调用；defer 保证所有错误路径都会关闭子段。
+func withXRaySubsegments(o *sqs.Options) {
+	o.APIOptions = append(o.APIOptions, func(stack *middleware.Stack) error {
+		return stack.Initialize.Add(middleware.InitializeMiddlewareFunc("XRaySubsegment", func(ctx context.Context, in middleware.InitializeInput, next middleware.InitializeHandler) (out middleware.InitializeOutput, md middleware.Metadata, err error) {
+			name := xraySubsegmentName(in.Parameters)
+			if name == "" {
+				return next.HandleInitialize(ctx, in)
+			}
+			ctx, seg := xray.BeginSubsegment(ctx, name)
+			defer func() { seg.Close(err) }()
+			return next.HandleInitialize(ctx, in)
+		}), middleware.Before)
+	})
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// compressedBodyPrefix：Dispatcher compress=true 时消息体的前缀，其后为 gzip 结果的 base64。
+const compressedBodyPrefix = "gz1:"
+
+// decodeMessageBody：带前缀时解压，否则原样返回（与 Dispatcher 的同名函数一致）。
+func decodeMessageBody(raw string) ([]byte, error) {
+	if !strings.HasPrefix(raw, compressedBodyPrefix) {
+		return []byte(raw), nil
+	}
+	z, err := base64.StdEncoding.DecodeString(raw[len(compressedBodyPrefix):])
+	if err != nil {
+		return nil, fmt.Errorf("decode compressed body: %w", err)
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(z))
+	if err != nil {
+		return nil, fmt.Errorf("gunzip body: %w", err)
+	}
+	defer zr.Close()
+	return io.ReadAll(zr)
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+const defaultMetricsNamespace = "TestFastServerless"
+
+// emfOut：EMF 日志行的输出目标（stdout 即 CloudWatch Logs）。
+var emfOut io.Writer = os.Stdout
+
+// emfEnabled：ENABLE_EMF=1/true 时每条处理完的消息输出一行 Embedded Metric Format（与 Dispatcher 同一开关）。
+func emfEnabled() bool {
+	switch strings.ToLower(strings.TrimSpace(os.Getenv("ENABLE_EMF"))) {
+	case "1", "true":
+		return true
+	}
+	return false
+}
+
+// metricsNamespace：METRICS_NAMESPACE，缺省 TestFastServerless。
+func metricsNamespace() string {
+	if v := strings.TrimSpace(os.Getenv("METRICS_NAMESPACE")); v != "" {
+		return v
+	}
+	return defaultMetricsNamespace
+}
+
+// emitWorkerMetrics：WorkerProcessingMs（workerDone - workerReceive），维度 Region/PushQueueName 与 Dispatcher 一致。
+// json.Marshal 不产生换行，CloudWatch 按行自动提取。
+func emitWorkerMetrics(region, pushQueueName, runID string, processingMs float64) {
+	if !emfEnabled() {
+		return
+	}
+	b, err := json.Marshal(map[string]any{
+		"_aws": map[string]any{
+			"Timestamp": time.Now().UnixMilli(),
+			"CloudWatchMetrics": []map[string]any{{
+				"Namespace":  metricsNamespace(),
+				"Dimensions": [][]string{{"Region", "PushQueueName"}},
+				"Metrics":    []map[string]string{{"Name": "WorkerProcessingMs", "Unit": "Milliseconds"}},
+			}},
+		},
+		"Region":             region,
+		"PushQueueName":      pushQueueName,
+		"WorkerProcessingMs": processingMs,
+		"runId":              runID,
+	})
+	if err != nil {
+		return
+	}
+	fmt.Fprintln(emfOut, string(b))
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 调用分类：cold 表示本次调用承担了容器初始化开销；near-cold 表示容器刚启动不久
+// （前几次调用，连接/JIT 等仍可能偏冷）；其余为 warm。
+const (
+	invocationCold     = "cold"
+	invocationNearCold = "near-cold"
+	invocationWarm     = "warm"
+)
+
+var (
+	processStart = time.Now()
+
+	requestCount      atomic.Int64
+	firstInvokeOnce   sync.Once
+	initToInvokeNanos int64
+
+	// coldStartPending：initAWS 首次初始化时置 true，随后第一次 handler 调用取走并复位，之后恒为 false。
+	coldStartPending atomic.Bool
+)
+
+type invocationInfo struct {
+	Class                 string
+	ContainerRequestCount int64
+	// 进程启动到首次调用的耗时（ms）。远大于阈值说明容器是预先初始化的（如预置并发），首调并未承担冷启动。
+	InitToFirstInvokeMs int64
+	// ColdStart：本次调用是初始化之后的第一次调用（与 Class 不同，不看 init->首调 的阈值），见 takeColdStart。
+	ColdStart bool
+}
+
+// markColdStart 在 initOnce.Do 内调用。
+func markColdStart() { coldStartPending.Store(true) }
+
+// takeColdStart 在 initAWS 之后调用：只有初始化后的第一次调用返回 true。
+func takeColdStart() bool { return coldStartPending.CompareAndSwap(true, false) }
+
+// trackInvocation 在每次 handler 调用开始时调用一次。阈值：
+//   - COLD_START_THRESHOLD_MS（默认 1000）：首调且 init->首调 不超过该值视为 cold
+//   - NEAR_COLD_REQUESTS（默认 5）：容器内第 2..N 次调用视为 near-cold
+func trackInvocation() invocationInfo {
+	now := time.Now()
+	firstInvokeOnce.Do(func() { initToInvokeNanos = int64(now.Sub(processStart)) })
+	n := requestCount.Add(1)
+	initMs := initToInvokeNanos / int64(time.Millisecond)
+
+	class := invocationWarm
+	switch {
+	case n == 1 && initMs <= int64(envIntDefault("COLD_START_THRESHOLD_MS", 1000)):
+		class = invocationCold
+	case n <= int64(envIntDefault("NEAR_COLD_REQUESTS", 5)):
+		class = invocationNearCold
+	}
+	return invocationInfo{Class: class, ContainerRequestCount: n, InitToFirstInvokeMs: initMs}
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"log/slog"
+	"os"
+	"strings"
+)
+
+// initLogger：每行一个 JSON 对象（slog JSONHandler，写到 stderr 即 CloudWatch Logs），便于 Logs Insights 按字段查询。
+// 设为默认 logger 后，其余 log.Printf 也以 INFO 级别的 JSON 行输出（内容在 msg 字段中）。
+func initLogger() {
+	slog.SetDefault(slog.New(slog.NewJSONHandler(os.Stderr, &slog.HandlerOptions{Level: logLevelFromEnv()})))
+}
+
+// logLevelFromEnv：LOG_LEVEL=debug/info/warn/error，缺省或无法识别时为 info。
+func logLevelFromEnv() slog.Level {
+	switch strings.ToLower(strings.TrimSpace(os.Getenv("LOG_LEVEL"))) {
+	case "debug":
+		return slog.LevelDebug
+	case "warn", "warning":
+		return slog.LevelWarn
+	case "error":
+		return slog.LevelError
+	default:
+		return slog.LevelInfo
+	}
+}
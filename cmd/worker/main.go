@@ -9,10 +9,12 @@ package main
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"os"
 	"path"
 	"strconv"
@@ -22,8 +24,12 @@ import (
 
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-lambda-go/lambdacontext"
+	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
 	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
 )
 
 type msgBody struct {
@@ -31,11 +37,38 @@ type msgBody struct {
 	SendUnixNano      int64  `json:"sendUnixNano"`
 	SendStartUnixNano int64  `json:"sendStartUnixNano"`
 	RunID             string `json:"runId"`
+	// FailMode="always-error"：Worker 始终处理失败，用于测量 maxReceiveCount/DLQ 转移耗时。
+	FailMode string `json:"failMode,omitempty"`
+	// Dispatcher 的部署版本，原样回显到回调中。
+	DispatcherVersion string `json:"dispatcherVersion,omitempty"`
+	// 路由属性的期望值，见 verifyRoute。
+	RouteAttributes map[string]string `json:"routeAttributes,omitempty"`
+	Padding         string            `json:"padding,omitempty"`
+	// EchoPadding：在回调中原样回显 padding，供 Dispatcher 逐字节校验。
+	EchoPadding bool `json:"echoPadding,omitempty"`
+	// OneWay：把接收时间写入 ONE_WAY_TABLE，不发送回调。
+	OneWay bool `json:"oneWay,omitempty"`
+	// WorkMs：在 workerReceive 与 workerDone 之间休眠该时长（毫秒），模拟真实处理耗时。
+	WorkMs int `json:"workMs,omitempty"`
+	// CallbackQueueURL：Dispatcher ephemeral 模式的临时 Receive 队列，非空时代替 RECEIVE_QUEUE_URL。
+	CallbackQueueURL string `json:"callbackQueueUrl,omitempty"`
+	// Probe：Dispatcher probeWorker 的探测消息，跳过 workMs 立即回调。
+	Probe bool `json:"probe,omitempty"`
 }
 
+const failModeAlwaysError = "always-error"
+
+// ephemeralQueuePrefix：与 Dispatcher 一致；callbackQueueUrl 只接受该前缀的临时队列，避免消息把回调导向任意队列。
+const ephemeralQueuePrefix = "TestFastServerlessEphemeral-"
+
+// maxWorkMs：与 Dispatcher 的上限一致，需低于本函数的 Lambda 超时。
+const maxWorkMs = 25000
+
 type callbackMessage struct {
 	ID    string `json:"id"`
 	RunID string `json:"runId"`
+	// Probe：应答的是探测消息。
+	Probe bool `json:"probe,omitempty"`
 
 	Region           string `json:"region"`
 	PushQueueName    string `json:"pushQueueName"`
@@ -44,14 +77,34 @@ type callbackMessage struct {
 	SendUnixNano      int64 `json:"sendUnixNano"`
 	SendStartUnixNano int64 `json:"sendStartUnixNano"`
 
-	WorkerReceiveUnixNano     int64 `json:"workerReceiveUnixNano"`
-	WorkerDoneUnixNano        int64 `json:"workerDoneUnixNano"`
+	WorkerReceiveUnixNano int64 `json:"workerReceiveUnixNano"`
+	WorkerDoneUnixNano    int64 `json:"workerDoneUnixNano"`
+	// 回调发送的结束时间无法写进正在发送的消息本身；Dispatcher 用回调消息的 SQS SentTimestamp
+	// 与该字段的差值近似 callbackSendMs，精确值见 Worker 日志中的 callbackSendMs。
 	CallbackSendStartUnixNano int64 `json:"callbackSendStartUnixNano"`
-	CallbackSendEndUnixNano   int64 `json:"callbackSendEndUnixNano"`
 
 	SqsSentTimestampMs         int64 `json:"sqsSentTimestampMs"`
 	SqsFirstReceiveTimestampMs int64 `json:"sqsFirstReceiveTimestampMs"`
 	SqsApproxReceiveCount      int64 `json:"sqsApproxReceiveCount"`
+	// EsmInvokeLatencyMs：workerReceive - ApproximateFirstReceiveTimestamp，近似事件源映射轮询到 Worker 被调用之间的开销（跨 SQS/Worker 时钟）。
+	EsmInvokeLatencyMs *float64 `json:"esmInvokeLatencyMs,omitempty"`
+
+	// Worker 容器的调用分类（cold|near-cold|warm），见 trackInvocation。
+	WorkerInvocationClass       string `json:"workerInvocationClass"`
+	WorkerContainerRequestCount int64  `json:"workerContainerRequestCount"`
+	WorkerInitToFirstInvokeMs   int64  `json:"workerInitToFirstInvokeMs"`
+	// WorkerColdStart：本次调用是 Worker 容器初始化后的第一次调用（同一批的所有 record 都为 true）。
+	WorkerColdStart bool `json:"workerColdStart,omitempty"`
+	// Worker 容器标识，用于判断相邻样本是否落在同一个容器上。
+	WorkerInstanceID  string `json:"workerInstanceId"`
+	DispatcherVersion string `json:"dispatcherVersion"`
+	WorkerVersion     string `json:"workerVersion"`
+	// 路由属性校验结果；未请求校验时省略。
+	RouteVerified *bool  `json:"routeVerified,omitempty"`
+	RouteMismatch string `json:"routeMismatch,omitempty"`
+	EchoedPadding string `json:"echoedPadding,omitempty"`
+	// TraceHeader：收到的 AWSTraceHeader，供 Dispatcher 确认 forceSample 的采样位是否送达。
+	TraceHeader string `json:"traceHeader,omitempty"`
 }
 
 var (
@@ -60,88 +113,290 @@ var (
 
 	sqsClient *sqs.Client
 	region    string
+	// dynamoDBClient：只在配置了 ONE_WAY_TABLE 时创建。
+	dynamoDBClient *dynamodb.Client
+
+	workerInstanceID = newInstanceID()
+	workerVersion    = deployVersion()
 )
 
+// deployVersion：部署版本标识，优先取 VERSION 环境变量，否则退化为 Lambda 函数版本（如 $LATEST）。
+// 注意不同函数的版本号互不相关，只有 VERSION 才能用于跨函数比较。
+func deployVersion() string {
+	if v := strings.TrimSpace(os.Getenv("VERSION")); v != "" {
+		return v
+	}
+	return lambdacontext.FunctionVersion
+}
+
+// newInstanceID：优先使用 Lambda 日志流名（每个执行环境唯一），本地运行时退化为随机值。
+func newInstanceID() string {
+	if s := strings.TrimSpace(os.Getenv("AWS_LAMBDA_LOG_STREAM_NAME")); s != "" {
+		return s
+	}
+	b := make([]byte, 8)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
 func initAWS() {
 	initOnce.Do(func() {
+		markColdStart()
+		initLogger()
 		cfg, err := config.LoadDefaultConfig(context.Background())
 		if err != nil {
 			initErr = fmt.Errorf("load aws config: %w", err)
 			return
 		}
 		region = cfg.Region
-		sqsClient = sqs.NewFromConfig(cfg)
+		sqsClient = sqs.NewFromConfig(cfg, xrayOptions(&cfg)...)
+		if strings.TrimSpace(os.Getenv("ONE_WAY_TABLE")) != "" {
+			dynamoDBClient = dynamodb.NewFromConfig(cfg)
+		}
 	})
 }
 
-func handler(ctx context.Context, event events.SQSEvent) error {
+// handler：函数需开启 ReportBatchItemFailures（template.yaml 中 FunctionResponseTypes），否则返回的失败列表被忽略、整批视为成功。
+// 初始化或配置错误时整批返回错误。
+func handler(ctx context.Context, event events.SQSEvent) (events.SQSEventResponse, error) {
+	inv := trackInvocation()
 	initAWS()
+	inv.ColdStart = takeColdStart()
 	if initErr != nil {
-		return initErr
+		return events.SQSEventResponse{}, initErr
 	}
 	receiveQueueURL := strings.TrimSpace(os.Getenv("RECEIVE_QUEUE_URL"))
 	if receiveQueueURL == "" {
-		return errors.New("missing env RECEIVE_QUEUE_URL")
+		return events.SQSEventResponse{}, errors.New("missing env RECEIVE_QUEUE_URL")
 	}
 	receiveQueueName := queueNameFromURL(receiveQueueURL)
 
+	var resp events.SQSEventResponse
 	for _, record := range event.Records {
-		// 每条 record 对应一条 SQS message。
-		pushQueueName := queueNameFromArn(record.EventSourceARN)
+		// 每条 record 对应一条 SQS message；只把处理失败的 record 报告为 BatchItemFailures，其余由 SQS 删除。
+		if err := processRecord(ctx, record, inv, receiveQueueURL, receiveQueueName); err != nil {
+			slog.Error("worker record failed", "messageId", record.MessageId, "err", err.Error())
+			resp.BatchItemFailures = append(resp.BatchItemFailures, events.SQSBatchItemFailure{ItemIdentifier: record.MessageId})
+		}
+	}
+	return resp, nil
+}
+
+// processRecord 处理单条 record；返回错误时该消息在可见性超时后重投（不影响同批其他消息）。
+func processRecord(ctx context.Context, record events.SQSMessage, inv invocationInfo, receiveQueueURL, receiveQueueName string) error {
+	pushQueueName := queueNameFromArn(record.EventSourceARN)
+	if !recordSignatureValid(record) {
+		// 未签名或被篡改的消息（其他运行或外部写入）：记录后忽略，不回调、不重试。
+		slog.Warn("worker rejected message signature", "messageId", record.MessageId, "pushQueue", pushQueueName)
+		return nil
+	}
 
-		var body msgBody
-		if err := json.Unmarshal([]byte(record.Body), &body); err != nil {
-			return fmt.Errorf("unmarshal message body: %w", err)
+	var body msgBody
+	raw, err := decodeMessageBody(record.Body)
+	if err == nil {
+		err = json.Unmarshal(raw, &body)
+	}
+	if err != nil {
+		// 无法解析的消息重试也不会成功：转发或丢弃后继续处理本批其余消息。
+		return handlePoisonRecord(ctx, record, fmt.Errorf("parse message body: %w", err))
+	}
+	applyMessageAttributes(&body, record.MessageAttributes)
+	if strings.TrimSpace(body.ID) == "" {
+		return errors.New("missing id in message body")
+	}
+	if strings.TrimSpace(body.RunID) == "" {
+		return errors.New("missing runId in message body")
+	}
+	if body.CallbackQueueURL != "" {
+		if !strings.HasPrefix(queueNameFromURL(body.CallbackQueueURL), ephemeralQueuePrefix) {
+			return fmt.Errorf("callbackQueueUrl must be an ephemeral queue (%s*): %s", ephemeralQueuePrefix, body.CallbackQueueURL)
 		}
-		if strings.TrimSpace(body.ID) == "" {
-			return errors.New("missing id in message body")
+		receiveQueueURL, receiveQueueName = body.CallbackQueueURL, queueNameFromURL(body.CallbackQueueURL)
+	}
+
+	if body.FailMode == failModeAlwaysError {
+		// 立即释放可见性以加速重投，使消息尽快达到 maxReceiveCount 并进入 DLQ。队列取自投递该 record 的
+		// EventSourceARN（PUSH_QUEUE_URLS 池、临时队列均适用）；失败时消息要等满 VisibilityTimeout 才重投，探测大概率超时。
+		if pushQueueURL := queueURLFromArn(record.EventSourceARN); pushQueueURL == "" {
+			slog.Warn("worker failMode visibility reset skipped", "id", body.ID, "runId", body.RunID, "eventSourceArn", record.EventSourceARN)
+		} else if _, err := sqsClient.ChangeMessageVisibility(ctx, &sqs.ChangeMessageVisibilityInput{
+			QueueUrl:          &pushQueueURL,
+			ReceiptHandle:     &record.ReceiptHandle,
+			VisibilityTimeout: 0,
+		}); err != nil {
+			slog.Warn("worker failMode visibility reset failed", "id", body.ID, "runId", body.RunID, "pushQueue", pushQueueName, "err", err.Error())
 		}
-		if strings.TrimSpace(body.RunID) == "" {
-			return errors.New("missing runId in message body")
+		slog.Info("worker failMode", "id", body.ID, "runId", body.RunID, "failMode", body.FailMode, "receiveCount", record.Attributes["ApproximateReceiveCount"])
+		return fmt.Errorf("failMode %s: id=%s", body.FailMode, body.ID)
+	}
+
+	if body.DispatcherVersion != "" && workerVersion != "" && body.DispatcherVersion != workerVersion {
+		slog.Warn("version mismatch", "id", body.ID, "runId", body.RunID, "dispatcherVersion", body.DispatcherVersion, "workerVersion", workerVersion)
+	}
+
+	routeVerified, routeMismatch := verifyRoute(body.RouteAttributes, record.MessageAttributes)
+	if routeVerified != nil && !*routeVerified {
+		slog.Warn("route mismatch", "id", body.ID, "runId", body.RunID, "routeMismatch", routeMismatch)
+	}
+
+	// workerReceiveUnixNano：Worker 实际开始处理的时间戳。
+	workerReceiveUnixNano := time.Now().UnixNano()
+	if ms, ok := decodeULIDTime(body.ID); ok {
+		slog.Info("worker ulid", "id", body.ID, "runId", body.RunID, "ulidTimeMs", ms, "sinceUlidMs", workerReceiveUnixNano/int64(time.Millisecond)-ms)
+	}
+
+	// SQS 属性时间戳（毫秒）
+	sqsSentTimestampMs := parseInt64OrZero(record.Attributes["SentTimestamp"])
+	sqsFirstReceiveTimestampMs := parseInt64OrZero(record.Attributes["ApproximateFirstReceiveTimestamp"])
+	sqsApproxReceiveCount := parseInt64OrZero(record.Attributes["ApproximateReceiveCount"])
+
+	if body.OneWay {
+		putStart := time.Now()
+		if err := putOneWayItem(ctx, body, workerReceiveUnixNano, sqsSentTimestampMs); err != nil {
+			return err
 		}
+		slog.Info("worker processed one-way",
+			"id", body.ID,
+			"runId", body.RunID,
+			"pushQueue", pushQueueName,
+			"workerReceiveUnixNano", workerReceiveUnixNano,
+			"putItemMs", float64(time.Since(putStart))/float64(time.Millisecond),
+		)
+		return nil
+	}
 
-		// workerReceiveUnixNano：Worker 实际开始处理的时间戳。
-		workerReceiveUnixNano := time.Now().UnixNano()
-
-		// SQS 属性时间戳（毫秒）
-		sqsSentTimestampMs := parseInt64OrZero(record.Attributes["SentTimestamp"])
-		sqsFirstReceiveTimestampMs := parseInt64OrZero(record.Attributes["ApproximateFirstReceiveTimestamp"])
-		sqsApproxReceiveCount := parseInt64OrZero(record.Attributes["ApproximateReceiveCount"])
-
-		workerDoneUnixNano := time.Now().UnixNano()
-		callbackSendStartUnixNano := time.Now().UnixNano()
-		cbBytes, err := json.Marshal(callbackMessage{
-			ID:                         body.ID,
-			RunID:                      body.RunID,
-			Region:                     region,
-			PushQueueName:              pushQueueName,
-			ReceiveQueueName:           receiveQueueName,
-			SendUnixNano:               body.SendUnixNano,
-			SendStartUnixNano:          body.SendStartUnixNano,
-			WorkerReceiveUnixNano:      workerReceiveUnixNano,
-			WorkerDoneUnixNano:         workerDoneUnixNano,
-			CallbackSendStartUnixNano:  callbackSendStartUnixNano,
-			SqsSentTimestampMs:         sqsSentTimestampMs,
-			SqsFirstReceiveTimestampMs: sqsFirstReceiveTimestampMs,
-			SqsApproxReceiveCount:      sqsApproxReceiveCount,
-		})
-		if err != nil {
-			return fmt.Errorf("marshal callback message: %w", err)
+	if !body.Probe {
+		simulateWork(ctx, body.WorkMs)
+	}
+	workerDoneUnixNano := time.Now().UnixNano()
+	callbackSendStartUnixNano := time.Now().UnixNano()
+	cbBytes, err := json.Marshal(callbackMessage{
+		ID:                          body.ID,
+		RunID:                       body.RunID,
+		Probe:                       body.Probe,
+		Region:                      region,
+		PushQueueName:               pushQueueName,
+		ReceiveQueueName:            receiveQueueName,
+		SendUnixNano:                body.SendUnixNano,
+		SendStartUnixNano:           body.SendStartUnixNano,
+		WorkerReceiveUnixNano:       workerReceiveUnixNano,
+		WorkerDoneUnixNano:          workerDoneUnixNano,
+		CallbackSendStartUnixNano:   callbackSendStartUnixNano,
+		SqsSentTimestampMs:          sqsSentTimestampMs,
+		SqsFirstReceiveTimestampMs:  sqsFirstReceiveTimestampMs,
+		SqsApproxReceiveCount:       sqsApproxReceiveCount,
+		EsmInvokeLatencyMs:          esmInvokeLatencyMs(workerReceiveUnixNano, sqsFirstReceiveTimestampMs),
+		WorkerInvocationClass:       inv.Class,
+		WorkerContainerRequestCount: inv.ContainerRequestCount,
+		WorkerInitToFirstInvokeMs:   inv.InitToFirstInvokeMs,
+		WorkerColdStart:             inv.ColdStart,
+		WorkerInstanceID:            workerInstanceID,
+		DispatcherVersion:           body.DispatcherVersion,
+		WorkerVersion:               workerVersion,
+		RouteVerified:               routeVerified,
+		RouteMismatch:               routeMismatch,
+		EchoedPadding:               echoedPadding(body),
+		TraceHeader:                 record.Attributes["AWSTraceHeader"],
+	})
+	if err != nil {
+		return fmt.Errorf("marshal callback message: %w", err)
+	}
+	cbBody := string(cbBytes)
+	cbInput := &sqs.SendMessageInput{
+		QueueUrl:          &receiveQueueURL,
+		MessageBody:       &cbBody,
+		MessageAttributes: signCallback(callbackAttributes(body.RunID, body.ID), cbBody),
+	}
+	// 强制采样的 trace（Sampled=1）沿回调继续传递，回程同样出现在 trace 中。
+	if th := record.Attributes["AWSTraceHeader"]; strings.Contains(th, "Sampled=1") {
+		cbInput.MessageSystemAttributes = map[string]types.MessageSystemAttributeValue{
+			string(types.MessageSystemAttributeNameForSendsAWSTraceHeader): {DataType: aws.String("String"), StringValue: aws.String(th)},
 		}
-		cbBody := string(cbBytes)
-		_, err = sqsClient.SendMessage(ctx, &sqs.SendMessageInput{
-			QueueUrl:    &receiveQueueURL,
-			MessageBody: &cbBody,
-		})
-		callbackSendEndUnixNano := time.Now().UnixNano()
-		if err != nil {
-			return fmt.Errorf("send callback message: %w", err)
+	}
+	// FIFO 回调队列：每条回调单独成组（互不阻塞），并以 id 去重。
+	if strings.HasSuffix(receiveQueueName, ".fifo") {
+		cbInput.MessageGroupId = aws.String(body.ID)
+		cbInput.MessageDeduplicationId = aws.String(body.ID)
+	}
+	_, err = sqsClient.SendMessage(ctx, cbInput)
+	callbackSendEndUnixNano := time.Now().UnixNano()
+	if err != nil {
+		return fmt.Errorf("send callback message: %w", err)
+	}
+	callbackSendMs := float64(callbackSendEndUnixNano-callbackSendStartUnixNano) / float64(time.Millisecond)
+
+	// 探测消息使用单独的日志消息，不计入基于 "worker processed" 的统计。
+	msg := "worker processed"
+	if body.Probe {
+		msg = "worker probe"
+	}
+	slog.Info(msg,
+		"id", body.ID,
+		"runId", body.RunID,
+		"pushQueue", pushQueueName,
+		"callbackQueue", receiveQueueName,
+		"workerReceiveUnixNano", workerReceiveUnixNano,
+		"workerDoneUnixNano", workerDoneUnixNano,
+		"callbackSendStartUnixNano", callbackSendStartUnixNano,
+		"callbackSendEndUnixNano", callbackSendEndUnixNano,
+		"callbackSendMs", callbackSendMs,
+	)
+	if !body.Probe {
+		emitWorkerMetrics(region, pushQueueName, body.RunID, float64(workerDoneUnixNano-workerReceiveUnixNano)/float64(time.Millisecond))
+	}
+	return nil
+}
+
+// applyMessageAttributes：ultraMinimal 模式下 Dispatcher 把 id 以外的字段放在 MessageAttributes 中，
+// 消息体里缺失的字段从属性补齐。
+func applyMessageAttributes(body *msgBody, attrs map[string]events.SQSMessageAttribute) {
+	attr := func(name string) string {
+		if a, ok := attrs[name]; ok && a.StringValue != nil {
+			return *a.StringValue
 		}
+		return ""
+	}
+	if body.RunID == "" {
+		body.RunID = attr("runId")
+	}
+	if body.SendUnixNano == 0 {
+		body.SendUnixNano = parseInt64OrZero(attr("sendUnixNano"))
+	}
+	if body.SendStartUnixNano == 0 {
+		body.SendStartUnixNano = parseInt64OrZero(attr("sendStartUnixNano"))
+	}
+	if body.DispatcherVersion == "" {
+		body.DispatcherVersion = attr("dispatcherVersion")
+	}
+}
 
-		log.Printf("worker processed id=%s pushQueue=%s workerReceiveUnixNano=%d workerDoneUnixNano=%d callbackQueue=%s callbackSendStartUnixNano=%d callbackSendEndUnixNano=%d", body.ID, pushQueueName, workerReceiveUnixNano, workerDoneUnixNano, receiveQueueName, callbackSendStartUnixNano, callbackSendEndUnixNano)
+// simulateWork 休眠 workMs 毫秒（上限 maxWorkMs），ctx 结束时提前返回。
+func simulateWork(ctx context.Context, workMs int) {
+	if workMs <= 0 {
+		return
+	}
+	select {
+	case <-ctx.Done():
+	case <-time.After(time.Duration(min(workMs, maxWorkMs)) * time.Millisecond):
 	}
+}
 
-	return nil
+func echoedPadding(body msgBody) string {
+	if !body.EchoPadding {
+		return ""
+	}
+	return body.Padding
+}
+
+// callbackAttributes：回调携带 runId/id 消息属性，Dispatcher 轮询时无需解析 body 即可识别其他运行的回调；任一为空时不设置。
+func callbackAttributes(runID, id string) map[string]types.MessageAttributeValue {
+	if runID == "" || id == "" {
+		return nil
+	}
+	return map[string]types.MessageAttributeValue{
+		"runId": {DataType: aws.String("String"), StringValue: aws.String(runID)},
+		"id":    {DataType: aws.String("String"), StringValue: aws.String(id)},
+	}
 }
 
 func queueNameFromArn(arn string) string {
@@ -153,6 +408,19 @@ func queueNameFromArn(arn string) string {
 	return parts[len(parts)-1]
 }
 
+// queueURLFromArn：由 arn:<partition>:sqs:<region>:<account>:<queueName> 拼出队列 URL；格式不符时返回空串。
+func queueURLFromArn(arn string) string {
+	parts := strings.Split(arn, ":")
+	if len(parts) != 6 || parts[2] != "sqs" || parts[3] == "" || parts[4] == "" || parts[5] == "" {
+		return ""
+	}
+	domain := "amazonaws.com"
+	if parts[1] == "aws-cn" {
+		domain = "amazonaws.com.cn"
+	}
+	return fmt.Sprintf("https://sqs.%s.%s/%s/%s", parts[3], domain, parts[4], parts[5])
+}
+
 func parseInt64OrZero(s string) int64 {
 	if strings.TrimSpace(s) == "" {
 		return 0
@@ -164,6 +432,28 @@ func parseInt64OrZero(s string) int64 {
 	return n
 }
 
+// esmInvokeLatencyMs：SQS 首次把消息交给事件源映射到 Worker 开始处理的间隔；缺少 FirstReceive 时返回 nil。
+// 重投的消息（receiveCount>1）中该值还包含此前的可见性超时。
+func esmInvokeLatencyMs(workerReceiveUnixNano, firstReceiveMs int64) *float64 {
+	if firstReceiveMs <= 0 {
+		return nil
+	}
+	ms := float64(workerReceiveUnixNano-firstReceiveMs*int64(time.Millisecond)) / float64(time.Millisecond)
+	return &ms
+}
+
+func envIntDefault(key string, def int) int {
+	v := strings.TrimSpace(os.Getenv(key))
+	if v == "" {
+		return def
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		return def
+	}
+	return n
+}
+
 func queueNameFromURL(queueURL string) string {
 	base := strings.SplitN(queueURL, "?", 2)[0]
 	return path.Base(base)
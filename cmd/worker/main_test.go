@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+)
+
+// fakeCallbackSQS：只处理 SendMessage 与 ChangeMessageVisibility 的最小 SQS JSON 协议假服务，记录收到的消息数与各自的目标队列名。
+type fakeCallbackSQS struct {
+	mu     sync.Mutex
+	sends  int
+	queues []string
+	// visibilityQueues：ChangeMessageVisibility 请求中的 QueueUrl。
+	visibilityQueues []string
+}
+
+func (f *fakeCallbackSQS) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	w.Header().Set("Content-Type", "application/x-amz-json-1.0")
+	var in struct{ QueueUrl string }
+	_ = json.NewDecoder(r.Body).Decode(&in)
+	switch strings.TrimPrefix(r.Header.Get("X-Amz-Target"), "AmazonSQS.") {
+	case "SendMessage":
+		f.sends++
+		f.queues = append(f.queues, queueNameFromURL(in.QueueUrl))
+		fmt.Fprintf(w, `{"MessageId":"cb-%d"}`, f.sends)
+	case "ChangeMessageVisibility":
+		f.visibilityQueues = append(f.visibilityQueues, in.QueueUrl)
+		fmt.Fprint(w, `{}`)
+	default:
+		fmt.Fprint(w, `{}`)
+	}
+}
+
+// useFakeCallbackSQS 把 sqsClient 指向 fakeCallbackSQS，并设置 RECEIVE_QUEUE_URL；返回假服务与其 URL。
+func useFakeCallbackSQS(t *testing.T) (*fakeCallbackSQS, string) {
+	f := &fakeCallbackSQS{}
+	srv := httptest.NewServer(f)
+	t.Cleanup(srv.Close)
+
+	initOnce.Do(func() {})
+	prev := sqsClient
+	sqsClient = sqs.New(sqs.Options{
+		Region:                           "us-east-1",
+		BaseEndpoint:                     aws.String(srv.URL),
+		Credentials:                      aws.AnonymousCredentials{},
+		DisableMessageChecksumValidation: true,
+	})
+	t.Cleanup(func() { sqsClient = prev })
+	t.Setenv("RECEIVE_QUEUE_URL", srv.URL+"/000000000000/receive")
+	return f, srv.URL
+}
+
+func TestPoisonRecord(t *testing.T) {
+	f, url := useFakeCallbackSQS(t)
+	poison := func(receiveCount string) events.SQSMessage {
+		return events.SQSMessage{MessageId: "p-" + receiveCount, Body: "not json", EventSourceARN: "arn:aws:sqs:us-east-1:000000000000:push",
+			Attributes: map[string]string{"ApproximateReceiveCount": receiveCount}}
+	}
+	parseErr := errors.New("parse message body: invalid character")
+
+	// 未配置 DLQ：只记录，由 SQS 删除。
+	t.Setenv("DLQ_QUEUE_URL", "")
+	if err := handlePoisonRecord(context.Background(), poison("1"), parseErr); err != nil || f.sends != 0 {
+		t.Fatalf("no dlq: err=%v sends=%d, want nil 0", err, f.sends)
+	}
+
+	t.Setenv("DLQ_QUEUE_URL", url+"/000000000000/push-dlq")
+	t.Setenv("MAX_RECEIVE_COUNT", "2")
+	// 未超过阈值：返回错误重投，不转发。
+	for _, n := range []string{"1", "2"} {
+		if err := handlePoisonRecord(context.Background(), poison(n), parseErr); err == nil || f.sends != 0 {
+			t.Fatalf("receiveCount=%s: err=%v sends=%d, want error and no forward", n, err, f.sends)
+		}
+	}
+	// 超过阈值：转发到 DLQ 后视为处理完成。
+	if err := handlePoisonRecord(context.Background(), poison("3"), parseErr); err != nil || f.sends != 1 || f.queues[0] != "push-dlq" {
+		t.Fatalf("receiveCount=3: err=%v sends=%d queues=%v, want forwarded to push-dlq", err, f.sends, f.queues)
+	}
+}
+
+func TestPartialBatchFailure(t *testing.T) {
+	f, url := useFakeCallbackSQS(t)
+
+	record := func(messageID, body string) events.SQSMessage {
+		return events.SQSMessage{MessageId: messageID, Body: body, EventSourceARN: "arn:aws:sqs:us-east-1:000000000000:push",
+			Attributes: map[string]string{"ApproximateReceiveCount": "1"}}
+	}
+	// m-2 缺少 id（处理失败）；m-4 无法解析，是否报告失败取决于 DLQ 配置与接收次数。
+	cases := []struct {
+		name, dlq, maxReceiveCount string
+		wantFailures               string
+		wantDLQ                    int
+	}{
+		{"no dlq", "", "0", "m-2", 0},
+		{"forwarded", url + "/000000000000/push-dlq", "0", "m-2", 1},
+		{"below threshold", url + "/000000000000/push-dlq", "3", "m-2,m-4", 0},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			t.Setenv("DLQ_QUEUE_URL", c.dlq)
+			t.Setenv("MAX_RECEIVE_COUNT", c.maxReceiveCount)
+			f.sends, f.queues = 0, nil
+			resp, err := handler(context.Background(), events.SQSEvent{Records: []events.SQSMessage{
+				record("m-1", `{"id":"a","runId":"run-1"}`),
+				record("m-2", `{"runId":"run-1"}`),
+				record("m-3", `{"id":"c","runId":"run-1"}`),
+				record("m-4", `{"id":`),
+			}})
+			if err != nil {
+				t.Fatalf("handler: %v", err)
+			}
+			var failed []string
+			for _, item := range resp.BatchItemFailures {
+				failed = append(failed, item.ItemIdentifier)
+			}
+			if strings.Join(failed, ",") != c.wantFailures {
+				t.Fatalf("batchItemFailures=%v, want %s", failed, c.wantFailures)
+			}
+			dlqSends := 0
+			for _, q := range f.queues {
+				if q == "push-dlq" {
+					dlqSends++
+				}
+			}
+			if f.sends-dlqSends != 2 || dlqSends != c.wantDLQ {
+				t.Fatalf("callbacks sent=%d dlq sends=%d, want 2 %d", f.sends-dlqSends, dlqSends, c.wantDLQ)
+			}
+		})
+	}
+}
+
+func TestFailModeResetsVisibilityOnSourceQueue(t *testing.T) {
+	f, _ := useFakeCallbackSQS(t)
+	// 可见性重置的目标取自 EventSourceARN，与 PUSH_QUEUE_URL 无关（池中的其他队列、未配置时同样适用）。
+	t.Setenv("PUSH_QUEUE_URL", "")
+	resp, err := handler(context.Background(), events.SQSEvent{Records: []events.SQSMessage{{
+		MessageId: "m-1", ReceiptHandle: "rh-1", EventSourceARN: "arn:aws:sqs:eu-west-1:123456789012:push-2",
+		Body: `{"id":"a","runId":"run-1","failMode":"always-error"}`,
+	}}})
+	if err != nil || len(resp.BatchItemFailures) != 1 || resp.BatchItemFailures[0].ItemIdentifier != "m-1" {
+		t.Fatalf("err=%v batchItemFailures=%v, want m-1 reported", err, resp.BatchItemFailures)
+	}
+	if want := "https://sqs.eu-west-1.amazonaws.com/123456789012/push-2"; len(f.visibilityQueues) != 1 || f.visibilityQueues[0] != want {
+		t.Fatalf("visibility reset on %v, want %s", f.visibilityQueues, want)
+	}
+	if f.sends != 0 {
+		t.Fatalf("sends=%d, want no callback for failMode", f.sends)
+	}
+}
+
+func TestQueueURLFromArn(t *testing.T) {
+	cases := map[string]string{
+		"arn:aws:sqs:us-east-1:000000000000:push":          "https://sqs.us-east-1.amazonaws.com/000000000000/push",
+		"arn:aws-cn:sqs:cn-north-1:000000000000:push.fifo": "https://sqs.cn-north-1.amazonaws.com.cn/000000000000/push.fifo",
+		"arn:aws:sns:us-east-1:000000000000:topic":         "",
+		"": "",
+	}
+	for arn, want := range cases {
+		if got := queueURLFromArn(arn); got != want {
+			t.Errorf("queueURLFromArn(%q) = %q, want %q", arn, got, want)
+		}
+	}
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	ddbtypes "github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// oneWayItemTTL：ONE_WAY_TABLE 条目的保留时间（表上以 ttl 属性开启 TTL）。
+const oneWayItemTTL = time.Hour
+
+// putOneWayItem：oneWay 模式下把接收时间写入 ONE_WAY_TABLE，代替回调消息；Dispatcher 用 GetItem 取回。
+func putOneWayItem(ctx context.Context, body msgBody, workerReceiveUnixNano, sqsSentTimestampMs int64) error {
+	table := strings.TrimSpace(os.Getenv("ONE_WAY_TABLE"))
+	if table == "" {
+		return errors.New("oneWay message but missing env ONE_WAY_TABLE")
+	}
+	n := func(v int64) ddbtypes.AttributeValue {
+		return &ddbtypes.AttributeValueMemberN{Value: strconv.FormatInt(v, 10)}
+	}
+	_, err := dynamoDBClient.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String(table),
+		Item: map[string]ddbtypes.AttributeValue{
+			"id":                    &ddbtypes.AttributeValueMemberS{Value: body.ID},
+			"runId":                 &ddbtypes.AttributeValueMemberS{Value: body.RunID},
+			"workerInstanceId":      &ddbtypes.AttributeValueMemberS{Value: workerInstanceID},
+			"workerReceiveUnixNano": n(workerReceiveUnixNano),
+			"sendStartUnixNano":     n(body.SendStartUnixNano),
+			"sqsSentTimestampMs":    n(sqsSentTimestampMs),
+			"ttl":                   n(time.Now().Add(oneWayItemTTL).Unix()),
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("put one-way item: %w", err)
+	}
+	return nil
+}
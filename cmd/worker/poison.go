@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+// poisonErrorAttr：转发到 DLQ_QUEUE_URL 的消息上携带解析错误的属性名。
+const poisonErrorAttr = "error"
+
+// handlePoisonRecord：消息体无法解析（解压或 json.Unmarshal 失败）时不再让整批重试。
+// 未配置 DLQ_QUEUE_URL 时只记录警告并返回 nil，由 SQS 删除。配置了 DLQ_QUEUE_URL 时：
+// ApproximateReceiveCount 超过 MAX_RECEIVE_COUNT（默认 0，即首次收到就转发）时把原始消息体连同错误转发到该队列，成功后返回 nil；
+// 未超过时返回错误，让该 record 进入 BatchItemFailures 重投，直到接收次数越过阈值（返回 nil 会被删除，永远等不到转发）。
+// 转发失败同样返回错误，让 SQS 重投而不是丢弃。
+func handlePoisonRecord(ctx context.Context, record events.SQSMessage, parseErr error) error {
+	receiveCount := parseInt64OrZero(record.Attributes["ApproximateReceiveCount"])
+	maxReceiveCount := int64(envIntDefault("MAX_RECEIVE_COUNT", 0))
+	dlqURL := strings.TrimSpace(os.Getenv("DLQ_QUEUE_URL"))
+	forward := dlqURL != "" && receiveCount > maxReceiveCount
+	slog.Warn("worker poison message",
+		"messageId", record.MessageId,
+		"pushQueue", queueNameFromArn(record.EventSourceARN),
+		"receiveCount", receiveCount,
+		"maxReceiveCount", maxReceiveCount,
+		"forwardedToDlq", forward,
+		"err", parseErr.Error(),
+	)
+	if dlqURL == "" {
+		return nil
+	}
+	if !forward {
+		return fmt.Errorf("poison message below MAX_RECEIVE_COUNT (%d/%d), retrying: %w", receiveCount, maxReceiveCount, parseErr)
+	}
+
+	in := &sqs.SendMessageInput{
+		QueueUrl:    &dlqURL,
+		MessageBody: aws.String(record.Body),
+		MessageAttributes: map[string]types.MessageAttributeValue{
+			poisonErrorAttr:      {DataType: aws.String("String"), StringValue: aws.String(parseErr.Error())},
+			"sourceMessageId":    {DataType: aws.String("String"), StringValue: aws.String(record.MessageId)},
+			"sourceQueue":        {DataType: aws.String("String"), StringValue: aws.String(queueNameFromArn(record.EventSourceARN))},
+			"sourceReceiveCount": {DataType: aws.String("Number"), StringValue: aws.String(strconv.FormatInt(receiveCount, 10))},
+		},
+	}
+	// FIFO 死信队列：以源消息 id 同时作为分组与去重 id。
+	if strings.HasSuffix(queueNameFromURL(dlqURL), ".fifo") {
+		in.MessageGroupId = aws.String(record.MessageId)
+		in.MessageDeduplicationId = aws.String(record.MessageId)
+	}
+	if _, err := sqsClient.SendMessage(ctx, in); err != nil {
+		return fmt.Errorf("forward poison message to dlq: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// internalAttributes：Dispatcher 在 ultraMinimal / compareAttributes / sns 传输下使用的属性，不参与路由校验。
+var internalAttributes = map[string]bool{
+	"runId":             true,
+	"id":                true,
+	"signature":         true,
+	"sendUnixNano":      true,
+	"sendStartUnixNano": true,
+	"dispatcherVersion": true,
+	"traceHeader":       true,
+}
+
+// verifyRoute 校验收到的 MessageAttributes（去掉内部属性）与本路由的期望完全一致。
+// 期望值优先取 ROUTE_ATTRIBUTES（本 Worker 所服务路由的属性，k=v 逗号分隔），否则取消息体中的 routeAttributes。
+// 两者都没有时不校验，返回 nil。
+func verifyRoute(expectedFromBody map[string]string, attrs map[string]events.SQSMessageAttribute) (*bool, string) {
+	expected := parseRouteAttributes(os.Getenv("ROUTE_ATTRIBUTES"))
+	if expected == nil {
+		expected = expectedFromBody
+	}
+	if len(expected) == 0 {
+		return nil, ""
+	}
+	var problems []string
+	for k, want := range expected {
+		a, ok := attrs[k]
+		switch {
+		case !ok || a.StringValue == nil:
+			problems = append(problems, fmt.Sprintf("missing %s", k))
+		case *a.StringValue != want:
+			problems = append(problems, fmt.Sprintf("%s=%q want %q", k, *a.StringValue, want))
+		}
+	}
+	for k := range attrs {
+		if _, ok := expected[k]; !ok && !internalAttributes[k] {
+			problems = append(problems, fmt.Sprintf("unexpected %s", k))
+		}
+	}
+	sort.Strings(problems)
+	ok := len(problems) == 0
+	return &ok, strings.Join(problems, "; ")
+}
+
+func parseRouteAttributes(s string) map[string]string {
+	var m map[string]string
+	for _, kv := range strings.Split(s, ",") {
+		k, v, found := strings.Cut(strings.TrimSpace(kv), "=")
+		if !found || strings.TrimSpace(k) == "" {
+			continue
+		}
+		if m == nil {
+			m = map[string]string{}
+		}
+		m[strings.TrimSpace(k)] = strings.TrimSpace(v)
+	}
+	return m
+}
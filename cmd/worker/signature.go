@@ -0,0 +1,59 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"strings"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+// attrSignature：与 Dispatcher 相同，HMAC-SHA256（hex）覆盖实际收发的消息 body。
+const attrSignature = "signature"
+
+// sharedSecret：SHARED_SECRET，需与 Dispatcher 一致；为空表示不签名也不校验。
+func sharedSecret() string {
+	return strings.TrimSpace(os.Getenv("SHARED_SECRET"))
+}
+
+func signBody(secret string, b []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(b)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func verifySig(secret string, b []byte, sig string) bool {
+	want, err := hex.DecodeString(strings.TrimSpace(sig))
+	if err != nil || len(want) == 0 {
+		return false
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(b)
+	return hmac.Equal(mac.Sum(nil), want)
+}
+
+// recordSignatureValid：未配置 SHARED_SECRET 时总是有效；否则要求 record 带有与 body 匹配的 signature 属性。
+func recordSignatureValid(record events.SQSMessage) bool {
+	secret := sharedSecret()
+	if secret == "" {
+		return true
+	}
+	return verifySig(secret, []byte(record.Body), aws.ToString(record.MessageAttributes[attrSignature].StringValue))
+}
+
+// signCallback：配置了 SHARED_SECRET 时在回调属性中加入 signature，供 Dispatcher 轮询时校验。
+func signCallback(attrs map[string]types.MessageAttributeValue, body string) map[string]types.MessageAttributeValue {
+	secret := sharedSecret()
+	if secret == "" {
+		return attrs
+	}
+	if attrs == nil {
+		attrs = map[string]types.MessageAttributeValue{}
+	}
+	attrs[attrSignature] = types.MessageAttributeValue{DataType: aws.String("String"), StringValue: aws.String(signBody(secret, []byte(body)))}
+	return attrs
+}
@@ -0,0 +1,27 @@
+package main
+
+const crockfordAlphabet = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+// decodeULIDTime 返回 ulid（Dispatcher idFormat=ulid）中嵌入的毫秒时间戳；id 不是合法的 ulid 时返回 false。
+func decodeULIDTime(id string) (int64, bool) {
+	if len(id) != 26 || id[0] > '7' {
+		return 0, false
+	}
+	var ms int64
+	for i := 0; i < len(id); i++ {
+		v := -1
+		for j := 0; j < len(crockfordAlphabet); j++ {
+			if crockfordAlphabet[j] == id[i] {
+				v = j
+				break
+			}
+		}
+		if v < 0 {
+			return 0, false
+		}
+		if i < 10 {
+			ms = ms<<5 | int64(v)
+		}
+	}
+	return ms, true
+}
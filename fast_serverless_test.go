@@ -4,10 +4,13 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"math"
 	"net/http"
 	"os"
+	"sort"
 	"strconv"
 	"strings"
 	"testing"
@@ -33,8 +36,12 @@ type execOutput struct {
 	PollEndUnixNano        int64 `json:"pollEndUnixNano"`
 	ReceiveMessageUnixNano int64 `json:"receiveMessageUnixNano"`
 
-	WorkerReceiveUnixNano int64 `json:"workerReceiveUnixNano"`
-	WorkerDoneUnixNano    int64 `json:"workerDoneUnixNano"`
+	WorkerReceiveUnixNano int64  `json:"workerReceiveUnixNano"`
+	WorkerDoneUnixNano    int64  `json:"workerDoneUnixNano"`
+	CallbackSendMs        int64  `json:"callbackSendMs"`
+	WorkerInstanceID      string `json:"workerInstanceId"`
+	// Worker 报告的调用分类（cold|near-cold|warm）。
+	WorkerInvocationClass string `json:"workerInvocationClass"`
 
 	SqsSentTimestampMs         int64 `json:"sqsSentTimestampMs"`
 	SqsFirstReceiveTimestampMs int64 `json:"sqsFirstReceiveTimestampMs"`
@@ -44,6 +51,7 @@ type execOutput struct {
 type apiResponse struct {
 	Status  string          `json:"status"`
 	TotalMs int64           `json:"totalMs"`
+	TotalUs float64         `json:"totalUs"`
 	Output  json.RawMessage `json:"output,omitempty"`
 	Error   string          `json:"error,omitempty"`
 }
@@ -82,7 +90,10 @@ func callRunAPI(ctx context.Context, apiEndpoint string, payload any, timeout ti
 
 	bodyBytes, _ := io.ReadAll(resp.Body)
 	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-		return apiResponse{}, fmt.Errorf("api status=%d body=%s", resp.StatusCode, string(bodyBytes))
+		// 尽量解析错误响应，便于调用方区分 TIMEOUT 与其他错误。
+		var out apiResponse
+		_ = json.Unmarshal(bodyBytes, &out)
+		return out, fmt.Errorf("api status=%d body=%s", resp.StatusCode, string(bodyBytes))
 	}
 
 	var out apiResponse
@@ -106,6 +117,27 @@ func TestFastServerlessFlowLatency(t *testing.T) {
 	if repeat <= 0 {
 		repeat = 1
 	}
+	// CENSOR_TIMEOUTS=1：超时样本不再直接判定失败，而是作为右删失样本参与百分位统计。
+	censorTimeouts := os.Getenv("CENSOR_TIMEOUTS") == "1"
+	// MAX_DETAIL_ROWS：逐次明细表最多输出的行数（<=0 表示不限制）；汇总统计始终基于全部样本。
+	maxDetailRows := getenvIntDefault("MAX_DETAIL_ROWS", 50)
+	// TARGET_P99_MS：SLA 判定模式。每个样本后检验 p99 是否已能以 95% 置信度判定低于/高于目标，
+	// 一旦判定即提前停止（REPEAT 作为样本数上限）；FAIL 时测试失败。
+	targetP99Ms := int64(getenvIntDefault("TARGET_P99_MS", 0))
+	slaSamples, slaExceed := 0, 0
+	slaResult := ""
+	// RUN_RETRIES：整轮重跑次数。当前一轮的前 RUN_RETRY_PROBE_SAMPLES 个样本中失败比例超过
+	// RUN_RETRY_FAILURE_RATE 时（系统性故障，或区域级的短暂抖动），退避 RUN_RETRY_BACKOFF_MS（每次翻倍）后
+	// 以新的 runId 从头重跑；未启用时任何失败都直接判定测试失败。
+	runRetries := getenvIntDefault("RUN_RETRIES", 0)
+	probeSamples := getenvIntDefault("RUN_RETRY_PROBE_SAMPLES", 3)
+	if probeSamples <= 0 {
+		probeSamples = 1
+	}
+	failureRate := getenvFloatDefault("RUN_RETRY_FAILURE_RATE", 0.5)
+	retryBackoff := time.Duration(getenvIntDefault("RUN_RETRY_BACKOFF_MS", 5000)) * time.Millisecond
+	// TAIL_PERCENTILES=1：额外输出 p999/p9999，样本数不足以支撑该分位时标记为不可靠。
+	tailPercentiles := os.Getenv("TAIL_PERCENTILES") == "1"
 
 	ctx, cancel := context.WithTimeout(context.Background(), 12*time.Minute)
 	defer cancel()
@@ -139,30 +171,101 @@ func TestFastServerlessFlowLatency(t *testing.T) {
 		OverheadMs  int64
 		WallMs      int64
 		ApiLambdaMs int64
+		WorkerClass string
 	}
 	metrics := make([]iterMetric, 0, repeat)
+	timeoutCount := 0
+	callbackSendMsList := make([]int64, 0, repeat)
+	workerInstanceIDs := make([]string, 0, repeat)
+	// 微秒精度的阶段耗时（由原始纳秒计算），避免同区域快速往返的差异被毫秒取整掩盖。
+	stageUs := map[string][]float64{}
+	// 去程延迟只用 SQS 时间戳：pureForwardLegUs = workerReceive - SentTimestamp（跨 SQS/Worker 时钟），
+	// sqsOnlyForwardLegUs = FirstReceive - Sent（纯 SQS 时钟）；判定为时钟偏差的样本不计入，只计数。
+	forwardLegSkewCount := 0
 
 	var minSendMs, maxSendMs int64
 	var minSqsWaitMs, maxSqsWaitMs int64
 	var minWorkerMs, maxWorkerMs int64
 	var minOverheadMs, maxOverheadMs int64
 
+	// resetRun：整轮重跑前清空上一轮的全部累计值（min/max 在第一个样本时重新赋值）。
+	resetRun := func() {
+		latenciesMs = latenciesMs[:0]
+		sumMs, minMs, maxMs = 0, 0, 0
+		sumSendMs, sumSqsWaitMs, sumWorkerMs, sumOverheadMs = 0, 0, 0, 0
+		metrics = metrics[:0]
+		timeoutCount = 0
+		callbackSendMsList = callbackSendMsList[:0]
+		workerInstanceIDs = workerInstanceIDs[:0]
+		stageUs = map[string][]float64{}
+		forwardLegSkewCount = 0
+		slaSamples, slaExceed = 0, 0
+	}
+	attempts := []runAttempt{{Attempt: 1}}
+	probeFailures := 0
+
 	for i := 0; i < repeat; i++ {
+		if targetP99Ms > 0 {
+			if slaResult = slaVerdict(slaSamples, slaExceed); slaResult != "" {
+				break
+			}
+		}
 		runID := fmt.Sprintf("run-%d-%d", i, time.Now().UnixNano())
+		if n := len(attempts); n > 1 {
+			runID = fmt.Sprintf("run-a%d-%d-%d", n, i, time.Now().UnixNano())
+		}
+		attempts[len(attempts)-1].Samples++
 
 		startWall := time.Now()
 		apiOut, err := callRunAPI(ctx, apiEndpoint, map[string]any{
 			"runId":            runID,
 			"messageBodyBytes": 0,
 			// 避免 API Gateway 29s 超时；Dispatcher 默认控制为 25s。
-			"maxWaitMs": 25000,
+			"maxWaitMs": requestMaxWaitMs,
 		}, 28*time.Second)
+		if err != nil && censorTimeouts && isTimeoutResponse(apiOut, err) {
+			timeoutCount++
+			slaSamples++
+			if requestMaxWaitMs > targetP99Ms {
+				slaExceed++
+			}
+			t.Logf("iter=%d timeout (censored at %dms): %v", i+1, requestMaxWaitMs, err)
+			continue
+		}
+		if err == nil && apiOut.Status != "OK" {
+			err = fmt.Errorf("api status not ok: status=%s error=%s", apiOut.Status, apiOut.Error)
+		}
+		if err != nil && runRetries > 0 && i < probeSamples {
+			cur := &attempts[len(attempts)-1]
+			probeFailures++
+			if cur.FirstError == "" {
+				cur.FirstError = err.Error()
+			}
+			t.Logf("iter=%d attempt=%d probe failure %d/%d: %v", i+1, cur.Attempt, probeFailures, probeSamples, err)
+			if !systemicFailure(probeFailures, probeSamples, failureRate) {
+				// 未判定为系统性故障的失败样本同样计入 SLA，按超出目标处理（与 CENSOR_TIMEOUTS 的超时一致），否则失败的运行也可能 PASS。
+				slaSamples++
+				slaExceed++
+				continue
+			}
+			cur.ProbeFailures = probeFailures
+			backoff := retryBackoff << (len(attempts) - 1)
+			if deadline, ok := ctx.Deadline(); len(attempts) > runRetries || (ok && time.Until(deadline) < backoff+time.Minute) {
+				cur.Outcome = "failed"
+				t.Fatalf("systemic failure: %d/%d probe samples failed on attempt %d/%d (attempts=%s): %v", probeFailures, probeSamples, cur.Attempt, runRetries+1, formatRunAttempts(attempts), err)
+			}
+			cur.Outcome = "retried"
+			t.Logf("systemic failure on attempt %d, retrying whole run in %v", cur.Attempt, backoff)
+			time.Sleep(backoff)
+			resetRun()
+			attempts = append(attempts, runAttempt{Attempt: len(attempts) + 1})
+			probeFailures = 0
+			i = -1
+			continue
+		}
 		if err != nil {
 			t.Fatalf("call api [%d/%d]: %v", i+1, repeat, err)
 		}
-		if apiOut.Status != "OK" {
-			t.Fatalf("api status not ok [%d/%d]: status=%s error=%s", i+1, repeat, apiOut.Status, apiOut.Error)
-		}
 		var output execOutput
 		if len(apiOut.Output) > 0 {
 			_ = json.Unmarshal(apiOut.Output, &output)
@@ -172,6 +275,11 @@ func TestFastServerlessFlowLatency(t *testing.T) {
 		t.Logf("iter=%d recv queue=%s receiveMessageUnixNano=%d workerReceiveUnixNano=%d workerDoneUnixNano=%d", i+1, output.ReceiveQueueName, output.ReceiveMessageUnixNano, output.WorkerReceiveUnixNano, output.WorkerDoneUnixNano)
 
 		wallMs := time.Since(startWall).Milliseconds()
+		callbackSendMsList = append(callbackSendMsList, output.CallbackSendMs)
+		if n := len(workerInstanceIDs); n > 0 && output.WorkerInstanceID != "" {
+			t.Logf("iter=%d workerInstanceId=%s sameWorkerAsPrevious=%t", i+1, output.WorkerInstanceID, output.WorkerInstanceID == workerInstanceIDs[n-1])
+		}
+		workerInstanceIDs = append(workerInstanceIDs, output.WorkerInstanceID)
 
 		// Dispatcher API：以返回的 TotalMs 作为总耗时；退化时用墙钟时间。
 		latencyMs := apiOut.TotalMs
@@ -179,11 +287,15 @@ func TestFastServerlessFlowLatency(t *testing.T) {
 			latencyMs = wallMs
 		}
 		latenciesMs = append(latenciesMs, latencyMs)
+		slaSamples++
+		if latencyMs > targetP99Ms {
+			slaExceed++
+		}
 		sumMs += latencyMs
-		if i == 0 || latencyMs < minMs {
+		if len(latenciesMs) == 1 || latencyMs < minMs {
 			minMs = latencyMs
 		}
-		if i == 0 || latencyMs > maxMs {
+		if len(latenciesMs) == 1 || latencyMs > maxMs {
 			maxMs = latencyMs
 		}
 
@@ -221,6 +333,35 @@ func TestFastServerlessFlowLatency(t *testing.T) {
 			overheadMs = 0
 		}
 
+		if apiOut.TotalUs > 0 {
+			stageUs["totalUs"] = append(stageUs["totalUs"], apiOut.TotalUs)
+		}
+		if output.SendEndUnixNano > 0 && output.SendStartUnixNano > 0 {
+			stageUs["sendToSqsUs"] = append(stageUs["sendToSqsUs"], nanosToUs(output.SendEndUnixNano-output.SendStartUnixNano))
+		}
+		if output.WorkerReceiveUnixNano > 0 && base > 0 {
+			stageUs["sqsWaitUs"] = append(stageUs["sqsWaitUs"], nanosToUs(output.WorkerReceiveUnixNano-base))
+		}
+		if output.SqsSentTimestampMs > 0 && output.WorkerReceiveUnixNano > 0 {
+			pureUs := nanosToUs(output.WorkerReceiveUnixNano - output.SqsSentTimestampMs*int64(time.Millisecond))
+			sqsOnlyUs := -1.0
+			if output.SqsFirstReceiveTimestampMs > 0 {
+				sqsOnlyUs = float64(output.SqsFirstReceiveTimestampMs-output.SqsSentTimestampMs) * 1000
+			}
+			// 时间戳只有毫秒精度，留 1ms 容差。
+			if pureUs < 0 || (sqsOnlyUs >= 0 && pureUs < sqsOnlyUs-1000) {
+				forwardLegSkewCount++
+			} else {
+				stageUs["pureForwardLegUs"] = append(stageUs["pureForwardLegUs"], pureUs)
+				if sqsOnlyUs >= 0 {
+					stageUs["sqsOnlyForwardLegUs"] = append(stageUs["sqsOnlyForwardLegUs"], sqsOnlyUs)
+				}
+			}
+		}
+		if output.WorkerDoneUnixNano > 0 && output.WorkerReceiveUnixNano > 0 {
+			stageUs["workerUs"] = append(stageUs["workerUs"], nanosToUs(output.WorkerDoneUnixNano-output.WorkerReceiveUnixNano))
+		}
+
 		sumSendMs += sendToSqsMs
 		sumSqsWaitMs += sqsWaitMs
 		sumWorkerMs += workerMs
@@ -235,8 +376,9 @@ func TestFastServerlessFlowLatency(t *testing.T) {
 			OverheadMs:  overheadMs,
 			WallMs:      wallMs,
 			ApiLambdaMs: apiOut.TotalMs,
+			WorkerClass: output.WorkerInvocationClass,
 		})
-		if i == 0 {
+		if len(metrics) == 1 {
 			minSendMs, maxSendMs = sendToSqsMs, sendToSqsMs
 			minSqsWaitMs, maxSqsWaitMs = sqsWaitMs, sqsWaitMs
 			minWorkerMs, maxWorkerMs = workerMs, workerMs
@@ -269,6 +411,12 @@ func TestFastServerlessFlowLatency(t *testing.T) {
 		}
 	}
 
+	attempts[len(attempts)-1].ProbeFailures = probeFailures
+	attempts[len(attempts)-1].Outcome = "completed"
+
+	if len(latenciesMs) == 0 {
+		t.Fatalf("all %d iterations timed out", repeat)
+	}
 	den := float64(len(latenciesMs))
 	avgTotalMs := float64(sumMs) / den
 	avgSendMs := float64(sumSendMs) / den
@@ -344,10 +492,16 @@ func TestFastServerlessFlowLatency(t *testing.T) {
 	fmt.Fprintf(&buf, "api=%s\n\n", apiEndpoint)
 
 	buf.WriteString("### Latency Breakdown (ms)\n\n")
-	breakdownHeaders := []string{"iter", "totalMs", "sendToSqsMs", "sqsWaitMs", "workerMs", "overheadMs", "wallMs", "apiLambdaMs"}
-	breakdownRight := []bool{true, true, true, true, true, true, true, true}
-	breakdownRows := make([][]string, 0, len(metrics))
-	for _, m := range metrics {
+	breakdownHeaders := []string{"iter", "totalMs", "sendToSqsMs", "sqsWaitMs", "workerMs", "overheadMs", "wallMs", "apiLambdaMs", "workerClass"}
+	breakdownRight := []bool{true, true, true, true, true, true, true, true, false}
+	totals := make([]int64, len(metrics))
+	for i, m := range metrics {
+		totals[i] = m.TotalMs
+	}
+	detailIdx := selectDetailIndexes(totals, maxDetailRows)
+	breakdownRows := make([][]string, 0, len(detailIdx))
+	for _, idx := range detailIdx {
+		m := metrics[idx]
 		breakdownRows = append(breakdownRows, []string{
 			fmt.Sprintf("%d", m.Iter),
 			fmt.Sprintf("%d", m.TotalMs),
@@ -357,9 +511,13 @@ func TestFastServerlessFlowLatency(t *testing.T) {
 			fmt.Sprintf("%d", m.OverheadMs),
 			fmt.Sprintf("%d", m.WallMs),
 			fmt.Sprintf("%d", m.ApiLambdaMs),
+			m.WorkerClass,
 		})
 	}
 	buf.WriteString(formatMarkdownTable(breakdownHeaders, breakdownRight, breakdownRows))
+	if len(detailIdx) < len(metrics) {
+		fmt.Fprintf(&buf, "\ndetailsTruncated=true shown=%d total=%d (first/last/slowest)\n", len(detailIdx), len(metrics))
+	}
 
 	// 冷启动独立表
 	buf.WriteString("\n### Cold Start (iter=1)\n\n")
@@ -402,6 +560,27 @@ func TestFastServerlessFlowLatency(t *testing.T) {
 	}
 	buf.WriteString(formatMarkdownTable(summaryHeaders, summaryRight, summaryRows))
 
+	// 冷启动代价：按 Worker 报告的调用分类（而不是迭代序号）分组，cold 与 warm 的 totalMs 均值之差；near-cold 不计入。
+	buf.WriteString("\n### Cold Start Penalty (worker-reported)\n\n")
+	var coldN, warmN int
+	var coldSum, warmSum int64
+	for _, m := range metrics {
+		switch m.WorkerClass {
+		case "cold":
+			coldN++
+			coldSum += m.TotalMs
+		case "warm":
+			warmN++
+			warmSum += m.TotalMs
+		}
+	}
+	penaltyRow := []string{fmt.Sprintf("%d", coldN), fmt.Sprintf("%d", warmN), "n/a", "n/a", "n/a"}
+	if coldN > 0 && warmN > 0 {
+		coldMean, warmMean := float64(coldSum)/float64(coldN), float64(warmSum)/float64(warmN)
+		penaltyRow[2], penaltyRow[3], penaltyRow[4] = fmt.Sprintf("%.3f", coldMean), fmt.Sprintf("%.3f", warmMean), fmt.Sprintf("%.3f", coldMean-warmMean)
+	}
+	buf.WriteString(formatMarkdownTable([]string{"coldSamples", "warmSamples", "coldMeanMs", "warmMeanMs", "coldStartPenaltyMs"}, []bool{true, true, true, true, true}, [][]string{penaltyRow}))
+
 	// 保留整体 summary 供对比（包含 cold + warm）
 	buf.WriteString("\n### All Summary (iter=1..N)\n\n")
 	allRows := [][]string{
@@ -411,6 +590,120 @@ func TestFastServerlessFlowLatency(t *testing.T) {
 	}
 	buf.WriteString(formatMarkdownTable(summaryHeaders, summaryRight, allRows))
 
+	// 百分位：completed-only 只统计完成的样本；timeout-inclusive 把超时样本按截止时间（maxWaitMs）计入。
+	// 超时样本的真实耗时 >= 截止时间（右删失），因此 timeout-inclusive 的百分位是真实值的下界，
+	// 而 completed-only 在存在超时时会系统性偏低。两者都输出，由使用者按需取舍。
+	buf.WriteString("\n### Percentiles (ms)\n\n")
+	pctHeaders := []string{"samples", "n", "timeouts", "p50", "p90", "p99"}
+	pctRight := []bool{false, true, true, true, true, true}
+	completed := sortedCopy(latenciesMs)
+	inclusive := append(sortedCopy(latenciesMs), repeatInt64(requestMaxWaitMs, timeoutCount)...)
+	sortInt64s(inclusive)
+	pctRows := [][]string{
+		{"completed-only", fmt.Sprintf("%d", len(completed)), "0", fmt.Sprintf("%d", percentileMs(completed, 50)), fmt.Sprintf("%d", percentileMs(completed, 90)), fmt.Sprintf("%d", percentileMs(completed, 99))},
+		{"timeout-inclusive", fmt.Sprintf("%d", len(inclusive)), fmt.Sprintf("%d", timeoutCount), fmt.Sprintf("%d", percentileMs(inclusive, 50)), fmt.Sprintf("%d", percentileMs(inclusive, 90)), fmt.Sprintf("%d", percentileMs(inclusive, 99))},
+	}
+	buf.WriteString(formatMarkdownTable(pctHeaders, pctRight, pctRows))
+
+	// 尾部百分位：保留了全部样本，直接按最近秩精确计算；pN 至少需要 minSamplesForPercentile(N) 个样本才有意义。
+	if tailPercentiles {
+		buf.WriteString("\n### Tail Percentiles (ms)\n\n")
+		tailRows := [][]string{}
+		unreliable := false
+		for _, row := range []struct {
+			name   string
+			sorted []int64
+		}{{"completed-only", completed}, {"timeout-inclusive", inclusive}} {
+			cells := []string{row.name, fmt.Sprintf("%d", len(row.sorted)), fmt.Sprintf("%d", percentileMs(row.sorted, 99))}
+			for _, p := range []float64{99.9, 99.99} {
+				cell := fmt.Sprintf("%d", percentileMs(row.sorted, p))
+				if len(row.sorted) < minSamplesForPercentile(p) {
+					cell += " (unreliable)"
+					unreliable = true
+				}
+				cells = append(cells, cell)
+			}
+			tailRows = append(tailRows, cells)
+		}
+		buf.WriteString(formatMarkdownTable([]string{"samples", "n", "p99", "p999", "p9999"}, []bool{false, true, true, true, true}, tailRows))
+		if unreliable {
+			buf.WriteString(fmt.Sprintf("\nWARNING: p999 needs n>=%d and p9999 needs n>=%d samples; values marked unreliable are just the maximum of too few samples\n", minSamplesForPercentile(99.9), minSamplesForPercentile(99.99)))
+		}
+	}
+
+	// 微秒精度百分位：与毫秒表同一批完成样本，sqsWaitUs 跨 Dispatcher/Worker 时钟。
+	buf.WriteString("\n### Percentiles (us)\n\n")
+	usRows := [][]string{}
+	for _, name := range []string{"totalUs", "sendToSqsUs", "sqsWaitUs", "workerUs", "pureForwardLegUs", "sqsOnlyForwardLegUs"} {
+		v := append([]float64(nil), stageUs[name]...)
+		if len(v) == 0 {
+			usRows = append(usRows, []string{name, "0", "n/a", "n/a", "n/a", "n/a"})
+			continue
+		}
+		sort.Float64s(v)
+		var sum float64
+		for _, x := range v {
+			sum += x
+		}
+		usRows = append(usRows, []string{name, fmt.Sprintf("%d", len(v)), fmt.Sprintf("%.3f", sum/float64(len(v))), fmt.Sprintf("%.3f", percentileFloat(v, 50)), fmt.Sprintf("%.3f", percentileFloat(v, 90)), fmt.Sprintf("%.3f", percentileFloat(v, 99))})
+	}
+	buf.WriteString(formatMarkdownTable([]string{"metric", "n", "avg", "p50", "p90", "p99"}, []bool{false, true, true, true, true, true}, usRows))
+	if forwardLegSkewCount > 0 {
+		buf.WriteString(fmt.Sprintf("\nforward-leg clock skew: %d sample(s) excluded from pureForwardLegUs\n", forwardLegSkewCount))
+	}
+
+	// Worker 回调 SendMessage 耗时（由回调消息的 SQS SentTimestamp 近似，跨时钟）。
+	buf.WriteString("\n### Callback Send (ms)\n\n")
+	cbSorted := sortedCopy(callbackSendMsList)
+	var cbSum int64
+	for _, v := range cbSorted {
+		cbSum += v
+	}
+	cbRows := [][]string{{
+		fmt.Sprintf("%d", len(cbSorted)),
+		fmt.Sprintf("%.3f", float64(cbSum)/float64(len(cbSorted))),
+		fmt.Sprintf("%d", percentileMs(cbSorted, 50)),
+		fmt.Sprintf("%d", percentileMs(cbSorted, 99)),
+		fmt.Sprintf("%d", cbSorted[len(cbSorted)-1]),
+	}}
+	buf.WriteString(formatMarkdownTable([]string{"n", "avg", "p50", "p99", "max"}, []bool{true, true, true, true, true}, cbRows))
+
+	// Worker 容器粘性：相邻两个完成样本落在同一 Worker 容器上的比例（仅多于 1 个样本时输出）。
+	if same, pairs, distinct := workerStickiness(workerInstanceIDs); pairs > 0 {
+		buf.WriteString("\n### Worker Stickiness\n\n")
+		stickRows := [][]string{{
+			fmt.Sprintf("%d", pairs),
+			fmt.Sprintf("%d", same),
+			fmt.Sprintf("%.3f", float64(same)/float64(pairs)),
+			fmt.Sprintf("%d", distinct),
+		}}
+		buf.WriteString(formatMarkdownTable([]string{"pairs", "sameAsPrevious", "stickiness", "distinctWorkers"}, []bool{true, true, true, true}, stickRows))
+	}
+
+	if runRetries > 0 {
+		buf.WriteString("\n### Run Attempts\n\n")
+		attemptRows := make([][]string, 0, len(attempts))
+		for _, a := range attempts {
+			attemptRows = append(attemptRows, []string{fmt.Sprintf("%d", a.Attempt), fmt.Sprintf("%d", a.Samples), fmt.Sprintf("%d", a.ProbeFailures), a.Outcome, a.FirstError})
+		}
+		buf.WriteString(formatMarkdownTable([]string{"attempt", "samples", "probeFailures", "outcome", "firstError"}, []bool{true, true, true, false, false}, attemptRows))
+		fmt.Fprintf(&buf, "\nrunAttempts=%d\n", len(attempts))
+	}
+
+	if targetP99Ms > 0 {
+		if slaResult == "" {
+			if slaResult = slaVerdict(slaSamples, slaExceed); slaResult == "" {
+				slaResult = "INCONCLUSIVE"
+			}
+		}
+		buf.WriteString("\n### SLA (p99)\n\n")
+		slaRows := [][]string{{fmt.Sprintf("%d", targetP99Ms), slaResult, fmt.Sprintf("%d", slaSamples), fmt.Sprintf("%d", repeat), fmt.Sprintf("%d", slaExceed)}}
+		buf.WriteString(formatMarkdownTable([]string{"targetP99Ms", "verdict", "samplesUsed", "maxSamples", "exceeded"}, []bool{true, false, true, true, true}, slaRows))
+		if slaResult == "FAIL" {
+			t.Errorf("SLA FAIL: p99 > %dms (%d/%d samples exceeded)", targetP99Ms, slaExceed, slaSamples)
+		}
+	}
+
 	// 这两个标记用于 tests.sh 提取内容写入 result.md。
 	fmt.Println("===BEGIN_RESULT_MD===")
 	fmt.Print(buf.String())
@@ -420,6 +713,174 @@ func TestFastServerlessFlowLatency(t *testing.T) {
 	fmt.Println("===END_RESULT_MD===")
 }
 
+// requestMaxWaitMs：每次调用的 maxWaitMs，同时作为超时样本的删失值。
+const requestMaxWaitMs = 25000
+
+// runAttempt：RUN_RETRIES 下单轮运行的结果。
+type runAttempt struct {
+	Attempt       int
+	Samples       int
+	ProbeFailures int
+	Outcome       string
+	FirstError    string
+}
+
+// systemicFailure：探测窗口内的失败比例已超过阈值（按窗口大小计算，可在窗口结束前提前判定）。
+func systemicFailure(failures, probeSamples int, threshold float64) bool {
+	return float64(failures)/float64(probeSamples) > threshold
+}
+
+func formatRunAttempts(attempts []runAttempt) string {
+	parts := make([]string, 0, len(attempts))
+	for _, a := range attempts {
+		parts = append(parts, fmt.Sprintf("#%d:%s(%d/%d)", a.Attempt, a.Outcome, a.ProbeFailures, a.Samples))
+	}
+	return strings.Join(parts, ",")
+}
+
+func isTimeoutResponse(out apiResponse, err error) bool {
+	if out.Status == "TIMEOUT" {
+		return true
+	}
+	return errors.Is(err, context.DeadlineExceeded) || strings.Contains(err.Error(), "Client.Timeout")
+}
+
+// selectDetailIndexes 在样本数超过 maxRows 时挑选最有代表性的明细行：
+// 最前、最后以及最慢的若干条（去重后按迭代顺序返回）。maxRows<=0 或样本数不超过上限时全部保留。
+func selectDetailIndexes(totals []int64, maxRows int) []int {
+	n := len(totals)
+	all := make([]int, n)
+	for i := range all {
+		all[i] = i
+	}
+	if maxRows <= 0 || n <= maxRows {
+		return all
+	}
+
+	each := maxRows / 3
+	picked := map[int]bool{}
+	for i := 0; i < each; i++ {
+		picked[i] = true
+		picked[n-1-i] = true
+	}
+	bySlowest := append([]int(nil), all...)
+	sort.SliceStable(bySlowest, func(i, j int) bool { return totals[bySlowest[i]] > totals[bySlowest[j]] })
+	for _, idx := range bySlowest {
+		if len(picked) >= maxRows {
+			break
+		}
+		picked[idx] = true
+	}
+
+	out := make([]int, 0, len(picked))
+	for i := 0; i < n; i++ {
+		if picked[i] {
+			out = append(out, i)
+		}
+	}
+	return out
+}
+
+// workerStickiness 统计相邻样本（均带 workerInstanceId）中 Worker 容器相同的对数。
+func workerStickiness(ids []string) (same, pairs, distinct int) {
+	seen := map[string]bool{}
+	for i, id := range ids {
+		if id == "" {
+			continue
+		}
+		seen[id] = true
+		if i == 0 || ids[i-1] == "" {
+			continue
+		}
+		pairs++
+		if ids[i-1] == id {
+			same++
+		}
+	}
+	return same, pairs, len(seen)
+}
+
+// slaVerdict：把"单个样本超过目标"视为概率 q 的伯努利事件，p99 低于目标等价于 q < 1%。
+// 用二项分布的精确尾概率做单侧检验（显著性 5%）：超出次数少到足以确信 q < 1% 时 PASS，
+// 多到足以确信 q > 1% 时 FAIL，否则返回空串继续采样。全部样本都未超出时约需 299 个样本才能 PASS。
+func slaVerdict(n, exceeded int) string {
+	const q, alpha = 0.01, 0.05
+	if n == 0 {
+		return ""
+	}
+	pmf := math.Pow(1-q, float64(n))
+	var below float64 // P(X <= exceeded)
+	var atMostPrev float64
+	for k := 0; k <= exceeded && k <= n; k++ {
+		if k > 0 {
+			pmf *= float64(n-k+1) / float64(k) * q / (1 - q)
+		}
+		if k == exceeded {
+			atMostPrev = below
+		}
+		below += pmf
+	}
+	switch {
+	case below <= alpha:
+		return "PASS"
+	case 1-atMostPrev <= alpha: // P(X >= exceeded)
+		return "FAIL"
+	}
+	return ""
+}
+
+// percentileMs：nearest-rank 百分位，输入需已升序排序。
+func percentileMs(sorted []int64, p float64) int64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+	if rank > len(sorted) {
+		rank = len(sorted)
+	}
+	return sorted[rank-1]
+}
+
+// minSamplesForPercentile：pN 的尾部（1-N/100）至少要有一个样本，例如 p999 需要 1000 个。
+func minSamplesForPercentile(p float64) int {
+	return int(math.Round(100 / (100 - p)))
+}
+
+func percentileFloat(sorted []float64, p float64) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+	if rank > len(sorted) {
+		rank = len(sorted)
+	}
+	return sorted[rank-1]
+}
+
+func sortedCopy(v []int64) []int64 {
+	out := append([]int64(nil), v...)
+	sortInt64s(out)
+	return out
+}
+
+func sortInt64s(v []int64) {
+	sort.Slice(v, func(i, j int) bool { return v[i] < v[j] })
+}
+
+func repeatInt64(v int64, n int) []int64 {
+	out := make([]int64, n)
+	for i := range out {
+		out[i] = v
+	}
+	return out
+}
+
 func formatMarkdownTable(headers []string, rightAlign []bool, rows [][]string) string {
 	colN := len(headers)
 	widths := make([]int, colN)
@@ -497,6 +958,10 @@ func padCell(s string, width int, right bool) string {
 	return s + pad
 }
 
+func nanosToUs(n int64) float64 {
+	return float64(n) / float64(time.Microsecond)
+}
+
 func nanosToMs(n int64) int64 {
 	return n / int64(time.Millisecond)
 }
@@ -537,3 +1002,15 @@ func getenvIntDefault(key string, def int) int {
 	}
 	return n
 }
+
+func getenvFloatDefault(key string, def float64) float64 {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		return def
+	}
+	return f
+}